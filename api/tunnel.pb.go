@@ -1,6 +1,6 @@
 // Code generated by protoc-gen-go. DO NOT EDIT.
 // versions:
-// 	protoc-gen-go v1.33.0
+// 	protoc-gen-go v1.36.6
 // 	protoc        v6.31.1
 // source: api/tunnel.proto
 
@@ -11,6 +11,7 @@ import (
 	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
 	reflect "reflect"
 	sync "sync"
+	unsafe "unsafe"
 )
 
 const (
@@ -20,25 +21,90 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
-type ClientToServer struct {
-	state         protoimpl.MessageState
-	sizeCache     protoimpl.SizeCache
-	unknownFields protoimpl.UnknownFields
+// Причина закрытия соединения внутри туннеля
+type CloseReason int32
+
+const (
+	CloseReason_CLOSE_REASON_UNSPECIFIED CloseReason = 0
+	// штатное завершение
+	CloseReason_CLOSE_REASON_DONE CloseReason = 1
+	// агент не смог подключиться к локальному сервису
+	CloseReason_CLOSE_REASON_DIAL_FAILED CloseReason = 2
+	// ошибка чтения или записи сокета
+	CloseReason_CLOSE_REASON_IO_ERROR CloseReason = 3
+	// агент или сервер отключаются
+	CloseReason_CLOSE_REASON_SHUTDOWN CloseReason = 4
+	// другая сторона прислала больше данных, чем позволяло окно
+	CloseReason_CLOSE_REASON_FLOW_CONTROL_ERROR CloseReason = 5
+)
+
+// Enum value maps for CloseReason.
+var (
+	CloseReason_name = map[int32]string{
+		0: "CLOSE_REASON_UNSPECIFIED",
+		1: "CLOSE_REASON_DONE",
+		2: "CLOSE_REASON_DIAL_FAILED",
+		3: "CLOSE_REASON_IO_ERROR",
+		4: "CLOSE_REASON_SHUTDOWN",
+		5: "CLOSE_REASON_FLOW_CONTROL_ERROR",
+	}
+	CloseReason_value = map[string]int32{
+		"CLOSE_REASON_UNSPECIFIED":        0,
+		"CLOSE_REASON_DONE":               1,
+		"CLOSE_REASON_DIAL_FAILED":        2,
+		"CLOSE_REASON_IO_ERROR":           3,
+		"CLOSE_REASON_SHUTDOWN":           4,
+		"CLOSE_REASON_FLOW_CONTROL_ERROR": 5,
+	}
+)
+
+func (x CloseReason) Enum() *CloseReason {
+	p := new(CloseReason)
+	*p = x
+	return p
+}
+
+func (x CloseReason) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (CloseReason) Descriptor() protoreflect.EnumDescriptor {
+	return file_api_tunnel_proto_enumTypes[0].Descriptor()
+}
+
+func (CloseReason) Type() protoreflect.EnumType {
+	return &file_api_tunnel_proto_enumTypes[0]
+}
+
+func (x CloseReason) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use CloseReason.Descriptor instead.
+func (CloseReason) EnumDescriptor() ([]byte, []int) {
+	return file_api_tunnel_proto_rawDescGZIP(), []int{0}
+}
 
-	// Types that are assignable to Message:
+type ClientToServer struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Message:
 	//
 	//	*ClientToServer_Register
 	//	*ClientToServer_Data
-	Message isClientToServer_Message `protobuf_oneof:"message"`
+	//	*ClientToServer_HalfClose
+	//	*ClientToServer_Close
+	//	*ClientToServer_ResetConnection
+	//	*ClientToServer_WindowUpdate
+	Message       isClientToServer_Message `protobuf_oneof:"message"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
 }
 
 func (x *ClientToServer) Reset() {
 	*x = ClientToServer{}
-	if protoimpl.UnsafeEnabled {
-		mi := &file_api_tunnel_proto_msgTypes[0]
-		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
-		ms.StoreMessageInfo(mi)
-	}
+	mi := &file_api_tunnel_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
 }
 
 func (x *ClientToServer) String() string {
@@ -49,7 +115,7 @@ func (*ClientToServer) ProtoMessage() {}
 
 func (x *ClientToServer) ProtoReflect() protoreflect.Message {
 	mi := &file_api_tunnel_proto_msgTypes[0]
-	if protoimpl.UnsafeEnabled && x != nil {
+	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
 			ms.StoreMessageInfo(mi)
@@ -64,23 +130,63 @@ func (*ClientToServer) Descriptor() ([]byte, []int) {
 	return file_api_tunnel_proto_rawDescGZIP(), []int{0}
 }
 
-func (m *ClientToServer) GetMessage() isClientToServer_Message {
-	if m != nil {
-		return m.Message
+func (x *ClientToServer) GetMessage() isClientToServer_Message {
+	if x != nil {
+		return x.Message
 	}
 	return nil
 }
 
 func (x *ClientToServer) GetRegister() *Register {
-	if x, ok := x.GetMessage().(*ClientToServer_Register); ok {
-		return x.Register
+	if x != nil {
+		if x, ok := x.Message.(*ClientToServer_Register); ok {
+			return x.Register
+		}
 	}
 	return nil
 }
 
 func (x *ClientToServer) GetData() *Data {
-	if x, ok := x.GetMessage().(*ClientToServer_Data); ok {
-		return x.Data
+	if x != nil {
+		if x, ok := x.Message.(*ClientToServer_Data); ok {
+			return x.Data
+		}
+	}
+	return nil
+}
+
+func (x *ClientToServer) GetHalfClose() *HalfClose {
+	if x != nil {
+		if x, ok := x.Message.(*ClientToServer_HalfClose); ok {
+			return x.HalfClose
+		}
+	}
+	return nil
+}
+
+func (x *ClientToServer) GetClose() *Close {
+	if x != nil {
+		if x, ok := x.Message.(*ClientToServer_Close); ok {
+			return x.Close
+		}
+	}
+	return nil
+}
+
+func (x *ClientToServer) GetResetConnection() *Reset {
+	if x != nil {
+		if x, ok := x.Message.(*ClientToServer_ResetConnection); ok {
+			return x.ResetConnection
+		}
+	}
+	return nil
+}
+
+func (x *ClientToServer) GetWindowUpdate() *WindowUpdate {
+	if x != nil {
+		if x, ok := x.Message.(*ClientToServer_WindowUpdate); ok {
+			return x.WindowUpdate
+		}
 	}
 	return nil
 }
@@ -97,29 +203,55 @@ type ClientToServer_Data struct {
 	Data *Data `protobuf:"bytes,2,opt,name=data,proto3,oneof"`
 }
 
+type ClientToServer_HalfClose struct {
+	HalfClose *HalfClose `protobuf:"bytes,3,opt,name=half_close,json=halfClose,proto3,oneof"`
+}
+
+type ClientToServer_Close struct {
+	Close *Close `protobuf:"bytes,4,opt,name=close,proto3,oneof"`
+}
+
+type ClientToServer_ResetConnection struct {
+	ResetConnection *Reset `protobuf:"bytes,5,opt,name=reset_connection,json=resetConnection,proto3,oneof"`
+}
+
+type ClientToServer_WindowUpdate struct {
+	WindowUpdate *WindowUpdate `protobuf:"bytes,6,opt,name=window_update,json=windowUpdate,proto3,oneof"`
+}
+
 func (*ClientToServer_Register) isClientToServer_Message() {}
 
 func (*ClientToServer_Data) isClientToServer_Message() {}
 
-type ServerToClient struct {
-	state         protoimpl.MessageState
-	sizeCache     protoimpl.SizeCache
-	unknownFields protoimpl.UnknownFields
+func (*ClientToServer_HalfClose) isClientToServer_Message() {}
 
-	// Types that are assignable to Message:
+func (*ClientToServer_Close) isClientToServer_Message() {}
+
+func (*ClientToServer_ResetConnection) isClientToServer_Message() {}
+
+func (*ClientToServer_WindowUpdate) isClientToServer_Message() {}
+
+type ServerToClient struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Message:
 	//
 	//	*ServerToClient_NewConnection
 	//	*ServerToClient_Data
-	Message isServerToClient_Message `protobuf_oneof:"message"`
+	//	*ServerToClient_HalfClose
+	//	*ServerToClient_Close
+	//	*ServerToClient_ResetConnection
+	//	*ServerToClient_WindowUpdate
+	//	*ServerToClient_Registered
+	Message       isServerToClient_Message `protobuf_oneof:"message"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
 }
 
 func (x *ServerToClient) Reset() {
 	*x = ServerToClient{}
-	if protoimpl.UnsafeEnabled {
-		mi := &file_api_tunnel_proto_msgTypes[1]
-		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
-		ms.StoreMessageInfo(mi)
-	}
+	mi := &file_api_tunnel_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
 }
 
 func (x *ServerToClient) String() string {
@@ -130,7 +262,7 @@ func (*ServerToClient) ProtoMessage() {}
 
 func (x *ServerToClient) ProtoReflect() protoreflect.Message {
 	mi := &file_api_tunnel_proto_msgTypes[1]
-	if protoimpl.UnsafeEnabled && x != nil {
+	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
 			ms.StoreMessageInfo(mi)
@@ -145,23 +277,72 @@ func (*ServerToClient) Descriptor() ([]byte, []int) {
 	return file_api_tunnel_proto_rawDescGZIP(), []int{1}
 }
 
-func (m *ServerToClient) GetMessage() isServerToClient_Message {
-	if m != nil {
-		return m.Message
+func (x *ServerToClient) GetMessage() isServerToClient_Message {
+	if x != nil {
+		return x.Message
 	}
 	return nil
 }
 
 func (x *ServerToClient) GetNewConnection() *NewConnection {
-	if x, ok := x.GetMessage().(*ServerToClient_NewConnection); ok {
-		return x.NewConnection
+	if x != nil {
+		if x, ok := x.Message.(*ServerToClient_NewConnection); ok {
+			return x.NewConnection
+		}
 	}
 	return nil
 }
 
 func (x *ServerToClient) GetData() *Data {
-	if x, ok := x.GetMessage().(*ServerToClient_Data); ok {
-		return x.Data
+	if x != nil {
+		if x, ok := x.Message.(*ServerToClient_Data); ok {
+			return x.Data
+		}
+	}
+	return nil
+}
+
+func (x *ServerToClient) GetHalfClose() *HalfClose {
+	if x != nil {
+		if x, ok := x.Message.(*ServerToClient_HalfClose); ok {
+			return x.HalfClose
+		}
+	}
+	return nil
+}
+
+func (x *ServerToClient) GetClose() *Close {
+	if x != nil {
+		if x, ok := x.Message.(*ServerToClient_Close); ok {
+			return x.Close
+		}
+	}
+	return nil
+}
+
+func (x *ServerToClient) GetResetConnection() *Reset {
+	if x != nil {
+		if x, ok := x.Message.(*ServerToClient_ResetConnection); ok {
+			return x.ResetConnection
+		}
+	}
+	return nil
+}
+
+func (x *ServerToClient) GetWindowUpdate() *WindowUpdate {
+	if x != nil {
+		if x, ok := x.Message.(*ServerToClient_WindowUpdate); ok {
+			return x.WindowUpdate
+		}
+	}
+	return nil
+}
+
+func (x *ServerToClient) GetRegistered() *Registered {
+	if x != nil {
+		if x, ok := x.Message.(*ServerToClient_Registered); ok {
+			return x.Registered
+		}
 	}
 	return nil
 }
@@ -178,25 +359,63 @@ type ServerToClient_Data struct {
 	Data *Data `protobuf:"bytes,2,opt,name=data,proto3,oneof"`
 }
 
+type ServerToClient_HalfClose struct {
+	HalfClose *HalfClose `protobuf:"bytes,3,opt,name=half_close,json=halfClose,proto3,oneof"`
+}
+
+type ServerToClient_Close struct {
+	Close *Close `protobuf:"bytes,4,opt,name=close,proto3,oneof"`
+}
+
+type ServerToClient_ResetConnection struct {
+	ResetConnection *Reset `protobuf:"bytes,5,opt,name=reset_connection,json=resetConnection,proto3,oneof"`
+}
+
+type ServerToClient_WindowUpdate struct {
+	WindowUpdate *WindowUpdate `protobuf:"bytes,6,opt,name=window_update,json=windowUpdate,proto3,oneof"`
+}
+
+type ServerToClient_Registered struct {
+	Registered *Registered `protobuf:"bytes,7,opt,name=registered,proto3,oneof"`
+}
+
 func (*ServerToClient_NewConnection) isServerToClient_Message() {}
 
 func (*ServerToClient_Data) isServerToClient_Message() {}
 
+func (*ServerToClient_HalfClose) isServerToClient_Message() {}
+
+func (*ServerToClient_Close) isServerToClient_Message() {}
+
+func (*ServerToClient_ResetConnection) isServerToClient_Message() {}
+
+func (*ServerToClient_WindowUpdate) isServerToClient_Message() {}
+
+func (*ServerToClient_Registered) isServerToClient_Message() {}
+
 type Register struct {
-	state         protoimpl.MessageState
-	sizeCache     protoimpl.SizeCache
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	TunnelId string                 `protobuf:"bytes,1,opt,name=tunnel_id,json=tunnelId,proto3" json:"tunnel_id,omitempty"`
+	// API-ключ владельца туннеля, сервер проверяет его перед регистрацией
+	ApiKey string `protobuf:"bytes,2,opt,name=api_key,json=apiKey,proto3" json:"api_key,omitempty"`
+	// токен прошлой сессии; если сервер ещё помнит её, соединения продолжаются
+	ResumeToken string `protobuf:"bytes,3,opt,name=resume_token,json=resumeToken,proto3" json:"resume_token,omitempty"`
+	// состояние соединений агента на момент переподключения
+	Connections []*ConnectionState `protobuf:"bytes,4,rep,name=connections,proto3" json:"connections,omitempty"`
+	// версия CLI-агента, сервер показывает её в API туннеля
+	AgentVersion string `protobuf:"bytes,5,opt,name=agent_version,json=agentVersion,proto3" json:"agent_version,omitempty"`
+	// туннели, которые агент обслуживает через один стрим; если список
+	// не пуст, поля tunnel_id, resume_token и connections не используются
+	Tunnels       []*TunnelRegister `protobuf:"bytes,6,rep,name=tunnels,proto3" json:"tunnels,omitempty"`
 	unknownFields protoimpl.UnknownFields
-
-	TunnelId string `protobuf:"bytes,1,opt,name=tunnel_id,json=tunnelId,proto3" json:"tunnel_id,omitempty"`
+	sizeCache     protoimpl.SizeCache
 }
 
 func (x *Register) Reset() {
 	*x = Register{}
-	if protoimpl.UnsafeEnabled {
-		mi := &file_api_tunnel_proto_msgTypes[2]
-		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
-		ms.StoreMessageInfo(mi)
-	}
+	mi := &file_api_tunnel_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
 }
 
 func (x *Register) String() string {
@@ -207,7 +426,7 @@ func (*Register) ProtoMessage() {}
 
 func (x *Register) ProtoReflect() protoreflect.Message {
 	mi := &file_api_tunnel_proto_msgTypes[2]
-	if protoimpl.UnsafeEnabled && x != nil {
+	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
 			ms.StoreMessageInfo(mi)
@@ -229,21 +448,327 @@ func (x *Register) GetTunnelId() string {
 	return ""
 }
 
-type NewConnection struct {
-	state         protoimpl.MessageState
+func (x *Register) GetApiKey() string {
+	if x != nil {
+		return x.ApiKey
+	}
+	return ""
+}
+
+func (x *Register) GetResumeToken() string {
+	if x != nil {
+		return x.ResumeToken
+	}
+	return ""
+}
+
+func (x *Register) GetConnections() []*ConnectionState {
+	if x != nil {
+		return x.Connections
+	}
+	return nil
+}
+
+func (x *Register) GetAgentVersion() string {
+	if x != nil {
+		return x.AgentVersion
+	}
+	return ""
+}
+
+func (x *Register) GetTunnels() []*TunnelRegister {
+	if x != nil {
+		return x.Tunnels
+	}
+	return nil
+}
+
+// Один туннель в Register с несколькими туннелями
+type TunnelRegister struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	TunnelId      string                 `protobuf:"bytes,1,opt,name=tunnel_id,json=tunnelId,proto3" json:"tunnel_id,omitempty"`
+	ResumeToken   string                 `protobuf:"bytes,2,opt,name=resume_token,json=resumeToken,proto3" json:"resume_token,omitempty"`
+	Connections   []*ConnectionState     `protobuf:"bytes,3,rep,name=connections,proto3" json:"connections,omitempty"`
+	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TunnelRegister) Reset() {
+	*x = TunnelRegister{}
+	mi := &file_api_tunnel_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TunnelRegister) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TunnelRegister) ProtoMessage() {}
+
+func (x *TunnelRegister) ProtoReflect() protoreflect.Message {
+	mi := &file_api_tunnel_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TunnelRegister.ProtoReflect.Descriptor instead.
+func (*TunnelRegister) Descriptor() ([]byte, []int) {
+	return file_api_tunnel_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *TunnelRegister) GetTunnelId() string {
+	if x != nil {
+		return x.TunnelId
+	}
+	return ""
+}
+
+func (x *TunnelRegister) GetResumeToken() string {
+	if x != nil {
+		return x.ResumeToken
+	}
+	return ""
+}
+
+func (x *TunnelRegister) GetConnections() []*ConnectionState {
+	if x != nil {
+		return x.Connections
+	}
+	return nil
+}
+
+// Ответ сервера на Register
+type Registered struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	ResumeToken string                 `protobuf:"bytes,1,opt,name=resume_token,json=resumeToken,proto3" json:"resume_token,omitempty"`
+	// true, если сервер подхватил прошлую сессию вместе с соединениями
+	Resumed     bool               `protobuf:"varint,2,opt,name=resumed,proto3" json:"resumed,omitempty"`
+	Connections []*ConnectionState `protobuf:"bytes,3,rep,name=connections,proto3" json:"connections,omitempty"`
+	// ответ на Register с несколькими туннелями, в том же порядке
+	Tunnels       []*TunnelRegistered `protobuf:"bytes,4,rep,name=tunnels,proto3" json:"tunnels,omitempty"`
 	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
 
-	ConnectionId string `protobuf:"bytes,1,opt,name=connection_id,json=connectionId,proto3" json:"connection_id,omitempty"`
+func (x *Registered) Reset() {
+	*x = Registered{}
+	mi := &file_api_tunnel_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
 }
 
-func (x *NewConnection) Reset() {
-	*x = NewConnection{}
-	if protoimpl.UnsafeEnabled {
-		mi := &file_api_tunnel_proto_msgTypes[3]
+func (x *Registered) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Registered) ProtoMessage() {}
+
+func (x *Registered) ProtoReflect() protoreflect.Message {
+	mi := &file_api_tunnel_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Registered.ProtoReflect.Descriptor instead.
+func (*Registered) Descriptor() ([]byte, []int) {
+	return file_api_tunnel_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Registered) GetResumeToken() string {
+	if x != nil {
+		return x.ResumeToken
+	}
+	return ""
+}
+
+func (x *Registered) GetResumed() bool {
+	if x != nil {
+		return x.Resumed
+	}
+	return false
+}
+
+func (x *Registered) GetConnections() []*ConnectionState {
+	if x != nil {
+		return x.Connections
+	}
+	return nil
+}
+
+func (x *Registered) GetTunnels() []*TunnelRegistered {
+	if x != nil {
+		return x.Tunnels
+	}
+	return nil
+}
+
+type TunnelRegistered struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	TunnelId      string                 `protobuf:"bytes,1,opt,name=tunnel_id,json=tunnelId,proto3" json:"tunnel_id,omitempty"`
+	ResumeToken   string                 `protobuf:"bytes,2,opt,name=resume_token,json=resumeToken,proto3" json:"resume_token,omitempty"`
+	Resumed       bool                   `protobuf:"varint,3,opt,name=resumed,proto3" json:"resumed,omitempty"`
+	Connections   []*ConnectionState     `protobuf:"bytes,4,rep,name=connections,proto3" json:"connections,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TunnelRegistered) Reset() {
+	*x = TunnelRegistered{}
+	mi := &file_api_tunnel_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TunnelRegistered) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TunnelRegistered) ProtoMessage() {}
+
+func (x *TunnelRegistered) ProtoReflect() protoreflect.Message {
+	mi := &file_api_tunnel_proto_msgTypes[5]
+	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
-		ms.StoreMessageInfo(mi)
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TunnelRegistered.ProtoReflect.Descriptor instead.
+func (*TunnelRegistered) Descriptor() ([]byte, []int) {
+	return file_api_tunnel_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *TunnelRegistered) GetTunnelId() string {
+	if x != nil {
+		return x.TunnelId
+	}
+	return ""
+}
+
+func (x *TunnelRegistered) GetResumeToken() string {
+	if x != nil {
+		return x.ResumeToken
 	}
+	return ""
+}
+
+func (x *TunnelRegistered) GetResumed() bool {
+	if x != nil {
+		return x.Resumed
+	}
+	return false
+}
+
+func (x *TunnelRegistered) GetConnections() []*ConnectionState {
+	if x != nil {
+		return x.Connections
+	}
+	return nil
+}
+
+// Сколько данных сторона получила по соединению, чтобы другая сторона
+// после переподключения дослала потерянное
+type ConnectionState struct {
+	state        protoimpl.MessageState `protogen:"open.v1"`
+	ConnectionId string                 `protobuf:"bytes,1,opt,name=connection_id,json=connectionId,proto3" json:"connection_id,omitempty"`
+	Received     uint64                 `protobuf:"varint,2,opt,name=received,proto3" json:"received,omitempty"`
+	// сколько байт возвращено отправителю через WindowUpdate
+	Credited uint64 `protobuf:"varint,3,opt,name=credited,proto3" json:"credited,omitempty"`
+	// получен HalfClose или Close
+	EofReceived   bool `protobuf:"varint,4,opt,name=eof_received,json=eofReceived,proto3" json:"eof_received,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ConnectionState) Reset() {
+	*x = ConnectionState{}
+	mi := &file_api_tunnel_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ConnectionState) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ConnectionState) ProtoMessage() {}
+
+func (x *ConnectionState) ProtoReflect() protoreflect.Message {
+	mi := &file_api_tunnel_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ConnectionState.ProtoReflect.Descriptor instead.
+func (*ConnectionState) Descriptor() ([]byte, []int) {
+	return file_api_tunnel_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ConnectionState) GetConnectionId() string {
+	if x != nil {
+		return x.ConnectionId
+	}
+	return ""
+}
+
+func (x *ConnectionState) GetReceived() uint64 {
+	if x != nil {
+		return x.Received
+	}
+	return 0
+}
+
+func (x *ConnectionState) GetCredited() uint64 {
+	if x != nil {
+		return x.Credited
+	}
+	return 0
+}
+
+func (x *ConnectionState) GetEofReceived() bool {
+	if x != nil {
+		return x.EofReceived
+	}
+	return false
+}
+
+type NewConnection struct {
+	state        protoimpl.MessageState `protogen:"open.v1"`
+	ConnectionId string                 `protobuf:"bytes,1,opt,name=connection_id,json=connectionId,proto3" json:"connection_id,omitempty"`
+	// туннель, к которому пришло соединение
+	TunnelId      string `protobuf:"bytes,2,opt,name=tunnel_id,json=tunnelId,proto3" json:"tunnel_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *NewConnection) Reset() {
+	*x = NewConnection{}
+	mi := &file_api_tunnel_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
 }
 
 func (x *NewConnection) String() string {
@@ -253,8 +778,8 @@ func (x *NewConnection) String() string {
 func (*NewConnection) ProtoMessage() {}
 
 func (x *NewConnection) ProtoReflect() protoreflect.Message {
-	mi := &file_api_tunnel_proto_msgTypes[3]
-	if protoimpl.UnsafeEnabled && x != nil {
+	mi := &file_api_tunnel_proto_msgTypes[7]
+	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
 			ms.StoreMessageInfo(mi)
@@ -266,7 +791,7 @@ func (x *NewConnection) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use NewConnection.ProtoReflect.Descriptor instead.
 func (*NewConnection) Descriptor() ([]byte, []int) {
-	return file_api_tunnel_proto_rawDescGZIP(), []int{3}
+	return file_api_tunnel_proto_rawDescGZIP(), []int{7}
 }
 
 func (x *NewConnection) GetConnectionId() string {
@@ -276,23 +801,29 @@ func (x *NewConnection) GetConnectionId() string {
 	return ""
 }
 
-type Data struct {
-	state         protoimpl.MessageState
-	sizeCache     protoimpl.SizeCache
-	unknownFields protoimpl.UnknownFields
+func (x *NewConnection) GetTunnelId() string {
+	if x != nil {
+		return x.TunnelId
+	}
+	return ""
+}
 
-	ConnectionId string `protobuf:"bytes,1,opt,name=connection_id,json=connectionId,proto3" json:"connection_id,omitempty"`
+type Data struct {
+	state        protoimpl.MessageState `protogen:"open.v1"`
+	ConnectionId string                 `protobuf:"bytes,1,opt,name=connection_id,json=connectionId,proto3" json:"connection_id,omitempty"`
 	// данные - чанки
 	Chunk []byte `protobuf:"bytes,2,opt,name=chunk,proto3" json:"chunk,omitempty"`
+	// смещение первого байта чанка от начала соединения
+	Offset        uint64 `protobuf:"varint,3,opt,name=offset,proto3" json:"offset,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
 }
 
 func (x *Data) Reset() {
 	*x = Data{}
-	if protoimpl.UnsafeEnabled {
-		mi := &file_api_tunnel_proto_msgTypes[4]
-		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
-		ms.StoreMessageInfo(mi)
-	}
+	mi := &file_api_tunnel_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
 }
 
 func (x *Data) String() string {
@@ -302,8 +833,8 @@ func (x *Data) String() string {
 func (*Data) ProtoMessage() {}
 
 func (x *Data) ProtoReflect() protoreflect.Message {
-	mi := &file_api_tunnel_proto_msgTypes[4]
-	if protoimpl.UnsafeEnabled && x != nil {
+	mi := &file_api_tunnel_proto_msgTypes[8]
+	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
 			ms.StoreMessageInfo(mi)
@@ -315,7 +846,7 @@ func (x *Data) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Data.ProtoReflect.Descriptor instead.
 func (*Data) Descriptor() ([]byte, []int) {
-	return file_api_tunnel_proto_rawDescGZIP(), []int{4}
+	return file_api_tunnel_proto_rawDescGZIP(), []int{8}
 }
 
 func (x *Data) GetConnectionId() string {
@@ -332,79 +863,386 @@ func (x *Data) GetChunk() []byte {
 	return nil
 }
 
-var File_api_tunnel_proto protoreflect.FileDescriptor
+func (x *Data) GetOffset() uint64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+// Отправитель больше не будет писать в соединение, но продолжает читать
+type HalfClose struct {
+	state        protoimpl.MessageState `protogen:"open.v1"`
+	ConnectionId string                 `protobuf:"bytes,1,opt,name=connection_id,json=connectionId,proto3" json:"connection_id,omitempty"`
+	// сколько всего байт было отправлено в соединение
+	FinalOffset   uint64 `protobuf:"varint,2,opt,name=final_offset,json=finalOffset,proto3" json:"final_offset,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HalfClose) Reset() {
+	*x = HalfClose{}
+	mi := &file_api_tunnel_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HalfClose) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HalfClose) ProtoMessage() {}
+
+func (x *HalfClose) ProtoReflect() protoreflect.Message {
+	mi := &file_api_tunnel_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HalfClose.ProtoReflect.Descriptor instead.
+func (*HalfClose) Descriptor() ([]byte, []int) {
+	return file_api_tunnel_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *HalfClose) GetConnectionId() string {
+	if x != nil {
+		return x.ConnectionId
+	}
+	return ""
+}
+
+func (x *HalfClose) GetFinalOffset() uint64 {
+	if x != nil {
+		return x.FinalOffset
+	}
+	return 0
+}
+
+// Штатное закрытие соединения в обе стороны
+type Close struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ConnectionId  string                 `protobuf:"bytes,1,opt,name=connection_id,json=connectionId,proto3" json:"connection_id,omitempty"`
+	Reason        CloseReason            `protobuf:"varint,2,opt,name=reason,proto3,enum=tunnel.CloseReason" json:"reason,omitempty"`
+	FinalOffset   uint64                 `protobuf:"varint,3,opt,name=final_offset,json=finalOffset,proto3" json:"final_offset,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Close) Reset() {
+	*x = Close{}
+	mi := &file_api_tunnel_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Close) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
 
-var file_api_tunnel_proto_rawDesc = []byte{
-	0x0a, 0x10, 0x61, 0x70, 0x69, 0x2f, 0x74, 0x75, 0x6e, 0x6e, 0x65, 0x6c, 0x2e, 0x70, 0x72, 0x6f,
-	0x74, 0x6f, 0x12, 0x06, 0x74, 0x75, 0x6e, 0x6e, 0x65, 0x6c, 0x22, 0x6f, 0x0a, 0x0e, 0x43, 0x6c,
-	0x69, 0x65, 0x6e, 0x74, 0x54, 0x6f, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x12, 0x2e, 0x0a, 0x08,
-	0x72, 0x65, 0x67, 0x69, 0x73, 0x74, 0x65, 0x72, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x10,
-	0x2e, 0x74, 0x75, 0x6e, 0x6e, 0x65, 0x6c, 0x2e, 0x52, 0x65, 0x67, 0x69, 0x73, 0x74, 0x65, 0x72,
-	0x48, 0x00, 0x52, 0x08, 0x72, 0x65, 0x67, 0x69, 0x73, 0x74, 0x65, 0x72, 0x12, 0x22, 0x0a, 0x04,
-	0x64, 0x61, 0x74, 0x61, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0c, 0x2e, 0x74, 0x75, 0x6e,
-	0x6e, 0x65, 0x6c, 0x2e, 0x44, 0x61, 0x74, 0x61, 0x48, 0x00, 0x52, 0x04, 0x64, 0x61, 0x74, 0x61,
-	0x42, 0x09, 0x0a, 0x07, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x22, 0x7f, 0x0a, 0x0e, 0x53,
-	0x65, 0x72, 0x76, 0x65, 0x72, 0x54, 0x6f, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x12, 0x3e, 0x0a,
-	0x0e, 0x6e, 0x65, 0x77, 0x5f, 0x63, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x18,
-	0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x15, 0x2e, 0x74, 0x75, 0x6e, 0x6e, 0x65, 0x6c, 0x2e, 0x4e,
-	0x65, 0x77, 0x43, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x48, 0x00, 0x52, 0x0d,
-	0x6e, 0x65, 0x77, 0x43, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x22, 0x0a,
-	0x04, 0x64, 0x61, 0x74, 0x61, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0c, 0x2e, 0x74, 0x75,
-	0x6e, 0x6e, 0x65, 0x6c, 0x2e, 0x44, 0x61, 0x74, 0x61, 0x48, 0x00, 0x52, 0x04, 0x64, 0x61, 0x74,
-	0x61, 0x42, 0x09, 0x0a, 0x07, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x22, 0x27, 0x0a, 0x08,
-	0x52, 0x65, 0x67, 0x69, 0x73, 0x74, 0x65, 0x72, 0x12, 0x1b, 0x0a, 0x09, 0x74, 0x75, 0x6e, 0x6e,
-	0x65, 0x6c, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x74, 0x75, 0x6e,
-	0x6e, 0x65, 0x6c, 0x49, 0x64, 0x22, 0x34, 0x0a, 0x0d, 0x4e, 0x65, 0x77, 0x43, 0x6f, 0x6e, 0x6e,
-	0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x23, 0x0a, 0x0d, 0x63, 0x6f, 0x6e, 0x6e, 0x65, 0x63,
-	0x74, 0x69, 0x6f, 0x6e, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0c, 0x63,
-	0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x49, 0x64, 0x22, 0x41, 0x0a, 0x04, 0x44,
-	0x61, 0x74, 0x61, 0x12, 0x23, 0x0a, 0x0d, 0x63, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x69, 0x6f,
-	0x6e, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0c, 0x63, 0x6f, 0x6e, 0x6e,
-	0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x49, 0x64, 0x12, 0x14, 0x0a, 0x05, 0x63, 0x68, 0x75, 0x6e,
-	0x6b, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x05, 0x63, 0x68, 0x75, 0x6e, 0x6b, 0x32, 0x56,
-	0x0a, 0x0d, 0x54, 0x75, 0x6e, 0x6e, 0x65, 0x6c, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x12,
-	0x45, 0x0a, 0x0f, 0x45, 0x73, 0x74, 0x61, 0x62, 0x6c, 0x69, 0x73, 0x68, 0x54, 0x75, 0x6e, 0x6e,
-	0x65, 0x6c, 0x12, 0x16, 0x2e, 0x74, 0x75, 0x6e, 0x6e, 0x65, 0x6c, 0x2e, 0x43, 0x6c, 0x69, 0x65,
-	0x6e, 0x74, 0x54, 0x6f, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x1a, 0x16, 0x2e, 0x74, 0x75, 0x6e,
-	0x6e, 0x65, 0x6c, 0x2e, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x54, 0x6f, 0x43, 0x6c, 0x69, 0x65,
-	0x6e, 0x74, 0x28, 0x01, 0x30, 0x01, 0x42, 0x25, 0x5a, 0x23, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62,
-	0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x77, 0x61, 0x73, 0x74, 0x65, 0x33, 0x64, 0x2f, 0x67, 0x68, 0x6f,
-	0x73, 0x74, 0x2d, 0x74, 0x75, 0x6e, 0x6e, 0x65, 0x6c, 0x2f, 0x61, 0x70, 0x69, 0x62, 0x06, 0x70,
-	0x72, 0x6f, 0x74, 0x6f, 0x33,
+func (*Close) ProtoMessage() {}
+
+func (x *Close) ProtoReflect() protoreflect.Message {
+	mi := &file_api_tunnel_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
 }
 
+// Deprecated: Use Close.ProtoReflect.Descriptor instead.
+func (*Close) Descriptor() ([]byte, []int) {
+	return file_api_tunnel_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *Close) GetConnectionId() string {
+	if x != nil {
+		return x.ConnectionId
+	}
+	return ""
+}
+
+func (x *Close) GetReason() CloseReason {
+	if x != nil {
+		return x.Reason
+	}
+	return CloseReason_CLOSE_REASON_UNSPECIFIED
+}
+
+func (x *Close) GetFinalOffset() uint64 {
+	if x != nil {
+		return x.FinalOffset
+	}
+	return 0
+}
+
+// Аварийный обрыв соединения, недоставленные данные отбрасываются
+type Reset struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ConnectionId  string                 `protobuf:"bytes,1,opt,name=connection_id,json=connectionId,proto3" json:"connection_id,omitempty"`
+	Reason        CloseReason            `protobuf:"varint,2,opt,name=reason,proto3,enum=tunnel.CloseReason" json:"reason,omitempty"`
+	Message       string                 `protobuf:"bytes,3,opt,name=message,proto3" json:"message,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Reset) Reset() {
+	*x = Reset{}
+	mi := &file_api_tunnel_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Reset) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Reset) ProtoMessage() {}
+
+func (x *Reset) ProtoReflect() protoreflect.Message {
+	mi := &file_api_tunnel_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Reset.ProtoReflect.Descriptor instead.
+func (*Reset) Descriptor() ([]byte, []int) {
+	return file_api_tunnel_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *Reset) GetConnectionId() string {
+	if x != nil {
+		return x.ConnectionId
+	}
+	return ""
+}
+
+func (x *Reset) GetReason() CloseReason {
+	if x != nil {
+		return x.Reason
+	}
+	return CloseReason_CLOSE_REASON_UNSPECIFIED
+}
+
+func (x *Reset) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+// Получатель освободил increment байт буфера соединения и разрешает
+// отправить ещё столько же. Начальное окно каждой стороны — 256 КиБ.
+type WindowUpdate struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ConnectionId  string                 `protobuf:"bytes,1,opt,name=connection_id,json=connectionId,proto3" json:"connection_id,omitempty"`
+	Increment     uint32                 `protobuf:"varint,2,opt,name=increment,proto3" json:"increment,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WindowUpdate) Reset() {
+	*x = WindowUpdate{}
+	mi := &file_api_tunnel_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WindowUpdate) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WindowUpdate) ProtoMessage() {}
+
+func (x *WindowUpdate) ProtoReflect() protoreflect.Message {
+	mi := &file_api_tunnel_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WindowUpdate.ProtoReflect.Descriptor instead.
+func (*WindowUpdate) Descriptor() ([]byte, []int) {
+	return file_api_tunnel_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *WindowUpdate) GetConnectionId() string {
+	if x != nil {
+		return x.ConnectionId
+	}
+	return ""
+}
+
+func (x *WindowUpdate) GetIncrement() uint32 {
+	if x != nil {
+		return x.Increment
+	}
+	return 0
+}
+
+var File_api_tunnel_proto protoreflect.FileDescriptor
+
+const file_api_tunnel_proto_rawDesc = "" +
+	"\n" +
+	"\x10api/tunnel.proto\x12\x06tunnel\"\xc3\x02\n" +
+	"\x0eClientToServer\x12.\n" +
+	"\bregister\x18\x01 \x01(\v2\x10.tunnel.RegisterH\x00R\bregister\x12\"\n" +
+	"\x04data\x18\x02 \x01(\v2\f.tunnel.DataH\x00R\x04data\x122\n" +
+	"\n" +
+	"half_close\x18\x03 \x01(\v2\x11.tunnel.HalfCloseH\x00R\thalfClose\x12%\n" +
+	"\x05close\x18\x04 \x01(\v2\r.tunnel.CloseH\x00R\x05close\x12:\n" +
+	"\x10reset_connection\x18\x05 \x01(\v2\r.tunnel.ResetH\x00R\x0fresetConnection\x12;\n" +
+	"\rwindow_update\x18\x06 \x01(\v2\x14.tunnel.WindowUpdateH\x00R\fwindowUpdateB\t\n" +
+	"\amessage\"\x89\x03\n" +
+	"\x0eServerToClient\x12>\n" +
+	"\x0enew_connection\x18\x01 \x01(\v2\x15.tunnel.NewConnectionH\x00R\rnewConnection\x12\"\n" +
+	"\x04data\x18\x02 \x01(\v2\f.tunnel.DataH\x00R\x04data\x122\n" +
+	"\n" +
+	"half_close\x18\x03 \x01(\v2\x11.tunnel.HalfCloseH\x00R\thalfClose\x12%\n" +
+	"\x05close\x18\x04 \x01(\v2\r.tunnel.CloseH\x00R\x05close\x12:\n" +
+	"\x10reset_connection\x18\x05 \x01(\v2\r.tunnel.ResetH\x00R\x0fresetConnection\x12;\n" +
+	"\rwindow_update\x18\x06 \x01(\v2\x14.tunnel.WindowUpdateH\x00R\fwindowUpdate\x124\n" +
+	"\n" +
+	"registered\x18\a \x01(\v2\x12.tunnel.RegisteredH\x00R\n" +
+	"registeredB\t\n" +
+	"\amessage\"\xf5\x01\n" +
+	"\bRegister\x12\x1b\n" +
+	"\ttunnel_id\x18\x01 \x01(\tR\btunnelId\x12\x17\n" +
+	"\aapi_key\x18\x02 \x01(\tR\x06apiKey\x12!\n" +
+	"\fresume_token\x18\x03 \x01(\tR\vresumeToken\x129\n" +
+	"\vconnections\x18\x04 \x03(\v2\x17.tunnel.ConnectionStateR\vconnections\x12#\n" +
+	"\ragent_version\x18\x05 \x01(\tR\fagentVersion\x120\n" +
+	"\atunnels\x18\x06 \x03(\v2\x16.tunnel.TunnelRegisterR\atunnels\"\x8b\x01\n" +
+	"\x0eTunnelRegister\x12\x1b\n" +
+	"\ttunnel_id\x18\x01 \x01(\tR\btunnelId\x12!\n" +
+	"\fresume_token\x18\x02 \x01(\tR\vresumeToken\x129\n" +
+	"\vconnections\x18\x03 \x03(\v2\x17.tunnel.ConnectionStateR\vconnections\"\xb8\x01\n" +
+	"\n" +
+	"Registered\x12!\n" +
+	"\fresume_token\x18\x01 \x01(\tR\vresumeToken\x12\x18\n" +
+	"\aresumed\x18\x02 \x01(\bR\aresumed\x129\n" +
+	"\vconnections\x18\x03 \x03(\v2\x17.tunnel.ConnectionStateR\vconnections\x122\n" +
+	"\atunnels\x18\x04 \x03(\v2\x18.tunnel.TunnelRegisteredR\atunnels\"\xa7\x01\n" +
+	"\x10TunnelRegistered\x12\x1b\n" +
+	"\ttunnel_id\x18\x01 \x01(\tR\btunnelId\x12!\n" +
+	"\fresume_token\x18\x02 \x01(\tR\vresumeToken\x12\x18\n" +
+	"\aresumed\x18\x03 \x01(\bR\aresumed\x129\n" +
+	"\vconnections\x18\x04 \x03(\v2\x17.tunnel.ConnectionStateR\vconnections\"\x91\x01\n" +
+	"\x0fConnectionState\x12#\n" +
+	"\rconnection_id\x18\x01 \x01(\tR\fconnectionId\x12\x1a\n" +
+	"\breceived\x18\x02 \x01(\x04R\breceived\x12\x1a\n" +
+	"\bcredited\x18\x03 \x01(\x04R\bcredited\x12!\n" +
+	"\feof_received\x18\x04 \x01(\bR\veofReceived\"Q\n" +
+	"\rNewConnection\x12#\n" +
+	"\rconnection_id\x18\x01 \x01(\tR\fconnectionId\x12\x1b\n" +
+	"\ttunnel_id\x18\x02 \x01(\tR\btunnelId\"Y\n" +
+	"\x04Data\x12#\n" +
+	"\rconnection_id\x18\x01 \x01(\tR\fconnectionId\x12\x14\n" +
+	"\x05chunk\x18\x02 \x01(\fR\x05chunk\x12\x16\n" +
+	"\x06offset\x18\x03 \x01(\x04R\x06offset\"S\n" +
+	"\tHalfClose\x12#\n" +
+	"\rconnection_id\x18\x01 \x01(\tR\fconnectionId\x12!\n" +
+	"\ffinal_offset\x18\x02 \x01(\x04R\vfinalOffset\"|\n" +
+	"\x05Close\x12#\n" +
+	"\rconnection_id\x18\x01 \x01(\tR\fconnectionId\x12+\n" +
+	"\x06reason\x18\x02 \x01(\x0e2\x13.tunnel.CloseReasonR\x06reason\x12!\n" +
+	"\ffinal_offset\x18\x03 \x01(\x04R\vfinalOffset\"s\n" +
+	"\x05Reset\x12#\n" +
+	"\rconnection_id\x18\x01 \x01(\tR\fconnectionId\x12+\n" +
+	"\x06reason\x18\x02 \x01(\x0e2\x13.tunnel.CloseReasonR\x06reason\x12\x18\n" +
+	"\amessage\x18\x03 \x01(\tR\amessage\"Q\n" +
+	"\fWindowUpdate\x12#\n" +
+	"\rconnection_id\x18\x01 \x01(\tR\fconnectionId\x12\x1c\n" +
+	"\tincrement\x18\x02 \x01(\rR\tincrement*\xbb\x01\n" +
+	"\vCloseReason\x12\x1c\n" +
+	"\x18CLOSE_REASON_UNSPECIFIED\x10\x00\x12\x15\n" +
+	"\x11CLOSE_REASON_DONE\x10\x01\x12\x1c\n" +
+	"\x18CLOSE_REASON_DIAL_FAILED\x10\x02\x12\x19\n" +
+	"\x15CLOSE_REASON_IO_ERROR\x10\x03\x12\x19\n" +
+	"\x15CLOSE_REASON_SHUTDOWN\x10\x04\x12#\n" +
+	"\x1fCLOSE_REASON_FLOW_CONTROL_ERROR\x10\x052V\n" +
+	"\rTunnelService\x12E\n" +
+	"\x0fEstablishTunnel\x12\x16.tunnel.ClientToServer\x1a\x16.tunnel.ServerToClient(\x010\x01B%Z#github.com/waste3d/ghost-tunnel/apib\x06proto3"
+
 var (
 	file_api_tunnel_proto_rawDescOnce sync.Once
-	file_api_tunnel_proto_rawDescData = file_api_tunnel_proto_rawDesc
+	file_api_tunnel_proto_rawDescData []byte
 )
 
 func file_api_tunnel_proto_rawDescGZIP() []byte {
 	file_api_tunnel_proto_rawDescOnce.Do(func() {
-		file_api_tunnel_proto_rawDescData = protoimpl.X.CompressGZIP(file_api_tunnel_proto_rawDescData)
+		file_api_tunnel_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_api_tunnel_proto_rawDesc), len(file_api_tunnel_proto_rawDesc)))
 	})
 	return file_api_tunnel_proto_rawDescData
 }
 
-var file_api_tunnel_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
-var file_api_tunnel_proto_goTypes = []interface{}{
-	(*ClientToServer)(nil), // 0: tunnel.ClientToServer
-	(*ServerToClient)(nil), // 1: tunnel.ServerToClient
-	(*Register)(nil),       // 2: tunnel.Register
-	(*NewConnection)(nil),  // 3: tunnel.NewConnection
-	(*Data)(nil),           // 4: tunnel.Data
+var file_api_tunnel_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_api_tunnel_proto_msgTypes = make([]protoimpl.MessageInfo, 13)
+var file_api_tunnel_proto_goTypes = []any{
+	(CloseReason)(0),         // 0: tunnel.CloseReason
+	(*ClientToServer)(nil),   // 1: tunnel.ClientToServer
+	(*ServerToClient)(nil),   // 2: tunnel.ServerToClient
+	(*Register)(nil),         // 3: tunnel.Register
+	(*TunnelRegister)(nil),   // 4: tunnel.TunnelRegister
+	(*Registered)(nil),       // 5: tunnel.Registered
+	(*TunnelRegistered)(nil), // 6: tunnel.TunnelRegistered
+	(*ConnectionState)(nil),  // 7: tunnel.ConnectionState
+	(*NewConnection)(nil),    // 8: tunnel.NewConnection
+	(*Data)(nil),             // 9: tunnel.Data
+	(*HalfClose)(nil),        // 10: tunnel.HalfClose
+	(*Close)(nil),            // 11: tunnel.Close
+	(*Reset)(nil),            // 12: tunnel.Reset
+	(*WindowUpdate)(nil),     // 13: tunnel.WindowUpdate
 }
 var file_api_tunnel_proto_depIdxs = []int32{
-	2, // 0: tunnel.ClientToServer.register:type_name -> tunnel.Register
-	4, // 1: tunnel.ClientToServer.data:type_name -> tunnel.Data
-	3, // 2: tunnel.ServerToClient.new_connection:type_name -> tunnel.NewConnection
-	4, // 3: tunnel.ServerToClient.data:type_name -> tunnel.Data
-	0, // 4: tunnel.TunnelService.EstablishTunnel:input_type -> tunnel.ClientToServer
-	1, // 5: tunnel.TunnelService.EstablishTunnel:output_type -> tunnel.ServerToClient
-	5, // [5:6] is the sub-list for method output_type
-	4, // [4:5] is the sub-list for method input_type
-	4, // [4:4] is the sub-list for extension type_name
-	4, // [4:4] is the sub-list for extension extendee
-	0, // [0:4] is the sub-list for field type_name
+	3,  // 0: tunnel.ClientToServer.register:type_name -> tunnel.Register
+	9,  // 1: tunnel.ClientToServer.data:type_name -> tunnel.Data
+	10, // 2: tunnel.ClientToServer.half_close:type_name -> tunnel.HalfClose
+	11, // 3: tunnel.ClientToServer.close:type_name -> tunnel.Close
+	12, // 4: tunnel.ClientToServer.reset_connection:type_name -> tunnel.Reset
+	13, // 5: tunnel.ClientToServer.window_update:type_name -> tunnel.WindowUpdate
+	8,  // 6: tunnel.ServerToClient.new_connection:type_name -> tunnel.NewConnection
+	9,  // 7: tunnel.ServerToClient.data:type_name -> tunnel.Data
+	10, // 8: tunnel.ServerToClient.half_close:type_name -> tunnel.HalfClose
+	11, // 9: tunnel.ServerToClient.close:type_name -> tunnel.Close
+	12, // 10: tunnel.ServerToClient.reset_connection:type_name -> tunnel.Reset
+	13, // 11: tunnel.ServerToClient.window_update:type_name -> tunnel.WindowUpdate
+	5,  // 12: tunnel.ServerToClient.registered:type_name -> tunnel.Registered
+	7,  // 13: tunnel.Register.connections:type_name -> tunnel.ConnectionState
+	4,  // 14: tunnel.Register.tunnels:type_name -> tunnel.TunnelRegister
+	7,  // 15: tunnel.TunnelRegister.connections:type_name -> tunnel.ConnectionState
+	7,  // 16: tunnel.Registered.connections:type_name -> tunnel.ConnectionState
+	6,  // 17: tunnel.Registered.tunnels:type_name -> tunnel.TunnelRegistered
+	7,  // 18: tunnel.TunnelRegistered.connections:type_name -> tunnel.ConnectionState
+	0,  // 19: tunnel.Close.reason:type_name -> tunnel.CloseReason
+	0,  // 20: tunnel.Reset.reason:type_name -> tunnel.CloseReason
+	1,  // 21: tunnel.TunnelService.EstablishTunnel:input_type -> tunnel.ClientToServer
+	2,  // 22: tunnel.TunnelService.EstablishTunnel:output_type -> tunnel.ServerToClient
+	22, // [22:23] is the sub-list for method output_type
+	21, // [21:22] is the sub-list for method input_type
+	21, // [21:21] is the sub-list for extension type_name
+	21, // [21:21] is the sub-list for extension extendee
+	0,  // [0:21] is the sub-list for field type_name
 }
 
 func init() { file_api_tunnel_proto_init() }
@@ -412,92 +1250,39 @@ func file_api_tunnel_proto_init() {
 	if File_api_tunnel_proto != nil {
 		return
 	}
-	if !protoimpl.UnsafeEnabled {
-		file_api_tunnel_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*ClientToServer); i {
-			case 0:
-				return &v.state
-			case 1:
-				return &v.sizeCache
-			case 2:
-				return &v.unknownFields
-			default:
-				return nil
-			}
-		}
-		file_api_tunnel_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*ServerToClient); i {
-			case 0:
-				return &v.state
-			case 1:
-				return &v.sizeCache
-			case 2:
-				return &v.unknownFields
-			default:
-				return nil
-			}
-		}
-		file_api_tunnel_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*Register); i {
-			case 0:
-				return &v.state
-			case 1:
-				return &v.sizeCache
-			case 2:
-				return &v.unknownFields
-			default:
-				return nil
-			}
-		}
-		file_api_tunnel_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*NewConnection); i {
-			case 0:
-				return &v.state
-			case 1:
-				return &v.sizeCache
-			case 2:
-				return &v.unknownFields
-			default:
-				return nil
-			}
-		}
-		file_api_tunnel_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*Data); i {
-			case 0:
-				return &v.state
-			case 1:
-				return &v.sizeCache
-			case 2:
-				return &v.unknownFields
-			default:
-				return nil
-			}
-		}
-	}
-	file_api_tunnel_proto_msgTypes[0].OneofWrappers = []interface{}{
+	file_api_tunnel_proto_msgTypes[0].OneofWrappers = []any{
 		(*ClientToServer_Register)(nil),
 		(*ClientToServer_Data)(nil),
+		(*ClientToServer_HalfClose)(nil),
+		(*ClientToServer_Close)(nil),
+		(*ClientToServer_ResetConnection)(nil),
+		(*ClientToServer_WindowUpdate)(nil),
 	}
-	file_api_tunnel_proto_msgTypes[1].OneofWrappers = []interface{}{
+	file_api_tunnel_proto_msgTypes[1].OneofWrappers = []any{
 		(*ServerToClient_NewConnection)(nil),
 		(*ServerToClient_Data)(nil),
+		(*ServerToClient_HalfClose)(nil),
+		(*ServerToClient_Close)(nil),
+		(*ServerToClient_ResetConnection)(nil),
+		(*ServerToClient_WindowUpdate)(nil),
+		(*ServerToClient_Registered)(nil),
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
-			RawDescriptor: file_api_tunnel_proto_rawDesc,
-			NumEnums:      0,
-			NumMessages:   5,
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_api_tunnel_proto_rawDesc), len(file_api_tunnel_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   13,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
 		GoTypes:           file_api_tunnel_proto_goTypes,
 		DependencyIndexes: file_api_tunnel_proto_depIdxs,
+		EnumInfos:         file_api_tunnel_proto_enumTypes,
 		MessageInfos:      file_api_tunnel_proto_msgTypes,
 	}.Build()
 	File_api_tunnel_proto = out.File
-	file_api_tunnel_proto_rawDesc = nil
 	file_api_tunnel_proto_goTypes = nil
 	file_api_tunnel_proto_depIdxs = nil
 }
@@ -2,18 +2,56 @@ package main
 
 import (
 	"context"
-	"log"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/spf13/pflag"
 
 	"github.com/waste3d/ghost-tunnel/internal/app"
+	"github.com/waste3d/ghost-tunnel/internal/infrastructure/logging"
 )
 
 func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	application, err := app.New(ctx)
+	// ghost-tunnel-server migrate up|down|status [flags] — управление схемой БД
+	args := os.Args[1:]
+	migrateCmd := ""
+	if len(args) > 0 && args[0] == "migrate" {
+		if len(args) < 2 {
+			fmt.Fprintf(os.Stderr, "usage: %s migrate %v [flags]\n", os.Args[0], app.MigrateCommands)
+			os.Exit(2)
+		}
+		migrateCmd, args = args[1], args[2:]
+	}
+
+	flags := pflag.NewFlagSet(os.Args[0], pflag.ExitOnError)
+	app.BindConfigFlags(flags)
+	flags.Parse(args)
+
+	cfg, err := app.LoadConfig(flags)
+	if err != nil {
+		logging.Fatal("Failed to load config", logging.Err(err))
+	}
+	if err := logging.Setup(cfg.Log.Level, cfg.Log.Format); err != nil {
+		logging.Fatal("Failed to set up logging", logging.Err(err))
+	}
+	if cfg.File != "" {
+		slog.Info("Using config file", "path", cfg.File)
+	}
+
+	if migrateCmd != "" {
+		if err := app.Migrate(ctx, cfg, migrateCmd); err != nil {
+			logging.Fatal("Migration failed", logging.Err(err))
+		}
+		return
+	}
+
+	application, err := app.New(ctx, cfg)
 	if err != nil {
-		log.Fatalf("Failed to create application: %v", err)
+		logging.Fatal("Failed to create application", logging.Err(err))
 	}
 
 	application.Run()
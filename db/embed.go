@@ -0,0 +1,28 @@
+// Package db встраивает SQL-миграции в бинарник сервера.
+package db
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed migrations/*.sql sqlite_migrations/*.sql
+var migrations embed.FS
+
+// Migrations — каталог goose-миграций Postgres.
+func Migrations() fs.FS {
+	return sub("migrations")
+}
+
+// SQLiteMigrations — отдельный набор миграций для SQLite.
+func SQLiteMigrations() fs.FS {
+	return sub("sqlite_migrations")
+}
+
+func sub(dir string) fs.FS {
+	sub, err := fs.Sub(migrations, dir)
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
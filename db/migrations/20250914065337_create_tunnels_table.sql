@@ -1,6 +1,8 @@
 -- +goose Up
+-- Первые базы создавались без goose, поэтому начальная схема создаётся
+-- только если её ещё нет: `migrate up` принимает такую базу под учёт.
 -- +goose StatementBegin
-create table tunnels (
+create table if not exists tunnels (
     id UUID PRIMARY KEY,
     subdomain VARCHAR(63) UNIQUE NOT NULL,
     local_host VARCHAR(255) NOT NULL,
@@ -9,7 +11,7 @@ create table tunnels (
     created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
 );
 
-create index idx_tunnels_subdomain on tunnels (subdomain);
+create index if not exists idx_tunnels_subdomain on tunnels (subdomain);
 -- +goose StatementEnd
 
 -- +goose Down
@@ -1,8 +1,9 @@
 -- +goose Up
--- Этот блок выполняется, когда мы накатываем миграцию (goose up)
+-- Этот блок выполняется, когда мы накатываем миграцию (goose up).
+-- Как и создание tunnels, шаги пропускаются, если база уже в этом состоянии.
 
 -- Шаг 1: Создаем таблицу для пользователей
-CREATE TABLE users (
+CREATE TABLE IF NOT EXISTS users (
     id UUID PRIMARY KEY,
     email VARCHAR(255) UNIQUE NOT NULL,
     password_hash VARCHAR(255) NOT NULL,
@@ -11,15 +12,24 @@ CREATE TABLE users (
 );
 
 -- Шаг 2: Добавляем колонку user_id в существующую таблицу tunnels
-ALTER TABLE tunnels ADD COLUMN user_id UUID;
+ALTER TABLE tunnels ADD COLUMN IF NOT EXISTS user_id UUID;
 
 -- Шаг 3: Создаем внешний ключ (foreign key), чтобы связать таблицы
 -- Это гарантирует, что в tunnels.user_id может быть только ID существующего пользователя
-ALTER TABLE tunnels 
-ADD CONSTRAINT fk_user
-FOREIGN KEY (user_id) 
-REFERENCES users(id) 
-ON DELETE CASCADE; -- Если удалить пользователя, все его туннели удалятся автоматически
+-- Если удалить пользователя, все его туннели удалятся автоматически
+-- +goose StatementBegin
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'fk_user' AND conrelid = 'tunnels'::regclass) THEN
+        ALTER TABLE tunnels
+        ADD CONSTRAINT fk_user
+        FOREIGN KEY (user_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE;
+    END IF;
+END
+$$;
+-- +goose StatementEnd
 
 -- +goose Down
 -- Этот блок выполняется, когда мы откатываем миграцию (goose down)
@@ -0,0 +1,10 @@
+-- +goose Up
+-- protocol: http или tcp; public_port заполнен только у TCP-туннелей
+ALTER TABLE tunnels ADD COLUMN protocol VARCHAR(10) NOT NULL DEFAULT 'http';
+ALTER TABLE tunnels ADD COLUMN public_port INT;
+ALTER TABLE tunnels ADD CONSTRAINT tunnels_public_port_key UNIQUE (public_port);
+
+-- +goose Down
+ALTER TABLE tunnels DROP CONSTRAINT tunnels_public_port_key;
+ALTER TABLE tunnels DROP COLUMN public_port;
+ALTER TABLE tunnels DROP COLUMN protocol;
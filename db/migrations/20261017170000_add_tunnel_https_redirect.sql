@@ -0,0 +1,6 @@
+-- +goose Up
+-- Перенаправлять ли HTTP-запросы к туннелю на HTTPS
+ALTER TABLE tunnels ADD COLUMN https_redirect BOOLEAN NOT NULL DEFAULT false;
+
+-- +goose Down
+ALTER TABLE tunnels DROP COLUMN https_redirect;
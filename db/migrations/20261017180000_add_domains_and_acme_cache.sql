@@ -0,0 +1,23 @@
+-- +goose Up
+-- Собственные домены пользователей, привязанные к туннелям
+CREATE TABLE domains (
+    hostname VARCHAR(253) PRIMARY KEY,
+    tunnel_id UUID NOT NULL REFERENCES tunnels(id) ON DELETE CASCADE,
+    cert_status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    cert_error TEXT NOT NULL DEFAULT '',
+    cert_expires_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_domains_tunnel_id ON domains (tunnel_id);
+
+-- Кэш ACME-клиента: ключ аккаунта и выпущенные сертификаты
+CREATE TABLE acme_cache (
+    key VARCHAR(255) PRIMARY KEY,
+    data BYTEA NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS acme_cache;
+DROP TABLE IF EXISTS domains;
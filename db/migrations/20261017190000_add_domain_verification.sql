@@ -0,0 +1,14 @@
+-- +goose Up
+-- Подтверждение владения собственным доменом через DNS (TXT или CNAME)
+ALTER TABLE domains ADD COLUMN verification_token VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE domains ADD COLUMN verified_at TIMESTAMPTZ;
+-- уже привязанные домены тоже должны пройти проверку
+UPDATE domains SET verification_token = md5(random()::text || hostname);
+
+-- Базовый домен туннеля хранится вместе с ним, а не подставляется при чтении
+ALTER TABLE tunnels ADD COLUMN domain VARCHAR(253) NOT NULL DEFAULT 'waste3d.ru';
+
+-- +goose Down
+ALTER TABLE tunnels DROP COLUMN IF EXISTS domain;
+ALTER TABLE domains DROP COLUMN IF EXISTS verified_at;
+ALTER TABLE domains DROP COLUMN IF EXISTS verification_token;
@@ -0,0 +1,21 @@
+-- +goose Up
+-- Использование туннелей по минутам, см. domain.StatsBucketSize
+CREATE TABLE tunnel_stats (
+    tunnel_id UUID NOT NULL REFERENCES tunnels(id) ON DELETE CASCADE,
+    bucket_start TIMESTAMPTZ NOT NULL,
+    requests BIGINT NOT NULL DEFAULT 0,
+    connections BIGINT NOT NULL DEFAULT 0,
+    bytes_in BIGINT NOT NULL DEFAULT 0,
+    bytes_out BIGINT NOT NULL DEFAULT 0,
+    status_1xx BIGINT NOT NULL DEFAULT 0,
+    status_2xx BIGINT NOT NULL DEFAULT 0,
+    status_3xx BIGINT NOT NULL DEFAULT 0,
+    status_4xx BIGINT NOT NULL DEFAULT 0,
+    status_5xx BIGINT NOT NULL DEFAULT 0,
+    latency_total_us BIGINT NOT NULL DEFAULT 0,
+    latency_max_us BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (tunnel_id, bucket_start)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS tunnel_stats;
@@ -0,0 +1,8 @@
+-- +goose Up
+-- Закреплён ли поддомен за пользователем. Туннели со случайным поддоменом
+-- (10 hex-символов) закреплёнными не считаются.
+ALTER TABLE tunnels ADD COLUMN reserved BOOLEAN NOT NULL DEFAULT false;
+UPDATE tunnels SET reserved = true WHERE subdomain !~ '^[0-9a-f]{10}$';
+
+-- +goose Down
+ALTER TABLE tunnels DROP COLUMN reserved;
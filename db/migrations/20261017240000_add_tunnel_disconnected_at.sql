@@ -0,0 +1,6 @@
+-- +goose Up
+-- Когда агент последний раз отключился: по нему удаляются заброшенные туннели
+ALTER TABLE tunnels ADD COLUMN last_disconnected_at TIMESTAMPTZ;
+
+-- +goose Down
+ALTER TABLE tunnels DROP COLUMN last_disconnected_at;
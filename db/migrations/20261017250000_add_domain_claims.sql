@@ -0,0 +1,17 @@
+-- +goose Up
+-- На имя могут претендовать несколько туннелей, пока ни один его не подтвердил:
+-- неподтверждённая заявка больше не блокирует настоящего владельца домена
+ALTER TABLE domains DROP CONSTRAINT domains_pkey;
+ALTER TABLE domains ADD PRIMARY KEY (hostname, tunnel_id);
+CREATE UNIQUE INDEX idx_domains_verified_hostname ON domains (hostname) WHERE verified_at IS NOT NULL;
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_domains_verified_hostname;
+-- у имени остаётся одна заявка: подтверждённая, иначе самая ранняя
+DELETE FROM domains WHERE ctid IN (
+    SELECT ctid FROM (
+        SELECT ctid, row_number() OVER (PARTITION BY hostname ORDER BY verified_at IS NULL, created_at) AS n FROM domains
+    ) ranked WHERE n > 1
+);
+ALTER TABLE domains DROP CONSTRAINT domains_pkey;
+ALTER TABLE domains ADD PRIMARY KEY (hostname);
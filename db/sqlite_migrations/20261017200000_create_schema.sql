@@ -0,0 +1,49 @@
+-- +goose Up
+-- Схема SQLite соответствует итоговой схеме Postgres из db/migrations
+CREATE TABLE users (
+    id TEXT PRIMARY KEY,
+    email TEXT UNIQUE NOT NULL,
+    password_hash TEXT NOT NULL,
+    api_key TEXT UNIQUE NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- protocol: http или tcp; public_port заполнен только у TCP-туннелей
+CREATE TABLE tunnels (
+    id TEXT PRIMARY KEY,
+    user_id TEXT REFERENCES users(id) ON DELETE CASCADE,
+    protocol TEXT NOT NULL DEFAULT 'http',
+    subdomain TEXT UNIQUE NOT NULL,
+    domain TEXT NOT NULL,
+    public_port INTEGER UNIQUE,
+    local_host TEXT NOT NULL,
+    local_port INTEGER NOT NULL,
+    https_redirect BOOLEAN NOT NULL DEFAULT 0,
+    status TEXT NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE domains (
+    hostname TEXT PRIMARY KEY,
+    tunnel_id TEXT NOT NULL REFERENCES tunnels(id) ON DELETE CASCADE,
+    verification_token TEXT NOT NULL DEFAULT '',
+    verified_at DATETIME,
+    cert_status TEXT NOT NULL DEFAULT 'pending',
+    cert_error TEXT NOT NULL DEFAULT '',
+    cert_expires_at DATETIME,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_domains_tunnel_id ON domains (tunnel_id);
+
+CREATE TABLE acme_cache (
+    key TEXT PRIMARY KEY,
+    data BLOB NOT NULL,
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS acme_cache;
+DROP TABLE IF EXISTS domains;
+DROP TABLE IF EXISTS tunnels;
+DROP TABLE IF EXISTS users;
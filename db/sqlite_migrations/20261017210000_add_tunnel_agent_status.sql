@@ -0,0 +1,10 @@
+-- +goose Up
+-- Когда и какой агент последний раз подключался к туннелю
+ALTER TABLE tunnels ADD COLUMN last_connected_at DATETIME;
+ALTER TABLE tunnels ADD COLUMN agent_version TEXT NOT NULL DEFAULT '';
+ALTER TABLE tunnels ADD COLUMN agent_addr TEXT NOT NULL DEFAULT '';
+
+-- +goose Down
+ALTER TABLE tunnels DROP COLUMN agent_addr;
+ALTER TABLE tunnels DROP COLUMN agent_version;
+ALTER TABLE tunnels DROP COLUMN last_connected_at;
@@ -0,0 +1,21 @@
+-- +goose Up
+-- Использование туннелей по минутам, см. domain.StatsBucketSize
+CREATE TABLE tunnel_stats (
+    tunnel_id TEXT NOT NULL REFERENCES tunnels(id) ON DELETE CASCADE,
+    bucket_start DATETIME NOT NULL,
+    requests INTEGER NOT NULL DEFAULT 0,
+    connections INTEGER NOT NULL DEFAULT 0,
+    bytes_in INTEGER NOT NULL DEFAULT 0,
+    bytes_out INTEGER NOT NULL DEFAULT 0,
+    status_1xx INTEGER NOT NULL DEFAULT 0,
+    status_2xx INTEGER NOT NULL DEFAULT 0,
+    status_3xx INTEGER NOT NULL DEFAULT 0,
+    status_4xx INTEGER NOT NULL DEFAULT 0,
+    status_5xx INTEGER NOT NULL DEFAULT 0,
+    latency_total_us INTEGER NOT NULL DEFAULT 0,
+    latency_max_us INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (tunnel_id, bucket_start)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS tunnel_stats;
@@ -0,0 +1,8 @@
+-- +goose Up
+-- Закреплён ли поддомен за пользователем. Туннели со случайным поддоменом
+-- (10 hex-символов) закреплёнными не считаются.
+ALTER TABLE tunnels ADD COLUMN reserved BOOLEAN NOT NULL DEFAULT false;
+UPDATE tunnels SET reserved = true WHERE length(subdomain) != 10 OR subdomain GLOB '*[^0-9a-f]*';
+
+-- +goose Down
+ALTER TABLE tunnels DROP COLUMN reserved;
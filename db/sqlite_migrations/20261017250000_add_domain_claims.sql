@@ -0,0 +1,40 @@
+-- +goose Up
+-- На имя могут претендовать несколько туннелей, пока ни один его не подтвердил:
+-- неподтверждённая заявка больше не блокирует настоящего владельца домена.
+-- SQLite не меняет первичный ключ, поэтому таблица пересоздаётся
+CREATE TABLE domains_new (
+    hostname TEXT NOT NULL,
+    tunnel_id TEXT NOT NULL REFERENCES tunnels(id) ON DELETE CASCADE,
+    verification_token TEXT NOT NULL DEFAULT '',
+    verified_at DATETIME,
+    cert_status TEXT NOT NULL DEFAULT 'pending',
+    cert_error TEXT NOT NULL DEFAULT '',
+    cert_expires_at DATETIME,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (hostname, tunnel_id)
+);
+INSERT INTO domains_new SELECT hostname, tunnel_id, verification_token, verified_at, cert_status, cert_error, cert_expires_at, created_at FROM domains;
+DROP TABLE domains;
+ALTER TABLE domains_new RENAME TO domains;
+CREATE INDEX idx_domains_tunnel_id ON domains (tunnel_id);
+CREATE UNIQUE INDEX idx_domains_verified_hostname ON domains (hostname) WHERE verified_at IS NOT NULL;
+
+-- +goose Down
+-- у имени остаётся одна заявка: подтверждённая, иначе самая ранняя
+CREATE TABLE domains_old (
+    hostname TEXT PRIMARY KEY,
+    tunnel_id TEXT NOT NULL REFERENCES tunnels(id) ON DELETE CASCADE,
+    verification_token TEXT NOT NULL DEFAULT '',
+    verified_at DATETIME,
+    cert_status TEXT NOT NULL DEFAULT 'pending',
+    cert_error TEXT NOT NULL DEFAULT '',
+    cert_expires_at DATETIME,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+INSERT INTO domains_old
+SELECT hostname, tunnel_id, verification_token, verified_at, cert_status, cert_error, cert_expires_at, created_at FROM (
+    SELECT *, row_number() OVER (PARTITION BY hostname ORDER BY verified_at IS NULL, julianday(created_at)) AS n FROM domains
+) WHERE n = 1;
+DROP TABLE domains;
+ALTER TABLE domains_old RENAME TO domains;
+CREATE INDEX idx_domains_tunnel_id ON domains (tunnel_id);
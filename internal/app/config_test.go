@@ -0,0 +1,106 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// loadConfig пишет yaml во временный файл и загружает его с флагами args.
+func loadConfig(t *testing.T, yaml string, args ...string) (*Config, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "server.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	fs := pflag.NewFlagSet("server", pflag.ContinueOnError)
+	BindConfigFlags(fs)
+	if err := fs.Parse(append([]string{"--config", path}, args...)); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	return LoadConfig(fs)
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	t.Setenv("GHOST_TUNNEL_DOMAINS_BASE", "env.test")
+	t.Setenv("GHOST_TUNNEL_LOG_LEVEL", "debug")
+	t.Setenv("GHOST_TUNNEL_LISTENERS_PUBLIC_HTTPS", "")
+
+	cfg, err := loadConfig(t, `
+listeners:
+  api: ":9001"
+  public_https: ":9443"
+database:
+  dsn: memory://
+domains:
+  base: file.test
+log:
+  level: warn
+tunnel_gc:
+  ttl: 24h
+`, "--log-level", "error")
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+
+	tests := []struct {
+		key, got, want string
+	}{
+		{"listeners.api (file)", cfg.Listeners.API, ":9001"},
+		{"listeners.grpc (default)", cfg.Listeners.GRPC, ":50051"},
+		{"listeners.public_https (empty env)", cfg.Listeners.PublicHTTPS, ""},
+		{"domains.base (env over file)", cfg.Domains.Base, "env.test"},
+		{"log.level (flag over env)", cfg.Log.Level, "error"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.key, tt.got, tt.want)
+		}
+	}
+	if cfg.TunnelGC.TTL != 24*time.Hour || cfg.TunnelGC.Enabled {
+		t.Errorf("tunnel_gc = %+v, want ttl from the file and GC disabled by default", cfg.TunnelGC)
+	}
+}
+
+func TestLoadConfigValidation(t *testing.T) {
+	_, err := loadConfig(t, `
+database:
+  dsn: mysql://localhost
+domains:
+  base: "not a domain"
+limits:
+  tcp_port_min: 30000
+  tcp_port_max: 20000
+log:
+  format: xml
+`)
+	if err == nil {
+		t.Fatal("LoadConfig accepted an invalid config")
+	}
+	// ошибки возвращаются все сразу
+	for _, key := range []string{"database.dsn", "domains.base", "limits", "log.format"} {
+		if !strings.Contains(err.Error(), key+":") {
+			t.Errorf("error %q does not mention %s", err, key)
+		}
+	}
+
+	// флаг с неверным значением тоже проверяется
+	if _, err := loadConfig(t, "database:\n  dsn: memory://\n", "--grpc-addr", "no-port"); err == nil || !strings.Contains(err.Error(), "listeners.grpc") {
+		t.Errorf("LoadConfig with --grpc-addr no-port = %v, want listeners.grpc error", err)
+	}
+}
+
+func TestLoadConfigMissingFile(t *testing.T) {
+	fs := pflag.NewFlagSet("server", pflag.ContinueOnError)
+	BindConfigFlags(fs)
+	if err := fs.Parse([]string{"--config", filepath.Join(t.TempDir(), "missing.yaml")}); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if _, err := LoadConfig(fs); err == nil {
+		t.Error("LoadConfig with a missing --config file succeeded")
+	}
+}
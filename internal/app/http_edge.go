@@ -0,0 +1,274 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/http/httputil"
+	"strings"
+	"time"
+
+	"github.com/waste3d/ghost-tunnel/internal/application"
+	"github.com/waste3d/ghost-tunnel/internal/domain"
+	"github.com/waste3d/ghost-tunnel/internal/infrastructure/logging"
+	"github.com/waste3d/ghost-tunnel/internal/infrastructure/metrics"
+	"github.com/waste3d/ghost-tunnel/internal/infrastructure/mux"
+	tunnelgrpc "github.com/waste3d/ghost-tunnel/internal/interfaces/grpc"
+)
+
+// tunnelHostSuffix — служебный хост, по которому http.Transport держит
+// отдельный пул соединений для каждого туннеля: "<tunnel id>.tunnel".
+const tunnelHostSuffix = ".tunnel"
+
+var errTunnelOffline = errors.New("tunnel agent is offline")
+
+// httpEdge — публичный HTTP reverse proxy. Каждый запрос на постоянном
+// соединении клиента маршрутизируется по Host отдельно, а соединения к
+// агенту переиспользуются между запросами через пул http.Transport.
+//
+// Upgrade-запросы (WebSocket, HMR dev-серверов) ReverseProxy передаёт как
+// есть, а после 101 Switching Protocols переключает сокет клиента в
+// двусторонний поток байтов поверх соединения туннеля до его закрытия.
+//
+// Туннель выбирается по полному имени хоста: <subdomain>.<baseDomain> или
+// подтверждённый собственный домен. Один и тот же обработчик обслуживает
+// HTTP и HTTPS слушатели.
+type httpEdge struct {
+	sm            *tunnelgrpc.SessionManager
+	connMgr       *tunnelgrpc.ConnectionManager
+	tunnelRepo    domain.TunnelRepository
+	domainService *application.DomainService
+	metrics       *metrics.Metrics
+	usage         *application.UsageRecorder
+	baseDomain    string
+	proxy         *httputil.ReverseProxy
+	// httpsPort — порт HTTPS слушателя для редиректов; 0, если TLS выключен
+	httpsPort int
+}
+
+func newHTTPEdge(sm *tunnelgrpc.SessionManager, connMgr *tunnelgrpc.ConnectionManager, tunnelRepo domain.TunnelRepository, domainService *application.DomainService, m *metrics.Metrics, usage *application.UsageRecorder, baseDomain string, httpsPort int) *httpEdge {
+	e := &httpEdge{
+		sm:            sm,
+		connMgr:       connMgr,
+		tunnelRepo:    tunnelRepo,
+		domainService: domainService,
+		metrics:       m,
+		usage:         usage,
+		baseDomain:    strings.ToLower(baseDomain),
+		httpsPort:     httpsPort,
+	}
+
+	transport := &http.Transport{
+		DialContext:         e.dialTunnel,
+		MaxIdleConnsPerHost: 16,
+		IdleConnTimeout:     90 * time.Second,
+		// ответ сервиса отдаём клиенту как есть
+		DisableCompression: true,
+	}
+	e.proxy = &httputil.ReverseProxy{
+		Rewrite:        e.rewrite,
+		Transport:      transport,
+		ErrorHandler:   e.proxyError,
+		ModifyResponse: e.modifyResponse,
+		// стриминговые ответы (SSE, long polling) не должны копиться в буфере
+		FlushInterval: -1,
+	}
+	return e
+}
+
+type tunnelCtxKey struct{}
+
+// startCtxKey — время начала запроса, от него считается задержка в статистике
+type startCtxKey struct{}
+
+func (e *httpEdge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	// ID запроса уходит локальному сервису и возвращается посетителю,
+	// по нему запрос находится в логах сервера и агента
+	requestID := logging.RequestID(r.Header.Get(logging.HeaderRequestID))
+	r.Header.Set(logging.HeaderRequestID, requestID)
+	w.Header().Set(logging.HeaderRequestID, requestID)
+	logger := slog.Default().With(logging.KeyRequestID, requestID, logging.KeyRemoteAddr, r.RemoteAddr)
+
+	// Запрос должен идти к тому же хосту, для которого клиент открыл TLS,
+	// иначе через сертификат одного туннеля можно достучаться до другого
+	if r.TLS != nil && r.TLS.ServerName != "" && !strings.EqualFold(hostname(r.Host), r.TLS.ServerName) {
+		http.Error(w, "host does not match TLS server name", http.StatusMisdirectedRequest)
+		return
+	}
+
+	tunnel, err := e.findTunnel(r.Context(), r.Host)
+	if err != nil {
+		logger.Error("Public HTTP: failed to find tunnel", "host", r.Host, logging.Err(err))
+		e.metrics.EdgeError(metrics.EdgeErrorLookup)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if tunnel == nil || tunnel.Protocol != domain.ProtocolHTTP {
+		e.metrics.EdgeError(metrics.EdgeErrorTunnelNotFound)
+		http.Error(w, "tunnel not found", http.StatusNotFound)
+		return
+	}
+	logger = logger.With(
+		logging.KeyTunnelID, tunnel.ID,
+		logging.KeySubdomain, tunnel.Endpoints.Subdomain,
+		logging.KeyUserID, tunnel.UserID,
+	)
+	if r.TLS == nil && tunnel.HTTPSRedirect && e.httpsPort != 0 {
+		http.Redirect(w, r, e.httpsURL(r), http.StatusPermanentRedirect)
+		return
+	}
+	if _, ok := e.sm.Get(string(tunnel.ID)); !ok {
+		e.metrics.EdgeError(metrics.EdgeErrorTunnelOffline)
+		e.usage.RecordRequest(tunnel.ID, http.StatusBadGateway, time.Since(start))
+		http.Error(w, errTunnelOffline.Error(), http.StatusBadGateway)
+		return
+	}
+
+	if upgrade := r.Header.Get("Upgrade"); upgrade != "" {
+		logger.Info("Public HTTP: upgrade", "upgrade", upgrade, "host", r.Host, "path", r.URL.Path)
+	}
+
+	ctx := context.WithValue(r.Context(), tunnelCtxKey{}, tunnel)
+	ctx = context.WithValue(ctx, startCtxKey{}, start)
+	ctx = logging.WithContext(ctx, logger)
+	// соединения к агенту переиспользуются, поэтому связь запроса с
+	// соединением туннеля видна только здесь
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if conn, ok := info.Conn.(*edgeConn); ok {
+				logger.Debug("Public HTTP: request routed", logging.KeyConnID, conn.ID(), "reused", info.Reused, "method", r.Method, "path", r.URL.Path)
+			}
+		},
+	})
+	e.proxy.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// findTunnel находит туннель по имени хоста. Для имён вне базового домена
+// поддомен не учитывается, иначе api.example.com попал бы в туннель "api".
+func (e *httpEdge) findTunnel(ctx context.Context, host string) (*domain.Tunnel, error) {
+	host = strings.TrimSuffix(strings.ToLower(hostname(host)), ".")
+	if subdomain, ok := strings.CutSuffix(host, "."+e.baseDomain); ok {
+		if strings.Contains(subdomain, ".") {
+			return nil, nil
+		}
+		return e.tunnelRepo.FindBySubdomain(ctx, subdomain)
+	}
+	return e.domainService.ResolveHost(ctx, host)
+}
+
+func (e *httpEdge) httpsURL(r *http.Request) string {
+	host := hostname(r.Host)
+	if e.httpsPort != 443 {
+		host = net.JoinHostPort(host, fmt.Sprint(e.httpsPort))
+	}
+	return "https://" + host + r.URL.RequestURI()
+}
+
+// hostname отбрасывает порт из заголовка Host.
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
+
+func (e *httpEdge) rewrite(pr *httputil.ProxyRequest) {
+	tunnel := pr.In.Context().Value(tunnelCtxKey{}).(*domain.Tunnel)
+	pr.Out.URL.Scheme = "http"
+	pr.Out.URL.Host = string(tunnel.ID) + tunnelHostSuffix
+	// локальный сервис должен видеть исходный Host
+	pr.Out.Host = pr.In.Host
+	pr.SetXForwarded()
+}
+
+// dialTunnel открывает новое соединение внутри туннеля вместо TCP-подключения.
+func (e *httpEdge) dialTunnel(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	tunnelID := strings.TrimSuffix(host, tunnelHostSuffix)
+
+	session, ok := e.sm.Get(tunnelID)
+	if !ok {
+		return nil, errTunnelOffline
+	}
+	conn, err := e.connMgr.Open(session)
+	if err != nil {
+		return nil, err
+	}
+	logging.FromContext(ctx).Info("Connection opened", logging.KeyConnID, conn.ID())
+	e.metrics.ConnectionOpened(string(domain.ProtocolHTTP))
+	e.usage.RecordConnection(domain.TunnelID(tunnelID))
+	// соединение переживает запрос, открывший его, поэтому ID запроса в его логгер не попадает
+	logger := slog.Default().With(logging.KeyTunnelID, tunnelID, logging.KeyConnID, conn.ID())
+	return &edgeConn{Conn: conn, connMgr: e.connMgr, logger: logger, usage: e.usage, tunnelID: domain.TunnelID(tunnelID)}, nil
+}
+
+// modifyResponse убирает ID запроса из ответа сервиса: посетитель уже
+// получает его в заголовке, выставленном до проксирования. Здесь же запрос
+// попадает в статистику туннеля с кодом и временем до заголовков ответа.
+func (e *httpEdge) modifyResponse(resp *http.Response) error {
+	resp.Header.Del(logging.HeaderRequestID)
+	e.recordRequest(resp.Request, resp.StatusCode)
+	return nil
+}
+
+func (e *httpEdge) recordRequest(r *http.Request, status int) {
+	tunnel := r.Context().Value(tunnelCtxKey{}).(*domain.Tunnel)
+	start := r.Context().Value(startCtxKey{}).(time.Time)
+	e.usage.RecordRequest(tunnel.ID, status, time.Since(start))
+}
+
+func (e *httpEdge) proxyError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	logging.FromContext(r.Context()).Warn("Public HTTP: proxy failed", "method", r.Method, "host", r.Host, "path", r.URL.Path, logging.Err(err))
+	e.recordRequest(r, http.StatusBadGateway)
+	if errors.Is(err, errTunnelOffline) {
+		e.metrics.EdgeError(metrics.EdgeErrorTunnelOffline)
+		http.Error(w, errTunnelOffline.Error(), http.StatusBadGateway)
+		return
+	}
+	e.metrics.EdgeError(metrics.EdgeErrorProxy)
+	http.Error(w, "tunnel error", http.StatusBadGateway)
+}
+
+// edgeConn убирает соединение из ConnectionManager, когда пул его закрывает,
+// и считает прошедшие через него байты в статистику туннеля.
+type edgeConn struct {
+	*mux.Conn
+	connMgr  *tunnelgrpc.ConnectionManager
+	logger   *slog.Logger
+	usage    *application.UsageRecorder
+	tunnelID domain.TunnelID
+}
+
+func (c *edgeConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.usage.RecordBytes(c.tunnelID, 0, int64(n))
+	}
+	return n, err
+}
+
+func (c *edgeConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.usage.RecordBytes(c.tunnelID, int64(n), 0)
+	}
+	return n, err
+}
+
+func (c *edgeConn) Close() error {
+	err := c.Conn.Close()
+	c.connMgr.Remove(c.ID())
+	c.logger.Info("Connection closed")
+	return err
+}
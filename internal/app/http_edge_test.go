@@ -0,0 +1,507 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/waste3d/ghost-tunnel/api"
+	"github.com/waste3d/ghost-tunnel/internal/application"
+	"github.com/waste3d/ghost-tunnel/internal/domain"
+	"github.com/waste3d/ghost-tunnel/internal/infrastructure/metrics"
+	"github.com/waste3d/ghost-tunnel/internal/infrastructure/persistence"
+	"github.com/waste3d/ghost-tunnel/internal/interfaces/cli"
+	tunnelgrpc "github.com/waste3d/ghost-tunnel/internal/interfaces/grpc"
+	"google.golang.org/grpc"
+)
+
+const testBaseDomain = "example.test"
+
+// edgeEnv — публичный HTTP edge с настоящим gRPC-сервером туннелей на
+// loopback. Агентом выступает клиент CLI, так что запросы проходят весь
+// путь до локального сервиса.
+type edgeEnv struct {
+	tunnels domain.TunnelRepository
+	domains domain.CustomDomainRepository
+	service *application.TunnelService
+	sm      *tunnelgrpc.SessionManager
+	connMgr *tunnelgrpc.ConnectionManager
+	edge    *httpEdge
+	tcpEdge *tcpEdge
+	// tcpPort — единственный порт диапазона TCP-туннелей, заведомо свободный
+	tcpPort  int
+	user     *domain.User
+	grpcAddr string
+}
+
+func newEdgeEnv(t *testing.T) *edgeEnv {
+	t.Helper()
+	tunnels := persistence.NewMemoryTunnelRepository()
+	users := persistence.NewMemoryUserRepository()
+	domains := persistence.NewMemoryCustomDomainRepository()
+	m := metrics.New()
+	sm := tunnelgrpc.NewSessionManager(m)
+	connMgr := tunnelgrpc.NewConnectionManager()
+	tcpPort := freePort(t)
+	service := application.NewTunnelService(tunnels, users, sm, testBaseDomain, domain.PortRange{Min: tcpPort, Max: tcpPort})
+	usage := application.NewUsageRecorder(persistence.NewMemoryTunnelStatsRepository(tunnels))
+	domainService := application.NewDomainService(domains, tunnels, stubDNS{}, nil)
+
+	user, err := domain.NewUser("alice@example.test", "secret")
+	if err != nil {
+		t.Fatalf("NewUser: %v", err)
+	}
+	if err := users.Save(context.Background(), user); err != nil {
+		t.Fatalf("Save user: %v", err)
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	tcp := newTCPEdge(connMgr, m, usage)
+	grpcServer := grpc.NewServer()
+	api.RegisterTunnelServiceServer(grpcServer, tunnelgrpc.NewTunnelServer(sm, connMgr, service, tcp))
+	go grpcServer.Serve(lis)
+	t.Cleanup(grpcServer.Stop)
+	t.Cleanup(tcp.Close)
+
+	return &edgeEnv{
+		tunnels:  tunnels,
+		domains:  domains,
+		service:  service,
+		sm:       sm,
+		connMgr:  connMgr,
+		edge:     newHTTPEdge(sm, connMgr, tunnels, domainService, m, usage, testBaseDomain, 0),
+		tcpEdge:  tcp,
+		tcpPort:  tcpPort,
+		user:     user,
+		grpcAddr: lis.Addr().String(),
+	}
+}
+
+func (e *edgeEnv) tunnel(t *testing.T, subdomain string) *domain.Tunnel {
+	t.Helper()
+	tunnel, err := e.service.CreateTunnel(context.Background(), application.CreateTunnelRequest{
+		UserID:    e.user.ID,
+		Subdomain: subdomain,
+		LocalPort: 3000,
+	})
+	if err != nil {
+		t.Fatalf("CreateTunnel: %v", err)
+	}
+	return tunnel
+}
+
+// connect подключает агента, который ведёт туннели к локальному сервису
+// localAddr, и ждёт, пока все они не появятся в SessionManager. Агент штатно
+// отключается в конце теста или раньше — вызовом возвращённой функции.
+func (e *edgeEnv) connect(t *testing.T, localAddr string, tunnels ...*domain.Tunnel) (stop func()) {
+	t.Helper()
+	routes := make([]cli.Route, len(tunnels))
+	for i, tunnel := range tunnels {
+		routes[i] = cli.Route{TunnelID: string(tunnel.ID), LocalAddr: localAddr}
+	}
+	client := cli.NewClient(string(e.user.APIKey), routes...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		client.Run(ctx, e.grpcAddr)
+		close(done)
+	}()
+	stop = func() {
+		cancel()
+		<-done
+	}
+	t.Cleanup(stop)
+
+	for _, tunnel := range tunnels {
+		waitFor(t, "agent of "+tunnel.Endpoints.Subdomain, func() bool {
+			_, ok := e.sm.Get(string(tunnel.ID))
+			return ok
+		})
+	}
+	return stop
+}
+
+// freePort возвращает порт, который только что был свободен.
+func freePort(t *testing.T) int {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer lis.Close()
+	return lis.Addr().(*net.TCPAddr).Port
+}
+
+// waitFor ждёт, пока cond не станет истинным.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// stubDNS — резолвер с заданными записями: TXT по имени и CNAME по хосту.
+type stubDNS struct {
+	txt   map[string][]string
+	cname map[string]string
+}
+
+func (r stubDNS) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	if txts, ok := r.txt[name]; ok {
+		return txts, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (r stubDNS) LookupCNAME(ctx context.Context, host string) (string, error) {
+	if cname, ok := r.cname[host]; ok {
+		return cname, nil
+	}
+	return "", &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func TestHTTPEdgeKeepAlive(t *testing.T) {
+	env := newEdgeEnv(t)
+	app, apiTunnel := env.tunnel(t, "app"), env.tunnel(t, "api")
+
+	// локальный сервис отвечает Host запроса и адресом соединения агента:
+	// по адресу видно, переиспользовал ли edge соединение туннеля
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s", r.Host, r.RemoteAddr)
+	}))
+	defer local.Close()
+	env.connect(t, local.Listener.Addr().String(), app, apiTunnel)
+
+	edge := httptest.NewServer(env.edge)
+	defer edge.Close()
+
+	// все запросы идут по одному соединению клиента
+	conn, err := net.Dial("tcp", edge.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	get := func(host string) (gotHost, agentAddr string) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, "http://"+host+"/", nil)
+		if err := req.Write(conn); err != nil {
+			t.Fatalf("write request: %v", err)
+		}
+		resp, err := http.ReadResponse(br, req)
+		if err != nil {
+			t.Fatalf("read response: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s = %d %s", host, resp.StatusCode, body)
+		}
+		if resp.Close {
+			t.Fatalf("GET %s closed the client connection", host)
+		}
+		_, err = fmt.Sscanf(string(body), "%s %s", &gotHost, &agentAddr)
+		if err != nil {
+			t.Fatalf("unexpected body %q", body)
+		}
+		return gotHost, agentAddr
+	}
+
+	appHost, apiHost := "app."+testBaseDomain, "api."+testBaseDomain
+	var agentAddrs []string
+	for _, host := range []string{appHost, apiHost, appHost, apiHost} {
+		gotHost, addr := get(host)
+		if gotHost != host {
+			t.Errorf("request to %s reached the service as %s", host, gotHost)
+		}
+		agentAddrs = append(agentAddrs, addr)
+	}
+	if agentAddrs[0] != agentAddrs[2] || agentAddrs[1] != agentAddrs[3] {
+		t.Errorf("tunnel connections were not reused: %v", agentAddrs)
+	}
+	if agentAddrs[0] == agentAddrs[1] {
+		t.Errorf("tunnels %s and %s share a connection", appHost, apiHost)
+	}
+	conns := env.connMgr.ConnectionsByTunnel()
+	if conns[string(app.ID)] != 1 || conns[string(apiTunnel.ID)] != 1 {
+		t.Errorf("ConnectionsByTunnel() = %v, want one pooled connection per tunnel", conns)
+	}
+
+	// пул закрывает простаивающие соединения, и они уходят из ConnectionManager
+	env.edge.proxy.Transport.(*http.Transport).CloseIdleConnections()
+	waitFor(t, "pooled connections removed", func() bool {
+		return len(env.connMgr.ConnectionsByTunnel()) == 0
+	})
+}
+
+// upgradeEchoServer изображает локальный сервис, который по Upgrade: echo
+// переключает соединение в эхо-протокол.
+func upgradeEchoServer(t *testing.T) string {
+	t.Helper()
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "echo" {
+			http.Error(w, "upgrade required", http.StatusUpgradeRequired)
+			return
+		}
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("Hijack: %v", err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\n")
+		rw.Flush()
+		io.Copy(conn, rw)
+	}))
+	t.Cleanup(local.Close)
+	return local.Listener.Addr().String()
+}
+
+// upgradeEcho переключает conn к туннелю app в эхо-протокол, проверяет обмен
+// сообщениями и то, что закрытие клиента закрывает соединение туннеля.
+func (e *edgeEnv) upgradeEcho(t *testing.T, conn net.Conn, scheme string) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, scheme+"://app."+testBaseDomain+"/ws", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "echo")
+	if err := req.Write(conn); err != nil {
+		t.Fatalf("write request: %v", err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Upgrade") != "echo" {
+		t.Fatalf("upgrade response = %d, Upgrade %q; want 101 echo", resp.StatusCode, resp.Header.Get("Upgrade"))
+	}
+
+	// после 101 сокет клиента — двусторонний поток поверх соединения туннеля
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	for _, msg := range []string{"ping", "hello over the tunnel"} {
+		if _, err := io.WriteString(conn, msg); err != nil {
+			t.Fatalf("write %q: %v", msg, err)
+		}
+		got := make([]byte, len(msg))
+		if _, err := io.ReadFull(br, got); err != nil {
+			t.Fatalf("read echo of %q: %v", msg, err)
+		}
+		if string(got) != msg {
+			t.Errorf("echo = %q, want %q", got, msg)
+		}
+	}
+
+	// закрытие клиента закрывает и соединение туннеля
+	conn.Close()
+	waitFor(t, "tunnel connection removed", func() bool {
+		return len(e.connMgr.ConnectionsByTunnel()) == 0
+	})
+}
+
+func TestHTTPEdgeUpgrade(t *testing.T) {
+	env := newEdgeEnv(t)
+	app := env.tunnel(t, "app")
+	env.connect(t, upgradeEchoServer(t), app)
+
+	edge := httptest.NewServer(env.edge)
+	defer edge.Close()
+
+	conn, err := net.Dial("tcp", edge.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	env.upgradeEcho(t, conn, "http")
+}
+
+// testCertificate возвращает самоподписанный сертификат из httptest.
+func testCertificate(t *testing.T) *tls.Certificate {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(nil)
+	srv.StartTLS()
+	srv.Close()
+	return &srv.TLS.Certificates[0]
+}
+
+func TestHTTPSEdgeUpgrade(t *testing.T) {
+	env := newEdgeEnv(t)
+	app := env.tunnel(t, "app")
+	env.connect(t, upgradeEchoServer(t), app)
+
+	cert := testCertificate(t)
+	server := initPublicTLSServer(&Config{}, env.edge, func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return cert, nil
+	})
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	go server.ServeTLS(lis, "", "")
+	defer server.Close()
+
+	// браузеры предлагают h2 первым; по HTTP/2 Upgrade не работает
+	conn, err := tls.Dial("tcp", lis.Addr().String(), &tls.Config{
+		ServerName:         "app." + testBaseDomain,
+		NextProtos:         []string{"h2", "http/1.1"},
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	if proto := conn.ConnectionState().NegotiatedProtocol; proto != "http/1.1" {
+		t.Fatalf("negotiated protocol = %q, want http/1.1", proto)
+	}
+	env.upgradeEcho(t, conn, "https")
+}
+
+func TestHTTPEdgeMisdirectedRequest(t *testing.T) {
+	env := newEdgeEnv(t)
+	env.tunnel(t, "app")
+
+	tests := []struct {
+		name       string
+		serverName string
+		host       string
+		want       int
+	}{
+		{"host matches SNI", "app.example.test", "app.example.test", http.StatusBadGateway},
+		{"host with port and other case", "app.example.test", "APP.example.test:8443", http.StatusBadGateway},
+		{"host of another tunnel", "app.example.test", "other.example.test", http.StatusMisdirectedRequest},
+		{"no SNI", "", "app.example.test", http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "https://"+tt.host+"/", nil)
+			req.TLS = &tls.ConnectionState{ServerName: tt.serverName}
+			rec := httptest.NewRecorder()
+			env.edge.ServeHTTP(rec, req)
+			// агент не подключён, поэтому прошедший проверку запрос получает 502
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPEdgeHTTPSRedirect(t *testing.T) {
+	env := newEdgeEnv(t)
+	_, err := env.service.CreateTunnel(context.Background(), application.CreateTunnelRequest{
+		UserID:        env.user.ID,
+		Subdomain:     "secure",
+		LocalPort:     3000,
+		HTTPSRedirect: true,
+	})
+	if err != nil {
+		t.Fatalf("CreateTunnel: %v", err)
+	}
+	env.tunnel(t, "plain")
+
+	tests := []struct {
+		name      string
+		target    string
+		tls       bool
+		httpsPort int
+		want      int
+		location  string
+	}{
+		{"default port", "http://secure.example.test/path?q=1", false, 443, http.StatusPermanentRedirect, "https://secure.example.test/path?q=1"},
+		{"custom port", "http://secure.example.test:8000/path", false, 8443, http.StatusPermanentRedirect, "https://secure.example.test:8443/path"},
+		{"already HTTPS", "https://secure.example.test/path", true, 443, http.StatusBadGateway, ""},
+		{"HTTPS disabled", "http://secure.example.test/path", false, 0, http.StatusBadGateway, ""},
+		{"redirect not enabled", "http://plain.example.test/path", false, 443, http.StatusBadGateway, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env.edge.httpsPort = tt.httpsPort
+			req := httptest.NewRequest(http.MethodPost, tt.target, nil)
+			if !tt.tls {
+				req.TLS = nil
+			}
+			rec := httptest.NewRecorder()
+			env.edge.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if got := rec.Header().Get("Location"); got != tt.location {
+				t.Errorf("Location = %q, want %q", got, tt.location)
+			}
+		})
+	}
+}
+
+func TestHTTPEdgeRouting(t *testing.T) {
+	env := newEdgeEnv(t)
+	api := env.tunnel(t, "api")
+	ctx := context.Background()
+	for _, host := range []string{"app.example.org", "pending.example.org"} {
+		d, err := domain.NewCustomDomain(host, api.ID, "token")
+		if err != nil {
+			t.Fatalf("NewCustomDomain: %v", err)
+		}
+		if host == "app.example.org" {
+			d.MarkVerified(time.Now())
+		}
+		if err := env.domains.Save(ctx, d); err != nil {
+			t.Fatalf("Save domain: %v", err)
+		}
+	}
+
+	tests := []struct {
+		host  string
+		found bool
+	}{
+		{"api.example.test", true},
+		{"API.Example.Test:8080", true},
+		{"api.example.test.", true},
+		{"api.example.com", false},
+		{"api.example.test.evil.org", false},
+		{"a.b.example.test", false},
+		{"a.api.example.test", false},
+		{"example.test", false},
+		{"missing.example.test", false},
+		{"app.example.org", true},
+		{"app.example.org:443", true},
+		{"pending.example.org", false},
+		{"unknown.example.org", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			tunnel, err := env.edge.findTunnel(ctx, tt.host)
+			if err != nil {
+				t.Fatalf("findTunnel: %v", err)
+			}
+			if tt.found != (tunnel != nil) || tunnel != nil && tunnel.ID != api.ID {
+				t.Fatalf("findTunnel() = %v, want found %v", tunnel, tt.found)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Host = tt.host
+			rec := httptest.NewRecorder()
+			env.edge.ServeHTTP(rec, req)
+			// агент не подключён, поэтому найденный туннель отвечает 502
+			want := http.StatusNotFound
+			if tt.found {
+				want = http.StatusBadGateway
+			}
+			if rec.Code != want {
+				t.Errorf("status = %d, want %d", rec.Code, want)
+			}
+		})
+	}
+}
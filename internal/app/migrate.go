@@ -0,0 +1,98 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+
+	"github.com/pressly/goose/v3"
+	"github.com/waste3d/ghost-tunnel/internal/infrastructure/logging"
+	"github.com/waste3d/ghost-tunnel/internal/infrastructure/persistence"
+)
+
+// MigrateCommands — подкоманды `migrate`, которые понимает Migrate.
+var MigrateCommands = []string{"up", "down", "status"}
+
+// Migrate выполняет подкоманду `migrate` сервера: up, down или status.
+func Migrate(ctx context.Context, cfg *Config, command string) error {
+	migrator, err := newMigrator(cfg.Database.DSN)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+
+	switch command {
+	case "up":
+		return migrateUp(ctx, migrator)
+	case "down":
+		result, err := migrator.Down(ctx)
+		if errors.Is(err, goose.ErrNoNextVersion) {
+			slog.Info("No migrations to roll back")
+			return nil
+		}
+		if result != nil {
+			logMigration(result)
+		}
+		return err
+	case "status":
+		status, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range status {
+			applied := "Pending"
+			if s.State == goose.StateApplied {
+				applied = s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%-20s %s\n", applied, filepath.Base(s.Source.Path))
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q, expected one of %v", command, MigrateCommands)
+	}
+}
+
+// autoMigrate накатывает миграции при старте сервера, если это включено в конфиге.
+func autoMigrate(ctx context.Context, dsn string) error {
+	migrator, err := newMigrator(dsn)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+	return migrateUp(ctx, migrator)
+}
+
+func newMigrator(dsn string) (*persistence.Migrator, error) {
+	switch storageDriver(dsn) {
+	case StorageDriverPostgres:
+		return persistence.NewPostgresMigrator(dsn)
+	case StorageDriverSQLite:
+		return persistence.NewSQLiteMigrator(dsn)
+	}
+	return nil, fmt.Errorf("migrations are not supported for dsn %q", dsn)
+}
+
+func migrateUp(ctx context.Context, migrator *persistence.Migrator) error {
+	results, err := migrator.Up(ctx)
+	for _, result := range results {
+		logMigration(result)
+	}
+	if err != nil {
+		return err
+	}
+	if len(results) == 0 {
+		slog.Info("Database schema is up to date")
+	}
+	return nil
+}
+
+func logMigration(result *goose.MigrationResult) {
+	args := []any{"direction", result.Direction, "migration", filepath.Base(result.Source.Path), "duration", result.Duration}
+	if result.Error != nil {
+		slog.Error("Migration failed", append(args, logging.Err(result.Error))...)
+		return
+	}
+	slog.Info("Migration applied", args...)
+}
@@ -0,0 +1,100 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/waste3d/ghost-tunnel/internal/domain"
+	"github.com/waste3d/ghost-tunnel/internal/infrastructure/persistence"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const (
+	StorageDriverPostgres = "postgres"
+	StorageDriverSQLite   = "sqlite"
+	// StorageDriverMemory держит всё в памяти процесса: для разработки и тестов
+	StorageDriverMemory = "memory"
+)
+
+// storageDriver выбирает хранилище по схеме DSN: postgres:// (или
+// postgresql://), sqlite:// или memory://. Пустая строка — схема не распознана.
+func storageDriver(dsn string) string {
+	scheme, _, _ := strings.Cut(dsn, "://")
+	switch scheme {
+	case "postgres", "postgresql":
+		return StorageDriverPostgres
+	case "sqlite":
+		return StorageDriverSQLite
+	case "memory":
+		return StorageDriverMemory
+	}
+	return ""
+}
+
+// storage — репозитории выбранного в конфиге хранилища.
+type storage struct {
+	tunnels   domain.TunnelRepository
+	users     domain.UserRepository
+	domains   domain.CustomDomainRepository
+	stats     domain.TunnelStatsRepository
+	acmeCache autocert.Cache
+	close     func()
+}
+
+func openStorage(ctx context.Context, cfg DatabaseConfig) (*storage, error) {
+	switch storageDriver(cfg.DSN) {
+	case StorageDriverMemory:
+		slog.Warn("Using in-memory storage, data will be lost on restart")
+		tunnels := persistence.NewMemoryTunnelRepository()
+		return &storage{
+			tunnels:   tunnels,
+			users:     persistence.NewMemoryUserRepository(),
+			domains:   persistence.NewMemoryCustomDomainRepository(),
+			stats:     persistence.NewMemoryTunnelStatsRepository(tunnels),
+			acmeCache: persistence.NewMemoryACMECache(),
+			close:     func() {},
+		}, nil
+	case StorageDriverPostgres:
+		// Миграции до подключения пула: реплики ждут друг друга на advisory lock
+		if cfg.AutoMigrate {
+			if err := autoMigrate(ctx, cfg.DSN); err != nil {
+				return nil, err
+			}
+		}
+		dbPool, err := initDB(ctx, cfg.DSN)
+		if err != nil {
+			return nil, err
+		}
+		return &storage{
+			tunnels:   persistence.NewPostgresTunnelRepository(dbPool),
+			users:     persistence.NewPostgresUserRepository(dbPool),
+			domains:   persistence.NewPostgresCustomDomainRepository(dbPool),
+			stats:     persistence.NewPostgresTunnelStatsRepository(dbPool),
+			acmeCache: persistence.NewPostgresACMECache(dbPool),
+			close:     dbPool.Close,
+		}, nil
+	case StorageDriverSQLite:
+		if cfg.AutoMigrate {
+			if err := autoMigrate(ctx, cfg.DSN); err != nil {
+				return nil, err
+			}
+		}
+		db, err := persistence.OpenSQLite(cfg.DSN)
+		if err != nil {
+			return nil, err
+		}
+		slog.Info("Successfully opened SQLite database")
+		return &storage{
+			tunnels:   persistence.NewSQLiteTunnelRepository(db),
+			users:     persistence.NewSQLiteUserRepository(db),
+			domains:   persistence.NewSQLiteCustomDomainRepository(db),
+			stats:     persistence.NewSQLiteTunnelStatsRepository(db),
+			acmeCache: persistence.NewSQLiteACMECache(db),
+			close:     func() { db.Close() },
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported database dsn scheme in %q", cfg.DSN)
+	}
+}
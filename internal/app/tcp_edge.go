@@ -0,0 +1,160 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+
+	"github.com/waste3d/ghost-tunnel/internal/application"
+	"github.com/waste3d/ghost-tunnel/internal/domain"
+	"github.com/waste3d/ghost-tunnel/internal/infrastructure/logging"
+	"github.com/waste3d/ghost-tunnel/internal/infrastructure/metrics"
+	"github.com/waste3d/ghost-tunnel/internal/infrastructure/mux"
+	tunnelgrpc "github.com/waste3d/ghost-tunnel/internal/interfaces/grpc"
+)
+
+// tcpEdge слушает выделенные публичные порты TCP-туннелей. Порт открыт,
+// пока у туннеля есть сессия, в том числе пока сервер ждёт переподключения агента.
+type tcpEdge struct {
+	connMgr *tunnelgrpc.ConnectionManager
+	metrics *metrics.Metrics
+	usage   *application.UsageRecorder
+
+	mu        sync.Mutex
+	listeners map[string]*tcpListener
+	closed    bool
+}
+
+type tcpListener struct {
+	lis  net.Listener
+	port int
+	// session защищён мьютексом tcpEdge и меняется, если агент зарегистрировался заново
+	session *tunnelgrpc.Session
+}
+
+func newTCPEdge(connMgr *tunnelgrpc.ConnectionManager, m *metrics.Metrics, usage *application.UsageRecorder) *tcpEdge {
+	return &tcpEdge{
+		connMgr:   connMgr,
+		metrics:   m,
+		usage:     usage,
+		listeners: make(map[string]*tcpListener),
+	}
+}
+
+func (e *tcpEdge) TunnelOnline(tunnel *domain.Tunnel, session *tunnelgrpc.Session) {
+	if tunnel.Protocol != domain.ProtocolTCP {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return
+	}
+	if l, ok := e.listeners[string(tunnel.ID)]; ok {
+		l.session = session
+		return
+	}
+
+	port := tunnel.Endpoints.Port
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		slog.Error("Failed to listen on public TCP port", logging.KeyTunnelID, tunnel.ID, "port", port, logging.Err(err))
+		return
+	}
+	l := &tcpListener{lis: lis, port: port, session: session}
+	e.listeners[string(tunnel.ID)] = l
+	slog.Info("Listening on public TCP port", logging.KeyTunnelID, tunnel.ID, "port", port)
+
+	go e.accept(l)
+}
+
+func (e *tcpEdge) TunnelOffline(session *tunnelgrpc.Session) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	l, ok := e.listeners[session.TunnelID()]
+	if !ok || l.session != session {
+		return
+	}
+	delete(e.listeners, session.TunnelID())
+	l.lis.Close()
+	slog.Info("Public TCP port closed", logging.KeyTunnelID, session.TunnelID(), "port", l.port)
+}
+
+// Close закрывает все публичные порты при остановке сервера.
+func (e *tcpEdge) Close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.closed = true
+	for tunnelID, l := range e.listeners {
+		l.lis.Close()
+		delete(e.listeners, tunnelID)
+	}
+}
+
+func (e *tcpEdge) accept(l *tcpListener) {
+	for {
+		conn, err := l.lis.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			slog.Warn("Public TCP: failed to accept connection", "port", l.port, logging.Err(err))
+			continue
+		}
+		go e.handle(l, conn)
+	}
+}
+
+// handle передаёт байты как есть, без разбора протокола.
+func (e *tcpEdge) handle(l *tcpListener, publicConn net.Conn) {
+	defer publicConn.Close()
+
+	e.mu.Lock()
+	session := l.session
+	e.mu.Unlock()
+
+	conn, err := e.connMgr.Open(session)
+	if err != nil {
+		return
+	}
+	defer e.connMgr.Remove(conn.ID())
+	e.metrics.ConnectionOpened(string(domain.ProtocolTCP))
+	tunnelID := domain.TunnelID(session.TunnelID())
+	e.usage.RecordConnection(tunnelID)
+
+	logger := slog.Default().With(
+		logging.KeyTunnelID, session.TunnelID(),
+		logging.KeyConnID, conn.ID(),
+		logging.KeyRemoteAddr, publicConn.RemoteAddr().String(),
+	)
+	logger.Info("Public TCP: proxy started", "port", l.port)
+	mux.Join(&usageConn{Conn: publicConn, tunnelID: tunnelID, usage: e.usage}, conn)
+	logger.Info("Public TCP: proxy finished")
+}
+
+// usageConn считает байты публичного соединения в статистику туннеля:
+// прочитанное у посетителя уходит к сервису, записанное пришло от него.
+type usageConn struct {
+	net.Conn
+	tunnelID domain.TunnelID
+	usage    *application.UsageRecorder
+}
+
+func (c *usageConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.usage.RecordBytes(c.tunnelID, int64(n), 0)
+	}
+	return n, err
+}
+
+func (c *usageConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.usage.RecordBytes(c.tunnelID, 0, int64(n))
+	}
+	return n, err
+}
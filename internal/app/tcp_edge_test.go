@@ -0,0 +1,129 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/waste3d/ghost-tunnel/internal/application"
+	"github.com/waste3d/ghost-tunnel/internal/domain"
+)
+
+// echoServer изображает локальный сервис: возвращает всё, что получил, и
+// закрывает запись, когда посетитель закончил отправку.
+func echoServer(t *testing.T) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	t.Cleanup(func() { lis.Close() })
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return lis.Addr().String()
+}
+
+func (e *edgeEnv) tcpTunnel(t *testing.T) *domain.Tunnel {
+	t.Helper()
+	tunnel, err := e.service.CreateTunnel(context.Background(), application.CreateTunnelRequest{
+		UserID:    e.user.ID,
+		Protocol:  domain.ProtocolTCP,
+		LocalPort: 22,
+	})
+	if err != nil {
+		t.Fatalf("CreateTunnel: %v", err)
+	}
+	if tunnel.Endpoints.Port != e.tcpPort {
+		t.Fatalf("tunnel port = %d, want %d", tunnel.Endpoints.Port, e.tcpPort)
+	}
+	return tunnel
+}
+
+func (e *edgeEnv) publicAddr() string {
+	return fmt.Sprintf("127.0.0.1:%d", e.tcpPort)
+}
+
+// portOpen сообщает, принимает ли публичный порт соединения.
+func (e *edgeEnv) portOpen() bool {
+	conn, err := net.DialTimeout("tcp", e.publicAddr(), time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+func TestTCPEdgeEcho(t *testing.T) {
+	env := newEdgeEnv(t)
+	tunnel := env.tcpTunnel(t)
+	if env.portOpen() {
+		t.Fatal("public port is open before the agent connected")
+	}
+	env.connect(t, echoServer(t), tunnel)
+
+	conn, err := net.Dial("tcp", env.publicAddr())
+	if err != nil {
+		t.Fatalf("Dial public port: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// больше начального окна потока, чтобы данные прошли через WindowUpdate
+	payload := make([]byte, 1<<20)
+	for i := range payload {
+		payload[i] = byte(i % 251)
+	}
+	go func() {
+		conn.Write(payload)
+		conn.(*net.TCPConn).CloseWrite()
+	}()
+	got, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("read echo: %v", err)
+	}
+	if len(got) != len(payload) {
+		t.Fatalf("echo returned %d bytes, want %d", len(got), len(payload))
+	}
+	for i := range got {
+		if got[i] != payload[i] {
+			t.Fatalf("echo differs at byte %d", i)
+		}
+	}
+}
+
+func TestTCPEdgeListenerLifecycle(t *testing.T) {
+	env := newEdgeEnv(t)
+	tunnel := env.tcpTunnel(t)
+	local := echoServer(t)
+
+	stop := env.connect(t, local, tunnel)
+	if !env.portOpen() {
+		t.Fatal("public port is closed while the agent is connected")
+	}
+
+	// агент штатно закрыл туннель: сервер не ждёт его обратно и закрывает порт
+	stop()
+	waitFor(t, "public port to close", func() bool { return !env.portOpen() })
+
+	// новый агент открывает порт снова
+	env.connect(t, local, tunnel)
+	waitFor(t, "public port to reopen", env.portOpen)
+
+	env.tcpEdge.Close()
+	if env.portOpen() {
+		t.Error("public port is open after the edge was closed")
+	}
+}
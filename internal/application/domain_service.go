@@ -0,0 +1,204 @@
+package application
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/waste3d/ghost-tunnel/internal/domain"
+)
+
+// CertificateIssuer выпускает TLS-сертификаты для собственных доменов.
+type CertificateIssuer interface {
+	// Issue запускает выпуск в фоне, результат попадает в статус домена.
+	Issue(hostname string)
+}
+
+// DNSResolver — DNS-запросы для подтверждения владения доменом.
+// net.Resolver подходит как есть, в тестах подставляется заглушка.
+type DNSResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+	LookupCNAME(ctx context.Context, host string) (string, error)
+}
+
+type AddDomainRequest struct {
+	Hostname string `json:"hostname"`
+}
+
+type DomainService struct {
+	domainRepo domain.CustomDomainRepository
+	tunnelRepo domain.TunnelRepository
+	resolver   DNSResolver
+	// issuer может быть nil, если ACME выключен: домены тогда остаются в статусе pending
+	issuer CertificateIssuer
+	policy TunnelPolicy
+}
+
+func NewDomainService(domainRepo domain.CustomDomainRepository, tunnelRepo domain.TunnelRepository, resolver DNSResolver, issuer CertificateIssuer) *DomainService {
+	return &DomainService{domainRepo: domainRepo, tunnelRepo: tunnelRepo, resolver: resolver, issuer: issuer, policy: OwnerPolicy{}}
+}
+
+func (s *DomainService) AddDomain(ctx context.Context, userID domain.UserID, tunnelRef string, req AddDomainRequest) (*domain.CustomDomain, error) {
+	tunnel, err := s.ownedTunnel(ctx, userID, tunnelRef)
+	if err != nil {
+		return nil, err
+	}
+
+	tokenBytes := make([]byte, 16)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return nil, fmt.Errorf("failed to generate verification token: %w", err)
+	}
+
+	d, err := domain.NewCustomDomain(req.Hostname, tunnel.ID, hex.EncodeToString(tokenBytes))
+	if err != nil {
+		return nil, err
+	}
+	if err := s.domainRepo.Save(ctx, d); err != nil {
+		return nil, fmt.Errorf("failed to add domain: %w", err)
+	}
+
+	// CNAME на туннель мог быть настроен заранее, тогда домен подтверждается сразу
+	if err := s.verify(ctx, tunnel, d); err != nil && !errors.Is(err, domain.ErrDomainNotVerified) {
+		return nil, err
+	}
+	return d, nil
+}
+
+// VerifyDomain проверяет DNS-записи домена: TXT с токеном подтверждения или
+// CNAME на адрес туннеля. После подтверждения запускается выпуск сертификата.
+func (s *DomainService) VerifyDomain(ctx context.Context, userID domain.UserID, tunnelRef, hostname string) (*domain.CustomDomain, error) {
+	tunnel, err := s.ownedTunnel(ctx, userID, tunnelRef)
+	if err != nil {
+		return nil, err
+	}
+	d, err := s.ownedDomain(ctx, tunnel, hostname)
+	if err != nil {
+		return nil, err
+	}
+	if d.Verified() {
+		return d, nil
+	}
+
+	if err := s.verify(ctx, tunnel, d); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// cnameTarget — имя, на которое должен указывать CNAME собственного домена.
+func cnameTarget(tunnel *domain.Tunnel) string {
+	return tunnel.Endpoints.Subdomain + "." + tunnel.Endpoints.Domain
+}
+
+func (s *DomainService) verify(ctx context.Context, tunnel *domain.Tunnel, d *domain.CustomDomain) error {
+	if !s.hasVerificationRecord(ctx, tunnel, d) {
+		return domain.ErrDomainNotVerified
+	}
+
+	d.MarkVerified(time.Now())
+	if err := s.domainRepo.UpdateVerification(ctx, d); err != nil {
+		return fmt.Errorf("failed to save domain verification: %w", err)
+	}
+	if s.issuer != nil {
+		s.issuer.Issue(d.Hostname)
+	}
+	return nil
+}
+
+// hasVerificationRecord ищет подтверждение в DNS. Ошибки резолвера (NXDOMAIN,
+// таймауты) означают, что подтверждения пока нет.
+func (s *DomainService) hasVerificationRecord(ctx context.Context, tunnel *domain.Tunnel, d *domain.CustomDomain) bool {
+	if txts, err := s.resolver.LookupTXT(ctx, d.VerificationRecord()); err == nil {
+		if slices.Contains(txts, d.VerificationToken) {
+			return true
+		}
+	}
+	if cname, err := s.resolver.LookupCNAME(ctx, d.Hostname); err == nil {
+		if strings.EqualFold(strings.TrimSuffix(cname, "."), cnameTarget(tunnel)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *DomainService) ListDomains(ctx context.Context, userID domain.UserID, tunnelRef string) ([]*domain.CustomDomain, error) {
+	tunnel, err := s.ownedTunnel(ctx, userID, tunnelRef)
+	if err != nil {
+		return nil, err
+	}
+
+	domains, err := s.domainRepo.ListByTunnel(ctx, tunnel.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list domains: %w", err)
+	}
+	return domains, nil
+}
+
+func (s *DomainService) RemoveDomain(ctx context.Context, userID domain.UserID, tunnelRef, hostname string) error {
+	tunnel, err := s.ownedTunnel(ctx, userID, tunnelRef)
+	if err != nil {
+		return err
+	}
+
+	d, err := s.ownedDomain(ctx, tunnel, hostname)
+	if err != nil {
+		return err
+	}
+
+	if err := s.domainRepo.Delete(ctx, d.Hostname, tunnel.ID); err != nil {
+		return fmt.Errorf("failed to remove domain: %w", err)
+	}
+	return nil
+}
+
+// ResolveHost находит туннель, на который маршрутизируется собственный домен.
+// Неподтверждённые домены не маршрутизируются.
+func (s *DomainService) ResolveHost(ctx context.Context, hostname string) (*domain.Tunnel, error) {
+	d, err := s.domainRepo.FindVerified(ctx, hostname)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find domain: %w", err)
+	}
+	if d == nil {
+		return nil, nil
+	}
+	tunnel, err := s.tunnelRepo.FindByID(ctx, d.TunnelID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find tunnel: %w", err)
+	}
+	return tunnel, nil
+}
+
+func (s *DomainService) ownedDomain(ctx context.Context, tunnel *domain.Tunnel, hostname string) (*domain.CustomDomain, error) {
+	hostname, err := domain.NormalizeHostname(hostname)
+	if err != nil {
+		return nil, err
+	}
+	d, err := s.domainRepo.FindClaim(ctx, hostname, tunnel.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find domain: %w", err)
+	}
+	if d == nil {
+		return nil, domain.ErrDomainNotFound
+	}
+	return d, nil
+}
+
+// ExpireClaims удаляет заявки на домены, не подтверждённые за
+// domain.UnverifiedDomainTTL, и возвращает их число.
+func (s *DomainService) ExpireClaims(ctx context.Context) (int, error) {
+	n, err := s.domainRepo.DeleteUnverified(ctx, time.Now().Add(-domain.UnverifiedDomainTTL))
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired domain claims: %w", err)
+	}
+	return n, nil
+}
+
+// ownedTunnel принимает ID туннеля или его поддомен, как и TunnelService.
+func (s *DomainService) ownedTunnel(ctx context.Context, userID domain.UserID, ref string) (*domain.Tunnel, error) {
+	return authorizedTunnel(ctx, s.tunnelRepo, s.policy, userID, ActionManageDomains, ref)
+}
@@ -0,0 +1,210 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/waste3d/ghost-tunnel/internal/domain"
+	"github.com/waste3d/ghost-tunnel/internal/infrastructure/persistence"
+)
+
+// stubDNS — резолвер с заданными записями.
+type stubDNS struct {
+	txt   map[string][]string
+	cname map[string]string
+}
+
+func (r stubDNS) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	if txts, ok := r.txt[name]; ok {
+		return txts, nil
+	}
+	return nil, errors.New("no such host")
+}
+
+func (r stubDNS) LookupCNAME(ctx context.Context, host string) (string, error) {
+	if cname, ok := r.cname[host]; ok {
+		return cname, nil
+	}
+	return "", errors.New("no such host")
+}
+
+// issuedSet запоминает домены, для которых запрошен сертификат.
+type issuedSet map[string]bool
+
+func (s issuedSet) Issue(hostname string) {
+	s[hostname] = true
+}
+
+func TestDomainServiceVerify(t *testing.T) {
+	const host = "app.example.org"
+	cases := []struct {
+		name     string
+		dns      func(token string) stubDNS
+		verified bool
+	}{
+		{"no records", func(string) stubDNS { return stubDNS{} }, false},
+		{"txt with token", func(token string) stubDNS {
+			return stubDNS{txt: map[string][]string{"_ghost-tunnel." + host: {"other", token}}}
+		}, true},
+		{"txt with wrong token", func(string) stubDNS {
+			return stubDNS{txt: map[string][]string{"_ghost-tunnel." + host: {"wrong-token"}}}
+		}, false},
+		{"txt on the hostname itself", func(token string) stubDNS {
+			return stubDNS{txt: map[string][]string{host: {token}}}
+		}, false},
+		{"cname to the tunnel", func(string) stubDNS {
+			return stubDNS{cname: map[string]string{host: "api.example.test"}}
+		}, true},
+		{"cname fqdn in other case", func(string) stubDNS {
+			return stubDNS{cname: map[string]string{host: "API.Example.Test."}}
+		}, true},
+		{"cname to another tunnel", func(string) stubDNS {
+			return stubDNS{cname: map[string]string{host: "web.example.test."}}
+		}, false},
+		{"cname to a lookalike", func(string) stubDNS {
+			return stubDNS{cname: map[string]string{host: "api.example.test.evil.org."}}
+		}, false},
+		{"cname to the base domain", func(string) stubDNS {
+			return stubDNS{cname: map[string]string{host: "example.test."}}
+		}, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			env := newTestEnv(t)
+			alice := env.user(t, "alice@example.test")
+			env.tunnel(t, alice, "api")
+			env.tunnel(t, alice, "web")
+
+			dns := &stubDNS{}
+			issued := issuedSet{}
+			service := NewDomainService(persistence.NewMemoryCustomDomainRepository(), env.tunnels, dns, issued)
+			d, err := service.AddDomain(ctx, alice.ID, "api", AddDomainRequest{Hostname: host})
+			if err != nil {
+				t.Fatalf("AddDomain: %v", err)
+			}
+			if d.Verified() {
+				t.Fatal("domain verified without DNS records")
+			}
+
+			*dns = tc.dns(d.VerificationToken)
+			d, err = service.VerifyDomain(ctx, alice.ID, "api", host)
+			if tc.verified {
+				if err != nil || !d.Verified() {
+					t.Fatalf("VerifyDomain() = %v, want verified", err)
+				}
+				if !issued[host] {
+					t.Error("certificate was not requested after verification")
+				}
+				if tunnel, _ := service.ResolveHost(ctx, host); tunnel == nil || tunnel.Endpoints.Subdomain != "api" {
+					t.Errorf("ResolveHost() = %v, want tunnel api", tunnel)
+				}
+				return
+			}
+			if !errors.Is(err, domain.ErrDomainNotVerified) {
+				t.Fatalf("VerifyDomain() = %v, want ErrDomainNotVerified", err)
+			}
+			if issued[host] {
+				t.Error("certificate was requested for an unverified domain")
+			}
+			if tunnel, _ := service.ResolveHost(ctx, host); tunnel != nil {
+				t.Errorf("ResolveHost() = %v for an unverified domain, want nil", tunnel)
+			}
+		})
+	}
+}
+
+func TestDomainServiceAddVerifiesExistingCNAME(t *testing.T) {
+	env := newTestEnv(t)
+	alice := env.user(t, "alice@example.test")
+	env.tunnel(t, alice, "api")
+
+	dns := stubDNS{cname: map[string]string{"app.example.org": "api.example.test."}}
+	service := NewDomainService(persistence.NewMemoryCustomDomainRepository(), env.tunnels, dns, nil)
+	d, err := service.AddDomain(context.Background(), alice.ID, "api", AddDomainRequest{Hostname: "App.Example.org"})
+	if err != nil {
+		t.Fatalf("AddDomain: %v", err)
+	}
+	if !d.Verified() || d.CertStatus != domain.CertificatePending {
+		t.Errorf("domain = %+v, want verified with a pending certificate", d)
+	}
+}
+
+func TestDomainServiceSquattedHostname(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t)
+	mallory := env.user(t, "mallory@example.test")
+	alice := env.user(t, "alice@example.test")
+	env.tunnel(t, mallory, "squat")
+	env.tunnel(t, alice, "api")
+
+	dns := &stubDNS{}
+	issued := issuedSet{}
+	service := NewDomainService(persistence.NewMemoryCustomDomainRepository(), env.tunnels, dns, issued)
+
+	// чужая неподтверждённая заявка не мешает владельцу домена
+	if _, err := service.AddDomain(ctx, mallory.ID, "squat", AddDomainRequest{Hostname: "example.com"}); err != nil {
+		t.Fatalf("AddDomain by squatter: %v", err)
+	}
+	claim, err := service.AddDomain(ctx, alice.ID, "api", AddDomainRequest{Hostname: "example.com"})
+	if err != nil {
+		t.Fatalf("AddDomain by owner = %v, want a second claim", err)
+	}
+
+	*dns = stubDNS{txt: map[string][]string{"_ghost-tunnel.example.com": {claim.VerificationToken}}}
+	if _, err := service.VerifyDomain(ctx, mallory.ID, "squat", "example.com"); !errors.Is(err, domain.ErrDomainNotVerified) {
+		t.Errorf("VerifyDomain by squatter = %v, want ErrDomainNotVerified", err)
+	}
+	if _, err := service.VerifyDomain(ctx, alice.ID, "api", "example.com"); err != nil {
+		t.Fatalf("VerifyDomain by owner: %v", err)
+	}
+	if tunnel, _ := service.ResolveHost(ctx, "example.com"); tunnel == nil || tunnel.Endpoints.Subdomain != "api" {
+		t.Errorf("ResolveHost() = %v, want the owner's tunnel", tunnel)
+	}
+
+	// после подтверждения заявка захватчика удалена, новую он не подаст
+	if _, err := service.VerifyDomain(ctx, mallory.ID, "squat", "example.com"); !errors.Is(err, domain.ErrDomainNotFound) {
+		t.Errorf("VerifyDomain of the displaced claim = %v, want ErrDomainNotFound", err)
+	}
+	if _, err := service.AddDomain(ctx, mallory.ID, "squat", AddDomainRequest{Hostname: "example.com"}); !errors.Is(err, domain.ErrDomainTaken) {
+		t.Errorf("AddDomain of a verified hostname = %v, want ErrDomainTaken", err)
+	}
+}
+
+func TestDomainServiceExpireClaims(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t)
+	alice := env.user(t, "alice@example.test")
+	tunnel := env.tunnel(t, alice, "api")
+
+	repo := persistence.NewMemoryCustomDomainRepository()
+	service := NewDomainService(repo, env.tunnels, noDNS{}, nil)
+	for _, c := range []struct {
+		hostname string
+		age      time.Duration
+		verified bool
+	}{
+		{"stale.example.org", domain.UnverifiedDomainTTL + time.Hour, false},
+		{"fresh.example.org", time.Hour, false},
+		{"old.example.org", domain.UnverifiedDomainTTL + time.Hour, true},
+	} {
+		d, _ := domain.NewCustomDomain(c.hostname, tunnel.ID, "token")
+		d.CreatedAt = time.Now().Add(-c.age)
+		if c.verified {
+			d.MarkVerified(d.CreatedAt)
+		}
+		if err := repo.Save(ctx, d); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+	}
+
+	if n, err := service.ExpireClaims(ctx); err != nil || n != 1 {
+		t.Fatalf("ExpireClaims() = %d, %v; want 1", n, err)
+	}
+	domains, _ := service.ListDomains(ctx, alice.ID, "api")
+	if len(domains) != 2 || domains[0].Hostname != "old.example.org" || domains[1].Hostname != "fresh.example.org" {
+		t.Errorf("domains after ExpireClaims = %v, want old and fresh", domains)
+	}
+}
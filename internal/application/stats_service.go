@@ -0,0 +1,109 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/waste3d/ghost-tunnel/internal/domain"
+)
+
+// TunnelStatsRequest — период и шаг статистики; нулевые значения заменяются
+// значениями по умолчанию: последние сутки с шагом в час.
+type TunnelStatsRequest struct {
+	From     time.Time
+	To       time.Time
+	Interval time.Duration
+}
+
+// TunnelStatsReport — статистика туннеля за [From, To) по интервалам длиной
+// Interval. Интервалы без трафика присутствуют с нулевыми счётчиками.
+type TunnelStatsReport struct {
+	TunnelID domain.TunnelID
+	From     time.Time
+	To       time.Time
+	Interval time.Duration
+	Buckets  []*domain.TunnelStats
+	Total    domain.TunnelStats
+}
+
+const (
+	defaultStatsInterval = time.Hour
+	defaultStatsPeriod   = 24 * time.Hour
+	// maxStatsBuckets ограничивает размер ответа: сутки с шагом в минуту
+	maxStatsBuckets = 1440
+)
+
+type StatsService struct {
+	statsRepo  domain.TunnelStatsRepository
+	tunnelRepo domain.TunnelRepository
+	policy     TunnelPolicy
+	now        func() time.Time
+}
+
+func NewStatsService(statsRepo domain.TunnelStatsRepository, tunnelRepo domain.TunnelRepository) *StatsService {
+	return &StatsService{statsRepo: statsRepo, tunnelRepo: tunnelRepo, policy: OwnerPolicy{}, now: time.Now}
+}
+
+// GetTunnelStats собирает сохранённые поминутные корзины туннеля в интервалы
+// запрошенной длины. Границы периода выравниваются по интервалу в UTC.
+// Последние секунды трафика могут ещё не попасть в базу, см. UsageRecorder.
+func (s *StatsService) GetTunnelStats(ctx context.Context, userID domain.UserID, tunnelRef string, req TunnelStatsRequest) (*TunnelStatsReport, error) {
+	tunnel, err := authorizedTunnel(ctx, s.tunnelRepo, s.policy, userID, ActionViewTunnel, tunnelRef)
+	if err != nil {
+		return nil, err
+	}
+
+	interval := req.Interval
+	if interval == 0 {
+		interval = defaultStatsInterval
+	}
+	if interval < domain.StatsBucketSize || interval%domain.StatsBucketSize != 0 {
+		return nil, fmt.Errorf("%w: interval must be a whole number of minutes", domain.ErrInvalidStatsRange)
+	}
+	to := req.To
+	if to.IsZero() {
+		to = s.now()
+	}
+	from := req.From
+	if from.IsZero() {
+		from = to.Add(-defaultStatsPeriod)
+	}
+	if !from.Before(to) {
+		return nil, fmt.Errorf("%w: from must be before to", domain.ErrInvalidStatsRange)
+	}
+
+	from = from.UTC().Truncate(interval)
+	if aligned := to.UTC().Truncate(interval); aligned.Before(to) {
+		to = aligned.Add(interval)
+	} else {
+		to = aligned
+	}
+	count := int(to.Sub(from) / interval)
+	if count > maxStatsBuckets {
+		return nil, fmt.Errorf("%w: at most %d intervals per request, got %d", domain.ErrInvalidStatsRange, maxStatsBuckets, count)
+	}
+
+	stored, err := s.statsRepo.Range(ctx, tunnel.ID, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tunnel stats: %w", err)
+	}
+
+	report := &TunnelStatsReport{
+		TunnelID: tunnel.ID,
+		From:     from,
+		To:       to,
+		Interval: interval,
+		Buckets:  make([]*domain.TunnelStats, count),
+		Total:    domain.TunnelStats{TunnelID: tunnel.ID, Start: from},
+	}
+	for i := range report.Buckets {
+		report.Buckets[i] = &domain.TunnelStats{TunnelID: tunnel.ID, Start: from.Add(time.Duration(i) * interval)}
+	}
+	for _, bucket := range stored {
+		i := int(bucket.Start.Sub(from) / interval)
+		report.Buckets[i].Merge(bucket)
+		report.Total.Merge(bucket)
+	}
+	return report, nil
+}
@@ -0,0 +1,113 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/waste3d/ghost-tunnel/internal/domain"
+	"github.com/waste3d/ghost-tunnel/internal/infrastructure/persistence"
+)
+
+func TestUsageRecorderAndStats(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	alice := env.user(t, "alice@example.test")
+	bob := env.user(t, "bob@example.test")
+	tunnel := env.tunnel(t, alice, "alice-app")
+
+	statsRepo := persistence.NewMemoryTunnelStatsRepository(env.tunnels)
+	recorder := NewUsageRecorder(statsRepo)
+	service := NewStatsService(statsRepo, env.tunnels)
+
+	now := time.Date(2026, 10, 17, 12, 30, 15, 0, time.UTC)
+	recorder.now = func() time.Time { return now }
+	service.now = func() time.Time { return now }
+
+	recorder.RecordConnection(tunnel.ID)
+	recorder.RecordRequest(tunnel.ID, 200, 20*time.Millisecond)
+	recorder.RecordBytes(tunnel.ID, 100, 2000)
+	now = now.Add(-time.Hour)
+	recorder.RecordRequest(tunnel.ID, 503, 40*time.Millisecond)
+	now = now.Add(time.Hour)
+	if err := recorder.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	// повторный Flush без новых событий ничего не удваивает
+	if err := recorder.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	report, err := service.GetTunnelStats(ctx, alice.ID, "alice-app", TunnelStatsRequest{})
+	if err != nil {
+		t.Fatalf("GetTunnelStats: %v", err)
+	}
+	wantFrom := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	if !report.From.Equal(wantFrom) || !report.To.Equal(wantFrom.Add(25*time.Hour)) || len(report.Buckets) != 25 {
+		t.Fatalf("report covers %v..%v in %d buckets, want %v..+25h in 25", report.From, report.To, len(report.Buckets), wantFrom)
+	}
+	last, prev := report.Buckets[24], report.Buckets[23]
+	if last.Requests != 1 || last.Status2xx != 1 || last.Connections != 1 || last.BytesIn != 100 || last.BytesOut != 2000 {
+		t.Errorf("last bucket = %+v", last)
+	}
+	if prev.Requests != 1 || prev.Status5xx != 1 || prev.LatencyMax != 40*time.Millisecond {
+		t.Errorf("previous bucket = %+v", prev)
+	}
+	if report.Total.Requests != 2 || report.Total.LatencyTotal != 60*time.Millisecond {
+		t.Errorf("total = %+v", report.Total)
+	}
+
+	if _, err := service.GetTunnelStats(ctx, bob.ID, "alice-app", TunnelStatsRequest{}); !errors.Is(err, domain.ErrForbidden) {
+		t.Errorf("stats of someone else's tunnel: err = %v, want ErrForbidden", err)
+	}
+
+	invalid := []TunnelStatsRequest{
+		{Interval: 30 * time.Second},
+		{Interval: 90 * time.Second},
+		{From: now, To: now.Add(-time.Hour)},
+		{From: now.Add(-48 * time.Hour), To: now, Interval: time.Minute},
+	}
+	for _, req := range invalid {
+		if _, err := service.GetTunnelStats(ctx, alice.ID, "alice-app", req); !errors.Is(err, domain.ErrInvalidStatsRange) {
+			t.Errorf("GetTunnelStats(%+v): err = %v, want ErrInvalidStatsRange", req, err)
+		}
+	}
+}
+
+type failingStatsRepo struct {
+	domain.TunnelStatsRepository
+	fail bool
+}
+
+func (r *failingStatsRepo) Add(ctx context.Context, stats []*domain.TunnelStats) error {
+	if r.fail {
+		return errors.New("database is down")
+	}
+	return r.TunnelStatsRepository.Add(ctx, stats)
+}
+
+func TestUsageRecorderKeepsStatsOnFailedFlush(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	tunnel := env.tunnel(t, env.user(t, "alice@example.test"), "alice-app")
+
+	repo := &failingStatsRepo{TunnelStatsRepository: persistence.NewMemoryTunnelStatsRepository(env.tunnels), fail: true}
+	recorder := NewUsageRecorder(repo)
+	now := time.Now()
+	recorder.now = func() time.Time { return now }
+	recorder.RecordConnection(tunnel.ID)
+	if err := recorder.Flush(ctx); err == nil {
+		t.Fatal("Flush: expected error")
+	}
+	recorder.RecordConnection(tunnel.ID)
+
+	repo.fail = false
+	if err := recorder.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	stats, err := repo.Range(ctx, tunnel.ID, now.Add(-time.Hour), now.Add(time.Hour))
+	if err != nil || len(stats) != 1 || stats[0].Connections != 2 {
+		t.Errorf("Range = %v, %v; want one bucket with 2 connections", stats, err)
+	}
+}
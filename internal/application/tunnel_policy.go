@@ -0,0 +1,60 @@
+package application
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/waste3d/ghost-tunnel/internal/domain"
+)
+
+// TunnelAction — операция над туннелем, на которую проверяются права.
+type TunnelAction string
+
+const (
+	ActionCreateTunnel  TunnelAction = "create"
+	ActionViewTunnel    TunnelAction = "view"
+	ActionUpdateTunnel  TunnelAction = "update"
+	ActionDeleteTunnel  TunnelAction = "delete"
+	ActionConnectTunnel TunnelAction = "connect"
+	ActionManageDomains TunnelAction = "manage_domains"
+)
+
+// TunnelPolicy решает, может ли пользователь выполнить действие над туннелем.
+// Отказ — ошибка, для которой errors.Is(err, domain.ErrForbidden) истинно.
+type TunnelPolicy interface {
+	Authorize(actor domain.UserID, action TunnelAction, tunnel *domain.Tunnel) error
+}
+
+// OwnerPolicy разрешает любые действия только владельцу туннеля. Туннели
+// без владельца недоступны никому.
+type OwnerPolicy struct{}
+
+func (OwnerPolicy) Authorize(actor domain.UserID, action TunnelAction, tunnel *domain.Tunnel) error {
+	if actor == "" || tunnel.UserID != actor {
+		return domain.ErrTunnelNotOwned
+	}
+	return nil
+}
+
+// authorizedTunnel находит туннель по ссылке из URL — UUID туннеля или его
+// поддомену — и проверяет по политике, что пользователь может выполнить действие.
+func authorizedTunnel(ctx context.Context, repo domain.TunnelRepository, policy TunnelPolicy, actor domain.UserID, action TunnelAction, ref string) (*domain.Tunnel, error) {
+	var tunnel *domain.Tunnel
+	var err error
+	if _, parseErr := uuid.Parse(ref); parseErr == nil {
+		tunnel, err = repo.FindByID(ctx, domain.TunnelID(ref))
+	} else {
+		tunnel, err = repo.FindBySubdomain(ctx, ref)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find tunnel: %w", err)
+	}
+	if tunnel == nil {
+		return nil, domain.ErrTunnelNotFound
+	}
+	if err := policy.Authorize(actor, action, tunnel); err != nil {
+		return nil, err
+	}
+	return tunnel, nil
+}
@@ -0,0 +1,77 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/waste3d/ghost-tunnel/internal/domain"
+)
+
+// TunnelReaper удаляет заброшенные туннели: незакреплённые, то есть со
+// случайным поддоменом, агент которых отключился дольше ttl назад. Закреплённые
+// туннели, туннели с собственными доменами и туннели с подключённым агентом
+// не удаляются. В режиме dryRun туннели только отбираются.
+type TunnelReaper struct {
+	tunnels domain.TunnelRepository
+	domains domain.CustomDomainRepository
+	// presence может быть nil, тогда полагаемся только на статус туннеля
+	presence AgentPresence
+	ttl      time.Duration
+	dryRun   bool
+	now      func() time.Time
+}
+
+func NewTunnelReaper(tunnels domain.TunnelRepository, domains domain.CustomDomainRepository, presence AgentPresence, ttl time.Duration, dryRun bool) *TunnelReaper {
+	return &TunnelReaper{tunnels: tunnels, domains: domains, presence: presence, ttl: ttl, dryRun: dryRun, now: time.Now}
+}
+
+// DryRun сообщает, что Reap только отбирает туннели, не удаляя их.
+func (r *TunnelReaper) DryRun() bool {
+	return r.dryRun
+}
+
+// Reap удаляет заброшенные туннели и возвращает действительно удалённые, а в
+// режиме dry-run — те, что были бы удалены. Ошибка удаления одного туннеля не
+// останавливает остальные: они возвращаются вместе с ошибкой.
+func (r *TunnelReaper) Reap(ctx context.Context) ([]*domain.Tunnel, error) {
+	cutoff := r.now().Add(-r.ttl)
+	candidates, err := r.tunnels.ListAbandoned(ctx, cutoff)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list abandoned tunnels: %w", err)
+	}
+
+	var reaped []*domain.Tunnel
+	var errs []error
+	for _, tunnel := range candidates {
+		// агент мог переподключиться после выборки
+		if r.presence != nil && r.presence.AgentOnline(tunnel.ID) {
+			continue
+		}
+		// собственный домен пользователь настраивал вручную, его не теряем
+		domains, err := r.domains.ListByTunnel(ctx, tunnel.ID)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to list domains of tunnel %s: %w", tunnel.ID, err))
+			continue
+		}
+		if len(domains) > 0 {
+			continue
+		}
+
+		if !r.dryRun {
+			// туннель могли закрепить или к нему мог подключиться агент после
+			// выборки: удаляем, только если он всё ещё заброшен
+			deleted, err := r.tunnels.DeleteAbandoned(ctx, tunnel.ID, cutoff)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to delete tunnel %s: %w", tunnel.ID, err))
+				continue
+			}
+			if !deleted {
+				continue
+			}
+		}
+		reaped = append(reaped, tunnel)
+	}
+	return reaped, errors.Join(errs...)
+}
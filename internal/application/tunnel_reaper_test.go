@@ -0,0 +1,121 @@
+package application
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/waste3d/ghost-tunnel/internal/domain"
+	"github.com/waste3d/ghost-tunnel/internal/infrastructure/persistence"
+)
+
+func TestTunnelReaper(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t)
+	alice := env.user(t, "alice@example.test")
+
+	reserved := env.tunnel(t, alice, "alice-app")
+	abandoned := env.tunnel(t, alice, "")
+	withDomain := env.tunnel(t, alice, "")
+	online := env.tunnel(t, alice, "")
+	fresh := env.tunnel(t, alice, "")
+
+	domains := persistence.NewMemoryCustomDomainRepository()
+	d, err := domain.NewCustomDomain("app.example.org", withDomain.ID, "token")
+	if err != nil {
+		t.Fatalf("NewCustomDomain: %v", err)
+	}
+	if err := domains.Save(ctx, d); err != nil {
+		t.Fatalf("Save domain: %v", err)
+	}
+
+	// fresh только что отключился, остальные простаивают с момента создания
+	later := time.Now().Add(2 * time.Hour)
+	if err := env.service.AgentConnected(ctx, fresh, domain.AgentInfo{}); err != nil {
+		t.Fatalf("AgentConnected: %v", err)
+	}
+	fresh.Deactivate(later.Add(-time.Minute))
+	if err := env.tunnels.UpdateStatus(ctx, fresh); err != nil {
+		t.Fatalf("UpdateStatus: %v", err)
+	}
+
+	newReaper := func(dryRun bool) *TunnelReaper {
+		r := NewTunnelReaper(env.tunnels, domains, onlineSet{online.ID: true}, time.Hour, dryRun)
+		r.now = func() time.Time { return later }
+		return r
+	}
+
+	reaped, err := newReaper(true).Reap(ctx)
+	if err != nil {
+		t.Fatalf("Reap(dry run): %v", err)
+	}
+	if len(reaped) != 1 || reaped[0].ID != abandoned.ID {
+		t.Fatalf("Reap(dry run) = %v, want only the abandoned tunnel", reaped)
+	}
+	if stored, _ := env.tunnels.FindByID(ctx, abandoned.ID); stored == nil {
+		t.Fatal("dry run deleted the tunnel")
+	}
+
+	reaped, err = newReaper(false).Reap(ctx)
+	if err != nil {
+		t.Fatalf("Reap: %v", err)
+	}
+	if len(reaped) != 1 || reaped[0].ID != abandoned.ID {
+		t.Fatalf("Reap() = %v, want only the abandoned tunnel", reaped)
+	}
+	if stored, _ := env.tunnels.FindByID(ctx, abandoned.ID); stored != nil {
+		t.Error("abandoned tunnel was not deleted")
+	}
+	for _, kept := range []*domain.Tunnel{reserved, withDomain, online, fresh} {
+		if stored, _ := env.tunnels.FindByID(ctx, kept.ID); stored == nil {
+			t.Errorf("tunnel %s was deleted", kept.Endpoints.Subdomain)
+		}
+	}
+}
+
+// racingTunnels вызывает afterList сразу после выборки, как будто туннель
+// изменился, пока Reap проверял кандидатов.
+type racingTunnels struct {
+	domain.TunnelRepository
+	afterList func()
+}
+
+func (r racingTunnels) ListAbandoned(ctx context.Context, offlineBefore time.Time) ([]*domain.Tunnel, error) {
+	tunnels, err := r.TunnelRepository.ListAbandoned(ctx, offlineBefore)
+	r.afterList()
+	return tunnels, err
+}
+
+func TestTunnelReaperSkipsChangedTunnels(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t)
+	alice := env.user(t, "alice@example.test")
+	reserved := env.tunnel(t, alice, "")
+	reconnected := env.tunnel(t, alice, "")
+	abandoned := env.tunnel(t, alice, "")
+
+	tunnels := racingTunnels{TunnelRepository: env.tunnels, afterList: func() {
+		reserved.Reserved = true
+		if err := env.tunnels.Update(ctx, reserved); err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+		if err := env.service.AgentConnected(ctx, reconnected, domain.AgentInfo{}); err != nil {
+			t.Fatalf("AgentConnected: %v", err)
+		}
+	}}
+	r := NewTunnelReaper(tunnels, persistence.NewMemoryCustomDomainRepository(), nil, time.Hour, false)
+	r.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+
+	reaped, err := r.Reap(ctx)
+	if err != nil {
+		t.Fatalf("Reap: %v", err)
+	}
+	if len(reaped) != 1 || reaped[0].ID != abandoned.ID {
+		t.Fatalf("Reap() = %v, want only the abandoned tunnel", reaped)
+	}
+	for _, kept := range []*domain.Tunnel{reserved, reconnected} {
+		if stored, _ := env.tunnels.FindByID(ctx, kept.ID); stored == nil {
+			t.Errorf("tunnel %s was deleted after it changed", kept.Endpoints.Subdomain)
+		}
+	}
+}
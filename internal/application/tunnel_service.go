@@ -4,7 +4,9 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	mathrand "math/rand/v2"
 	"time"
 
 	"github.com/google/uuid"
@@ -14,19 +16,65 @@ import (
 // DTO - Data Transfer Objects для передачи данных из/в слой интерфейсов
 type CreateTunnelRequest struct {
 	UserID    domain.UserID
+	Protocol  domain.TunnelProtocol
 	Subdomain string
 	LocalPort int
+	// HTTPSRedirect перенаправляет HTTP-запросы к туннелю на HTTPS
+	HTTPSRedirect bool
 }
 
+// UpdateTunnelRequest — изменяемые поля туннеля; nil означает «не менять».
+// Смена поддомена закрепляет его за пользователем, если Reserved не задан явно.
+type UpdateTunnelRequest struct {
+	Subdomain     *string
+	LocalHost     *string
+	LocalPort     *int
+	HTTPSRedirect *bool
+	Reserved      *bool
+}
+
+type ListTunnelsRequest struct {
+	Status domain.TunnelStatus
+	Limit  int
+	Offset int
+}
+
+// TunnelPage — страница списка туннелей и их общее число по фильтру.
+type TunnelPage struct {
+	Tunnels []*domain.Tunnel
+	Total   int
+	Limit   int
+	Offset  int
+}
+
+const (
+	defaultTunnelPageSize = 50
+	maxTunnelPageSize     = 100
+)
+
+// AgentPresence сообщает, подключён ли агент туннеля к этому серверу прямо сейчас.
+type AgentPresence interface {
+	AgentOnline(id domain.TunnelID) bool
+}
+
+// сколько раз пробуем выделить порт, если его одновременно занял другой туннель
+const portAllocationAttempts = 5
+
 type TunnelService struct {
 	tunnelRepo domain.TunnelRepository // Зависимость от ИНТЕРФЕЙСА
 	userRepo   domain.UserRepository
+	// baseDomain — домен, в котором туннели получают поддомены
+	baseDomain string
+	tcpPorts   domain.PortRange
+	policy     TunnelPolicy
+	// presence может быть nil, тогда туннели считаются не подключёнными
+	presence AgentPresence
 }
 
 // *** ИСПРАВЛЕННАЯ СИГНАТУРА КОНСТРУКТОРА ***
 // Теперь он принимает ИНТЕРФЕЙС, а не конкретную структуру.
-func NewTunnelService(tunnelRepo domain.TunnelRepository, userRepo domain.UserRepository) *TunnelService {
-	return &TunnelService{tunnelRepo: tunnelRepo, userRepo: userRepo}
+func NewTunnelService(tunnelRepo domain.TunnelRepository, userRepo domain.UserRepository, presence AgentPresence, baseDomain string, tcpPorts domain.PortRange) *TunnelService {
+	return &TunnelService{tunnelRepo: tunnelRepo, userRepo: userRepo, presence: presence, baseDomain: baseDomain, tcpPorts: tcpPorts, policy: OwnerPolicy{}}
 }
 
 func (s *TunnelService) GetUserRepository() domain.UserRepository {
@@ -34,7 +82,23 @@ func (s *TunnelService) GetUserRepository() domain.UserRepository {
 }
 
 func (s *TunnelService) CreateTunnel(ctx context.Context, req CreateTunnelRequest) (*domain.Tunnel, error) {
-	if req.Subdomain == "" {
+	switch req.Protocol {
+	case "":
+		req.Protocol = domain.ProtocolHTTP
+	case domain.ProtocolHTTP, domain.ProtocolTCP:
+	default:
+		return nil, domain.ErrUnsupportedProtocol
+	}
+
+	// Поддомен, выбранный пользователем, закрепляется за ним, случайный — нет
+	reserved := req.Subdomain != ""
+	if reserved {
+		subdomain, err := domain.NormalizeSubdomain(req.Subdomain)
+		if err != nil {
+			return nil, err
+		}
+		req.Subdomain = subdomain
+	} else {
 		randomBytes := make([]byte, 5)
 		if _, err := rand.Read(randomBytes); err != nil {
 			return nil, fmt.Errorf("failed to generate random subdomain: %w", err)
@@ -43,22 +107,35 @@ func (s *TunnelService) CreateTunnel(ctx context.Context, req CreateTunnelReques
 	}
 
 	newTunnel := &domain.Tunnel{
-		ID:     domain.TunnelID(uuid.New().String()),
-		UserID: "",
+		ID:       domain.TunnelID(uuid.New().String()),
+		UserID:   "",
+		Protocol: req.Protocol,
 		Endpoints: domain.Endpoint{
 			Subdomain: req.Subdomain,
-			Domain:    "gtunnel.ru",
+			Domain:    s.baseDomain,
 			Port:      80,
 		},
 		LocalTarget: domain.LocalTarget{
 			Host: "localhost",
 			Port: req.LocalPort,
 		},
-		Status:    domain.StatusInactive,
-		CreatedAt: time.Now(),
+		HTTPSRedirect: req.HTTPSRedirect,
+		Reserved:      reserved,
+		Status:        domain.StatusInactive,
+		CreatedAt:     time.Now(),
 	}
 
 	newTunnel.UserID = req.UserID
+	if err := s.policy.Authorize(req.UserID, ActionCreateTunnel, newTunnel); err != nil {
+		return nil, err
+	}
+
+	if newTunnel.Protocol == domain.ProtocolTCP {
+		if err := s.saveWithPort(ctx, newTunnel); err != nil {
+			return nil, fmt.Errorf("failed to create tunnel: %w", err)
+		}
+		return newTunnel, nil
+	}
 
 	if err := s.tunnelRepo.Save(ctx, newTunnel); err != nil {
 		return nil, fmt.Errorf("failed to create tunnel: %w", err)
@@ -67,6 +144,256 @@ func (s *TunnelService) CreateTunnel(ctx context.Context, req CreateTunnelReques
 	return newTunnel, nil
 }
 
-func (s *TunnelService) DeleteTunnel(ctx context.Context, subdomain string) error {
-	return s.tunnelRepo.Delete(ctx, subdomain)
+// OpenTunnel возвращает туннель, к которому подключится агент. Если поддомен
+// уже закреплён за пользователем, туннель переиспользуется с новыми локальным
+// портом и редиректом, иначе создаётся новый; created сообщает, какой из
+// случаев произошёл.
+func (s *TunnelService) OpenTunnel(ctx context.Context, req CreateTunnelRequest) (tunnel *domain.Tunnel, created bool, err error) {
+	if req.Subdomain != "" {
+		tunnel, err := s.reuseTunnel(ctx, req)
+		if err != nil || tunnel != nil {
+			return tunnel, false, err
+		}
+	}
+
+	tunnel, err = s.CreateTunnel(ctx, req)
+	if errors.Is(err, domain.ErrSubdomainTaken) && req.Subdomain != "" {
+		// Тот же поддомен успел создать параллельный запрос
+		tunnel, err = s.reuseTunnel(ctx, req)
+		if err == nil && tunnel == nil {
+			err = domain.ErrSubdomainTaken
+		}
+		return tunnel, false, err
+	}
+	return tunnel, err == nil, err
+}
+
+// reuseTunnel находит туннель пользователя с запрошенным поддоменом и
+// обновляет его локальный порт и редирект. Возвращает nil, если поддомен
+// свободен. Чужой поддомен или туннель другого протокола считаются занятыми.
+func (s *TunnelService) reuseTunnel(ctx context.Context, req CreateTunnelRequest) (*domain.Tunnel, error) {
+	subdomain, err := domain.NormalizeSubdomain(req.Subdomain)
+	if err != nil {
+		return nil, err
+	}
+	tunnel, err := s.tunnelRepo.FindBySubdomain(ctx, subdomain)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find tunnel: %w", err)
+	}
+	if tunnel == nil {
+		return nil, nil
+	}
+
+	if err := s.policy.Authorize(req.UserID, ActionUpdateTunnel, tunnel); err != nil {
+		return nil, domain.ErrSubdomainTaken
+	}
+	protocol := req.Protocol
+	if protocol == "" {
+		protocol = domain.ProtocolHTTP
+	}
+	if tunnel.Protocol != protocol {
+		return nil, fmt.Errorf("%w by a %s tunnel", domain.ErrSubdomainTaken, tunnel.Protocol)
+	}
+	if req.LocalPort < 1 || req.LocalPort > 65535 {
+		return nil, domain.ErrInvalidLocalTarget
+	}
+
+	tunnel.LocalTarget.Port = req.LocalPort
+	tunnel.HTTPSRedirect = req.HTTPSRedirect
+	tunnel.Reserved = true
+	if err := s.tunnelRepo.Update(ctx, tunnel); err != nil {
+		return nil, fmt.Errorf("failed to update tunnel: %w", err)
+	}
+	return tunnel, nil
+}
+
+// saveWithPort выделяет TCP-туннелю свободный публичный порт и сохраняет его.
+// Порт может успеть занять параллельный запрос, тогда пробуем другой.
+func (s *TunnelService) saveWithPort(ctx context.Context, tunnel *domain.Tunnel) error {
+	for range portAllocationAttempts {
+		port, err := s.pickFreePort(ctx)
+		if err != nil {
+			return err
+		}
+		tunnel.Endpoints.Port = port
+
+		err = s.tunnelRepo.Save(ctx, tunnel)
+		if !errors.Is(err, domain.ErrPortTaken) {
+			return err
+		}
+	}
+	return domain.ErrNoFreePorts
+}
+
+func (s *TunnelService) pickFreePort(ctx context.Context) (int, error) {
+	used, err := s.tunnelRepo.ListPublicPorts(ctx)
+	if err != nil {
+		return 0, err
+	}
+	taken := make(map[int]bool, len(used))
+	for _, port := range used {
+		taken[port] = true
+	}
+
+	var free []int
+	for port := s.tcpPorts.Min; port <= s.tcpPorts.Max; port++ {
+		if !taken[port] {
+			free = append(free, port)
+		}
+	}
+	if len(free) == 0 {
+		return 0, domain.ErrNoFreePorts
+	}
+	return free[mathrand.IntN(len(free))], nil
+}
+
+func (s *TunnelService) ListTunnels(ctx context.Context, userID domain.UserID, req ListTunnelsRequest) (*TunnelPage, error) {
+	switch req.Status {
+	case "", domain.StatusActive, domain.StatusInactive:
+	default:
+		return nil, domain.ErrInvalidTunnelStatus
+	}
+	if req.Limit <= 0 {
+		req.Limit = defaultTunnelPageSize
+	}
+	req.Limit = min(req.Limit, maxTunnelPageSize)
+	req.Offset = max(req.Offset, 0)
+
+	tunnels, total, err := s.tunnelRepo.ListByUser(ctx, userID, domain.TunnelFilter{
+		Status: req.Status,
+		Limit:  req.Limit,
+		Offset: req.Offset,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tunnels: %w", err)
+	}
+	return &TunnelPage{Tunnels: tunnels, Total: total, Limit: req.Limit, Offset: req.Offset}, nil
+}
+
+// GetTunnel возвращает туннель пользователя по ID или поддомену.
+func (s *TunnelService) GetTunnel(ctx context.Context, userID domain.UserID, ref string) (*domain.Tunnel, error) {
+	return authorizedTunnel(ctx, s.tunnelRepo, s.policy, userID, ActionViewTunnel, ref)
+}
+
+func (s *TunnelService) UpdateTunnel(ctx context.Context, userID domain.UserID, ref string, req UpdateTunnelRequest) (*domain.Tunnel, error) {
+	tunnel, err := authorizedTunnel(ctx, s.tunnelRepo, s.policy, userID, ActionUpdateTunnel, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Subdomain != nil {
+		subdomain, err := domain.NormalizeSubdomain(*req.Subdomain)
+		if err != nil {
+			return nil, err
+		}
+		tunnel.Endpoints.Subdomain = subdomain
+		tunnel.Reserved = true
+	}
+	if req.Reserved != nil {
+		tunnel.Reserved = *req.Reserved
+	}
+	if req.LocalHost != nil {
+		if *req.LocalHost == "" {
+			return nil, domain.ErrInvalidLocalTarget
+		}
+		tunnel.LocalTarget.Host = *req.LocalHost
+	}
+	if req.LocalPort != nil {
+		if *req.LocalPort < 1 || *req.LocalPort > 65535 {
+			return nil, domain.ErrInvalidLocalTarget
+		}
+		tunnel.LocalTarget.Port = *req.LocalPort
+	}
+	if req.HTTPSRedirect != nil {
+		tunnel.HTTPSRedirect = *req.HTTPSRedirect
+	}
+
+	if err := s.tunnelRepo.Update(ctx, tunnel); err != nil {
+		return nil, fmt.Errorf("failed to update tunnel: %w", err)
+	}
+	return tunnel, nil
+}
+
+func (s *TunnelService) DeleteTunnel(ctx context.Context, userID domain.UserID, ref string) error {
+	tunnel, err := authorizedTunnel(ctx, s.tunnelRepo, s.policy, userID, ActionDeleteTunnel, ref)
+	if err != nil {
+		return err
+	}
+	return s.tunnelRepo.Delete(ctx, tunnel.Endpoints.Subdomain)
+}
+
+// AuthorizeAgent проверяет, что агент с данным API-ключом владеет туннелем,
+// к которому он пытается подключиться.
+func (s *TunnelService) AuthorizeAgent(ctx context.Context, tunnelID domain.TunnelID, apiKey string) (*domain.Tunnel, error) {
+	if apiKey == "" {
+		return nil, domain.ErrInvalidAPIKey
+	}
+
+	user, err := s.userRepo.FindByAPIKey(ctx, apiKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find user by API key: %w", err)
+	}
+	if user == nil {
+		return nil, domain.ErrInvalidAPIKey
+	}
+
+	if _, err := uuid.Parse(string(tunnelID)); err != nil {
+		return nil, domain.ErrTunnelNotFound
+	}
+
+	tunnel, err := s.tunnelRepo.FindByID(ctx, tunnelID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find tunnel: %w", err)
+	}
+	if tunnel == nil {
+		return nil, domain.ErrTunnelNotFound
+	}
+
+	if err := s.policy.Authorize(user.ID, ActionConnectTunnel, tunnel); err != nil {
+		return nil, err
+	}
+
+	return tunnel, nil
+}
+
+// Online сообщает, подключён ли агент туннеля прямо сейчас. В отличие от
+// статуса, он ложен и пока сервер ждёт переподключения оборвавшегося агента.
+func (s *TunnelService) Online(id domain.TunnelID) bool {
+	return s.presence != nil && s.presence.AgentOnline(id)
+}
+
+// AgentConnected отмечает туннель активным и запоминает, какой агент к нему подключился.
+func (s *TunnelService) AgentConnected(ctx context.Context, tunnel *domain.Tunnel, agent domain.AgentInfo) error {
+	tunnel.Activate(agent, time.Now())
+	if err := s.tunnelRepo.UpdateStatus(ctx, tunnel); err != nil {
+		return fmt.Errorf("failed to update tunnel status: %w", err)
+	}
+	return nil
+}
+
+// AgentDisconnected отмечает туннель неактивным, когда его сессия закрыта
+// окончательно. Туннель могли удалить, пока агент был подключён, — это не ошибка.
+func (s *TunnelService) AgentDisconnected(ctx context.Context, id domain.TunnelID) error {
+	tunnel, err := s.tunnelRepo.FindByID(ctx, id)
+	if err != nil {
+		return fmt.Errorf("failed to find tunnel: %w", err)
+	}
+	if tunnel == nil {
+		return nil
+	}
+	tunnel.Deactivate(time.Now())
+	if err := s.tunnelRepo.UpdateStatus(ctx, tunnel); err != nil && !errors.Is(err, domain.ErrTunnelNotFound) {
+		return fmt.Errorf("failed to update tunnel status: %w", err)
+	}
+	return nil
+}
+
+// ResetStatuses снимает статус active, оставшийся от прошлого запуска сервера:
+// сессии агентов живут в памяти и перезапуск не переживают.
+func (s *TunnelService) ResetStatuses(ctx context.Context) (int, error) {
+	n, err := s.tunnelRepo.ResetStatuses(ctx, time.Now())
+	if err != nil {
+		return 0, fmt.Errorf("failed to reset tunnel statuses: %w", err)
+	}
+	return n, nil
 }
@@ -0,0 +1,334 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/waste3d/ghost-tunnel/internal/domain"
+	"github.com/waste3d/ghost-tunnel/internal/infrastructure/persistence"
+)
+
+type testEnv struct {
+	tunnels domain.TunnelRepository
+	users   domain.UserRepository
+	service *TunnelService
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	tunnels := persistence.NewMemoryTunnelRepository()
+	users := persistence.NewMemoryUserRepository()
+	return &testEnv{
+		tunnels: tunnels,
+		users:   users,
+		service: NewTunnelService(tunnels, users, nil, "example.test", domain.PortRange{Min: 20000, Max: 20010}),
+	}
+}
+
+func (e *testEnv) user(t *testing.T, email string) *domain.User {
+	t.Helper()
+	user, err := domain.NewUser(email, "secret")
+	if err != nil {
+		t.Fatalf("NewUser: %v", err)
+	}
+	if err := e.users.Save(context.Background(), user); err != nil {
+		t.Fatalf("Save user: %v", err)
+	}
+	return user
+}
+
+func (e *testEnv) tunnel(t *testing.T, owner *domain.User, subdomain string) *domain.Tunnel {
+	t.Helper()
+	tunnel, err := e.service.CreateTunnel(context.Background(), CreateTunnelRequest{
+		UserID:    owner.ID,
+		Subdomain: subdomain,
+		LocalPort: 3000,
+	})
+	if err != nil {
+		t.Fatalf("CreateTunnel: %v", err)
+	}
+	return tunnel
+}
+
+func TestOwnerPolicy(t *testing.T) {
+	tunnel := &domain.Tunnel{UserID: "alice"}
+	cases := []struct {
+		name    string
+		actor   domain.UserID
+		tunnel  *domain.Tunnel
+		allowed bool
+	}{
+		{"owner", "alice", tunnel, true},
+		{"other user", "bob", tunnel, false},
+		{"anonymous", "", tunnel, false},
+		{"tunnel without owner", "alice", &domain.Tunnel{}, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := OwnerPolicy{}.Authorize(tc.actor, ActionDeleteTunnel, tc.tunnel)
+			if tc.allowed && err != nil {
+				t.Errorf("Authorize() = %v, want nil", err)
+			}
+			if !tc.allowed && !errors.Is(err, domain.ErrForbidden) {
+				t.Errorf("Authorize() = %v, want ErrForbidden", err)
+			}
+		})
+	}
+}
+
+func TestTunnelServiceCrossUserAccess(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t)
+	alice := env.user(t, "alice@example.test")
+	bob := env.user(t, "bob@example.test")
+	tunnel := env.tunnel(t, alice, "alice-app")
+	newSubdomain := "stolen"
+
+	// чужой туннель нельзя ни прочитать, ни изменить, ни удалить — ни по ID, ни по поддомену
+	for _, ref := range []string{string(tunnel.ID), tunnel.Endpoints.Subdomain} {
+		if _, err := env.service.GetTunnel(ctx, bob.ID, ref); !errors.Is(err, domain.ErrForbidden) {
+			t.Errorf("GetTunnel(%s) by other user = %v, want ErrForbidden", ref, err)
+		}
+		_, err := env.service.UpdateTunnel(ctx, bob.ID, ref, UpdateTunnelRequest{Subdomain: &newSubdomain})
+		if !errors.Is(err, domain.ErrForbidden) {
+			t.Errorf("UpdateTunnel(%s) by other user = %v, want ErrForbidden", ref, err)
+		}
+		if err := env.service.DeleteTunnel(ctx, bob.ID, ref); !errors.Is(err, domain.ErrForbidden) {
+			t.Errorf("DeleteTunnel(%s) by other user = %v, want ErrForbidden", ref, err)
+		}
+		if _, err := env.service.AuthorizeAgent(ctx, tunnel.ID, string(bob.APIKey)); !errors.Is(err, domain.ErrForbidden) {
+			t.Errorf("AuthorizeAgent by other user = %v, want ErrForbidden", err)
+		}
+	}
+
+	stored, err := env.tunnels.FindByID(ctx, tunnel.ID)
+	if err != nil || stored == nil {
+		t.Fatalf("tunnel disappeared after rejected calls: %v, %v", stored, err)
+	}
+	if stored.Endpoints.Subdomain != "alice-app" {
+		t.Errorf("subdomain changed by other user to %q", stored.Endpoints.Subdomain)
+	}
+
+	page, err := env.service.ListTunnels(ctx, bob.ID, ListTunnelsRequest{})
+	if err != nil {
+		t.Fatalf("ListTunnels: %v", err)
+	}
+	if page.Total != 0 || len(page.Tunnels) != 0 {
+		t.Errorf("other user sees %d tunnels, want none", page.Total)
+	}
+
+	// владельцу всё разрешено
+	if _, err := env.service.GetTunnel(ctx, alice.ID, tunnel.Endpoints.Subdomain); err != nil {
+		t.Errorf("GetTunnel by owner: %v", err)
+	}
+	if _, err := env.service.AuthorizeAgent(ctx, tunnel.ID, string(alice.APIKey)); err != nil {
+		t.Errorf("AuthorizeAgent by owner: %v", err)
+	}
+	if err := env.service.DeleteTunnel(ctx, alice.ID, string(tunnel.ID)); err != nil {
+		t.Errorf("DeleteTunnel by owner: %v", err)
+	}
+	if _, err := env.service.GetTunnel(ctx, alice.ID, string(tunnel.ID)); !errors.Is(err, domain.ErrTunnelNotFound) {
+		t.Errorf("GetTunnel after delete = %v, want ErrTunnelNotFound", err)
+	}
+}
+
+func TestDomainServiceCrossUserAccess(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t)
+	alice := env.user(t, "alice@example.test")
+	bob := env.user(t, "bob@example.test")
+	tunnel := env.tunnel(t, alice, "alice-app")
+
+	domains := NewDomainService(persistence.NewMemoryCustomDomainRepository(), env.tunnels, noDNS{}, nil)
+	if _, err := domains.AddDomain(ctx, bob.ID, string(tunnel.ID), AddDomainRequest{Hostname: "app.example.org"}); !errors.Is(err, domain.ErrForbidden) {
+		t.Errorf("AddDomain by other user = %v, want ErrForbidden", err)
+	}
+	if _, err := domains.ListDomains(ctx, bob.ID, tunnel.Endpoints.Subdomain); !errors.Is(err, domain.ErrForbidden) {
+		t.Errorf("ListDomains by other user = %v, want ErrForbidden", err)
+	}
+	if _, err := domains.AddDomain(ctx, alice.ID, string(tunnel.ID), AddDomainRequest{Hostname: "app.example.org"}); err != nil {
+		t.Errorf("AddDomain by owner: %v", err)
+	}
+}
+
+func TestTunnelServiceAgentStatus(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t)
+	alice := env.user(t, "alice@example.test")
+	tunnel := env.tunnel(t, alice, "alice-app")
+
+	if err := env.service.AgentConnected(ctx, tunnel, domain.AgentInfo{Version: "1.2.3", RemoteAddr: "203.0.113.7:52000"}); err != nil {
+		t.Fatalf("AgentConnected: %v", err)
+	}
+	stored, err := env.service.GetTunnel(ctx, alice.ID, string(tunnel.ID))
+	if err != nil {
+		t.Fatalf("GetTunnel: %v", err)
+	}
+	if stored.Status != domain.StatusActive || stored.LastConnectedAt == nil || stored.Agent.Version != "1.2.3" {
+		t.Errorf("after connect tunnel = %+v, want active with agent info", stored)
+	}
+
+	if err := env.service.AgentDisconnected(ctx, tunnel.ID); err != nil {
+		t.Fatalf("AgentDisconnected: %v", err)
+	}
+	stored, err = env.service.GetTunnel(ctx, alice.ID, string(tunnel.ID))
+	if err != nil {
+		t.Fatalf("GetTunnel: %v", err)
+	}
+	if stored.Status != domain.StatusInactive || stored.Agent.RemoteAddr != "203.0.113.7:52000" {
+		t.Errorf("after disconnect tunnel = %+v, want inactive with last agent info kept", stored)
+	}
+
+	// туннель удалили, пока агент был подключён
+	if err := env.service.DeleteTunnel(ctx, alice.ID, string(tunnel.ID)); err != nil {
+		t.Fatalf("DeleteTunnel: %v", err)
+	}
+	if err := env.service.AgentDisconnected(ctx, tunnel.ID); err != nil {
+		t.Errorf("AgentDisconnected(deleted) = %v, want nil", err)
+	}
+}
+
+func TestTunnelServiceOpenTunnel(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t)
+	alice := env.user(t, "alice@example.test")
+	bob := env.user(t, "bob@example.test")
+
+	first, created, err := env.service.OpenTunnel(ctx, CreateTunnelRequest{UserID: alice.ID, Subdomain: "Alice-App", LocalPort: 3000})
+	if err != nil || !created {
+		t.Fatalf("OpenTunnel(new) = %v, %v; want created", created, err)
+	}
+	if first.Endpoints.Subdomain != "alice-app" || !first.Reserved {
+		t.Errorf("new tunnel = %+v, want reserved alice-app", first)
+	}
+
+	// повторный запуск с тем же поддоменом получает тот же туннель
+	again, created, err := env.service.OpenTunnel(ctx, CreateTunnelRequest{UserID: alice.ID, Subdomain: "alice-app", LocalPort: 8080, HTTPSRedirect: true})
+	if err != nil || created {
+		t.Fatalf("OpenTunnel(reserved) = %v, %v; want reused", created, err)
+	}
+	if again.ID != first.ID || again.LocalTarget.Port != 8080 || !again.HTTPSRedirect {
+		t.Errorf("reused tunnel = %+v, want %s with port 8080 and redirect", again, first.ID)
+	}
+
+	if _, _, err := env.service.OpenTunnel(ctx, CreateTunnelRequest{UserID: bob.ID, Subdomain: "alice-app", LocalPort: 3000}); !errors.Is(err, domain.ErrSubdomainTaken) {
+		t.Errorf("OpenTunnel(other user's subdomain) = %v, want ErrSubdomainTaken", err)
+	}
+	if _, _, err := env.service.OpenTunnel(ctx, CreateTunnelRequest{UserID: alice.ID, Protocol: domain.ProtocolTCP, Subdomain: "alice-app", LocalPort: 3000}); !errors.Is(err, domain.ErrSubdomainTaken) {
+		t.Errorf("OpenTunnel(other protocol) = %v, want ErrSubdomainTaken", err)
+	}
+
+	// без поддомена каждый раз создаётся новый незакреплённый туннель
+	random, created, err := env.service.OpenTunnel(ctx, CreateTunnelRequest{UserID: alice.ID, LocalPort: 3000})
+	if err != nil || !created || random.Reserved {
+		t.Errorf("OpenTunnel(random) = %+v, %v, %v; want new unreserved tunnel", random, created, err)
+	}
+
+	// выбранный пользователем поддомен закрепляется и при смене через PATCH
+	newSubdomain := "alice-api"
+	updated, err := env.service.UpdateTunnel(ctx, alice.ID, string(random.ID), UpdateTunnelRequest{Subdomain: &newSubdomain})
+	if err != nil || !updated.Reserved {
+		t.Errorf("UpdateTunnel(subdomain) = %+v, %v; want reserved", updated, err)
+	}
+}
+
+func TestTunnelServiceOnline(t *testing.T) {
+	env := newTestEnv(t)
+	if env.service.Online("any") {
+		t.Error("Online() without presence = true, want false")
+	}
+
+	online := onlineSet{"a": true}
+	service := NewTunnelService(env.tunnels, env.users, online, "example.test", domain.PortRange{})
+	if !service.Online("a") || service.Online("b") {
+		t.Error("Online() does not follow agent presence")
+	}
+}
+
+// collidingTunnels перед каждым из первых collisions сохранений занимает
+// выбранный порт другим туннелем, как параллельный запрос, и возвращает ErrPortTaken.
+type collidingTunnels struct {
+	domain.TunnelRepository
+	collisions int
+	taken      []int
+}
+
+func (r *collidingTunnels) Save(ctx context.Context, tunnel *domain.Tunnel) error {
+	if tunnel.Protocol != domain.ProtocolTCP || len(r.taken) == r.collisions {
+		return r.TunnelRepository.Save(ctx, tunnel)
+	}
+	rival := *tunnel
+	rival.ID = domain.TunnelID(uuid.New().String())
+	rival.Endpoints.Subdomain = "rival-" + string(rival.ID)[:8]
+	if err := r.TunnelRepository.Save(ctx, &rival); err != nil {
+		return err
+	}
+	r.taken = append(r.taken, tunnel.Endpoints.Port)
+	return domain.ErrPortTaken
+}
+
+func TestTunnelServiceTCPPorts(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t)
+	alice := env.user(t, "alice@example.test")
+	tcp := CreateTunnelRequest{UserID: alice.ID, Protocol: domain.ProtocolTCP, LocalPort: 22}
+
+	t.Run("retry after collision", func(t *testing.T) {
+		tunnels := &collidingTunnels{TunnelRepository: persistence.NewMemoryTunnelRepository(), collisions: 1}
+		service := NewTunnelService(tunnels, env.users, nil, "example.test", domain.PortRange{Min: 20000, Max: 20001})
+		tunnel, err := service.CreateTunnel(ctx, tcp)
+		if err != nil {
+			t.Fatalf("CreateTunnel: %v", err)
+		}
+		if len(tunnels.taken) != 1 || tunnel.Endpoints.Port == tunnels.taken[0] {
+			t.Fatalf("port %d after collision on %v, want the other port", tunnel.Endpoints.Port, tunnels.taken)
+		}
+		if port := tunnel.Endpoints.Port; port < 20000 || port > 20001 {
+			t.Errorf("port %d is outside the range", port)
+		}
+	})
+
+	t.Run("range exhausted", func(t *testing.T) {
+		service := NewTunnelService(persistence.NewMemoryTunnelRepository(), env.users, nil, "example.test", domain.PortRange{Min: 20000, Max: 20000})
+		if _, err := service.CreateTunnel(ctx, tcp); err != nil {
+			t.Fatalf("CreateTunnel: %v", err)
+		}
+		if _, err := service.CreateTunnel(ctx, tcp); !errors.Is(err, domain.ErrNoFreePorts) {
+			t.Errorf("CreateTunnel with no free ports = %v, want ErrNoFreePorts", err)
+		}
+		// HTTP-туннелям порт не нужен
+		if _, err := service.CreateTunnel(ctx, CreateTunnelRequest{UserID: alice.ID, LocalPort: 3000}); err != nil {
+			t.Errorf("CreateTunnel(http) = %v", err)
+		}
+	})
+
+	t.Run("collisions on every attempt", func(t *testing.T) {
+		tunnels := &collidingTunnels{TunnelRepository: persistence.NewMemoryTunnelRepository(), collisions: 100}
+		service := NewTunnelService(tunnels, env.users, nil, "example.test", domain.PortRange{Min: 20000, Max: 20100})
+		if _, err := service.CreateTunnel(ctx, tcp); !errors.Is(err, domain.ErrNoFreePorts) {
+			t.Errorf("CreateTunnel = %v, want ErrNoFreePorts", err)
+		}
+		if len(tunnels.taken) != portAllocationAttempts {
+			t.Errorf("made %d attempts, want %d", len(tunnels.taken), portAllocationAttempts)
+		}
+	})
+}
+
+type onlineSet map[domain.TunnelID]bool
+
+func (s onlineSet) AgentOnline(id domain.TunnelID) bool {
+	return s[id]
+}
+
+// noDNS — резолвер без записей: домены не подтверждаются.
+type noDNS struct{}
+
+func (noDNS) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	return nil, errors.New("no such host")
+}
+
+func (noDNS) LookupCNAME(ctx context.Context, host string) (string, error) {
+	return "", errors.New("no such host")
+}
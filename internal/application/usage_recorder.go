@@ -0,0 +1,88 @@
+package application
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/waste3d/ghost-tunnel/internal/domain"
+)
+
+type usageKey struct {
+	tunnelID domain.TunnelID
+	start    time.Time
+}
+
+// UsageRecorder копит использование туннелей в памяти по корзинам
+// domain.StatsBucketSize и периодически прибавляет их к сохранённым. Методы
+// Record* вызываются на пути проксирования и в базу не ходят.
+type UsageRecorder struct {
+	repo domain.TunnelStatsRepository
+	now  func() time.Time
+
+	mu      sync.Mutex
+	pending map[usageKey]*domain.TunnelStats
+}
+
+func NewUsageRecorder(repo domain.TunnelStatsRepository) *UsageRecorder {
+	return &UsageRecorder{repo: repo, now: time.Now, pending: make(map[usageKey]*domain.TunnelStats)}
+}
+
+// RecordRequest учитывает HTTP-запрос: код ответа и время до его заголовков.
+func (r *UsageRecorder) RecordRequest(tunnelID domain.TunnelID, status int, latency time.Duration) {
+	r.update(tunnelID, func(s *domain.TunnelStats) { s.AddRequest(status, latency) })
+}
+
+// RecordConnection учитывает соединение, открытое через туннель.
+func (r *UsageRecorder) RecordConnection(tunnelID domain.TunnelID) {
+	r.update(tunnelID, func(s *domain.TunnelStats) { s.Connections++ })
+}
+
+// RecordBytes учитывает байты, переданные к локальному сервису (in) и от него (out).
+func (r *UsageRecorder) RecordBytes(tunnelID domain.TunnelID, in, out int64) {
+	r.update(tunnelID, func(s *domain.TunnelStats) {
+		s.BytesIn += in
+		s.BytesOut += out
+	})
+}
+
+func (r *UsageRecorder) update(tunnelID domain.TunnelID, apply func(*domain.TunnelStats)) {
+	key := usageKey{tunnelID: tunnelID, start: r.now().UTC().Truncate(domain.StatsBucketSize)}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.pending[key]
+	if !ok {
+		s = &domain.TunnelStats{TunnelID: tunnelID, Start: key.start}
+		r.pending[key] = s
+	}
+	apply(s)
+}
+
+// Flush сохраняет накопленное. Если запись не удалась, данные возвращаются
+// в очередь и уйдут со следующей попыткой.
+func (r *UsageRecorder) Flush(ctx context.Context) error {
+	r.mu.Lock()
+	pending := r.pending
+	r.pending = make(map[usageKey]*domain.TunnelStats)
+	r.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+	stats := slices.Collect(maps.Values(pending))
+	if err := r.repo.Add(ctx, stats); err != nil {
+		r.mu.Lock()
+		for key, s := range pending {
+			if current, ok := r.pending[key]; ok {
+				s.Merge(current)
+			}
+			r.pending[key] = s
+		}
+		r.mu.Unlock()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package domain
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrDomainTaken     = errors.New("domain is already attached to a tunnel")
+	ErrDomainNotFound  = errors.New("domain not found")
+	ErrInvalidHostname = errors.New("invalid hostname")
+	// ErrDomainNotVerified — в DNS не нашлось ни TXT, ни CNAME записи, подтверждающей владение.
+	ErrDomainNotVerified = errors.New("domain ownership is not verified")
+)
+
+// VerificationRecordPrefix — поддомен TXT-записи для подтверждения владения:
+// _ghost-tunnel.<hostname> TXT "<VerificationToken>".
+const VerificationRecordPrefix = "_ghost-tunnel."
+
+// UnverifiedDomainTTL — сколько хранится неподтверждённая заявка на домен.
+// Заявка не мешает другим туннелям претендовать на то же имя, но копиться
+// вечно незачем.
+const UnverifiedDomainTTL = 7 * 24 * time.Hour
+
+// CertificateStatus — состояние TLS-сертификата собственного домена.
+type CertificateStatus string
+
+const (
+	CertificatePending CertificateStatus = "pending"
+	CertificateIssued  CertificateStatus = "issued"
+	CertificateFailed  CertificateStatus = "failed"
+)
+
+// CustomDomain — заявка туннеля на собственное имя пользователя.
+// Трафик на него маршрутизируется и сертификат для него выпускается через ACME
+// только после подтверждения владения через DNS.
+type CustomDomain struct {
+	Hostname          string
+	TunnelID          TunnelID
+	VerificationToken string
+	VerifiedAt        *time.Time
+	CertStatus        CertificateStatus
+	CertError         string
+	CertExpiresAt     *time.Time
+	CreatedAt         time.Time
+}
+
+func NewCustomDomain(hostname string, tunnelID TunnelID, verificationToken string) (*CustomDomain, error) {
+	hostname, err := NormalizeHostname(hostname)
+	if err != nil {
+		return nil, err
+	}
+	return &CustomDomain{
+		Hostname:          hostname,
+		TunnelID:          tunnelID,
+		VerificationToken: verificationToken,
+		CertStatus:        CertificatePending,
+		CreatedAt:         time.Now(),
+	}, nil
+}
+
+// VerificationRecord — имя TXT-записи, в которой ожидается VerificationToken.
+func (d *CustomDomain) VerificationRecord() string {
+	return VerificationRecordPrefix + d.Hostname
+}
+
+func (d *CustomDomain) Verified() bool {
+	return d.VerifiedAt != nil
+}
+
+// MarkVerified фиксирует подтверждение владения доменом.
+func (d *CustomDomain) MarkVerified(at time.Time) {
+	d.VerifiedAt = &at
+}
+
+// NormalizeHostname приводит имя к нижнему регистру и проверяет, что это
+// полное DNS-имя без порта и wildcard.
+func NormalizeHostname(hostname string) (string, error) {
+	hostname = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(hostname)), ".")
+	if len(hostname) == 0 || len(hostname) > 253 || !strings.Contains(hostname, ".") {
+		return "", ErrInvalidHostname
+	}
+	for _, label := range strings.Split(hostname, ".") {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return "", ErrInvalidHostname
+		}
+		for _, c := range label {
+			if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+				return "", ErrInvalidHostname
+			}
+		}
+	}
+	return hostname, nil
+}
+
+// CertificateIssued фиксирует успешный выпуск или продление сертификата.
+func (d *CustomDomain) CertificateIssued(expiresAt time.Time) {
+	d.CertStatus = CertificateIssued
+	d.CertError = ""
+	d.CertExpiresAt = &expiresAt
+}
+
+// CertificateFailed фиксирует ошибку выпуска. Ранее выпущенный сертификат
+// продолжает работать до истечения срока, поэтому дата не сбрасывается.
+func (d *CustomDomain) CertificateFailed(err error) {
+	d.CertStatus = CertificateFailed
+	d.CertError = err.Error()
+}
@@ -0,0 +1,32 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+// CustomDomainRepository хранит заявки туннелей на собственные домены. На одно
+// имя могут претендовать несколько туннелей, но подтверждённой бывает только
+// одна заявка: она вытесняет остальные.
+type CustomDomainRepository interface {
+	// Save добавляет заявку. ErrDomainTaken — имя уже подтверждено или этот
+	// туннель уже на него претендует.
+	Save(ctx context.Context, d *CustomDomain) error
+	// FindVerified возвращает подтверждённую заявку на имя, по ней
+	// маршрутизируется трафик и выпускается сертификат; nil, если такой нет.
+	FindVerified(ctx context.Context, hostname string) (*CustomDomain, error)
+	// FindClaim возвращает заявку туннеля на имя; nil, если такой нет.
+	FindClaim(ctx context.Context, hostname string, tunnelID TunnelID) (*CustomDomain, error)
+	ListByTunnel(ctx context.Context, tunnelID TunnelID) ([]*CustomDomain, error)
+	// List возвращает все домены, например для планировщика продления сертификатов.
+	List(ctx context.Context) ([]*CustomDomain, error)
+	// UpdateVerification сохраняет подтверждение заявки и удаляет заявки других
+	// туннелей на то же имя. ErrDomainTaken — имя уже подтвердил другой туннель,
+	// ErrDomainNotFound — заявки уже нет.
+	UpdateVerification(ctx context.Context, d *CustomDomain) error
+	UpdateCertificate(ctx context.Context, d *CustomDomain) error
+	Delete(ctx context.Context, hostname string, tunnelID TunnelID) error
+	// DeleteUnverified удаляет неподтверждённые заявки, созданные раньше
+	// createdBefore, и возвращает их число.
+	DeleteUnverified(ctx context.Context, createdBefore time.Time) (int, error)
+}
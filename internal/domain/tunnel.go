@@ -1,6 +1,27 @@
 package domain
 
-import "time"
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	ErrTunnelNotFound = errors.New("tunnel not found")
+	// ErrForbidden — у пользователя нет прав на операцию.
+	ErrForbidden      = errors.New("forbidden")
+	ErrTunnelNotOwned = fmt.Errorf("%w: tunnel does not belong to this user", ErrForbidden)
+	ErrSubdomainTaken = errors.New("subdomain is already taken")
+	// ErrInvalidSubdomain — поддомен не является корректной DNS-меткой.
+	ErrInvalidSubdomain    = errors.New("invalid subdomain")
+	ErrInvalidLocalTarget  = errors.New("invalid local host or port")
+	ErrInvalidTunnelStatus = errors.New("invalid tunnel status")
+
+	ErrUnsupportedProtocol = errors.New("unsupported tunnel protocol")
+	ErrPortTaken           = errors.New("public port is already taken")
+	ErrNoFreePorts         = errors.New("no free public ports left")
+)
 
 type TunnelID string
 
@@ -15,6 +36,21 @@ type LocalTarget struct {
 	Port int
 }
 
+// PortRange — диапазон публичных портов, из которого сервер выделяет порты TCP-туннелям.
+type PortRange struct {
+	Min int
+	Max int
+}
+
+type TunnelProtocol string
+
+const (
+	ProtocolHTTP TunnelProtocol = "http"
+	// ProtocolTCP — туннель без разбора HTTP: байты с выделенного публичного
+	// порта (Endpoint.Port) передаются в локальный сервис как есть.
+	ProtocolTCP TunnelProtocol = "tcp"
+)
+
 type TunnelStatus string
 
 const (
@@ -22,21 +58,72 @@ const (
 	StatusInactive TunnelStatus = "inactive"
 )
 
+// AgentInfo — сведения об агенте, который последним подключался к туннелю.
+type AgentInfo struct {
+	Version    string
+	RemoteAddr string
+}
+
 type Tunnel struct {
 	ID          TunnelID
 	UserID      UserID
+	Protocol    TunnelProtocol
 	Endpoints   Endpoint
 	LocalTarget LocalTarget
-	Status      TunnelStatus
-	CreatedAt   time.Time
+	// HTTPSRedirect — отвечать на HTTP-запросы редиректом на HTTPS
+	HTTPSRedirect bool
+	// Reserved — поддомен выбран пользователем и закреплён за ним; такой
+	// туннель переиспользуется при каждом запуске агента. Туннели со
+	// случайным поддоменом не закреплены.
+	Reserved  bool
+	Status    TunnelStatus
+	CreatedAt time.Time
+	// LastConnectedAt — когда агент последний раз подключался; nil, если ни разу
+	LastConnectedAt *time.Time
+	// LastDisconnectedAt — когда агент последний раз отключился; nil, если ни разу
+	LastDisconnectedAt *time.Time
+	Agent              AgentInfo
 }
 
-func (t *Tunnel) Activate() error {
+// NormalizeSubdomain приводит поддомен к нижнему регистру и проверяет, что
+// это одна DNS-метка.
+func NormalizeSubdomain(subdomain string) (string, error) {
+	subdomain = strings.ToLower(strings.TrimSpace(subdomain))
+	if len(subdomain) == 0 || len(subdomain) > 63 || subdomain[0] == '-' || subdomain[len(subdomain)-1] == '-' {
+		return "", ErrInvalidSubdomain
+	}
+	for _, c := range subdomain {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+			return "", ErrInvalidSubdomain
+		}
+	}
+	return subdomain, nil
+}
+
+// Activate отмечает, что к туннелю подключился агент.
+func (t *Tunnel) Activate(agent AgentInfo, at time.Time) {
 	t.Status = StatusActive
-	return nil
+	t.Agent = agent
+	t.LastConnectedAt = &at
 }
 
-func (t *Tunnel) Deactivate() error {
+// Deactivate отмечает, что агент отключился. Сведения о последнем
+// подключении сохраняются.
+func (t *Tunnel) Deactivate(at time.Time) {
 	t.Status = StatusInactive
-	return nil
+	t.LastDisconnectedAt = &at
+}
+
+// OfflineSince — с какого момента у туннеля нет агента. У туннелей, которые
+// отключились до появления LastDisconnectedAt, берётся время последнего
+// подключения, а у ни разу не подключавшихся — время создания.
+func (t *Tunnel) OfflineSince() time.Time {
+	switch {
+	case t.LastDisconnectedAt != nil:
+		return *t.LastDisconnectedAt
+	case t.LastConnectedAt != nil:
+		return *t.LastConnectedAt
+	default:
+		return t.CreatedAt
+	}
 }
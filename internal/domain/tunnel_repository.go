@@ -1,10 +1,44 @@
 package domain
 
-import "context"
+import (
+	"context"
+	"time"
+)
+
+// TunnelFilter — условия выборки туннелей пользователя. Пустой Status —
+// туннели в любом статусе; Limit <= 0 — без ограничения.
+type TunnelFilter struct {
+	Status TunnelStatus
+	Limit  int
+	Offset int
+}
 
 type TunnelRepository interface {
 	Save(ctx context.Context, tunnel *Tunnel) error
 	FindByID(ctx context.Context, id TunnelID) (*Tunnel, error)
 	FindBySubdomain(ctx context.Context, subdomain string) (*Tunnel, error)
+	// ListByUser возвращает страницу туннелей пользователя, новые первыми,
+	// и общее число туннелей, подходящих под фильтр.
+	ListByUser(ctx context.Context, userID UserID, filter TunnelFilter) ([]*Tunnel, int, error)
+	// ListPublicPorts возвращает порты, уже выделенные TCP-туннелям.
+	ListPublicPorts(ctx context.Context) ([]int, error)
+	// Update сохраняет изменяемые пользователем поля туннеля: поддомен,
+	// локальный адрес и настройки. Для несуществующего туннеля — ErrTunnelNotFound.
+	Update(ctx context.Context, tunnel *Tunnel) error
+	// UpdateStatus сохраняет статус туннеля и сведения о последнем подключении
+	// агента. Для несуществующего туннеля — ErrTunnelNotFound.
+	UpdateStatus(ctx context.Context, tunnel *Tunnel) error
+	// ResetStatuses переводит все активные туннели в inactive с временем
+	// отключения at и возвращает, сколько их было. Вызывается при старте
+	// сервера: сессии прошлого запуска не пережили перезапуск.
+	ResetStatuses(ctx context.Context, at time.Time) (int, error)
+	// ListAbandoned возвращает незакреплённые неактивные туннели, у которых
+	// нет агента с момента раньше offlineBefore (см. Tunnel.OfflineSince),
+	// дольше всех простаивающие первыми.
+	ListAbandoned(ctx context.Context, offlineBefore time.Time) ([]*Tunnel, error)
+	// DeleteAbandoned удаляет туннель, только если он всё ещё заброшен в смысле
+	// ListAbandoned, и сообщает, был ли он удалён. Так туннель, который с момента
+	// выборки закрепили или к которому подключился агент, не удаляется.
+	DeleteAbandoned(ctx context.Context, id TunnelID, offlineBefore time.Time) (bool, error)
 	Delete(ctx context.Context, subdomain string) error
 }
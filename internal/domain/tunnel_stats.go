@@ -0,0 +1,81 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// StatsBucketSize — шаг, с которым хранится статистика туннелей. Более
+// крупные интервалы собираются из таких корзин при чтении.
+const StatsBucketSize = time.Minute
+
+var ErrInvalidStatsRange = errors.New("invalid stats range")
+
+// TunnelStats — использование туннеля за интервал, начинающийся в Start.
+// Запросы и коды ответов считаются только для HTTP-туннелей, соединения и
+// байты — для всех.
+type TunnelStats struct {
+	TunnelID TunnelID
+	Start    time.Time
+
+	Requests    int64
+	Connections int64
+	// BytesIn — от посетителей к локальному сервису, BytesOut — обратно
+	BytesIn  int64
+	BytesOut int64
+
+	// Ответы по классам кодов; 502 от самого сервера тоже попадает в Status5xx
+	Status1xx int64
+	Status2xx int64
+	Status3xx int64
+	Status4xx int64
+	Status5xx int64
+
+	// LatencyTotal — сумма времени до заголовков ответа по всем запросам,
+	// среднее — LatencyTotal / Requests
+	LatencyTotal time.Duration
+	LatencyMax   time.Duration
+}
+
+// AddRequest учитывает HTTP-запрос с кодом ответа status.
+func (s *TunnelStats) AddRequest(status int, latency time.Duration) {
+	s.Requests++
+	switch status / 100 {
+	case 1:
+		s.Status1xx++
+	case 2:
+		s.Status2xx++
+	case 3:
+		s.Status3xx++
+	case 4:
+		s.Status4xx++
+	case 5:
+		s.Status5xx++
+	}
+	s.LatencyTotal += latency
+	s.LatencyMax = max(s.LatencyMax, latency)
+}
+
+// Merge прибавляет счётчики other; TunnelID и Start не меняются.
+func (s *TunnelStats) Merge(other *TunnelStats) {
+	s.Requests += other.Requests
+	s.Connections += other.Connections
+	s.BytesIn += other.BytesIn
+	s.BytesOut += other.BytesOut
+	s.Status1xx += other.Status1xx
+	s.Status2xx += other.Status2xx
+	s.Status3xx += other.Status3xx
+	s.Status4xx += other.Status4xx
+	s.Status5xx += other.Status5xx
+	s.LatencyTotal += other.LatencyTotal
+	s.LatencyMax = max(s.LatencyMax, other.LatencyMax)
+}
+
+type TunnelStatsRepository interface {
+	// Add прибавляет счётчики к сохранённым корзинам с теми же TunnelID и
+	// Start. Корзины удалённых туннелей пропускаются.
+	Add(ctx context.Context, stats []*TunnelStats) error
+	// Range возвращает корзины туннеля с Start в [from, to) по возрастанию Start.
+	Range(ctx context.Context, tunnelID TunnelID, from, to time.Time) ([]*TunnelStats, error)
+}
@@ -13,6 +13,7 @@ var (
 	ErrUserAlreadyExists  = errors.New("user with this email already exists")
 	ErrInvalidCredentials = errors.New("invalid email or password")
 	ErrPasswordMismatch   = errors.New("password and confirm password do not match")
+	ErrInvalidAPIKey      = errors.New("invalid API key")
 )
 
 type UserID string
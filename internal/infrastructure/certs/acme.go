@@ -0,0 +1,178 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/waste3d/ghost-tunnel/internal/domain"
+	"github.com/waste3d/ghost-tunnel/internal/infrastructure/logging"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ACMEConfig описывает ACME-клиент. DirectoryURL можно направить на
+// локальный Pebble или другой тестовый CA.
+type ACMEConfig struct {
+	DirectoryURL string
+	Email        string
+	// RootCAs — доверенные корни для HTTPS каталога ACME; нужны тестовым CA
+	// с самоподписанным сертификатом. nil — системные корни.
+	RootCAs *x509.CertPool
+	// RenewBefore — за сколько до истечения продлевать сертификат.
+	RenewBefore time.Duration
+	// CheckInterval — как часто планировщик проверяет сертификаты всех доменов.
+	CheckInterval time.Duration
+}
+
+// ACMEManager выпускает и продлевает сертификаты собственных доменов.
+// Челленджи HTTP-01 отдаёт публичный HTTP слушатель через HTTPHandler,
+// TLS-ALPN-01 — HTTPS слушатель, если в его NextProtos есть acme.ALPNProto.
+type ACMEManager struct {
+	manager       *autocert.Manager
+	domains       domain.CustomDomainRepository
+	checkInterval time.Duration
+
+	mu      sync.Mutex
+	issuing map[string]bool
+}
+
+func NewACMEManager(cfg ACMEConfig, cache autocert.Cache, domains domain.CustomDomainRepository) *ACMEManager {
+	client := &acme.Client{DirectoryURL: cfg.DirectoryURL}
+	if cfg.RootCAs != nil {
+		client.HTTPClient = &http.Client{
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: cfg.RootCAs}},
+		}
+	}
+
+	m := &ACMEManager{
+		domains:       domains,
+		checkInterval: cfg.CheckInterval,
+		issuing:       make(map[string]bool),
+	}
+	m.manager = &autocert.Manager{
+		Prompt:      autocert.AcceptTOS,
+		Cache:       cache,
+		HostPolicy:  m.hostPolicy,
+		Client:      client,
+		Email:       cfg.Email,
+		RenewBefore: cfg.RenewBefore,
+	}
+	return m
+}
+
+func (m *ACMEManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return m.manager.GetCertificate(hello)
+}
+
+// HTTPHandler отвечает на HTTP-01 челленджи и передаёт остальные запросы в fallback.
+func (m *ACMEManager) HTTPHandler(fallback http.Handler) http.Handler {
+	return m.manager.HTTPHandler(fallback)
+}
+
+// Issue запускает выпуск сертификата в фоне; результат записывается в статус домена.
+func (m *ACMEManager) Issue(hostname string) {
+	go m.issue(context.Background(), hostname)
+}
+
+// Run проверяет сертификаты всех доменов сразу и затем раз в CheckInterval,
+// пока не отменён ctx. Сертификаты, которым пора продлеваться, autocert
+// перевыпускает сам, а здесь обновляется статус и повторяются неудачные выпуски.
+func (m *ACMEManager) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.checkInterval)
+	defer ticker.Stop()
+	for {
+		m.checkAll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *ACMEManager) checkAll(ctx context.Context) {
+	domains, err := m.domains.List(ctx)
+	if err != nil {
+		slog.Error("ACME: failed to list domains", logging.Err(err))
+		return
+	}
+	for _, d := range domains {
+		if ctx.Err() != nil {
+			return
+		}
+		if !d.Verified() {
+			continue
+		}
+		m.issue(ctx, d.Hostname)
+	}
+}
+
+func (m *ACMEManager) issue(ctx context.Context, hostname string) {
+	// один и тот же домен не выпускаем параллельно
+	m.mu.Lock()
+	if m.issuing[hostname] {
+		m.mu.Unlock()
+		return
+	}
+	m.issuing[hostname] = true
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		delete(m.issuing, hostname)
+		m.mu.Unlock()
+	}()
+
+	cert, err := m.manager.GetCertificate(browserHello(hostname))
+
+	d, findErr := m.domains.FindVerified(ctx, hostname)
+	if findErr != nil {
+		slog.Error("ACME: failed to load domain", "hostname", hostname, logging.Err(findErr))
+		return
+	}
+	if d == nil {
+		// домен успели удалить
+		return
+	}
+
+	if err != nil {
+		slog.Warn("ACME: failed to obtain certificate", "hostname", hostname, logging.Err(err))
+		d.CertificateFailed(err)
+	} else {
+		if d.CertStatus != domain.CertificateIssued || d.CertExpiresAt == nil || !d.CertExpiresAt.Equal(cert.Leaf.NotAfter) {
+			slog.Info("ACME: certificate issued", "hostname", hostname, "not_after", cert.Leaf.NotAfter.Format(time.RFC3339))
+		}
+		d.CertificateIssued(cert.Leaf.NotAfter)
+	}
+	if err := m.domains.UpdateCertificate(ctx, d); err != nil {
+		slog.Error("ACME: failed to save certificate status", "hostname", hostname, logging.Err(err))
+	}
+}
+
+// browserHello имитирует ClientHello современного клиента, чтобы autocert
+// выпустил ECDSA-сертификат — тот же, что потом запросят браузеры.
+func browserHello(hostname string) *tls.ClientHelloInfo {
+	return &tls.ClientHelloInfo{
+		ServerName:       hostname,
+		CipherSuites:     []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		SignatureSchemes: []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+		SupportedCurves:  []tls.CurveID{tls.CurveP256},
+	}
+}
+
+// hostPolicy разрешает выпуск только для подтверждённых доменов, привязанных к туннелям.
+func (m *ACMEManager) hostPolicy(ctx context.Context, host string) error {
+	d, err := m.domains.FindVerified(ctx, host)
+	if err != nil {
+		return err
+	}
+	if d == nil {
+		return fmt.Errorf("acme: host %q is not a verified custom domain", host)
+	}
+	return nil
+}
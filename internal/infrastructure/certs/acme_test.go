@@ -0,0 +1,368 @@
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/waste3d/ghost-tunnel/internal/domain"
+	"github.com/waste3d/ghost-tunnel/internal/infrastructure/persistence"
+)
+
+// fakeCA — минимальный ACME-сервер (RFC 8555) поверх HTTPS. Подписи JWS не
+// проверяются, а челленджи считаются пройденными сразу, кроме хостов из reject.
+type fakeCA struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *ecdsa.PrivateKey
+	cert   *x509.Certificate
+
+	mu     sync.Mutex
+	nonce  int
+	orders []*fakeOrder
+	reject map[string]bool
+}
+
+type fakeOrder struct {
+	host   string
+	status string
+	authz  string
+	der    []byte
+}
+
+func newFakeCA(t *testing.T) *fakeCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fake ACME CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	ca := &fakeCA{t: t, key: key, cert: cert, reject: make(map[string]bool)}
+	ca.server = httptest.NewTLSServer(http.HandlerFunc(ca.serveHTTP))
+	t.Cleanup(ca.server.Close)
+	return ca
+}
+
+func (ca *fakeCA) directoryURL() string {
+	return ca.server.URL + "/directory"
+}
+
+// rootCAs — корни, которым нужно доверять, чтобы ходить в каталог по HTTPS.
+func (ca *fakeCA) rootCAs() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.server.Certificate())
+	return pool
+}
+
+func (ca *fakeCA) orderCount() int {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	return len(ca.orders)
+}
+
+func (ca *fakeCA) ordersFor(host string) int {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	n := 0
+	for _, o := range ca.orders {
+		if o.host == host {
+			n++
+		}
+	}
+	return n
+}
+
+func (ca *fakeCA) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	ca.nonce++
+	w.Header().Set("Replay-Nonce", "nonce-"+strconv.Itoa(ca.nonce))
+
+	base := ca.server.URL
+	if r.URL.Path == "/directory" {
+		ca.reply(w, http.StatusOK, map[string]string{
+			"newNonce":   base + "/nonce",
+			"newAccount": base + "/account",
+			"newOrder":   base + "/order",
+			"revokeCert": base + "/revoke",
+			"keyChange":  base + "/key-change",
+		})
+		return
+	}
+	if r.URL.Path == "/nonce" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	payload := ca.payload(r)
+	kind, idx, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	var order *fakeOrder
+	if idx != "" {
+		i, err := strconv.Atoi(idx)
+		if err != nil || i >= len(ca.orders) {
+			http.NotFound(w, r)
+			return
+		}
+		order = ca.orders[i]
+	}
+
+	switch {
+	case kind == "account":
+		w.Header().Set("Location", base+"/account/1")
+		ca.reply(w, http.StatusCreated, map[string]string{"status": "valid"})
+
+	case kind == "order" && order == nil:
+		var req struct {
+			Identifiers []struct{ Value string } `json:"identifiers"`
+		}
+		json.Unmarshal(payload, &req)
+		order = &fakeOrder{host: req.Identifiers[0].Value, status: "pending", authz: "pending"}
+		ca.orders = append(ca.orders, order)
+		w.Header().Set("Location", fmt.Sprintf("%s/order/%d", base, len(ca.orders)-1))
+		ca.reply(w, http.StatusCreated, ca.orderJSON(len(ca.orders)-1))
+
+	case kind == "order":
+		w.Header().Set("Location", base+r.URL.Path)
+		ca.reply(w, http.StatusOK, ca.orderJSON(mustAtoi(idx)))
+
+	case kind == "authz":
+		var req struct{ Status string }
+		json.Unmarshal(payload, &req)
+		if req.Status == "deactivated" {
+			order.authz = "deactivated"
+		}
+		ca.reply(w, http.StatusOK, ca.authzJSON(mustAtoi(idx)))
+
+	case kind == "challenge":
+		if ca.reject[order.host] {
+			order.authz, order.status = "invalid", "invalid"
+		} else {
+			order.authz, order.status = "valid", "ready"
+		}
+		w.Header().Set("Link", fmt.Sprintf("<%s/authz/%s>;rel=\"up\"", base, idx))
+		ca.reply(w, http.StatusOK, ca.challengeJSON(mustAtoi(idx)))
+
+	case kind == "finalize":
+		var req struct{ CSR string }
+		json.Unmarshal(payload, &req)
+		der, _ := base64.RawURLEncoding.DecodeString(req.CSR)
+		order.der = ca.sign(der)
+		order.status = "valid"
+		w.Header().Set("Location", fmt.Sprintf("%s/order/%s", base, idx))
+		ca.reply(w, http.StatusOK, ca.orderJSON(mustAtoi(idx)))
+
+	case kind == "cert":
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: order.der})
+		pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// payload достаёт полезную нагрузку из JWS запроса, не проверяя подпись.
+func (ca *fakeCA) payload(r *http.Request) []byte {
+	var jws struct{ Payload string }
+	if err := json.NewDecoder(r.Body).Decode(&jws); err != nil {
+		return nil
+	}
+	payload, _ := base64.RawURLEncoding.DecodeString(jws.Payload)
+	return payload
+}
+
+func (ca *fakeCA) reply(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (ca *fakeCA) orderJSON(i int) map[string]any {
+	o, base := ca.orders[i], ca.server.URL
+	v := map[string]any{
+		"status":         o.status,
+		"identifiers":    []map[string]string{{"type": "dns", "value": o.host}},
+		"authorizations": []string{fmt.Sprintf("%s/authz/%d", base, i)},
+		"finalize":       fmt.Sprintf("%s/finalize/%d", base, i),
+	}
+	if o.der != nil {
+		v["certificate"] = fmt.Sprintf("%s/cert/%d", base, i)
+	}
+	return v
+}
+
+func (ca *fakeCA) authzJSON(i int) map[string]any {
+	return map[string]any{
+		"status":     ca.orders[i].authz,
+		"identifier": map[string]string{"type": "dns", "value": ca.orders[i].host},
+		"challenges": []any{ca.challengeJSON(i)},
+	}
+}
+
+func (ca *fakeCA) challengeJSON(i int) map[string]any {
+	status := ca.orders[i].authz
+	if status == "deactivated" {
+		status = "invalid"
+	}
+	return map[string]any{
+		"type":   "tls-alpn-01",
+		"url":    fmt.Sprintf("%s/challenge/%d", ca.server.URL, i),
+		"token":  fmt.Sprintf("token-%d", i),
+		"status": status,
+	}
+}
+
+// sign выпускает сертификат по CSR.
+func (ca *fakeCA) sign(csrDER []byte) []byte {
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		ca.t.Errorf("ParseCertificateRequest: %v", err)
+		return nil
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      csr.Subject,
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour).Truncate(time.Second),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, csr.PublicKey, ca.key)
+	if err != nil {
+		ca.t.Errorf("CreateCertificate: %v", err)
+	}
+	return der
+}
+
+func mustAtoi(s string) int {
+	i, _ := strconv.Atoi(s)
+	return i
+}
+
+// acmeEnv — ACMEManager, подключённый к fakeCA, с доменами в памяти.
+type acmeEnv struct {
+	ca      *fakeCA
+	domains domain.CustomDomainRepository
+	manager *ACMEManager
+}
+
+func newACMEEnv(t *testing.T) *acmeEnv {
+	t.Helper()
+	ca := newFakeCA(t)
+	domains := persistence.NewMemoryCustomDomainRepository()
+	manager := NewACMEManager(ACMEConfig{
+		DirectoryURL:  ca.directoryURL(),
+		Email:         "admin@example.test",
+		RootCAs:       ca.rootCAs(),
+		RenewBefore:   30 * 24 * time.Hour,
+		CheckInterval: time.Hour,
+	}, persistence.NewMemoryACMECache(), domains)
+	return &acmeEnv{ca: ca, domains: domains, manager: manager}
+}
+
+func (e *acmeEnv) addDomain(t *testing.T, hostname string, verified bool) {
+	t.Helper()
+	d, err := domain.NewCustomDomain(hostname, "tunnel", "token")
+	if err != nil {
+		t.Fatalf("NewCustomDomain: %v", err)
+	}
+	if verified {
+		d.MarkVerified(time.Now())
+	}
+	if err := e.domains.Save(context.Background(), d); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+}
+
+func (e *acmeEnv) domain(t *testing.T, hostname string) *domain.CustomDomain {
+	t.Helper()
+	d, err := e.domains.FindClaim(context.Background(), hostname, "tunnel")
+	if err != nil || d == nil {
+		t.Fatalf("FindClaim(%s) = %v, %v", hostname, d, err)
+	}
+	return d
+}
+
+func TestACMEHostPolicy(t *testing.T) {
+	env := newACMEEnv(t)
+	env.addDomain(t, "pending.example.org", false)
+
+	for _, host := range []string{"pending.example.org", "unknown.example.org"} {
+		if _, err := env.manager.GetCertificate(browserHello(host)); err == nil {
+			t.Errorf("GetCertificate(%s) succeeded, want the host rejected", host)
+		}
+	}
+	if n := env.ca.orderCount(); n != 0 {
+		t.Errorf("CA received %d orders for unverified hosts", n)
+	}
+}
+
+func TestACMEIssue(t *testing.T) {
+	env := newACMEEnv(t)
+	env.addDomain(t, "app.example.org", true)
+	env.addDomain(t, "broken.example.org", true)
+	env.addDomain(t, "pending.example.org", false)
+	env.ca.reject["broken.example.org"] = true
+
+	env.manager.checkAll(context.Background())
+
+	issued := env.domain(t, "app.example.org")
+	if issued.CertStatus != domain.CertificateIssued || issued.CertExpiresAt == nil || issued.CertError != "" {
+		t.Fatalf("issued domain = %+v, want certificate issued", issued)
+	}
+	cert, err := env.manager.GetCertificate(browserHello("app.example.org"))
+	if err != nil {
+		t.Fatalf("GetCertificate after issue: %v", err)
+	}
+	if !cert.Leaf.NotAfter.Equal(*issued.CertExpiresAt) {
+		t.Errorf("CertExpiresAt = %v, want %v", issued.CertExpiresAt, cert.Leaf.NotAfter)
+	}
+
+	failed := env.domain(t, "broken.example.org")
+	if failed.CertStatus != domain.CertificateFailed || failed.CertError == "" {
+		t.Errorf("rejected domain = %+v, want certificate failed with an error", failed)
+	}
+	if pending := env.domain(t, "pending.example.org"); pending.CertStatus != domain.CertificatePending {
+		t.Errorf("unverified domain status = %s, want pending", pending.CertStatus)
+	}
+
+	// повторная проверка берёт выпущенный сертификат из кэша, не заказывая новый
+	orders := env.ca.ordersFor("app.example.org")
+	env.manager.checkAll(context.Background())
+	if n := env.ca.ordersFor("app.example.org"); n != orders {
+		t.Errorf("recheck placed %d new orders for an issued certificate", n-orders)
+	}
+	if d := env.domain(t, "app.example.org"); d.CertStatus != domain.CertificateIssued {
+		t.Errorf("issued domain after recheck = %s, want issued", d.CertStatus)
+	}
+}
@@ -0,0 +1,65 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+var ErrNoCertificate = errors.New("no certificate for server name")
+
+// Store выбирает сертификат по SNI: сначала точное совпадение имени,
+// затем wildcard-сертификат на уровень выше (*.example.com для a.example.com).
+type Store struct {
+	mu    sync.RWMutex
+	certs map[string]*tls.Certificate
+}
+
+func NewStore() *Store {
+	return &Store{certs: make(map[string]*tls.Certificate)}
+}
+
+// LoadFile загружает пару сертификат/ключ в PEM и регистрирует её под всеми именами сертификата.
+func (s *Store) LoadFile(certFile, keyFile string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate %s: %w", certFile, err)
+	}
+	return s.Add(&cert)
+}
+
+func (s *Store) Add(cert *tls.Certificate) error {
+	if cert.Leaf == nil {
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return fmt.Errorf("failed to parse certificate: %w", err)
+		}
+		cert.Leaf = leaf
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, name := range cert.Leaf.DNSNames {
+		s.certs[strings.ToLower(name)] = cert
+	}
+	return nil
+}
+
+func (s *Store) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if cert, ok := s.certs[name]; ok {
+		return cert, nil
+	}
+	if _, parent, ok := strings.Cut(name, "."); ok {
+		if cert, ok := s.certs["*."+parent]; ok {
+			return cert, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %q", ErrNoCertificate, hello.ServerName)
+}
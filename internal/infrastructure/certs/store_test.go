@@ -0,0 +1,78 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// selfSigned выпускает самоподписанный сертификат на имена names.
+func selfSigned(t *testing.T, names ...string) *tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestStoreGetCertificate(t *testing.T) {
+	store := NewStore()
+	wildcard := selfSigned(t, "*.example.test", "example.test")
+	custom := selfSigned(t, "app.example.org")
+	// точное имя важнее wildcard
+	exact := selfSigned(t, "special.example.test")
+	for _, cert := range []*tls.Certificate{wildcard, custom, exact} {
+		if err := store.Add(cert); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+
+	tests := []struct {
+		serverName string
+		want       *tls.Certificate
+	}{
+		{"app.example.test", wildcard},
+		{"APP.Example.Test", wildcard},
+		{"app.example.test.", wildcard},
+		{"example.test", wildcard},
+		{"special.example.test", exact},
+		{"app.example.org", custom},
+		// wildcard покрывает только один уровень
+		{"a.b.example.test", nil},
+		{"other.example.org", nil},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.serverName, func(t *testing.T) {
+			got, err := store.GetCertificate(&tls.ClientHelloInfo{ServerName: tt.serverName})
+			if tt.want == nil {
+				if !errors.Is(err, ErrNoCertificate) {
+					t.Errorf("GetCertificate() = %v, %v; want ErrNoCertificate", got, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("GetCertificate() = %v, %v; want certificate for %v", got, err, tt.want.Leaf.DNSNames)
+			}
+		})
+	}
+}
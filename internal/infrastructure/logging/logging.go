@@ -0,0 +1,114 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Имена полей, общие для сервера и CLI: по ним запрос посетителя
+// прослеживается через публичный слушатель, gRPC-стрим агента и клиента.
+const (
+	KeyRequestID  = "request_id"
+	KeyTunnelID   = "tunnel_id"
+	KeyConnID     = "conn_id"
+	KeySubdomain  = "subdomain"
+	KeyUserID     = "user_id"
+	KeyRemoteAddr = "remote_addr"
+	KeyError      = "error"
+)
+
+// HeaderRequestID — заголовок с ID запроса. Сервер принимает его от
+// клиента или балансировщика и передаёт дальше локальному сервису.
+const HeaderRequestID = "X-Request-Id"
+
+// maxRequestIDLen ограничивает ID запроса, пришедший снаружи.
+const maxRequestIDLen = 128
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Formats — форматы вывода, которые понимает New.
+var Formats = []string{FormatText, FormatJSON}
+
+// ParseLevel разбирает уровень логирования: debug, info, warn или error.
+func ParseLevel(level string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(strings.TrimSpace(level))); err != nil {
+		return 0, fmt.Errorf("unknown log level %q, expected debug, info, warn or error", level)
+	}
+	return l, nil
+}
+
+// New создаёт логгер с заданным уровнем и форматом вывода.
+func New(w io.Writer, level, format string) (*slog.Logger, error) {
+	l, err := ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	opts := &slog.HandlerOptions{Level: l}
+	switch format {
+	case FormatText:
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q, expected one of %v", format, Formats)
+}
+
+// Setup делает логгер логгером по умолчанию. Вывод стандартного пакета log
+// (например, от сторонних библиотек) тоже идёт через него.
+func Setup(level, format string) error {
+	logger, err := New(os.Stderr, level, format)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	return nil
+}
+
+// Err — поле с ошибкой.
+func Err(err error) slog.Attr {
+	return slog.Any(KeyError, err)
+}
+
+// Fatal пишет ошибку и завершает процесс.
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// RequestID возвращает ID запроса из заголовка, если он короткий и состоит
+// из безопасных символов, иначе генерирует новый.
+func RequestID(header string) string {
+	if header != "" && len(header) <= maxRequestIDLen && strings.IndexFunc(header, unsafeIDRune) < 0 {
+		return header
+	}
+	return uuid.NewString()
+}
+
+func unsafeIDRune(r rune) bool {
+	return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_.:", r))
+}
+
+type ctxKey struct{}
+
+// WithContext сохраняет в контексте логгер с полями текущего запроса или соединения.
+func WithContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, logger)
+}
+
+// FromContext возвращает логгер из контекста или логгер по умолчанию.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(ctxKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
@@ -0,0 +1,52 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestParseLevel(t *testing.T) {
+	for _, level := range []string{"debug", "info", "warn", "error", "INFO"} {
+		if _, err := ParseLevel(level); err != nil {
+			t.Errorf("ParseLevel(%q): %v", level, err)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("ParseLevel(verbose): expected error")
+	}
+}
+
+func TestNewJSON(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, "warn", FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("skipped")
+	logger.Warn("kept", KeyTunnelID, "t1")
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("output is not a single JSON line: %v\n%s", err, buf.String())
+	}
+	if entry["msg"] != "kept" || entry[KeyTunnelID] != "t1" {
+		t.Errorf("unexpected entry %v", entry)
+	}
+
+	if _, err := New(&buf, "info", "xml"); err == nil {
+		t.Error("New with unknown format: expected error")
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	if got := RequestID("abc-123"); got != "abc-123" {
+		t.Errorf("RequestID kept = %q, want abc-123", got)
+	}
+	for _, header := range []string{"", "bad id", "x\ny", strings.Repeat("a", maxRequestIDLen+1)} {
+		if got := RequestID(header); got == header || got == "" {
+			t.Errorf("RequestID(%q) = %q, want generated ID", header, got)
+		}
+	}
+}
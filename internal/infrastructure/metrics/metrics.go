@@ -0,0 +1,201 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+
+	grpcprom "github.com/grpc-ecosystem/go-grpc-middleware/providers/prometheus"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+)
+
+const namespace = "ghost_tunnel"
+
+// Направления трафика туннеля в метке direction.
+const (
+	// DirectionInbound — от посетителя публичного адреса к локальному сервису
+	DirectionInbound = "inbound"
+	// DirectionOutbound — от локального сервиса обратно посетителю
+	DirectionOutbound = "outbound"
+)
+
+// Причины ошибок публичного HTTP в метке reason.
+const (
+	EdgeErrorLookup         = "lookup_failed"
+	EdgeErrorTunnelNotFound = "tunnel_not_found"
+	EdgeErrorTunnelOffline  = "tunnel_offline"
+	EdgeErrorProxy          = "proxy_failed"
+)
+
+// SessionStats — текущее число сессий агентов: всех зарегистрированных и
+// подключённых прямо сейчас (остальные ждут переподключения агента).
+type SessionStats interface {
+	SessionCounts() (total, attached int)
+}
+
+// ConnectionStats — число открытых соединений по туннелям.
+type ConnectionStats interface {
+	ConnectionsByTunnel() map[string]int
+}
+
+// Metrics — метрики сервера в формате Prometheus. Хранятся в собственном
+// реестре, а не в глобальном, чтобы в /metrics попадало только нужное.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	tunnelBytes       *prometheus.CounterVec
+	connectionsOpened *prometheus.CounterVec
+	edgeDuration      *prometheus.HistogramVec
+	edgeErrors        *prometheus.CounterVec
+	tunnelsReclaimed  *prometheus.CounterVec
+	grpc              *grpcprom.ServerMetrics
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		tunnelBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "tunnel_bytes_total",
+			Help:      "Bytes of payload carried through tunnels.",
+		}, []string{"tunnel", "direction"}),
+		connectionsOpened: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "connections_opened_total",
+			Help:      "Connections opened through tunnels by public edge protocol.",
+		}, []string{"protocol"}),
+		edgeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "edge_request_duration_seconds",
+			Help:      "Latency of requests served by the public HTTP edge.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"scheme", "method", "code"}),
+		edgeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "edge_errors_total",
+			Help:      "Requests the public HTTP edge could not route to a tunnel.",
+		}, []string{"reason"}),
+		tunnelsReclaimed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "tunnels_reclaimed_total",
+			Help:      "Abandoned ephemeral tunnels deleted by garbage collection; dry_run=\"true\" counts those that would have been deleted.",
+		}, []string{"dry_run"}),
+		// стрим агента живёт часами, поэтому и корзины длинные
+		grpc: grpcprom.NewServerMetrics(grpcprom.WithServerHandlingTimeHistogram(
+			grpcprom.WithHistogramBuckets([]float64{1, 10, 60, 600, 3600, 6 * 3600, 24 * 3600}),
+		)),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.tunnelBytes,
+		m.connectionsOpened,
+		m.edgeDuration,
+		m.edgeErrors,
+		m.tunnelsReclaimed,
+		m.grpc,
+	)
+	return m
+}
+
+// Handler отдаёт метрики для Prometheus.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// WatchSessions публикует gauge-метрики сессий агентов и открытых соединений.
+// Значения читаются в момент сбора, а не обновляются на каждое событие.
+func (m *Metrics) WatchSessions(sessions SessionStats, conns ConnectionStats) {
+	m.registry.MustRegister(&sessionCollector{sessions: sessions, conns: conns})
+}
+
+// TunnelTraffic — счётчики байтов туннеля в обоих направлениях.
+func (m *Metrics) TunnelTraffic(tunnelID string) Traffic {
+	return Traffic{
+		Inbound:  m.tunnelBytes.WithLabelValues(tunnelID, DirectionInbound),
+		Outbound: m.tunnelBytes.WithLabelValues(tunnelID, DirectionOutbound),
+	}
+}
+
+// ForgetTunnel удаляет ряды туннеля, когда его сессия закрыта окончательно,
+// чтобы число рядов не росло с каждым когда-либо подключавшимся туннелем.
+func (m *Metrics) ForgetTunnel(tunnelID string) {
+	m.tunnelBytes.DeletePartialMatch(prometheus.Labels{"tunnel": tunnelID})
+}
+
+// ConnectionOpened учитывает соединение, открытое публичным HTTP или TCP слушателем.
+func (m *Metrics) ConnectionOpened(protocol string) {
+	m.connectionsOpened.WithLabelValues(protocol).Inc()
+}
+
+// EdgeError учитывает запрос, который публичный HTTP не смог доставить в туннель.
+func (m *Metrics) EdgeError(reason string) {
+	m.edgeErrors.WithLabelValues(reason).Inc()
+}
+
+// TunnelsReclaimed учитывает туннели, удалённые сборкой заброшенных туннелей,
+// или в режиме dry-run — отобранные для удаления.
+func (m *Metrics) TunnelsReclaimed(n int, dryRun bool) {
+	m.tunnelsReclaimed.WithLabelValues(strconv.FormatBool(dryRun)).Add(float64(n))
+}
+
+// InstrumentEdge замеряет время ответа публичного HTTP слушателя.
+// scheme различает HTTP и HTTPS слушатели с одним обработчиком.
+func (m *Metrics) InstrumentEdge(scheme string, next http.Handler) http.Handler {
+	observer := m.edgeDuration.MustCurryWith(prometheus.Labels{"scheme": scheme})
+	return promhttp.InstrumentHandlerDuration(observer, next)
+}
+
+// GRPCServerOptions подключает метрики к gRPC-серверу. После регистрации
+// сервисов нужно вызвать InitializeGRPC, чтобы ряды появились до первых вызовов.
+func (m *Metrics) GRPCServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(m.grpc.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(m.grpc.StreamServerInterceptor()),
+	}
+}
+
+func (m *Metrics) InitializeGRPC(server *grpc.Server) {
+	m.grpc.InitializeMetrics(server)
+}
+
+// Traffic — счётчики байтов одного туннеля.
+type Traffic struct {
+	Inbound  prometheus.Counter
+	Outbound prometheus.Counter
+}
+
+var (
+	sessionsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "sessions"),
+		"Agent sessions held by the server; detached sessions wait for the agent to resume.",
+		[]string{"state"}, nil,
+	)
+	connectionsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "connections"),
+		"Connections currently open through a tunnel.",
+		[]string{"tunnel"}, nil,
+	)
+)
+
+type sessionCollector struct {
+	sessions SessionStats
+	conns    ConnectionStats
+}
+
+func (c *sessionCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- sessionsDesc
+	ch <- connectionsDesc
+}
+
+func (c *sessionCollector) Collect(ch chan<- prometheus.Metric) {
+	total, attached := c.sessions.SessionCounts()
+	ch <- prometheus.MustNewConstMetric(sessionsDesc, prometheus.GaugeValue, float64(attached), "attached")
+	ch <- prometheus.MustNewConstMetric(sessionsDesc, prometheus.GaugeValue, float64(total-attached), "detached")
+	for tunnelID, n := range c.conns.ConnectionsByTunnel() {
+		ch <- prometheus.MustNewConstMetric(connectionsDesc, prometheus.GaugeValue, float64(n), tunnelID)
+	}
+}
@@ -0,0 +1,80 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type fakeStats struct {
+	total, attached int
+	conns           map[string]int
+}
+
+func (f fakeStats) SessionCounts() (int, int) {
+	return f.total, f.attached
+}
+
+func (f fakeStats) ConnectionsByTunnel() map[string]int {
+	return f.conns
+}
+
+func TestSessionGauges(t *testing.T) {
+	m := New()
+	stats := fakeStats{total: 3, attached: 2, conns: map[string]int{"t1": 4}}
+	m.WatchSessions(stats, stats)
+
+	expected := `
+# HELP ghost_tunnel_connections Connections currently open through a tunnel.
+# TYPE ghost_tunnel_connections gauge
+ghost_tunnel_connections{tunnel="t1"} 4
+# HELP ghost_tunnel_sessions Agent sessions held by the server; detached sessions wait for the agent to resume.
+# TYPE ghost_tunnel_sessions gauge
+ghost_tunnel_sessions{state="attached"} 2
+ghost_tunnel_sessions{state="detached"} 1
+`
+	err := testutil.GatherAndCompare(m.registry, strings.NewReader(expected), "ghost_tunnel_sessions", "ghost_tunnel_connections")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTunnelTraffic(t *testing.T) {
+	m := New()
+	traffic := m.TunnelTraffic("t1")
+	traffic.Inbound.Add(100)
+	traffic.Outbound.Add(40)
+	m.TunnelTraffic("t2").Inbound.Add(7)
+
+	if got := testutil.ToFloat64(m.tunnelBytes.WithLabelValues("t1", DirectionInbound)); got != 100 {
+		t.Errorf("inbound bytes = %v, want 100", got)
+	}
+	if got := testutil.ToFloat64(m.tunnelBytes.WithLabelValues("t1", DirectionOutbound)); got != 40 {
+		t.Errorf("outbound bytes = %v, want 40", got)
+	}
+
+	// после ухода туннеля его ряды пропадают, ряды t2 в обоих направлениях остаются
+	m.ForgetTunnel("t1")
+	if n := testutil.CollectAndCount(m.tunnelBytes); n != 2 {
+		t.Errorf("series after ForgetTunnel = %d, want 2", n)
+	}
+}
+
+func TestTunnelsReclaimed(t *testing.T) {
+	m := New()
+	m.TunnelsReclaimed(3, false)
+	m.TunnelsReclaimed(2, true)
+	m.TunnelsReclaimed(0, false)
+
+	expected := `
+# HELP ghost_tunnel_tunnels_reclaimed_total Abandoned ephemeral tunnels deleted by garbage collection; dry_run="true" counts those that would have been deleted.
+# TYPE ghost_tunnel_tunnels_reclaimed_total counter
+ghost_tunnel_tunnels_reclaimed_total{dry_run="false"} 3
+ghost_tunnel_tunnels_reclaimed_total{dry_run="true"} 2
+`
+	err := testutil.GatherAndCompare(m.registry, strings.NewReader(expected), "ghost_tunnel_tunnels_reclaimed_total")
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,485 @@
+package mux
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/waste3d/ghost-tunnel/api"
+)
+
+const (
+	// MaxChunkSize — максимальный размер одного Data-сообщения.
+	MaxChunkSize = 32 * 1024
+	// InitialWindow — сколько байт можно отправить в новое соединение,
+	// не дожидаясь WindowUpdate. Одинаково для обеих сторон.
+	InitialWindow = 256 * 1024
+	// ResumeTimeout — сколько соединения переживают обрыв gRPC-стрима
+	// в ожидании переподключения агента.
+	ResumeTimeout = 30 * time.Second
+)
+
+// FrameSender отправляет кадры протокола на другую сторону туннеля.
+// Реализация обязана быть безопасной для конкурентного использования.
+type FrameSender interface {
+	SendData(connID string, offset uint64, chunk []byte) error
+	SendHalfClose(connID string, finalOffset uint64) error
+	SendClose(connID string, reason api.CloseReason, finalOffset uint64) error
+	SendReset(connID string, reason api.CloseReason, message string) error
+	SendWindowUpdate(connID string, increment uint32) error
+}
+
+// ResetError возвращается из Read и Write, если соединение было оборвано.
+type ResetError struct {
+	Reason  api.CloseReason
+	Message string
+}
+
+func (e *ResetError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("connection reset: %s", e.Reason)
+	}
+	return fmt.Sprintf("connection reset: %s: %s", e.Reason, e.Message)
+}
+
+// ErrWriteClosed возвращается из Write после CloseWrite или после Close от другой стороны.
+var ErrWriteClosed = errors.New("write side of the connection is closed")
+
+// Conn — одно проксируемое соединение внутри gRPC-стрима туннеля.
+//
+// Поток данных ограничен окнами: отправитель не пишет больше, чем ему
+// разрешил получатель, а получатель возвращает окно по мере того, как
+// читатель вычитывает буфер. Поэтому Deliver никогда не блокирует
+// горутину, читающую стрим, и медленное соединение не тормозит остальные.
+//
+// Каждый чанк несёт смещение от начала соединения, а отправленные, но ещё
+// не подтверждённые окном данные хранятся до WindowUpdate. Благодаря этому
+// после переподключения стрима Resume досылает ровно то, что не дошло.
+type Conn struct {
+	id     string
+	sender FrameSender
+
+	// sendMu упорядочивает отправку данных между Write, CloseWrite и Resume
+	sendMu sync.Mutex
+
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	// входящие данные
+	queue    [][]byte
+	buffered int
+	received uint64
+	credited uint64
+	eofKnown bool
+	eofAt    uint64
+	inClosed bool
+
+	// исходящие данные
+	sent        uint64
+	acked       uint64
+	reserved    int
+	retained    []byte
+	writeClosed bool
+	peerClosed  bool
+
+	readDeadline  deadline
+	writeDeadline deadline
+
+	err  error
+	done chan struct{}
+}
+
+func NewConn(id string, sender FrameSender) *Conn {
+	c := &Conn{
+		id:     id,
+		sender: sender,
+		done:   make(chan struct{}),
+	}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+func (c *Conn) ID() string {
+	return c.id
+}
+
+// LocalAddr и RemoteAddr нужны, чтобы Conn можно было отдать как net.Conn,
+// например в http.Transport; адресом служит ID соединения.
+func (c *Conn) LocalAddr() net.Addr {
+	return tunnelAddr(c.id)
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return tunnelAddr(c.id)
+}
+
+// SetDeadline, SetReadDeadline и SetWriteDeadline работают как у net.Conn:
+// после дедлайна ожидающие и новые Read и Write возвращают
+// os.ErrDeadlineExceeded, а соединение остаётся открытым. Нулевое время
+// снимает дедлайн.
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline.set(c, t)
+	c.writeDeadline.set(c, t)
+	return nil
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline.set(c, t)
+	return nil
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeDeadline.set(c, t)
+	return nil
+}
+
+// deadline — дедлайн чтения или записи. Таймер будит горутины, ждущие
+// на c.cond, когда дедлайн наступает.
+type deadline struct {
+	at    time.Time
+	timer *time.Timer
+}
+
+// set вызывается под c.mu.
+func (d *deadline) set(c *Conn, t time.Time) {
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	d.at = t
+	if wait := time.Until(t); !t.IsZero() && wait > 0 {
+		d.timer = time.AfterFunc(wait, func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			c.cond.Broadcast()
+		})
+	}
+	// дедлайн мог сдвинуться в прошлое
+	c.cond.Broadcast()
+}
+
+// exceeded вызывается под c.mu.
+func (d *deadline) exceeded() bool {
+	return !d.at.IsZero() && !time.Now().Before(d.at)
+}
+
+type tunnelAddr string
+
+func (a tunnelAddr) Network() string {
+	return "tunnel"
+}
+
+func (a tunnelAddr) String() string {
+	return string(a)
+}
+
+// Done закрывается, когда соединение закрыто локально или оборвано другой стороной.
+func (c *Conn) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	for len(c.queue) == 0 && !c.inClosed && !c.isDone() && !c.readDeadline.exceeded() {
+		c.cond.Wait()
+	}
+	if c.isDone() {
+		err := c.err
+		c.mu.Unlock()
+		return 0, err
+	}
+	if c.readDeadline.exceeded() {
+		c.mu.Unlock()
+		return 0, os.ErrDeadlineExceeded
+	}
+	if len(c.queue) == 0 {
+		c.mu.Unlock()
+		return 0, io.EOF
+	}
+
+	n := copy(p, c.queue[0])
+	if n == len(c.queue[0]) {
+		c.queue[0] = nil
+		c.queue = c.queue[1:]
+	} else {
+		c.queue[0] = c.queue[0][n:]
+	}
+	c.buffered -= n
+
+	// Возвращаем окно пачками, чтобы не слать WindowUpdate на каждый Read
+	var increment uint64
+	if consumed := c.received - uint64(c.buffered); consumed-c.credited >= InitialWindow/2 && !c.inClosed {
+		increment = consumed - c.credited
+		c.credited = consumed
+	}
+	c.mu.Unlock()
+
+	if increment > 0 {
+		c.sender.SendWindowUpdate(c.id, uint32(increment))
+	}
+	return n, nil
+}
+
+// Write отправляет данные, дожидаясь свободного окна у другой стороны.
+// Ошибки транспорта не возвращаются: данные остаются в буфере до
+// подтверждения и будут досланы после переподключения.
+func (c *Conn) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n, err := c.reserve(len(p))
+		if err != nil {
+			return written, err
+		}
+
+		c.sendMu.Lock()
+		c.mu.Lock()
+		offset := c.sent
+		c.retained = append(c.retained, p[:n]...)
+		c.sent += uint64(n)
+		c.reserved -= n
+		c.mu.Unlock()
+		c.sender.SendData(c.id, offset, p[:n])
+		c.sendMu.Unlock()
+
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// reserve ждёт, пока окно отправки не станет ненулевым, и занимает до size байт.
+func (c *Conn) reserve(size int) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.sendWindow() == 0 && !c.isDone() && !c.writeClosed && !c.peerClosed && !c.writeDeadline.exceeded() {
+		c.cond.Wait()
+	}
+	if c.isDone() {
+		return 0, c.err
+	}
+	if c.writeClosed || c.peerClosed {
+		return 0, ErrWriteClosed
+	}
+	if c.writeDeadline.exceeded() {
+		return 0, os.ErrDeadlineExceeded
+	}
+
+	n := min(size, c.sendWindow(), MaxChunkSize)
+	c.reserved += n
+	return n, nil
+}
+
+// CloseWrite сообщает другой стороне, что данных больше не будет.
+func (c *Conn) CloseWrite() error {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	c.mu.Lock()
+	if c.writeClosed || c.isDone() {
+		c.mu.Unlock()
+		return nil
+	}
+	c.writeClosed = true
+	finalOffset := c.sent
+	c.cond.Broadcast()
+	c.mu.Unlock()
+	return c.sender.SendHalfClose(c.id, finalOffset)
+}
+
+// Close штатно закрывает соединение в обе стороны. Если обе стороны уже
+// отправили HalfClose, другой стороне ничего не отправляется.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	if c.isDone() {
+		c.mu.Unlock()
+		return nil
+	}
+	notify := !(c.writeClosed && c.inClosed) && !c.peerClosed
+	finalOffset := c.sent
+	c.finish(net.ErrClosed)
+	c.mu.Unlock()
+
+	if notify {
+		return c.sender.SendClose(c.id, api.CloseReason_CLOSE_REASON_DONE, finalOffset)
+	}
+	return nil
+}
+
+// Reset аварийно обрывает соединение и сообщает причину другой стороне.
+func (c *Conn) Reset(reason api.CloseReason, message string) error {
+	c.mu.Lock()
+	if c.isDone() {
+		c.mu.Unlock()
+		return nil
+	}
+	c.finish(&ResetError{Reason: reason, Message: message})
+	c.mu.Unlock()
+	return c.sender.SendReset(c.id, reason, message)
+}
+
+// Deliver передаёт в соединение чанк, пришедший от другой стороны.
+// Повторы уже полученных данных отбрасываются, чанки после разрыва в
+// последовательности тоже: отправитель дошлёт их при Resume.
+// Если другая сторона превысила окно, соединение обрывается.
+func (c *Conn) Deliver(offset uint64, chunk []byte) {
+	c.mu.Lock()
+	end := offset + uint64(len(chunk))
+	if c.inClosed || c.isDone() || end <= c.received || offset > c.received {
+		c.mu.Unlock()
+		return
+	}
+	chunk = chunk[c.received-offset:]
+
+	if c.received+uint64(len(chunk))-c.credited > InitialWindow {
+		c.mu.Unlock()
+		c.Reset(api.CloseReason_CLOSE_REASON_FLOW_CONTROL_ERROR, "receive window exceeded")
+		return
+	}
+	c.queue = append(c.queue, chunk)
+	c.buffered += len(chunk)
+	c.received += uint64(len(chunk))
+	if c.eofKnown && c.received >= c.eofAt {
+		c.inClosed = true
+	}
+	c.cond.Broadcast()
+	c.mu.Unlock()
+}
+
+// DeliverWindowUpdate расширяет окно отправки после WindowUpdate от другой стороны.
+func (c *Conn) DeliverWindowUpdate(increment uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.acknowledge(c.acked + uint64(increment))
+}
+
+// DeliverHalfClose обрабатывает HalfClose: как только придут все finalOffset
+// байт и буфер будет вычитан, Read вернёт io.EOF.
+func (c *Conn) DeliverHalfClose(finalOffset uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.markEOF(finalOffset)
+}
+
+// DeliverClose обрабатывает Close: чтение дочитывает данные, запись больше невозможна.
+func (c *Conn) DeliverClose(finalOffset uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.markEOF(finalOffset)
+	c.peerClosed = true
+	c.cond.Broadcast()
+}
+
+// DeliverReset обрабатывает Reset: недоставленные данные отбрасываются.
+func (c *Conn) DeliverReset(reason api.CloseReason, message string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inClosed = true
+	if !c.isDone() {
+		c.finish(&ResetError{Reason: reason, Message: message})
+	}
+}
+
+// State описывает, сколько данных соединение получило от другой стороны.
+func (c *Conn) State() *api.ConnectionState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return &api.ConnectionState{
+		ConnectionId: c.id,
+		Received:     c.received,
+		Credited:     c.credited,
+		EofReceived:  c.eofKnown,
+	}
+}
+
+// Resume продолжает соединение после переподключения: учитывает окно,
+// которое другая сторона успела вернуть, и досылает всё, что она не получила.
+func (c *Conn) Resume(peer *api.ConnectionState) {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	c.mu.Lock()
+	if c.isDone() {
+		c.mu.Unlock()
+		return
+	}
+	c.acknowledge(peer.GetCredited())
+	from := max(peer.GetReceived(), c.acked)
+	from = min(from, c.sent)
+	pending := append([]byte(nil), c.retained[from-c.acked:]...)
+	resendEOF := c.writeClosed && !peer.GetEofReceived()
+	finalOffset := c.sent
+	c.mu.Unlock()
+
+	for len(pending) > 0 {
+		n := min(len(pending), MaxChunkSize)
+		c.sender.SendData(c.id, from, pending[:n])
+		from += uint64(n)
+		pending = pending[n:]
+	}
+	if resendEOF {
+		c.sender.SendHalfClose(c.id, finalOffset)
+	}
+}
+
+func (c *Conn) closedByPeer() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.peerClosed
+}
+
+// sendWindow вызывается под c.mu.
+func (c *Conn) sendWindow() int {
+	return InitialWindow - int(c.sent-c.acked) - c.reserved
+}
+
+// acknowledge вызывается под c.mu: данные до total подтверждены и больше не нужны.
+func (c *Conn) acknowledge(total uint64) {
+	total = min(total, c.sent)
+	if total <= c.acked {
+		return
+	}
+	c.retained = c.retained[total-c.acked:]
+	c.acked = total
+	c.cond.Broadcast()
+}
+
+// markEOF вызывается под c.mu.
+func (c *Conn) markEOF(finalOffset uint64) {
+	c.eofKnown = true
+	c.eofAt = finalOffset
+	if c.received >= finalOffset {
+		c.inClosed = true
+	}
+	c.cond.Broadcast()
+}
+
+func (c *Conn) isDone() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// finish вызывается под c.mu.
+func (c *Conn) finish(err error) {
+	c.err = err
+	c.queue = nil
+	c.buffered = 0
+	c.retained = nil
+	c.readDeadline.set(c, time.Time{})
+	c.writeDeadline.set(c, time.Time{})
+	close(c.done)
+	c.cond.Broadcast()
+}
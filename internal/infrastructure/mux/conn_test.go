@@ -0,0 +1,433 @@
+package mux
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/waste3d/ghost-tunnel/api"
+)
+
+// frame — кадр, отправленный через recordingSender. offset — смещение Data
+// или finalOffset у HalfClose и Close.
+type frame struct {
+	kind      string
+	offset    uint64
+	data      []byte
+	reason    api.CloseReason
+	increment uint32
+}
+
+// recordingSender запоминает отправленные кадры. Пока failData не nil,
+// SendData возвращает её, как оборванный стрим.
+type recordingSender struct {
+	mu       sync.Mutex
+	frames   []frame
+	failData error
+}
+
+func (s *recordingSender) record(f frame) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.frames = append(s.frames, f)
+}
+
+func (s *recordingSender) SendData(connID string, offset uint64, chunk []byte) error {
+	s.mu.Lock()
+	err := s.failData
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	s.record(frame{kind: "data", offset: offset, data: append([]byte(nil), chunk...)})
+	return nil
+}
+
+func (s *recordingSender) SendHalfClose(connID string, finalOffset uint64) error {
+	s.record(frame{kind: "half_close", offset: finalOffset})
+	return nil
+}
+
+func (s *recordingSender) SendClose(connID string, reason api.CloseReason, finalOffset uint64) error {
+	s.record(frame{kind: "close", offset: finalOffset, reason: reason})
+	return nil
+}
+
+func (s *recordingSender) SendReset(connID string, reason api.CloseReason, message string) error {
+	s.record(frame{kind: "reset", reason: reason})
+	return nil
+}
+
+func (s *recordingSender) SendWindowUpdate(connID string, increment uint32) error {
+	s.record(frame{kind: "window_update", increment: increment})
+	return nil
+}
+
+func (s *recordingSender) setFailData(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failData = err
+}
+
+func (s *recordingSender) snapshot() []frame {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]frame(nil), s.frames...)
+}
+
+// dataSent — сколько байт ушло в кадрах Data.
+func (s *recordingSender) dataSent() int {
+	n := 0
+	for _, f := range s.snapshot() {
+		if f.kind == "data" {
+			n += len(f.data)
+		}
+	}
+	return n
+}
+
+// pipeSender сразу доставляет кадры соединению другой стороны, как будто
+// между ними исправный стрим.
+type pipeSender struct {
+	peer *Conn
+}
+
+func (s *pipeSender) SendData(connID string, offset uint64, chunk []byte) error {
+	s.peer.Deliver(offset, append([]byte(nil), chunk...))
+	return nil
+}
+
+func (s *pipeSender) SendHalfClose(connID string, finalOffset uint64) error {
+	s.peer.DeliverHalfClose(finalOffset)
+	return nil
+}
+
+func (s *pipeSender) SendClose(connID string, reason api.CloseReason, finalOffset uint64) error {
+	s.peer.DeliverClose(finalOffset)
+	return nil
+}
+
+func (s *pipeSender) SendReset(connID string, reason api.CloseReason, message string) error {
+	s.peer.DeliverReset(reason, message)
+	return nil
+}
+
+func (s *pipeSender) SendWindowUpdate(connID string, increment uint32) error {
+	s.peer.DeliverWindowUpdate(increment)
+	return nil
+}
+
+// newPipe возвращает два конца одного соединения туннеля.
+func newPipe() (*Conn, *Conn) {
+	sa, sb := &pipeSender{}, &pipeSender{}
+	a, b := NewConn("conn", sa), NewConn("conn", sb)
+	sa.peer, sb.peer = b, a
+	return a, b
+}
+
+// waitFor ждёт, пока cond не станет истинным.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// result ждёт значение из канала не дольше двух секунд.
+func result[T any](t *testing.T, what string, ch <-chan T) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+		var zero T
+		return zero
+	}
+}
+
+func readAll(c *Conn) <-chan string {
+	ch := make(chan string, 1)
+	go func() {
+		data, _ := io.ReadAll(c)
+		ch <- string(data)
+	}()
+	return ch
+}
+
+func TestConnHalfCloseKeepsReading(t *testing.T) {
+	sender := &recordingSender{}
+	c := NewConn("conn", sender)
+
+	if _, err := c.Write([]byte("ping")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := c.CloseWrite(); err != nil {
+		t.Fatalf("CloseWrite: %v", err)
+	}
+	if _, err := c.Write([]byte("more")); !errors.Is(err, ErrWriteClosed) {
+		t.Errorf("Write after CloseWrite = %v, want ErrWriteClosed", err)
+	}
+
+	// другая сторона продолжает писать после нашего HalfClose
+	got := readAll(c)
+	c.Deliver(0, []byte("pong "))
+	c.Deliver(5, []byte("and more"))
+	c.DeliverHalfClose(13)
+	if data := result(t, "read", got); data != "pong and more" {
+		t.Errorf("read after CloseWrite = %q, want %q", data, "pong and more")
+	}
+
+	frames := sender.snapshot()
+	last := frames[len(frames)-1]
+	if last.kind != "half_close" || last.offset != 4 {
+		t.Errorf("last frame = %+v, want half_close at 4", last)
+	}
+}
+
+func TestConnResetReachesReader(t *testing.T) {
+	c := NewConn("conn", &recordingSender{})
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := c.Read(make([]byte, 16))
+		errc <- err
+	}()
+	c.DeliverReset(api.CloseReason_CLOSE_REASON_IO_ERROR, "local service failed")
+
+	var reset *ResetError
+	if err := result(t, "read", errc); !errors.As(err, &reset) || reset.Reason != api.CloseReason_CLOSE_REASON_IO_ERROR {
+		t.Fatalf("Read after Reset = %v, want ResetError with IO_ERROR", err)
+	}
+	if _, err := c.Write([]byte("x")); !errors.As(err, &reset) {
+		t.Errorf("Write after Reset = %v, want ResetError", err)
+	}
+	select {
+	case <-c.Done():
+	default:
+		t.Error("Done() is not closed after Reset")
+	}
+}
+
+func TestConnResetSendsReason(t *testing.T) {
+	sender := &recordingSender{}
+	c := NewConn("conn", sender)
+	c.Deliver(0, []byte("unread"))
+
+	c.Reset(api.CloseReason_CLOSE_REASON_IO_ERROR, "boom")
+	if _, err := c.Read(make([]byte, 16)); err == nil {
+		t.Error("Read after local Reset returned buffered data, want error")
+	}
+	frames := sender.snapshot()
+	if len(frames) != 1 || frames[0].kind != "reset" || frames[0].reason != api.CloseReason_CLOSE_REASON_IO_ERROR {
+		t.Errorf("frames = %+v, want a single IO_ERROR reset", frames)
+	}
+}
+
+func TestConnCloseAfterHalfCloses(t *testing.T) {
+	t.Run("both sides half-closed", func(t *testing.T) {
+		sender := &recordingSender{}
+		c := NewConn("conn", sender)
+		c.Write([]byte("data"))
+		c.CloseWrite()
+		c.DeliverHalfClose(0)
+		if _, err := c.Read(make([]byte, 1)); err != io.EOF {
+			t.Fatalf("Read = %v, want EOF", err)
+		}
+		before := len(sender.snapshot())
+
+		if err := c.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+		if frames := sender.snapshot(); len(frames) != before {
+			t.Errorf("Close sent %+v after both HalfCloses, want nothing", frames[before:])
+		}
+	})
+
+	t.Run("closed by peer", func(t *testing.T) {
+		sender := &recordingSender{}
+		c := NewConn("conn", sender)
+		c.DeliverClose(0)
+		if _, err := c.Write([]byte("x")); !errors.Is(err, ErrWriteClosed) {
+			t.Errorf("Write after peer Close = %v, want ErrWriteClosed", err)
+		}
+		c.Close()
+		if frames := sender.snapshot(); len(frames) != 0 {
+			t.Errorf("Close after peer Close sent %+v, want nothing", frames)
+		}
+	})
+
+	t.Run("only local half-closed", func(t *testing.T) {
+		// другая сторона ещё пишет, Close сообщает ей, что читать больше некому
+		sender := &recordingSender{}
+		c := NewConn("conn", sender)
+		c.Write([]byte("data"))
+		c.CloseWrite()
+		c.Close()
+		c.Close()
+		frames := sender.snapshot()
+		if len(frames) != 3 || frames[2].kind != "close" || frames[2].offset != 4 {
+			t.Errorf("frames = %+v, want data, half_close and one close at 4", frames)
+		}
+	})
+}
+
+func TestConnStalledReaderDoesNotBlockOthers(t *testing.T) {
+	stalled := NewConn("stalled", &recordingSender{})
+	active := NewConn("active", &recordingSender{})
+
+	// у stalled никто не читает: его буфер заполнен на всё окно
+	delivered := make(chan struct{})
+	go func() {
+		for offset := 0; offset < InitialWindow; offset += MaxChunkSize {
+			stalled.Deliver(uint64(offset), make([]byte, MaxChunkSize))
+		}
+		active.Deliver(0, []byte("still flowing"))
+		close(delivered)
+	}()
+	result(t, "deliver", delivered)
+
+	buf := make([]byte, 64)
+	n, err := active.Read(buf)
+	if err != nil || string(buf[:n]) != "still flowing" {
+		t.Errorf("Read = %q, %v; want %q", buf[:n], err, "still flowing")
+	}
+}
+
+func TestConnWriteWaitsForWindow(t *testing.T) {
+	sender := &recordingSender{}
+	c := NewConn("conn", sender)
+
+	const extra = 1000
+	written := make(chan int, 1)
+	go func() {
+		n, _ := c.Write(make([]byte, InitialWindow+extra))
+		written <- n
+	}()
+
+	waitFor(t, "the initial window to be sent", func() bool { return sender.dataSent() == InitialWindow })
+	select {
+	case n := <-written:
+		t.Fatalf("Write returned %d before the window was extended", n)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if sent := sender.dataSent(); sent != InitialWindow {
+		t.Fatalf("sent %d bytes, want exactly the window %d", sent, InitialWindow)
+	}
+
+	c.DeliverWindowUpdate(extra)
+	if n := result(t, "write", written); n != InitialWindow+extra {
+		t.Errorf("Write = %d, want %d", n, InitialWindow+extra)
+	}
+	if sent := sender.dataSent(); sent != InitialWindow+extra {
+		t.Errorf("sent %d bytes, want %d", sent, InitialWindow+extra)
+	}
+}
+
+func TestConnReadReturnsWindow(t *testing.T) {
+	sender := &recordingSender{}
+	c := NewConn("conn", sender)
+	c.Deliver(0, make([]byte, InitialWindow))
+
+	if _, err := io.ReadFull(c, make([]byte, InitialWindow/2)); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	frames := sender.snapshot()
+	if len(frames) != 1 || frames[0].kind != "window_update" || frames[0].increment != InitialWindow/2 {
+		t.Fatalf("frames = %+v, want one window_update of %d", frames, InitialWindow/2)
+	}
+	// возвращённое окно можно заполнить снова
+	c.Deliver(InitialWindow, make([]byte, InitialWindow/2))
+	select {
+	case <-c.Done():
+		t.Error("connection was reset after the window was returned")
+	default:
+	}
+}
+
+func TestConnWindowOverflowResets(t *testing.T) {
+	sender := &recordingSender{}
+	c := NewConn("conn", sender)
+
+	c.Deliver(0, make([]byte, InitialWindow))
+	c.Deliver(InitialWindow, []byte("one byte too many"))
+
+	frames := sender.snapshot()
+	if len(frames) != 1 || frames[0].kind != "reset" || frames[0].reason != api.CloseReason_CLOSE_REASON_FLOW_CONTROL_ERROR {
+		t.Fatalf("frames = %+v, want a FLOW_CONTROL_ERROR reset", frames)
+	}
+	var reset *ResetError
+	if _, err := c.Read(make([]byte, 1)); !errors.As(err, &reset) || reset.Reason != api.CloseReason_CLOSE_REASON_FLOW_CONTROL_ERROR {
+		t.Errorf("Read = %v, want FLOW_CONTROL_ERROR", err)
+	}
+	// буфер не растёт после обрыва
+	c.Deliver(InitialWindow, make([]byte, MaxChunkSize))
+	c.mu.Lock()
+	buffered := c.buffered
+	c.mu.Unlock()
+	if buffered != 0 {
+		t.Errorf("buffered = %d after reset, want 0", buffered)
+	}
+}
+
+func TestConnReadDeadline(t *testing.T) {
+	c := NewConn("conn", &recordingSender{})
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := c.Read(make([]byte, 16))
+		errc <- err
+	}()
+	c.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	err := result(t, "read", errc)
+	var netErr net.Error
+	if !errors.Is(err, os.ErrDeadlineExceeded) || !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("Read after the deadline = %v, want a timeout", err)
+	}
+	if _, err := c.Read(make([]byte, 16)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Read with a past deadline = %v, want os.ErrDeadlineExceeded", err)
+	}
+
+	// дедлайн не закрывает соединение: после его снятия чтение продолжается
+	c.SetReadDeadline(time.Time{})
+	c.Deliver(0, []byte("data"))
+	buf := make([]byte, 16)
+	if n, err := c.Read(buf); err != nil || string(buf[:n]) != "data" {
+		t.Errorf("Read after clearing the deadline = %q, %v; want %q", buf[:n], err, "data")
+	}
+}
+
+func TestConnWriteDeadline(t *testing.T) {
+	sender := &recordingSender{}
+	c := NewConn("conn", sender)
+	c.SetWriteDeadline(time.Now().Add(20 * time.Millisecond))
+
+	// окно другой стороны кончилось, Write ждёт до дедлайна
+	type writeResult struct {
+		n   int
+		err error
+	}
+	done := make(chan writeResult, 1)
+	go func() {
+		n, err := c.Write(make([]byte, InitialWindow+1))
+		done <- writeResult{n, err}
+	}()
+	res := result(t, "write", done)
+	if res.n != InitialWindow || !errors.Is(res.err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Write = %d, %v; want %d, os.ErrDeadlineExceeded", res.n, res.err, InitialWindow)
+	}
+
+	c.SetDeadline(time.Time{})
+	c.DeliverWindowUpdate(1)
+	if n, err := c.Write([]byte("x")); n != 1 || err != nil {
+		t.Errorf("Write after clearing the deadline = %d, %v; want 1, nil", n, err)
+	}
+}
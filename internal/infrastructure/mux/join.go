@@ -0,0 +1,82 @@
+package mux
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/waste3d/ghost-tunnel/api"
+)
+
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// Join проксирует данные между локальным сокетом и соединением туннеля,
+// пока обе стороны не закончат передачу. EOF с любой стороны передаётся
+// как half-close, ошибки сокета — как Reset.
+func Join(local net.Conn, c *Conn) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	// туннель -> локальный сокет
+	go func() {
+		defer wg.Done()
+		buf := make([]byte, MaxChunkSize)
+		for {
+			n, err := c.Read(buf)
+			if n > 0 {
+				if _, werr := local.Write(buf[:n]); werr != nil {
+					c.Reset(api.CloseReason_CLOSE_REASON_IO_ERROR, werr.Error())
+					local.Close()
+					return
+				}
+			}
+			if err == io.EOF {
+				// после Close другая сторона уже не читает, держать сокет открытым незачем
+				if cw, ok := local.(closeWriter); ok && !c.closedByPeer() {
+					cw.CloseWrite()
+				} else {
+					local.Close()
+				}
+				return
+			}
+			if err != nil {
+				local.Close()
+				return
+			}
+		}
+	}()
+
+	// локальный сокет -> туннель
+	go func() {
+		defer wg.Done()
+		buf := make([]byte, MaxChunkSize)
+		for {
+			n, err := local.Read(buf)
+			if n > 0 {
+				if _, werr := c.Write(buf[:n]); werr != nil {
+					if !errors.Is(werr, ErrWriteClosed) {
+						local.Close()
+					}
+					return
+				}
+			}
+			if err == io.EOF {
+				c.CloseWrite()
+				return
+			}
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					c.Reset(api.CloseReason_CLOSE_REASON_IO_ERROR, err.Error())
+				}
+				return
+			}
+		}
+	}()
+
+	wg.Wait()
+	c.Close()
+	local.Close()
+}
@@ -0,0 +1,98 @@
+package mux
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/waste3d/ghost-tunnel/api"
+)
+
+// tcpPair возвращает два конца локального TCP-соединения: у net.Pipe нет CloseWrite.
+func tcpPair(t *testing.T) (client, server *net.TCPConn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := ln.Accept()
+		accepted <- conn
+	}()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	server = result(t, "accept", accepted).(*net.TCPConn)
+	t.Cleanup(func() {
+		conn.Close()
+		server.Close()
+	})
+	return conn.(*net.TCPConn), server
+}
+
+func TestJoinLocalHalfClose(t *testing.T) {
+	client, local := tcpPair(t)
+	tunnel, remote := newPipe()
+	joined := make(chan struct{})
+	go func() {
+		Join(local, tunnel)
+		close(joined)
+	}()
+
+	// локальная сторона закончила запрос, но ждёт ответ
+	client.Write([]byte("request"))
+	client.CloseWrite()
+	if data := result(t, "remote read", readAll(remote)); data != "request" {
+		t.Fatalf("remote read %q, want %q", data, "request")
+	}
+
+	// удалённая сторона продолжает писать после HalfClose
+	for _, part := range []string{"response ", "in ", "parts"} {
+		if _, err := remote.Write([]byte(part)); err != nil {
+			t.Fatalf("remote Write: %v", err)
+		}
+	}
+	remote.CloseWrite()
+
+	got := make(chan string, 1)
+	go func() {
+		data, _ := io.ReadAll(client)
+		got <- string(data)
+	}()
+	if data := result(t, "client read", got); data != "response in parts" {
+		t.Errorf("client read %q, want %q", data, "response in parts")
+	}
+	result(t, "join", joined)
+}
+
+func TestJoinRemoteResetClosesLocal(t *testing.T) {
+	client, local := tcpPair(t)
+	tunnel, remote := newPipe()
+	joined := make(chan struct{})
+	go func() {
+		Join(local, tunnel)
+		close(joined)
+	}()
+
+	remote.Reset(api.CloseReason_CLOSE_REASON_IO_ERROR, "local service failed")
+
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := client.Read(make([]byte, 1))
+		readErr <- err
+	}()
+	if err := result(t, "client read", readErr); err == nil {
+		t.Error("client Read after Reset succeeded, want the socket closed")
+	}
+	result(t, "join", joined)
+
+	var reset *ResetError
+	if _, err := tunnel.Read(make([]byte, 1)); !errors.As(err, &reset) {
+		t.Errorf("tunnel Read after Reset = %v, want ResetError", err)
+	}
+}
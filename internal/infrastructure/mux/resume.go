@@ -0,0 +1,34 @@
+package mux
+
+import "github.com/waste3d/ghost-tunnel/api"
+
+// States собирает состояние соединений для Register/Registered.
+func States(conns []*Conn) []*api.ConnectionState {
+	states := make([]*api.ConnectionState, 0, len(conns))
+	for _, conn := range conns {
+		states = append(states, conn.State())
+	}
+	return states
+}
+
+// Reconcile продолжает соединения, о которых помнит другая сторона, и
+// возвращает те, что она успела забыть: их остаётся только оборвать.
+//
+// Потерянные данные досылаются в фоне: обе стороны досылают одновременно,
+// и если не начать читать стрим, gRPC flow control заблокирует обе.
+func Reconcile(conns []*Conn, peer []*api.ConnectionState) (lost []*Conn) {
+	byID := make(map[string]*api.ConnectionState, len(peer))
+	for _, state := range peer {
+		byID[state.GetConnectionId()] = state
+	}
+
+	for _, conn := range conns {
+		state, ok := byID[conn.ID()]
+		if !ok {
+			lost = append(lost, conn)
+			continue
+		}
+		go conn.Resume(state)
+	}
+	return lost
+}
@@ -0,0 +1,106 @@
+package mux
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/waste3d/ghost-tunnel/api"
+)
+
+func TestConnResumeRetransmits(t *testing.T) {
+	sender := &recordingSender{}
+	c := NewConn("conn", sender)
+	if _, err := c.Write([]byte("hello world")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := c.CloseWrite(); err != nil {
+		t.Fatalf("CloseWrite: %v", err)
+	}
+	before := len(sender.snapshot())
+
+	// другая сторона получила "hello " и потеряла остальное вместе с HalfClose
+	c.Resume(&api.ConnectionState{ConnectionId: "conn", Received: 6, Credited: 2})
+
+	resent := sender.snapshot()[before:]
+	if len(resent) != 2 {
+		t.Fatalf("Resume sent %+v, want data and half_close", resent)
+	}
+	if f := resent[0]; f.kind != "data" || f.offset != 6 || string(f.data) != "world" {
+		t.Errorf("resent %+v, want data %q at 6", f, "world")
+	}
+	if f := resent[1]; f.kind != "half_close" || f.offset != 11 {
+		t.Errorf("resent %+v, want half_close at 11", f)
+	}
+}
+
+func TestConnResumeNothingLost(t *testing.T) {
+	sender := &recordingSender{}
+	c := NewConn("conn", sender)
+	if _, err := c.Write([]byte("hello")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := c.CloseWrite(); err != nil {
+		t.Fatalf("CloseWrite: %v", err)
+	}
+	before := len(sender.snapshot())
+
+	c.Resume(&api.ConnectionState{ConnectionId: "conn", Received: 5, Credited: 5, EofReceived: true})
+
+	if resent := sender.snapshot()[before:]; len(resent) != 0 {
+		t.Errorf("Resume sent %+v, want nothing", resent)
+	}
+	c.mu.Lock()
+	window, retained := c.sendWindow(), len(c.retained)
+	c.mu.Unlock()
+	if window != InitialWindow || retained != 0 {
+		t.Errorf("after Resume window = %d, retained = %d; want %d and 0", window, retained, InitialWindow)
+	}
+}
+
+func TestConnWriteSurvivesSendFailure(t *testing.T) {
+	sender := &recordingSender{}
+	c := NewConn("conn", sender)
+
+	// стрим оборвался: Write не сообщает об этом, данные ждут Resume
+	sender.setFailData(errors.New("stream closed"))
+	n, err := c.Write([]byte("payload"))
+	if err != nil || n != len("payload") {
+		t.Fatalf("Write with a broken stream = %d, %v; want %d, nil", n, err, len("payload"))
+	}
+	if sent := sender.dataSent(); sent != 0 {
+		t.Fatalf("dataSent = %d, want 0", sent)
+	}
+
+	sender.setFailData(nil)
+	c.Resume(&api.ConnectionState{ConnectionId: "conn"})
+
+	frames := sender.snapshot()
+	if len(frames) != 1 || frames[0].offset != 0 || string(frames[0].data) != "payload" {
+		t.Errorf("Resume sent %+v, want data %q at 0", frames, "payload")
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	keptSender, lostSender := &recordingSender{}, &recordingSender{}
+	kept, lost := NewConn("kept", keptSender), NewConn("lost", lostSender)
+	for _, c := range []*Conn{kept, lost} {
+		if _, err := c.Write([]byte("data")); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	before := len(keptSender.snapshot())
+
+	got := Reconcile([]*Conn{kept, lost}, []*api.ConnectionState{{ConnectionId: "kept"}})
+	if len(got) != 1 || got[0] != lost {
+		t.Fatalf("Reconcile lost = %v, want only the connection unknown to the peer", got)
+	}
+
+	// Resume идёт в фоне
+	waitFor(t, "retransmit", func() bool { return len(keptSender.snapshot()) > before })
+	if f := keptSender.snapshot()[before]; f.kind != "data" || f.offset != 0 || string(f.data) != "data" {
+		t.Errorf("resent %+v, want data %q at 0", f, "data")
+	}
+	if sent := lostSender.dataSent(); sent != len("data") {
+		t.Errorf("lost connection sent %d bytes, want no retransmit", sent)
+	}
+}
@@ -0,0 +1,46 @@
+package persistence
+
+import (
+	"context"
+	"sync"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// MemoryACMECache держит ACME-ключи и сертификаты в памяти: после
+// перезапуска сервера они выпускаются заново.
+type MemoryACMECache struct {
+	mu   sync.RWMutex
+	data map[string][]byte
+}
+
+func NewMemoryACMECache() autocert.Cache {
+	return &MemoryACMECache{data: make(map[string][]byte)}
+}
+
+func (c *MemoryACMECache) Get(ctx context.Context, key string) ([]byte, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	data, ok := c.data[key]
+	if !ok {
+		return nil, autocert.ErrCacheMiss
+	}
+	return data, nil
+}
+
+func (c *MemoryACMECache) Put(ctx context.Context, key string, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.data[key] = append([]byte(nil), data...)
+	return nil
+}
+
+func (c *MemoryACMECache) Delete(ctx context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.data, key)
+	return nil
+}
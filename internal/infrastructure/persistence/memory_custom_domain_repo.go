@@ -0,0 +1,155 @@
+package persistence
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/waste3d/ghost-tunnel/internal/domain"
+)
+
+// domainKey — заявка туннеля на имя.
+type domainKey struct {
+	hostname string
+	tunnelID domain.TunnelID
+}
+
+// MemoryCustomDomainRepository хранит собственные домены в памяти процесса.
+type MemoryCustomDomainRepository struct {
+	mu      sync.RWMutex
+	domains map[domainKey]*domain.CustomDomain
+}
+
+func NewMemoryCustomDomainRepository() domain.CustomDomainRepository {
+	return &MemoryCustomDomainRepository{domains: make(map[domainKey]*domain.CustomDomain)}
+}
+
+func (r *MemoryCustomDomainRepository) Save(ctx context.Context, d *domain.CustomDomain) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.domains[domainKey{d.Hostname, d.TunnelID}]; ok {
+		return domain.ErrDomainTaken
+	}
+	if r.verified(d.Hostname) != nil {
+		return domain.ErrDomainTaken
+	}
+	saved := *d
+	r.domains[domainKey{d.Hostname, d.TunnelID}] = &saved
+	return nil
+}
+
+func (r *MemoryCustomDomainRepository) FindVerified(ctx context.Context, hostname string) (*domain.CustomDomain, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	d := r.verified(hostname)
+	if d == nil {
+		return nil, nil
+	}
+	found := *d
+	return &found, nil
+}
+
+func (r *MemoryCustomDomainRepository) FindClaim(ctx context.Context, hostname string, tunnelID domain.TunnelID) (*domain.CustomDomain, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	d, ok := r.domains[domainKey{hostname, tunnelID}]
+	if !ok {
+		return nil, nil
+	}
+	found := *d
+	return &found, nil
+}
+
+func (r *MemoryCustomDomainRepository) ListByTunnel(ctx context.Context, tunnelID domain.TunnelID) ([]*domain.CustomDomain, error) {
+	return r.list(func(d *domain.CustomDomain) bool { return d.TunnelID == tunnelID }), nil
+}
+
+func (r *MemoryCustomDomainRepository) List(ctx context.Context) ([]*domain.CustomDomain, error) {
+	return r.list(func(*domain.CustomDomain) bool { return true }), nil
+}
+
+func (r *MemoryCustomDomainRepository) UpdateVerification(ctx context.Context, d *domain.CustomDomain) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.domains[domainKey{d.Hostname, d.TunnelID}]
+	if !ok {
+		// заявку вытеснил другой туннель или её удалили
+		return domain.ErrDomainNotFound
+	}
+	if d.Verified() {
+		if other := r.verified(d.Hostname); other != nil && other.TunnelID != d.TunnelID {
+			return domain.ErrDomainTaken
+		}
+		for key := range r.domains {
+			if key.hostname == d.Hostname && key.tunnelID != d.TunnelID {
+				delete(r.domains, key)
+			}
+		}
+	}
+	stored.VerifiedAt = d.VerifiedAt
+	return nil
+}
+
+func (r *MemoryCustomDomainRepository) UpdateCertificate(ctx context.Context, d *domain.CustomDomain) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if stored, ok := r.domains[domainKey{d.Hostname, d.TunnelID}]; ok {
+		stored.CertStatus = d.CertStatus
+		stored.CertError = d.CertError
+		stored.CertExpiresAt = d.CertExpiresAt
+	}
+	return nil
+}
+
+func (r *MemoryCustomDomainRepository) Delete(ctx context.Context, hostname string, tunnelID domain.TunnelID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.domains, domainKey{hostname, tunnelID})
+	return nil
+}
+
+func (r *MemoryCustomDomainRepository) DeleteUnverified(ctx context.Context, createdBefore time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	deleted := 0
+	for key, d := range r.domains {
+		if !d.Verified() && d.CreatedAt.Before(createdBefore) {
+			delete(r.domains, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// verified вызывается под r.mu.
+func (r *MemoryCustomDomainRepository) verified(hostname string) *domain.CustomDomain {
+	for key, d := range r.domains {
+		if key.hostname == hostname && d.Verified() {
+			return d
+		}
+	}
+	return nil
+}
+
+func (r *MemoryCustomDomainRepository) list(match func(*domain.CustomDomain) bool) []*domain.CustomDomain {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var domains []*domain.CustomDomain
+	for _, d := range r.domains {
+		if match(d) {
+			found := *d
+			domains = append(domains, &found)
+		}
+	}
+	sort.Slice(domains, func(i, j int) bool { return domains[i].CreatedAt.Before(domains[j].CreatedAt) })
+	return domains
+}
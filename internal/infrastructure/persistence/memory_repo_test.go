@@ -0,0 +1,24 @@
+package persistence
+
+import "testing"
+
+func TestMemoryTunnelRepository(t *testing.T) {
+	testTunnelRepository(t, NewMemoryTunnelRepository(), NewMemoryUserRepository())
+}
+
+func TestMemoryTunnelStatsRepository(t *testing.T) {
+	tunnels := NewMemoryTunnelRepository()
+	testTunnelStatsRepository(t, NewMemoryTunnelStatsRepository(tunnels), tunnels)
+}
+
+func TestMemoryUserRepository(t *testing.T) {
+	testUserRepository(t, NewMemoryUserRepository())
+}
+
+func TestMemoryCustomDomainRepository(t *testing.T) {
+	testCustomDomainRepository(t, NewMemoryCustomDomainRepository(), NewMemoryTunnelRepository())
+}
+
+func TestMemoryACMECache(t *testing.T) {
+	testACMECache(t, NewMemoryACMECache())
+}
@@ -0,0 +1,234 @@
+package persistence
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/waste3d/ghost-tunnel/internal/domain"
+)
+
+// MemoryTunnelRepository хранит туннели в памяти процесса. Ведёт себя так же,
+// как PostgresTunnelRepository, и нужен для локальной разработки и тестов.
+type MemoryTunnelRepository struct {
+	mu          sync.RWMutex
+	byID        map[domain.TunnelID]*domain.Tunnel
+	bySubdomain map[string]domain.TunnelID
+	byPort      map[int]domain.TunnelID
+}
+
+func NewMemoryTunnelRepository() domain.TunnelRepository {
+	return &MemoryTunnelRepository{
+		byID:        make(map[domain.TunnelID]*domain.Tunnel),
+		bySubdomain: make(map[string]domain.TunnelID),
+		byPort:      make(map[int]domain.TunnelID),
+	}
+}
+
+func (r *MemoryTunnelRepository) Save(ctx context.Context, tunnel *domain.Tunnel) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if tunnel.Protocol == domain.ProtocolTCP {
+		if _, ok := r.byPort[tunnel.Endpoints.Port]; ok {
+			return domain.ErrPortTaken
+		}
+	}
+	// как и в Postgres, конфликт первичного ключа отдаётся тем же ErrSubdomainTaken
+	if _, ok := r.bySubdomain[tunnel.Endpoints.Subdomain]; ok {
+		return domain.ErrSubdomainTaken
+	}
+	if _, ok := r.byID[tunnel.ID]; ok {
+		return domain.ErrSubdomainTaken
+	}
+
+	t := cloneTunnel(tunnel)
+	// У HTTP-туннелей порт не хранится, при чтении всегда 80
+	if t.Protocol != domain.ProtocolTCP {
+		t.Endpoints.Port = 80
+	} else {
+		r.byPort[t.Endpoints.Port] = t.ID
+	}
+	r.byID[t.ID] = t
+	r.bySubdomain[t.Endpoints.Subdomain] = t.ID
+	return nil
+}
+
+func (r *MemoryTunnelRepository) FindByID(ctx context.Context, id domain.TunnelID) (*domain.Tunnel, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	t, ok := r.byID[id]
+	if !ok {
+		return nil, nil
+	}
+	return cloneTunnel(t), nil
+}
+
+func (r *MemoryTunnelRepository) FindBySubdomain(ctx context.Context, subdomain string) (*domain.Tunnel, error) {
+	r.mu.RLock()
+	id, ok := r.bySubdomain[subdomain]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, nil
+	}
+	return r.FindByID(ctx, id)
+}
+
+func (r *MemoryTunnelRepository) ListByUser(ctx context.Context, userID domain.UserID, filter domain.TunnelFilter) ([]*domain.Tunnel, int, error) {
+	r.mu.RLock()
+	var tunnels []*domain.Tunnel
+	for _, t := range r.byID {
+		if t.UserID == userID && (filter.Status == "" || t.Status == filter.Status) {
+			tunnels = append(tunnels, cloneTunnel(t))
+		}
+	}
+	r.mu.RUnlock()
+
+	// тот же порядок, что и в SQL-реализациях: новые первыми, затем по id
+	sort.Slice(tunnels, func(i, j int) bool {
+		if !tunnels[i].CreatedAt.Equal(tunnels[j].CreatedAt) {
+			return tunnels[i].CreatedAt.After(tunnels[j].CreatedAt)
+		}
+		return tunnels[i].ID < tunnels[j].ID
+	})
+
+	total := len(tunnels)
+	tunnels = tunnels[min(filter.Offset, total):]
+	if filter.Limit > 0 && filter.Limit < len(tunnels) {
+		tunnels = tunnels[:filter.Limit]
+	}
+	return tunnels, total, nil
+}
+
+func (r *MemoryTunnelRepository) ListPublicPorts(ctx context.Context) ([]int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ports := make([]int, 0, len(r.byPort))
+	for port := range r.byPort {
+		ports = append(ports, port)
+	}
+	return ports, nil
+}
+
+func (r *MemoryTunnelRepository) Update(ctx context.Context, tunnel *domain.Tunnel) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.byID[tunnel.ID]
+	if !ok {
+		return domain.ErrTunnelNotFound
+	}
+	subdomain := tunnel.Endpoints.Subdomain
+	if id, ok := r.bySubdomain[subdomain]; ok && id != tunnel.ID {
+		return domain.ErrSubdomainTaken
+	}
+
+	delete(r.bySubdomain, stored.Endpoints.Subdomain)
+	r.bySubdomain[subdomain] = stored.ID
+	stored.Endpoints.Subdomain = subdomain
+	stored.LocalTarget = tunnel.LocalTarget
+	stored.HTTPSRedirect = tunnel.HTTPSRedirect
+	stored.Reserved = tunnel.Reserved
+	return nil
+}
+
+func (r *MemoryTunnelRepository) UpdateStatus(ctx context.Context, tunnel *domain.Tunnel) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.byID[tunnel.ID]
+	if !ok {
+		return domain.ErrTunnelNotFound
+	}
+	updated := cloneTunnel(tunnel)
+	stored.Status = updated.Status
+	stored.LastConnectedAt = updated.LastConnectedAt
+	stored.LastDisconnectedAt = updated.LastDisconnectedAt
+	stored.Agent = updated.Agent
+	return nil
+}
+
+func (r *MemoryTunnelRepository) ResetStatuses(ctx context.Context, at time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := 0
+	for _, t := range r.byID {
+		if t.Status == domain.StatusActive {
+			t.Deactivate(at)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r *MemoryTunnelRepository) ListAbandoned(ctx context.Context, offlineBefore time.Time) ([]*domain.Tunnel, error) {
+	r.mu.RLock()
+	var tunnels []*domain.Tunnel
+	for _, t := range r.byID {
+		if !t.Reserved && t.Status == domain.StatusInactive && t.OfflineSince().Before(offlineBefore) {
+			tunnels = append(tunnels, cloneTunnel(t))
+		}
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(tunnels, func(i, j int) bool {
+		a, b := tunnels[i].OfflineSince(), tunnels[j].OfflineSince()
+		if !a.Equal(b) {
+			return a.Before(b)
+		}
+		return tunnels[i].ID < tunnels[j].ID
+	})
+	return tunnels, nil
+}
+
+func (r *MemoryTunnelRepository) DeleteAbandoned(ctx context.Context, id domain.TunnelID, offlineBefore time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, ok := r.byID[id]
+	if !ok || t.Reserved || t.Status != domain.StatusInactive || !t.OfflineSince().Before(offlineBefore) {
+		return false, nil
+	}
+	r.remove(t)
+	return true, nil
+}
+
+func (r *MemoryTunnelRepository) Delete(ctx context.Context, subdomain string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id, ok := r.bySubdomain[subdomain]
+	if !ok {
+		return nil
+	}
+	r.remove(r.byID[id])
+	return nil
+}
+
+// remove вызывается под r.mu.
+func (r *MemoryTunnelRepository) remove(t *domain.Tunnel) {
+	if t.Protocol == domain.ProtocolTCP {
+		delete(r.byPort, t.Endpoints.Port)
+	}
+	delete(r.bySubdomain, t.Endpoints.Subdomain)
+	delete(r.byID, t.ID)
+}
+
+// cloneTunnel копирует туннель вместе с полями-указателями, чтобы хранимые
+// данные нельзя было изменить через переданный или возвращённый туннель.
+func cloneTunnel(tunnel *domain.Tunnel) *domain.Tunnel {
+	t := *tunnel
+	if tunnel.LastConnectedAt != nil {
+		at := *tunnel.LastConnectedAt
+		t.LastConnectedAt = &at
+	}
+	if tunnel.LastDisconnectedAt != nil {
+		at := *tunnel.LastDisconnectedAt
+		t.LastDisconnectedAt = &at
+	}
+	return &t
+}
@@ -0,0 +1,66 @@
+package persistence
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/waste3d/ghost-tunnel/internal/domain"
+)
+
+type statsKey struct {
+	tunnelID domain.TunnelID
+	start    time.Time
+}
+
+// MemoryTunnelStatsRepository хранит статистику туннелей в памяти процесса.
+// Внешних ключей нет, поэтому существование туннеля проверяется через tunnels.
+type MemoryTunnelStatsRepository struct {
+	tunnels domain.TunnelRepository
+
+	mu    sync.RWMutex
+	stats map[statsKey]*domain.TunnelStats
+}
+
+func NewMemoryTunnelStatsRepository(tunnels domain.TunnelRepository) domain.TunnelStatsRepository {
+	return &MemoryTunnelStatsRepository{tunnels: tunnels, stats: make(map[statsKey]*domain.TunnelStats)}
+}
+
+func (r *MemoryTunnelStatsRepository) Add(ctx context.Context, stats []*domain.TunnelStats) error {
+	for _, s := range stats {
+		tunnel, err := r.tunnels.FindByID(ctx, s.TunnelID)
+		if err != nil {
+			return err
+		}
+		if tunnel == nil {
+			continue
+		}
+
+		key := statsKey{tunnelID: s.TunnelID, start: s.Start.UTC()}
+		r.mu.Lock()
+		stored, ok := r.stats[key]
+		if !ok {
+			stored = &domain.TunnelStats{TunnelID: s.TunnelID, Start: key.start}
+			r.stats[key] = stored
+		}
+		stored.Merge(s)
+		r.mu.Unlock()
+	}
+	return nil
+}
+
+func (r *MemoryTunnelStatsRepository) Range(ctx context.Context, tunnelID domain.TunnelID, from, to time.Time) ([]*domain.TunnelStats, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var stats []*domain.TunnelStats
+	for key, s := range r.stats {
+		if key.tunnelID == tunnelID && !key.start.Before(from) && key.start.Before(to) {
+			found := *s
+			stats = append(stats, &found)
+		}
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Start.Before(stats[j].Start) })
+	return stats, nil
+}
@@ -0,0 +1,70 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/waste3d/ghost-tunnel/internal/domain"
+)
+
+// MemoryUserRepository хранит пользователей в памяти процесса.
+type MemoryUserRepository struct {
+	mu       sync.RWMutex
+	byID     map[domain.UserID]*domain.User
+	byEmail  map[string]domain.UserID
+	byAPIKey map[domain.APIKey]domain.UserID
+}
+
+func NewMemoryUserRepository() domain.UserRepository {
+	return &MemoryUserRepository{
+		byID:     make(map[domain.UserID]*domain.User),
+		byEmail:  make(map[string]domain.UserID),
+		byAPIKey: make(map[domain.APIKey]domain.UserID),
+	}
+}
+
+func (r *MemoryUserRepository) Save(ctx context.Context, user *domain.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.byID[user.ID]; ok {
+		return fmt.Errorf("%w: duplicate id", domain.ErrUserAlreadyExists)
+	}
+	if _, ok := r.byEmail[user.Email]; ok {
+		return fmt.Errorf("%w: duplicate email", domain.ErrUserAlreadyExists)
+	}
+	if _, ok := r.byAPIKey[user.APIKey]; ok {
+		return fmt.Errorf("%w: duplicate api key", domain.ErrUserAlreadyExists)
+	}
+
+	u := *user
+	r.byID[u.ID] = &u
+	r.byEmail[u.Email] = u.ID
+	r.byAPIKey[u.APIKey] = u.ID
+	return nil
+}
+
+func (r *MemoryUserRepository) FindByEmail(ctx context.Context, email string) (*domain.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	id, ok := r.byEmail[email]
+	if !ok {
+		return nil, nil
+	}
+	user := *r.byID[id]
+	return &user, nil
+}
+
+func (r *MemoryUserRepository) FindByAPIKey(ctx context.Context, apiKey string) (*domain.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	id, ok := r.byAPIKey[domain.APIKey(apiKey)]
+	if !ok {
+		return nil, nil
+	}
+	user := *r.byID[id]
+	return &user, nil
+}
@@ -0,0 +1,86 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/pressly/goose/v3"
+	"github.com/pressly/goose/v3/lock"
+	"github.com/waste3d/ghost-tunnel/db"
+)
+
+// Migrator применяет встроенные в бинарник миграции: db/migrations для
+// Postgres и db/sqlite_migrations для SQLite. В Postgres на время миграции
+// берётся advisory lock, поэтому несколько реплик, стартующих одновременно,
+// применят каждую миграцию ровно один раз.
+type Migrator struct {
+	db       *sql.DB
+	provider *goose.Provider
+}
+
+func NewPostgresMigrator(dsn string) (*Migrator, error) {
+	sqlDB, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	// ждём освобождения блокировки до 5 минут: 60 попыток раз в 5 секунд
+	locker, err := lock.NewPostgresSessionLocker(lock.WithLockTimeout(5, 60))
+	if err != nil {
+		sqlDB.Close()
+		return nil, fmt.Errorf("failed to create migration lock: %w", err)
+	}
+
+	return newMigrator(sqlDB, goose.DialectPostgres, db.Migrations(), goose.WithSessionLocker(locker))
+}
+
+// NewSQLiteMigrator не берёт блокировку: SQLite рассчитан на один узел.
+func NewSQLiteMigrator(dsn string) (*Migrator, error) {
+	sqlDB, err := OpenSQLite(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return newMigrator(sqlDB, goose.DialectSQLite3, db.SQLiteMigrations())
+}
+
+func newMigrator(sqlDB *sql.DB, dialect goose.Dialect, migrations fs.FS, opts ...goose.ProviderOption) (*Migrator, error) {
+	provider, err := goose.NewProvider(dialect, sqlDB, migrations, opts...)
+	if err != nil {
+		sqlDB.Close()
+		return nil, fmt.Errorf("failed to load migrations: %w", err)
+	}
+	return &Migrator{db: sqlDB, provider: provider}, nil
+}
+
+// Up применяет все ещё не применённые миграции.
+func (m *Migrator) Up(ctx context.Context) ([]*goose.MigrationResult, error) {
+	results, err := m.provider.Up(ctx)
+	if err != nil {
+		return results, fmt.Errorf("failed to apply migrations: %w", err)
+	}
+	return results, nil
+}
+
+// Down откатывает последнюю применённую миграцию.
+func (m *Migrator) Down(ctx context.Context) (*goose.MigrationResult, error) {
+	result, err := m.provider.Down(ctx)
+	if err != nil {
+		return result, fmt.Errorf("failed to roll back migration: %w", err)
+	}
+	return result, nil
+}
+
+func (m *Migrator) Status(ctx context.Context) ([]*goose.MigrationStatus, error) {
+	status, err := m.provider.Status(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get migration status: %w", err)
+	}
+	return status, nil
+}
+
+func (m *Migrator) Close() error {
+	return m.db.Close()
+}
@@ -0,0 +1,51 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// PostgresACMECache хранит ключ ACME-аккаунта и выпущенные сертификаты в
+// Postgres, чтобы все реплики сервера пользовались одними сертификатами.
+type PostgresACMECache struct {
+	db *pgxpool.Pool
+}
+
+func NewPostgresACMECache(db *pgxpool.Pool) autocert.Cache {
+	return &PostgresACMECache{db: db}
+}
+
+func (c *PostgresACMECache) Get(ctx context.Context, key string) ([]byte, error) {
+	var data []byte
+	err := c.db.QueryRow(ctx, `SELECT data FROM acme_cache WHERE key = $1`, key).Scan(&data)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, autocert.ErrCacheMiss
+		}
+		return nil, fmt.Errorf("could not read acme cache: %w", err)
+	}
+	return data, nil
+}
+
+func (c *PostgresACMECache) Put(ctx context.Context, key string, data []byte) error {
+	query := `
+		INSERT INTO acme_cache (key, data, updated_at) VALUES ($1, $2, NOW())
+		ON CONFLICT (key) DO UPDATE SET data = EXCLUDED.data, updated_at = NOW()
+	`
+	if _, err := c.db.Exec(ctx, query, key, data); err != nil {
+		return fmt.Errorf("could not write acme cache: %w", err)
+	}
+	return nil
+}
+
+func (c *PostgresACMECache) Delete(ctx context.Context, key string) error {
+	if _, err := c.db.Exec(ctx, `DELETE FROM acme_cache WHERE key = $1`, key); err != nil {
+		return fmt.Errorf("could not delete from acme cache: %w", err)
+	}
+	return nil
+}
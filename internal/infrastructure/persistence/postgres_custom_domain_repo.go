@@ -0,0 +1,185 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/waste3d/ghost-tunnel/internal/domain"
+)
+
+const customDomainColumns = `hostname, tunnel_id, verification_token, verified_at, cert_status, cert_error, cert_expires_at, created_at`
+
+type PostgresCustomDomainRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewPostgresCustomDomainRepository(db *pgxpool.Pool) domain.CustomDomainRepository {
+	return &PostgresCustomDomainRepository{db: db}
+}
+
+func (r *PostgresCustomDomainRepository) Save(ctx context.Context, d *domain.CustomDomain) error {
+	// подтверждённое имя уже никто не получит: его заявку вытесняет только
+	// подтверждение другого туннеля, см. UpdateVerification
+	verified, err := r.FindVerified(ctx, d.Hostname)
+	if err != nil {
+		return err
+	}
+	if verified != nil {
+		return domain.ErrDomainTaken
+	}
+
+	query := `
+		INSERT INTO domains (hostname, tunnel_id, verification_token, verified_at, cert_status, cert_error, cert_expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+	_, err = r.db.Exec(ctx, query,
+		d.Hostname,
+		d.TunnelID,
+		d.VerificationToken,
+		d.VerifiedAt,
+		d.CertStatus,
+		d.CertError,
+		d.CertExpiresAt,
+		d.CreatedAt,
+	)
+
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return domain.ErrDomainTaken
+		}
+		return fmt.Errorf("could not save domain: %w", err)
+	}
+	return nil
+}
+
+func (r *PostgresCustomDomainRepository) FindVerified(ctx context.Context, hostname string) (*domain.CustomDomain, error) {
+	query := `SELECT ` + customDomainColumns + ` FROM domains WHERE hostname = $1 AND verified_at IS NOT NULL`
+	return r.find(ctx, query, hostname)
+}
+
+func (r *PostgresCustomDomainRepository) FindClaim(ctx context.Context, hostname string, tunnelID domain.TunnelID) (*domain.CustomDomain, error) {
+	query := `SELECT ` + customDomainColumns + ` FROM domains WHERE hostname = $1 AND tunnel_id = $2`
+	return r.find(ctx, query, hostname, tunnelID)
+}
+
+func (r *PostgresCustomDomainRepository) find(ctx context.Context, query string, args ...any) (*domain.CustomDomain, error) {
+	d, err := r.scanDomain(r.db.QueryRow(ctx, query, args...))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not find domain: %w", err)
+	}
+	return d, nil
+}
+
+func (r *PostgresCustomDomainRepository) ListByTunnel(ctx context.Context, tunnelID domain.TunnelID) ([]*domain.CustomDomain, error) {
+	query := `SELECT ` + customDomainColumns + ` FROM domains WHERE tunnel_id = $1 ORDER BY created_at`
+	return r.list(ctx, query, tunnelID)
+}
+
+func (r *PostgresCustomDomainRepository) List(ctx context.Context) ([]*domain.CustomDomain, error) {
+	query := `SELECT ` + customDomainColumns + ` FROM domains ORDER BY created_at`
+	return r.list(ctx, query)
+}
+
+func (r *PostgresCustomDomainRepository) UpdateVerification(ctx context.Context, d *domain.CustomDomain) error {
+	// подтверждение и удаление чужих заявок — один запрос; второе
+	// подтверждение того же имени нарушит idx_domains_verified_hostname
+	query := `
+		WITH updated AS (
+			UPDATE domains SET verified_at = $3 WHERE hostname = $1 AND tunnel_id = $2
+			RETURNING hostname, verified_at
+		), deleted AS (
+			DELETE FROM domains
+			WHERE hostname IN (SELECT hostname FROM updated WHERE verified_at IS NOT NULL) AND tunnel_id <> $2
+		)
+		SELECT count(*) FROM updated
+	`
+	var updated int
+	err := r.db.QueryRow(ctx, query, d.Hostname, d.TunnelID, d.VerifiedAt).Scan(&updated)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return domain.ErrDomainTaken
+		}
+		return fmt.Errorf("could not update domain verification: %w", err)
+	}
+	if updated == 0 {
+		// заявку вытеснил другой туннель или её удалили
+		return domain.ErrDomainNotFound
+	}
+	return nil
+}
+
+func (r *PostgresCustomDomainRepository) UpdateCertificate(ctx context.Context, d *domain.CustomDomain) error {
+	query := `UPDATE domains SET cert_status = $3, cert_error = $4, cert_expires_at = $5 WHERE hostname = $1 AND tunnel_id = $2`
+	_, err := r.db.Exec(ctx, query, d.Hostname, d.TunnelID, d.CertStatus, d.CertError, d.CertExpiresAt)
+	if err != nil {
+		return fmt.Errorf("could not update domain certificate: %w", err)
+	}
+	return nil
+}
+
+func (r *PostgresCustomDomainRepository) Delete(ctx context.Context, hostname string, tunnelID domain.TunnelID) error {
+	query := `DELETE FROM domains WHERE hostname = $1 AND tunnel_id = $2`
+	_, err := r.db.Exec(ctx, query, hostname, tunnelID)
+	if err != nil {
+		return fmt.Errorf("could not delete domain: %w", err)
+	}
+	return nil
+}
+
+func (r *PostgresCustomDomainRepository) DeleteUnverified(ctx context.Context, createdBefore time.Time) (int, error) {
+	query := `DELETE FROM domains WHERE verified_at IS NULL AND created_at < $1`
+	tag, err := r.db.Exec(ctx, query, createdBefore)
+	if err != nil {
+		return 0, fmt.Errorf("could not delete unverified domains: %w", err)
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+func (r *PostgresCustomDomainRepository) list(ctx context.Context, query string, args ...any) ([]*domain.CustomDomain, error) {
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not list domains: %w", err)
+	}
+	defer rows.Close()
+
+	var domains []*domain.CustomDomain
+	for rows.Next() {
+		d, err := r.scanDomain(rows)
+		if err != nil {
+			return nil, fmt.Errorf("could not scan domain: %w", err)
+		}
+		domains = append(domains, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not list domains: %w", err)
+	}
+	return domains, nil
+}
+
+func (r *PostgresCustomDomainRepository) scanDomain(row pgx.Row) (*domain.CustomDomain, error) {
+	var d domain.CustomDomain
+	err := row.Scan(
+		&d.Hostname,
+		&d.TunnelID,
+		&d.VerificationToken,
+		&d.VerifiedAt,
+		&d.CertStatus,
+		&d.CertError,
+		&d.CertExpiresAt,
+		&d.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
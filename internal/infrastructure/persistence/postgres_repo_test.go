@@ -0,0 +1,59 @@
+package persistence
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// testPostgresPool подключается к базе из GHOST_TUNNEL_TEST_DATABASE_DSN и
+// накатывает на неё миграции. Без переменной тест пропускается.
+func testPostgresPool(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+	dsn := os.Getenv("GHOST_TUNNEL_TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("GHOST_TUNNEL_TEST_DATABASE_DSN is not set")
+	}
+	ctx := context.Background()
+
+	migrator, err := NewPostgresMigrator(dsn)
+	if err != nil {
+		t.Fatalf("NewPostgresMigrator: %v", err)
+	}
+	defer migrator.Close()
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+func TestPostgresTunnelRepository(t *testing.T) {
+	pool := testPostgresPool(t)
+	testTunnelRepository(t, NewPostgresTunnelRepository(pool), NewPostgresUserRepository(pool))
+}
+
+func TestPostgresTunnelStatsRepository(t *testing.T) {
+	pool := testPostgresPool(t)
+	testTunnelStatsRepository(t, NewPostgresTunnelStatsRepository(pool), NewPostgresTunnelRepository(pool))
+}
+
+func TestPostgresUserRepository(t *testing.T) {
+	testUserRepository(t, NewPostgresUserRepository(testPostgresPool(t)))
+}
+
+func TestPostgresCustomDomainRepository(t *testing.T) {
+	pool := testPostgresPool(t)
+	testCustomDomainRepository(t, NewPostgresCustomDomainRepository(pool), NewPostgresTunnelRepository(pool))
+}
+
+func TestPostgresACMECache(t *testing.T) {
+	testACMECache(t, NewPostgresACMECache(testPostgresPool(t)))
+}
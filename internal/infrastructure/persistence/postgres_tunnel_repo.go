@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
@@ -12,8 +13,6 @@ import (
 	"github.com/waste3d/ghost-tunnel/internal/domain"
 )
 
-var ErrSubdomainTaken = errors.New("subdomain is already taken")
-
 type PostgresTunnelRepository struct {
 	db *pgxpool.Pool
 }
@@ -26,8 +25,8 @@ func NewPostgresTunnelRepository(db *pgxpool.Pool) domain.TunnelRepository {
 
 func (r *PostgresTunnelRepository) Save(ctx context.Context, tunnel *domain.Tunnel) error {
 	query := `
-		INSERT INTO tunnels (id, user_id, subdomain, local_host, local_port, status, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		INSERT INTO tunnels (id, user_id, protocol, subdomain, domain, public_port, local_host, local_port, https_redirect, reserved, status, created_at, last_connected_at, last_disconnected_at, agent_version, agent_addr)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
 	`
 
 	var userID any
@@ -37,20 +36,38 @@ func (r *PostgresTunnelRepository) Save(ctx context.Context, tunnel *domain.Tunn
 		userID = nil
 	}
 
+	// Выделенный порт есть только у TCP-туннелей, у HTTP колонка пустая
+	var publicPort any
+	if tunnel.Protocol == domain.ProtocolTCP {
+		publicPort = tunnel.Endpoints.Port
+	}
+
 	_, err := r.db.Exec(ctx, query,
 		tunnel.ID,
 		userID,
+		tunnel.Protocol,
 		tunnel.Endpoints.Subdomain,
+		tunnel.Endpoints.Domain,
+		publicPort,
 		tunnel.LocalTarget.Host,
 		tunnel.LocalTarget.Port,
+		tunnel.HTTPSRedirect,
+		tunnel.Reserved,
 		tunnel.Status,
 		tunnel.CreatedAt,
+		tunnel.LastConnectedAt,
+		tunnel.LastDisconnectedAt,
+		tunnel.Agent.Version,
+		tunnel.Agent.RemoteAddr,
 	)
 
 	if err != nil {
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
-			return ErrSubdomainTaken
+			if pgErr.ConstraintName == "tunnels_public_port_key" {
+				return domain.ErrPortTaken
+			}
+			return domain.ErrSubdomainTaken
 		}
 		return fmt.Errorf("could not save tunnel: %w", err)
 	}
@@ -58,38 +75,206 @@ func (r *PostgresTunnelRepository) Save(ctx context.Context, tunnel *domain.Tunn
 }
 
 func (r *PostgresTunnelRepository) FindBySubdomain(ctx context.Context, subdomain string) (*domain.Tunnel, error) {
-	query := `SELECT id, user_id, subdomain, local_host, local_port, status, created_at FROM tunnels WHERE subdomain = $1`
+	query := `SELECT id, user_id, protocol, subdomain, domain, public_port, local_host, local_port, https_redirect, reserved, status, created_at, last_connected_at, last_disconnected_at, agent_version, agent_addr FROM tunnels WHERE subdomain = $1`
 	row := r.db.QueryRow(ctx, query, subdomain)
 
+	tunnel, err := r.scanTunnel(row)
+	if err != nil {
+		return nil, fmt.Errorf("could not find tunnel by subdomain: %w", err)
+	}
+	return tunnel, nil
+}
+
+func (r *PostgresTunnelRepository) FindByID(ctx context.Context, id domain.TunnelID) (*domain.Tunnel, error) {
+	query := `SELECT id, user_id, protocol, subdomain, domain, public_port, local_host, local_port, https_redirect, reserved, status, created_at, last_connected_at, last_disconnected_at, agent_version, agent_addr FROM tunnels WHERE id = $1`
+	row := r.db.QueryRow(ctx, query, id)
+
+	tunnel, err := r.scanTunnel(row)
+	if err != nil {
+		return nil, fmt.Errorf("could not find tunnel by id: %w", err)
+	}
+	return tunnel, nil
+}
+
+func (r *PostgresTunnelRepository) scanTunnel(row pgx.Row) (*domain.Tunnel, error) {
 	var t domain.Tunnel
 	var userID sql.NullString
+	var publicPort sql.NullInt32
 
 	err := row.Scan(
 		&t.ID,
 		&userID,
+		&t.Protocol,
 		&t.Endpoints.Subdomain,
+		&t.Endpoints.Domain,
+		&publicPort,
 		&t.LocalTarget.Host,
 		&t.LocalTarget.Port,
+		&t.HTTPSRedirect,
+		&t.Reserved,
 		&t.Status,
 		&t.CreatedAt,
+		&t.LastConnectedAt,
+		&t.LastDisconnectedAt,
+		&t.Agent.Version,
+		&t.Agent.RemoteAddr,
 	)
 
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
 	if userID.Valid {
 		t.UserID = domain.UserID(userID.String)
 	}
+	t.Endpoints.Port = 80
+	if publicPort.Valid {
+		t.Endpoints.Port = int(publicPort.Int32)
+	}
+	return &t, nil
+}
+
+func (r *PostgresTunnelRepository) ListByUser(ctx context.Context, userID domain.UserID, filter domain.TunnelFilter) ([]*domain.Tunnel, int, error) {
+	where := ` FROM tunnels WHERE user_id = $1 AND ($2::text = '' OR status = $2::text)`
 
+	var total int
+	if err := r.db.QueryRow(ctx, `SELECT count(*)`+where, userID, filter.Status).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("could not count tunnels: %w", err)
+	}
+
+	// LIMIT NULL в Postgres означает «без ограничения»
+	var limit any
+	if filter.Limit > 0 {
+		limit = filter.Limit
+	}
+	query := `SELECT id, user_id, protocol, subdomain, domain, public_port, local_host, local_port, https_redirect, reserved, status, created_at, last_connected_at, last_disconnected_at, agent_version, agent_addr` +
+		where + ` ORDER BY created_at DESC, id LIMIT $3 OFFSET $4`
+	rows, err := r.db.Query(ctx, query, userID, filter.Status, limit, filter.Offset)
 	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, nil
+		return nil, 0, fmt.Errorf("could not list tunnels: %w", err)
+	}
+	defer rows.Close()
+
+	var tunnels []*domain.Tunnel
+	for rows.Next() {
+		tunnel, err := r.scanTunnel(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("could not scan tunnel: %w", err)
 		}
-		return nil, fmt.Errorf("could not find tunnel by subdomain: %w", err)
+		tunnels = append(tunnels, tunnel)
 	}
-	t.Endpoints.Domain = "waste3d.ru"
-	return &t, nil
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("could not list tunnels: %w", err)
+	}
+	return tunnels, total, nil
 }
 
-func (r *PostgresTunnelRepository) FindByID(ctx context.Context, id domain.TunnelID) (*domain.Tunnel, error) {
-	return nil, errors.New("not implemented")
+func (r *PostgresTunnelRepository) ListPublicPorts(ctx context.Context) ([]int, error) {
+	query := `SELECT public_port FROM tunnels WHERE public_port IS NOT NULL`
+	rows, err := r.db.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("could not list public ports: %w", err)
+	}
+
+	ports, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		return nil, fmt.Errorf("could not list public ports: %w", err)
+	}
+	return ports, nil
+}
+
+func (r *PostgresTunnelRepository) Update(ctx context.Context, tunnel *domain.Tunnel) error {
+	query := `
+		UPDATE tunnels SET subdomain = $2, local_host = $3, local_port = $4, https_redirect = $5, reserved = $6
+		WHERE id = $1
+	`
+	tag, err := r.db.Exec(ctx, query,
+		tunnel.ID,
+		tunnel.Endpoints.Subdomain,
+		tunnel.LocalTarget.Host,
+		tunnel.LocalTarget.Port,
+		tunnel.HTTPSRedirect,
+		tunnel.Reserved,
+	)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return domain.ErrSubdomainTaken
+		}
+		return fmt.Errorf("could not update tunnel: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrTunnelNotFound
+	}
+	return nil
+}
+
+func (r *PostgresTunnelRepository) UpdateStatus(ctx context.Context, tunnel *domain.Tunnel) error {
+	query := `
+		UPDATE tunnels SET status = $2, last_connected_at = $3, last_disconnected_at = $4, agent_version = $5, agent_addr = $6
+		WHERE id = $1
+	`
+	tag, err := r.db.Exec(ctx, query,
+		tunnel.ID,
+		tunnel.Status,
+		tunnel.LastConnectedAt,
+		tunnel.LastDisconnectedAt,
+		tunnel.Agent.Version,
+		tunnel.Agent.RemoteAddr,
+	)
+	if err != nil {
+		return fmt.Errorf("could not update tunnel status: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrTunnelNotFound
+	}
+	return nil
+}
+
+func (r *PostgresTunnelRepository) ResetStatuses(ctx context.Context, at time.Time) (int, error) {
+	query := `UPDATE tunnels SET status = $1, last_disconnected_at = $3 WHERE status = $2`
+	tag, err := r.db.Exec(ctx, query, domain.StatusInactive, domain.StatusActive, at)
+	if err != nil {
+		return 0, fmt.Errorf("could not reset tunnel statuses: %w", err)
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+func (r *PostgresTunnelRepository) ListAbandoned(ctx context.Context, offlineBefore time.Time) ([]*domain.Tunnel, error) {
+	query := `SELECT id, user_id, protocol, subdomain, domain, public_port, local_host, local_port, https_redirect, reserved, status, created_at, last_connected_at, last_disconnected_at, agent_version, agent_addr FROM tunnels
+		WHERE NOT reserved AND status = $1 AND COALESCE(last_disconnected_at, last_connected_at, created_at) < $2
+		ORDER BY COALESCE(last_disconnected_at, last_connected_at, created_at), id`
+	rows, err := r.db.Query(ctx, query, domain.StatusInactive, offlineBefore)
+	if err != nil {
+		return nil, fmt.Errorf("could not list abandoned tunnels: %w", err)
+	}
+	defer rows.Close()
+
+	var tunnels []*domain.Tunnel
+	for rows.Next() {
+		tunnel, err := r.scanTunnel(rows)
+		if err != nil {
+			return nil, fmt.Errorf("could not scan tunnel: %w", err)
+		}
+		tunnels = append(tunnels, tunnel)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not list abandoned tunnels: %w", err)
+	}
+	return tunnels, nil
+}
+
+func (r *PostgresTunnelRepository) DeleteAbandoned(ctx context.Context, id domain.TunnelID, offlineBefore time.Time) (bool, error) {
+	query := `DELETE FROM tunnels
+		WHERE id = $1 AND NOT reserved AND status = $2 AND COALESCE(last_disconnected_at, last_connected_at, created_at) < $3`
+	tag, err := r.db.Exec(ctx, query, id, domain.StatusInactive, offlineBefore)
+	if err != nil {
+		return false, fmt.Errorf("could not delete abandoned tunnel: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
 }
 
 func (r *PostgresTunnelRepository) Delete(ctx context.Context, subdomain string) error {
@@ -0,0 +1,112 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/waste3d/ghost-tunnel/internal/domain"
+)
+
+const tunnelStatsColumns = `tunnel_id, bucket_start, requests, connections, bytes_in, bytes_out, status_1xx, status_2xx, status_3xx, status_4xx, status_5xx, latency_total_us, latency_max_us`
+
+type PostgresTunnelStatsRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewPostgresTunnelStatsRepository(db *pgxpool.Pool) domain.TunnelStatsRepository {
+	return &PostgresTunnelStatsRepository{db: db}
+}
+
+func (r *PostgresTunnelStatsRepository) Add(ctx context.Context, stats []*domain.TunnelStats) error {
+	// SELECT ... WHERE EXISTS пропускает туннели, удалённые после подсчёта,
+	// вместо ошибки внешнего ключа на всю пачку. Типы параметров в списке
+	// SELECT Postgres сам не выводит, поэтому они приведены явно.
+	query := `
+		INSERT INTO tunnel_stats (` + tunnelStatsColumns + `)
+		SELECT $1::uuid, $2::timestamptz, $3::bigint, $4::bigint, $5::bigint, $6::bigint, $7::bigint,
+			$8::bigint, $9::bigint, $10::bigint, $11::bigint, $12::bigint, $13::bigint
+		WHERE EXISTS (SELECT 1 FROM tunnels WHERE id = $1::uuid)
+		ON CONFLICT (tunnel_id, bucket_start) DO UPDATE SET
+			requests = tunnel_stats.requests + EXCLUDED.requests,
+			connections = tunnel_stats.connections + EXCLUDED.connections,
+			bytes_in = tunnel_stats.bytes_in + EXCLUDED.bytes_in,
+			bytes_out = tunnel_stats.bytes_out + EXCLUDED.bytes_out,
+			status_1xx = tunnel_stats.status_1xx + EXCLUDED.status_1xx,
+			status_2xx = tunnel_stats.status_2xx + EXCLUDED.status_2xx,
+			status_3xx = tunnel_stats.status_3xx + EXCLUDED.status_3xx,
+			status_4xx = tunnel_stats.status_4xx + EXCLUDED.status_4xx,
+			status_5xx = tunnel_stats.status_5xx + EXCLUDED.status_5xx,
+			latency_total_us = tunnel_stats.latency_total_us + EXCLUDED.latency_total_us,
+			latency_max_us = GREATEST(tunnel_stats.latency_max_us, EXCLUDED.latency_max_us)
+	`
+	batch := &pgx.Batch{}
+	for _, s := range stats {
+		batch.Queue(query,
+			s.TunnelID,
+			s.Start.UTC(),
+			s.Requests,
+			s.Connections,
+			s.BytesIn,
+			s.BytesOut,
+			s.Status1xx,
+			s.Status2xx,
+			s.Status3xx,
+			s.Status4xx,
+			s.Status5xx,
+			s.LatencyTotal.Microseconds(),
+			s.LatencyMax.Microseconds(),
+		)
+	}
+	if err := r.db.SendBatch(ctx, batch).Close(); err != nil {
+		return fmt.Errorf("could not save tunnel stats: %w", err)
+	}
+	return nil
+}
+
+func (r *PostgresTunnelStatsRepository) Range(ctx context.Context, tunnelID domain.TunnelID, from, to time.Time) ([]*domain.TunnelStats, error) {
+	query := `
+		SELECT ` + tunnelStatsColumns + ` FROM tunnel_stats
+		WHERE tunnel_id = $1 AND bucket_start >= $2 AND bucket_start < $3
+		ORDER BY bucket_start
+	`
+	rows, err := r.db.Query(ctx, query, tunnelID, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("could not list tunnel stats: %w", err)
+	}
+	defer rows.Close()
+
+	var stats []*domain.TunnelStats
+	for rows.Next() {
+		var s domain.TunnelStats
+		var latencyTotal, latencyMax int64
+		err := rows.Scan(
+			&s.TunnelID,
+			&s.Start,
+			&s.Requests,
+			&s.Connections,
+			&s.BytesIn,
+			&s.BytesOut,
+			&s.Status1xx,
+			&s.Status2xx,
+			&s.Status3xx,
+			&s.Status4xx,
+			&s.Status5xx,
+			&latencyTotal,
+			&latencyMax,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("could not scan tunnel stats: %w", err)
+		}
+		s.Start = s.Start.UTC()
+		s.LatencyTotal = time.Duration(latencyTotal) * time.Microsecond
+		s.LatencyMax = time.Duration(latencyMax) * time.Microsecond
+		stats = append(stats, &s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not list tunnel stats: %w", err)
+	}
+	return stats, nil
+}
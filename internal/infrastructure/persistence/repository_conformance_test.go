@@ -0,0 +1,964 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/waste3d/ghost-tunnel/internal/domain"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Общие проверки для всех реализаций репозиториев: каждая реализация
+// вызывает testTunnelRepository, testUserRepository и остальные из своего теста.
+// Туннелям с владельцем нужен пользователь в той же базе, поэтому
+// testTunnelRepository получает оба репозитория.
+// Имена и порты генерируются уникальными, поэтому набор можно гонять
+// на общей базе без очистки таблиц.
+
+func newTestTunnel(protocol domain.TunnelProtocol, port int) *domain.Tunnel {
+	id := uuid.New().String()
+	return &domain.Tunnel{
+		ID:       domain.TunnelID(id),
+		Protocol: protocol,
+		Endpoints: domain.Endpoint{
+			Subdomain: "t-" + id[:8],
+			Domain:    "example.test",
+			Port:      port,
+		},
+		LocalTarget:   domain.LocalTarget{Host: "localhost", Port: 3000},
+		HTTPSRedirect: true,
+		Reserved:      true,
+		Status:        domain.StatusActive,
+		// Postgres хранит время с точностью до микросекунд
+		CreatedAt: time.Now().Truncate(time.Microsecond),
+	}
+}
+
+// uniquePort выбирает порт, которого заведомо нет среди уже выделенных.
+func uniquePort(t *testing.T, repo domain.TunnelRepository) int {
+	t.Helper()
+	ports, err := repo.ListPublicPorts(context.Background())
+	if err != nil {
+		t.Fatalf("ListPublicPorts: %v", err)
+	}
+	taken := make(map[int]bool, len(ports))
+	for _, p := range ports {
+		taken[p] = true
+	}
+	for port := 40000; port < 65536; port++ {
+		if !taken[port] {
+			return port
+		}
+	}
+	t.Fatal("no free test ports")
+	return 0
+}
+
+func assertTunnelEqual(t *testing.T, got, want *domain.Tunnel) {
+	t.Helper()
+	if got == nil {
+		t.Fatalf("tunnel %s not found", want.ID)
+	}
+	if !got.CreatedAt.Equal(want.CreatedAt) {
+		t.Errorf("CreatedAt = %v, want %v", got.CreatedAt, want.CreatedAt)
+	}
+	if !sameTime(got.LastConnectedAt, want.LastConnectedAt) {
+		t.Errorf("LastConnectedAt = %v, want %v", got.LastConnectedAt, want.LastConnectedAt)
+	}
+	if !sameTime(got.LastDisconnectedAt, want.LastDisconnectedAt) {
+		t.Errorf("LastDisconnectedAt = %v, want %v", got.LastDisconnectedAt, want.LastDisconnectedAt)
+	}
+	g, w := *got, *want
+	g.CreatedAt, w.CreatedAt = time.Time{}, time.Time{}
+	g.LastConnectedAt, w.LastConnectedAt = nil, nil
+	g.LastDisconnectedAt, w.LastDisconnectedAt = nil, nil
+	if g != w {
+		t.Errorf("tunnel = %+v, want %+v", g, w)
+	}
+}
+
+func sameTime(a, b *time.Time) bool {
+	return (a == nil) == (b == nil) && (a == nil || a.Equal(*b))
+}
+
+func testTunnelRepository(t *testing.T, repo domain.TunnelRepository, users domain.UserRepository) {
+	ctx := context.Background()
+
+	t.Run("SaveAndFind", func(t *testing.T) {
+		tunnel := newTestTunnel(domain.ProtocolHTTP, 80)
+		if err := repo.Save(ctx, tunnel); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+
+		byID, err := repo.FindByID(ctx, tunnel.ID)
+		if err != nil {
+			t.Fatalf("FindByID: %v", err)
+		}
+		assertTunnelEqual(t, byID, tunnel)
+
+		bySubdomain, err := repo.FindBySubdomain(ctx, tunnel.Endpoints.Subdomain)
+		if err != nil {
+			t.Fatalf("FindBySubdomain: %v", err)
+		}
+		assertTunnelEqual(t, bySubdomain, tunnel)
+	})
+
+	t.Run("ReturnedTunnelIsACopy", func(t *testing.T) {
+		tunnel := newTestTunnel(domain.ProtocolHTTP, 80)
+		if err := repo.Save(ctx, tunnel); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+		tunnel.Status = domain.StatusInactive
+
+		found, err := repo.FindByID(ctx, tunnel.ID)
+		if err != nil {
+			t.Fatalf("FindByID: %v", err)
+		}
+		found.LocalTarget.Port = 1
+		again, err := repo.FindByID(ctx, tunnel.ID)
+		if err != nil {
+			t.Fatalf("FindByID: %v", err)
+		}
+		if again.Status != domain.StatusActive || again.LocalTarget.Port != 3000 {
+			t.Errorf("stored tunnel changed through a returned pointer: %+v", again)
+		}
+	})
+
+	t.Run("NotFoundIsNil", func(t *testing.T) {
+		byID, err := repo.FindByID(ctx, domain.TunnelID(uuid.New().String()))
+		if err != nil || byID != nil {
+			t.Errorf("FindByID(missing) = %v, %v; want nil, nil", byID, err)
+		}
+		bySubdomain, err := repo.FindBySubdomain(ctx, "missing-"+uuid.New().String()[:8])
+		if err != nil || bySubdomain != nil {
+			t.Errorf("FindBySubdomain(missing) = %v, %v; want nil, nil", bySubdomain, err)
+		}
+	})
+
+	t.Run("SubdomainIsUnique", func(t *testing.T) {
+		first := newTestTunnel(domain.ProtocolHTTP, 80)
+		if err := repo.Save(ctx, first); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+		second := newTestTunnel(domain.ProtocolHTTP, 80)
+		second.Endpoints.Subdomain = first.Endpoints.Subdomain
+		if err := repo.Save(ctx, second); !errors.Is(err, domain.ErrSubdomainTaken) {
+			t.Fatalf("Save(duplicate subdomain) = %v, want ErrSubdomainTaken", err)
+		}
+		found, err := repo.FindByID(ctx, second.ID)
+		if err != nil || found != nil {
+			t.Errorf("rejected tunnel was stored: %v, %v", found, err)
+		}
+	})
+
+	t.Run("TCPPorts", func(t *testing.T) {
+		port := uniquePort(t, repo)
+		tunnel := newTestTunnel(domain.ProtocolTCP, port)
+		if err := repo.Save(ctx, tunnel); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+		found, err := repo.FindByID(ctx, tunnel.ID)
+		if err != nil {
+			t.Fatalf("FindByID: %v", err)
+		}
+		assertTunnelEqual(t, found, tunnel)
+
+		ports, err := repo.ListPublicPorts(ctx)
+		if err != nil {
+			t.Fatalf("ListPublicPorts: %v", err)
+		}
+		if !containsPort(ports, port) {
+			t.Errorf("ListPublicPorts() = %v, missing %d", ports, port)
+		}
+
+		clash := newTestTunnel(domain.ProtocolTCP, port)
+		if err := repo.Save(ctx, clash); !errors.Is(err, domain.ErrPortTaken) {
+			t.Errorf("Save(duplicate port) = %v, want ErrPortTaken", err)
+		}
+	})
+
+	t.Run("HTTPTunnelsHaveNoPublicPort", func(t *testing.T) {
+		before, err := repo.ListPublicPorts(ctx)
+		if err != nil {
+			t.Fatalf("ListPublicPorts: %v", err)
+		}
+		tunnel := newTestTunnel(domain.ProtocolHTTP, uniquePort(t, repo))
+		if err := repo.Save(ctx, tunnel); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+		after, err := repo.ListPublicPorts(ctx)
+		if err != nil {
+			t.Fatalf("ListPublicPorts: %v", err)
+		}
+		if len(after) != len(before) {
+			t.Errorf("HTTP tunnel allocated a public port: %v -> %v", before, after)
+		}
+		found, err := repo.FindByID(ctx, tunnel.ID)
+		if err != nil {
+			t.Fatalf("FindByID: %v", err)
+		}
+		if found.Endpoints.Port != 80 {
+			t.Errorf("HTTP tunnel port = %d, want 80", found.Endpoints.Port)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		port := uniquePort(t, repo)
+		tunnel := newTestTunnel(domain.ProtocolTCP, port)
+		if err := repo.Save(ctx, tunnel); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+		if err := repo.Delete(ctx, tunnel.Endpoints.Subdomain); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		found, err := repo.FindByID(ctx, tunnel.ID)
+		if err != nil || found != nil {
+			t.Errorf("FindByID(deleted) = %v, %v; want nil, nil", found, err)
+		}
+		ports, err := repo.ListPublicPorts(ctx)
+		if err != nil {
+			t.Fatalf("ListPublicPorts: %v", err)
+		}
+		if containsPort(ports, port) {
+			t.Errorf("port %d still allocated after Delete", port)
+		}
+
+		// после удаления поддомен и порт снова свободны
+		reused := newTestTunnel(domain.ProtocolTCP, port)
+		reused.Endpoints.Subdomain = tunnel.Endpoints.Subdomain
+		if err := repo.Save(ctx, reused); err != nil {
+			t.Errorf("Save(reused subdomain and port): %v", err)
+		}
+
+		if err := repo.Delete(ctx, "missing-"+uuid.New().String()[:8]); err != nil {
+			t.Errorf("Delete(missing) = %v, want nil", err)
+		}
+	})
+
+	t.Run("ConcurrentSaveOfSameSubdomain", func(t *testing.T) {
+		subdomain := "race-" + uuid.New().String()[:8]
+		const writers = 8
+		errs := make([]error, writers)
+		var wg sync.WaitGroup
+		for i := range writers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				tunnel := newTestTunnel(domain.ProtocolHTTP, 80)
+				tunnel.Endpoints.Subdomain = subdomain
+				errs[i] = repo.Save(ctx, tunnel)
+			}()
+		}
+		wg.Wait()
+
+		saved := 0
+		for _, err := range errs {
+			switch {
+			case err == nil:
+				saved++
+			case !errors.Is(err, domain.ErrSubdomainTaken):
+				t.Errorf("Save: %v", err)
+			}
+		}
+		if saved != 1 {
+			t.Errorf("%d concurrent saves of one subdomain succeeded, want 1", saved)
+		}
+	})
+
+	t.Run("ListByUser", func(t *testing.T) {
+		owner, other := newTestUser(t), newTestUser(t)
+		for _, u := range []*domain.User{owner, other} {
+			if err := users.Save(ctx, u); err != nil {
+				t.Fatalf("Save user: %v", err)
+			}
+		}
+
+		// три туннеля владельца с разным временем создания и один чужой
+		base := time.Now().Truncate(time.Microsecond)
+		var owned []*domain.Tunnel
+		for i := range 3 {
+			tunnel := newTestTunnel(domain.ProtocolHTTP, 80)
+			tunnel.UserID = owner.ID
+			tunnel.CreatedAt = base.Add(time.Duration(i) * time.Second)
+			if i == 1 {
+				tunnel.Status = domain.StatusInactive
+			}
+			if err := repo.Save(ctx, tunnel); err != nil {
+				t.Fatalf("Save: %v", err)
+			}
+			owned = append(owned, tunnel)
+		}
+		foreign := newTestTunnel(domain.ProtocolHTTP, 80)
+		foreign.UserID = other.ID
+		if err := repo.Save(ctx, foreign); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+
+		all, total, err := repo.ListByUser(ctx, owner.ID, domain.TunnelFilter{})
+		if err != nil {
+			t.Fatalf("ListByUser: %v", err)
+		}
+		if total != 3 || len(all) != 3 {
+			t.Fatalf("ListByUser() = %d tunnels, total %d; want 3, 3", len(all), total)
+		}
+		for i, want := range []*domain.Tunnel{owned[2], owned[1], owned[0]} {
+			assertTunnelEqual(t, all[i], want)
+		}
+
+		page, total, err := repo.ListByUser(ctx, owner.ID, domain.TunnelFilter{Limit: 1, Offset: 1})
+		if err != nil {
+			t.Fatalf("ListByUser(page): %v", err)
+		}
+		if total != 3 || len(page) != 1 || page[0].ID != owned[1].ID {
+			t.Errorf("ListByUser(limit 1, offset 1) = %v, total %d; want [%s], 3", page, total, owned[1].ID)
+		}
+
+		active, total, err := repo.ListByUser(ctx, owner.ID, domain.TunnelFilter{Status: domain.StatusActive})
+		if err != nil {
+			t.Fatalf("ListByUser(active): %v", err)
+		}
+		if total != 2 || len(active) != 2 {
+			t.Errorf("ListByUser(active) = %d tunnels, total %d; want 2, 2", len(active), total)
+		}
+
+		beyond, total, err := repo.ListByUser(ctx, owner.ID, domain.TunnelFilter{Limit: 10, Offset: 10})
+		if err != nil {
+			t.Fatalf("ListByUser(beyond): %v", err)
+		}
+		if total != 3 || len(beyond) != 0 {
+			t.Errorf("ListByUser(offset 10) = %d tunnels, total %d; want 0, 3", len(beyond), total)
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		tunnel := newTestTunnel(domain.ProtocolHTTP, 80)
+		if err := repo.Save(ctx, tunnel); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+		oldSubdomain := tunnel.Endpoints.Subdomain
+
+		tunnel.Endpoints.Subdomain = "u-" + uuid.New().String()[:8]
+		tunnel.LocalTarget = domain.LocalTarget{Host: "127.0.0.2", Port: 8080}
+		tunnel.HTTPSRedirect = false
+		tunnel.Reserved = false
+		if err := repo.Update(ctx, tunnel); err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+
+		found, err := repo.FindBySubdomain(ctx, tunnel.Endpoints.Subdomain)
+		if err != nil {
+			t.Fatalf("FindBySubdomain: %v", err)
+		}
+		assertTunnelEqual(t, found, tunnel)
+		old, err := repo.FindBySubdomain(ctx, oldSubdomain)
+		if err != nil || old != nil {
+			t.Errorf("FindBySubdomain(old) = %v, %v; want nil, nil", old, err)
+		}
+
+		other := newTestTunnel(domain.ProtocolHTTP, 80)
+		if err := repo.Save(ctx, other); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+		other.Endpoints.Subdomain = tunnel.Endpoints.Subdomain
+		if err := repo.Update(ctx, other); !errors.Is(err, domain.ErrSubdomainTaken) {
+			t.Errorf("Update(taken subdomain) = %v, want ErrSubdomainTaken", err)
+		}
+
+		missing := newTestTunnel(domain.ProtocolHTTP, 80)
+		if err := repo.Update(ctx, missing); !errors.Is(err, domain.ErrTunnelNotFound) {
+			t.Errorf("Update(missing) = %v, want ErrTunnelNotFound", err)
+		}
+	})
+
+	t.Run("UpdateDoesNotTouchStatus", func(t *testing.T) {
+		tunnel := newTestTunnel(domain.ProtocolHTTP, 80)
+		if err := repo.Save(ctx, tunnel); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+		// статусом владеет UpdateStatus, PATCH из API его не перезаписывает
+		stale := *tunnel
+		stale.Status = domain.StatusInactive
+		if err := repo.Update(ctx, &stale); err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+		found, err := repo.FindByID(ctx, tunnel.ID)
+		if err != nil {
+			t.Fatalf("FindByID: %v", err)
+		}
+		assertTunnelEqual(t, found, tunnel)
+	})
+
+	t.Run("UpdateStatus", func(t *testing.T) {
+		tunnel := newTestTunnel(domain.ProtocolHTTP, 80)
+		tunnel.Status = domain.StatusInactive
+		if err := repo.Save(ctx, tunnel); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+
+		tunnel.Activate(domain.AgentInfo{Version: "1.2.3", RemoteAddr: "203.0.113.7:52000"}, time.Now().Truncate(time.Microsecond))
+		// остальные поля UpdateStatus не сохраняет
+		changed := *tunnel
+		changed.LocalTarget.Port = 1
+		if err := repo.UpdateStatus(ctx, &changed); err != nil {
+			t.Fatalf("UpdateStatus: %v", err)
+		}
+		found, err := repo.FindByID(ctx, tunnel.ID)
+		if err != nil {
+			t.Fatalf("FindByID: %v", err)
+		}
+		assertTunnelEqual(t, found, tunnel)
+
+		tunnel.Deactivate(time.Now().Truncate(time.Microsecond))
+		if err := repo.UpdateStatus(ctx, tunnel); err != nil {
+			t.Fatalf("UpdateStatus: %v", err)
+		}
+		found, err = repo.FindByID(ctx, tunnel.ID)
+		if err != nil {
+			t.Fatalf("FindByID: %v", err)
+		}
+		assertTunnelEqual(t, found, tunnel)
+
+		missing := newTestTunnel(domain.ProtocolHTTP, 80)
+		if err := repo.UpdateStatus(ctx, missing); !errors.Is(err, domain.ErrTunnelNotFound) {
+			t.Errorf("UpdateStatus(missing) = %v, want ErrTunnelNotFound", err)
+		}
+	})
+
+	t.Run("ResetStatuses", func(t *testing.T) {
+		active := newTestTunnel(domain.ProtocolHTTP, 80)
+		active.Activate(domain.AgentInfo{Version: "1.2.3"}, time.Now().Truncate(time.Microsecond))
+		if err := repo.Save(ctx, active); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+
+		at := time.Now().Truncate(time.Microsecond)
+		n, err := repo.ResetStatuses(ctx, at)
+		if err != nil {
+			t.Fatalf("ResetStatuses: %v", err)
+		}
+		if n < 1 {
+			t.Errorf("ResetStatuses() = %d, want at least 1", n)
+		}
+		found, err := repo.FindByID(ctx, active.ID)
+		if err != nil {
+			t.Fatalf("FindByID: %v", err)
+		}
+		// сведения о последнем подключении остаются
+		active.Deactivate(at)
+		assertTunnelEqual(t, found, active)
+
+		if n, err := repo.ResetStatuses(ctx, at); err != nil || n != 0 {
+			t.Errorf("second ResetStatuses() = %d, %v; want 0, nil", n, err)
+		}
+	})
+
+	t.Run("ListAbandoned", func(t *testing.T) {
+		now := time.Now().Truncate(time.Microsecond)
+		save := func(reserved bool, createdAgo time.Duration, disconnectedAgo time.Duration) *domain.Tunnel {
+			t.Helper()
+			tunnel := newTestTunnel(domain.ProtocolHTTP, 80)
+			tunnel.Reserved = reserved
+			tunnel.CreatedAt = now.Add(-createdAgo)
+			if disconnectedAgo > 0 {
+				tunnel.Activate(domain.AgentInfo{Version: "1.2.3"}, tunnel.CreatedAt)
+				tunnel.Deactivate(now.Add(-disconnectedAgo))
+			} else {
+				tunnel.Status = domain.StatusInactive
+			}
+			if err := repo.Save(ctx, tunnel); err != nil {
+				t.Fatalf("Save: %v", err)
+			}
+			return tunnel
+		}
+		neverConnected := save(false, 3*time.Hour, 0)
+		disconnected := save(false, 4*time.Hour, 2*time.Hour)
+		reserved := save(true, 4*time.Hour, 2*time.Hour)
+		recent := save(false, 4*time.Hour, time.Minute)
+		online := newTestTunnel(domain.ProtocolHTTP, 80)
+		online.Reserved = false
+		online.CreatedAt = now.Add(-4 * time.Hour)
+		online.Activate(domain.AgentInfo{}, online.CreatedAt)
+		if err := repo.Save(ctx, online); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+
+		tunnels, err := repo.ListAbandoned(ctx, now.Add(-time.Hour))
+		if err != nil {
+			t.Fatalf("ListAbandoned: %v", err)
+		}
+		pos := make(map[domain.TunnelID]int, len(tunnels))
+		for i, tunnel := range tunnels {
+			pos[tunnel.ID] = i
+		}
+		for _, tunnel := range []*domain.Tunnel{reserved, recent, online} {
+			if _, ok := pos[tunnel.ID]; ok {
+				t.Errorf("ListAbandoned() contains %s", tunnel.Endpoints.Subdomain)
+			}
+		}
+		i, ok1 := pos[neverConnected.ID]
+		j, ok2 := pos[disconnected.ID]
+		if !ok1 || !ok2 {
+			t.Fatalf("ListAbandoned() = %d tunnels, want abandoned ones included", len(tunnels))
+		}
+		// простаивающие дольше идут первыми
+		if i > j {
+			t.Errorf("ListAbandoned() order: never connected at %d, disconnected at %d", i, j)
+		}
+		assertTunnelEqual(t, tunnels[j], disconnected)
+
+		cutoff := now.Add(-time.Hour)
+		for _, tunnel := range []*domain.Tunnel{reserved, recent, online} {
+			deleted, err := repo.DeleteAbandoned(ctx, tunnel.ID, cutoff)
+			if err != nil {
+				t.Fatalf("DeleteAbandoned: %v", err)
+			}
+			if deleted {
+				t.Errorf("DeleteAbandoned() deleted %s", tunnel.Endpoints.Subdomain)
+			}
+		}
+		deleted, err := repo.DeleteAbandoned(ctx, disconnected.ID, cutoff)
+		if err != nil || !deleted {
+			t.Fatalf("DeleteAbandoned() = %v, %v; want the abandoned tunnel deleted", deleted, err)
+		}
+		if found, err := repo.FindByID(ctx, disconnected.ID); err != nil || found != nil {
+			t.Errorf("FindByID after DeleteAbandoned = %v, %v; want nil", found, err)
+		}
+		// повторное удаление ничего не находит
+		if deleted, err := repo.DeleteAbandoned(ctx, disconnected.ID, cutoff); err != nil || deleted {
+			t.Errorf("second DeleteAbandoned() = %v, %v; want false", deleted, err)
+		}
+	})
+}
+
+func containsPort(ports []int, port int) bool {
+	sort.Ints(ports)
+	i := sort.SearchInts(ports, port)
+	return i < len(ports) && ports[i] == port
+}
+
+func newTestUser(t *testing.T) *domain.User {
+	t.Helper()
+	user, err := domain.NewUser(uuid.New().String()+"@example.test", "secret")
+	if err != nil {
+		t.Fatalf("NewUser: %v", err)
+	}
+	user.CreatedAt = user.CreatedAt.Truncate(time.Microsecond)
+	return user
+}
+
+func assertUserEqual(t *testing.T, got, want *domain.User) {
+	t.Helper()
+	if got == nil {
+		t.Fatalf("user %s not found", want.Email)
+	}
+	if !got.CreatedAt.Equal(want.CreatedAt) {
+		t.Errorf("CreatedAt = %v, want %v", got.CreatedAt, want.CreatedAt)
+	}
+	g, w := *got, *want
+	g.CreatedAt, w.CreatedAt = time.Time{}, time.Time{}
+	if g != w {
+		t.Errorf("user = %+v, want %+v", g, w)
+	}
+}
+
+func testUserRepository(t *testing.T, repo domain.UserRepository) {
+	ctx := context.Background()
+
+	t.Run("SaveAndFind", func(t *testing.T) {
+		user := newTestUser(t)
+		if err := repo.Save(ctx, user); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+
+		byEmail, err := repo.FindByEmail(ctx, user.Email)
+		if err != nil {
+			t.Fatalf("FindByEmail: %v", err)
+		}
+		assertUserEqual(t, byEmail, user)
+
+		byKey, err := repo.FindByAPIKey(ctx, string(user.APIKey))
+		if err != nil {
+			t.Fatalf("FindByAPIKey: %v", err)
+		}
+		assertUserEqual(t, byKey, user)
+	})
+
+	t.Run("NotFoundIsNil", func(t *testing.T) {
+		byEmail, err := repo.FindByEmail(ctx, uuid.New().String()+"@example.test")
+		if err != nil || byEmail != nil {
+			t.Errorf("FindByEmail(missing) = %v, %v; want nil, nil", byEmail, err)
+		}
+		byKey, err := repo.FindByAPIKey(ctx, uuid.New().String())
+		if err != nil || byKey != nil {
+			t.Errorf("FindByAPIKey(missing) = %v, %v; want nil, nil", byKey, err)
+		}
+	})
+
+	t.Run("EmailIsUnique", func(t *testing.T) {
+		first := newTestUser(t)
+		if err := repo.Save(ctx, first); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+		second := newTestUser(t)
+		second.Email = first.Email
+		if err := repo.Save(ctx, second); !errors.Is(err, domain.ErrUserAlreadyExists) {
+			t.Errorf("Save(duplicate email) = %v, want ErrUserAlreadyExists", err)
+		}
+		found, err := repo.FindByAPIKey(ctx, string(second.APIKey))
+		if err != nil || found != nil {
+			t.Errorf("rejected user was stored: %v, %v", found, err)
+		}
+	})
+
+	t.Run("APIKeyIsUnique", func(t *testing.T) {
+		first := newTestUser(t)
+		if err := repo.Save(ctx, first); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+		second := newTestUser(t)
+		second.APIKey = first.APIKey
+		if err := repo.Save(ctx, second); !errors.Is(err, domain.ErrUserAlreadyExists) {
+			t.Errorf("Save(duplicate api key) = %v, want ErrUserAlreadyExists", err)
+		}
+	})
+}
+
+func testTunnelStatsRepository(t *testing.T, repo domain.TunnelStatsRepository, tunnels domain.TunnelRepository) {
+	ctx := context.Background()
+	start := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+
+	saveTunnel := func(t *testing.T) *domain.Tunnel {
+		t.Helper()
+		tunnel := newTestTunnel(domain.ProtocolHTTP, 0)
+		if err := tunnels.Save(ctx, tunnel); err != nil {
+			t.Fatalf("Save tunnel: %v", err)
+		}
+		return tunnel
+	}
+
+	t.Run("AddAccumulates", func(t *testing.T) {
+		tunnel := saveTunnel(t)
+		first := &domain.TunnelStats{TunnelID: tunnel.ID, Start: start, Connections: 1, BytesIn: 100, BytesOut: 1000}
+		first.AddRequest(200, 30*time.Millisecond)
+		second := &domain.TunnelStats{TunnelID: tunnel.ID, Start: start, BytesIn: 10}
+		second.AddRequest(502, 10*time.Millisecond)
+		second.AddRequest(404, time.Millisecond)
+
+		if err := repo.Add(ctx, []*domain.TunnelStats{first}); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+		if err := repo.Add(ctx, []*domain.TunnelStats{second}); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+
+		got, err := repo.Range(ctx, tunnel.ID, start, start.Add(domain.StatsBucketSize))
+		if err != nil {
+			t.Fatalf("Range: %v", err)
+		}
+		want := *first
+		want.Merge(second)
+		if len(got) != 1 || *got[0] != want {
+			t.Fatalf("Range = %+v, want [%+v]", got, want)
+		}
+	})
+
+	t.Run("RangeIsHalfOpenAndOrdered", func(t *testing.T) {
+		tunnel := saveTunnel(t)
+		other := saveTunnel(t)
+		var stats []*domain.TunnelStats
+		for i := 3; i >= 0; i-- {
+			stats = append(stats, &domain.TunnelStats{TunnelID: tunnel.ID, Start: start.Add(time.Duration(i) * domain.StatsBucketSize), Connections: int64(i + 1)})
+		}
+		stats = append(stats, &domain.TunnelStats{TunnelID: other.ID, Start: start.Add(domain.StatsBucketSize), Connections: 7})
+		if err := repo.Add(ctx, stats); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+
+		got, err := repo.Range(ctx, tunnel.ID, start.Add(domain.StatsBucketSize), start.Add(3*domain.StatsBucketSize))
+		if err != nil {
+			t.Fatalf("Range: %v", err)
+		}
+		if len(got) != 2 {
+			t.Fatalf("Range returned %d buckets, want 2", len(got))
+		}
+		for i, s := range got {
+			wantStart := start.Add(time.Duration(i+1) * domain.StatsBucketSize)
+			if !s.Start.Equal(wantStart) || s.Connections != int64(i+2) || s.TunnelID != tunnel.ID {
+				t.Errorf("bucket %d = %+v, want start %v with %d connections", i, s, wantStart, i+2)
+			}
+		}
+	})
+
+	t.Run("UnknownTunnelIsSkipped", func(t *testing.T) {
+		tunnel := saveTunnel(t)
+		missing := domain.TunnelID(uuid.New().String())
+		err := repo.Add(ctx, []*domain.TunnelStats{
+			{TunnelID: missing, Start: start, Connections: 1},
+			{TunnelID: tunnel.ID, Start: start, Connections: 2},
+		})
+		if err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+		if got, err := repo.Range(ctx, missing, start, start.Add(time.Hour)); err != nil || len(got) != 0 {
+			t.Errorf("Range(missing) = %v, %v; want empty", got, err)
+		}
+		if got, err := repo.Range(ctx, tunnel.ID, start, start.Add(time.Hour)); err != nil || len(got) != 1 {
+			t.Errorf("Range(existing) = %v, %v; want one bucket", got, err)
+		}
+	})
+}
+
+func newTestDomain(t *testing.T, tunnelID domain.TunnelID) *domain.CustomDomain {
+	t.Helper()
+	d, err := domain.NewCustomDomain("d-"+uuid.New().String()[:8]+".example.org", tunnelID, "token")
+	if err != nil {
+		t.Fatalf("NewCustomDomain: %v", err)
+	}
+	d.CreatedAt = d.CreatedAt.Truncate(time.Microsecond)
+	return d
+}
+
+func assertDomainEqual(t *testing.T, got, want *domain.CustomDomain) {
+	t.Helper()
+	if got == nil {
+		t.Fatalf("domain %s not found", want.Hostname)
+	}
+	if !got.CreatedAt.Equal(want.CreatedAt) {
+		t.Errorf("CreatedAt = %v, want %v", got.CreatedAt, want.CreatedAt)
+	}
+	if !sameTime(got.VerifiedAt, want.VerifiedAt) {
+		t.Errorf("VerifiedAt = %v, want %v", got.VerifiedAt, want.VerifiedAt)
+	}
+	if !sameTime(got.CertExpiresAt, want.CertExpiresAt) {
+		t.Errorf("CertExpiresAt = %v, want %v", got.CertExpiresAt, want.CertExpiresAt)
+	}
+	g, w := *got, *want
+	g.CreatedAt, w.CreatedAt = time.Time{}, time.Time{}
+	g.VerifiedAt, w.VerifiedAt = nil, nil
+	g.CertExpiresAt, w.CertExpiresAt = nil, nil
+	if g != w {
+		t.Errorf("domain = %+v, want %+v", g, w)
+	}
+}
+
+func testCustomDomainRepository(t *testing.T, repo domain.CustomDomainRepository, tunnels domain.TunnelRepository) {
+	ctx := context.Background()
+	tunnel := newTestTunnel(domain.ProtocolHTTP, 80)
+	if err := tunnels.Save(ctx, tunnel); err != nil {
+		t.Fatalf("Save tunnel: %v", err)
+	}
+
+	t.Run("SaveAndFind", func(t *testing.T) {
+		d := newTestDomain(t, tunnel.ID)
+		if err := repo.Save(ctx, d); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+		found, err := repo.FindClaim(ctx, d.Hostname, tunnel.ID)
+		if err != nil {
+			t.Fatalf("FindClaim: %v", err)
+		}
+		assertDomainEqual(t, found, d)
+
+		if err := repo.Save(ctx, newTestDomainNamed(t, d.Hostname, tunnel.ID)); !errors.Is(err, domain.ErrDomainTaken) {
+			t.Errorf("Save duplicate = %v, want ErrDomainTaken", err)
+		}
+		if found, err := repo.FindVerified(ctx, d.Hostname); err != nil || found != nil {
+			t.Errorf("FindVerified(unverified) = %v, %v; want nil, nil", found, err)
+		}
+		if found, err := repo.FindClaim(ctx, "missing-"+d.Hostname, tunnel.ID); err != nil || found != nil {
+			t.Errorf("FindClaim(missing) = %v, %v; want nil, nil", found, err)
+		}
+	})
+
+	t.Run("Claims", func(t *testing.T) {
+		squatter := newTestTunnel(domain.ProtocolHTTP, 80)
+		owner := newTestTunnel(domain.ProtocolHTTP, 80)
+		for _, tn := range []*domain.Tunnel{squatter, owner} {
+			if err := tunnels.Save(ctx, tn); err != nil {
+				t.Fatalf("Save tunnel: %v", err)
+			}
+		}
+		squatted := newTestDomain(t, squatter.ID)
+		if err := repo.Save(ctx, squatted); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+		// неподтверждённая заявка не мешает другому туннелю
+		claim := newTestDomainNamed(t, squatted.Hostname, owner.ID)
+		claim.CreatedAt = claim.CreatedAt.Truncate(time.Microsecond)
+		if err := repo.Save(ctx, claim); err != nil {
+			t.Fatalf("Save of a second claim: %v", err)
+		}
+
+		claim.MarkVerified(time.Now().Truncate(time.Microsecond))
+		if err := repo.UpdateVerification(ctx, claim); err != nil {
+			t.Fatalf("UpdateVerification: %v", err)
+		}
+		found, err := repo.FindVerified(ctx, claim.Hostname)
+		if err != nil {
+			t.Fatalf("FindVerified: %v", err)
+		}
+		assertDomainEqual(t, found, claim)
+
+		// подтверждение вытесняет остальные заявки, новые не принимаются
+		if found, err := repo.FindClaim(ctx, squatted.Hostname, squatter.ID); err != nil || found != nil {
+			t.Errorf("squatter's claim after verification = %v, %v; want nil", found, err)
+		}
+		if err := repo.Save(ctx, newTestDomainNamed(t, claim.Hostname, squatter.ID)); !errors.Is(err, domain.ErrDomainTaken) {
+			t.Errorf("Save of a verified hostname = %v, want ErrDomainTaken", err)
+		}
+		squatted.MarkVerified(time.Now())
+		if err := repo.UpdateVerification(ctx, squatted); !errors.Is(err, domain.ErrDomainNotFound) {
+			t.Errorf("UpdateVerification of a displaced claim = %v, want ErrDomainNotFound", err)
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		other := newTestTunnel(domain.ProtocolHTTP, 80)
+		if err := tunnels.Save(ctx, other); err != nil {
+			t.Fatalf("Save tunnel: %v", err)
+		}
+		first := newTestDomain(t, other.ID)
+		second := newTestDomain(t, other.ID)
+		second.CreatedAt = first.CreatedAt.Add(time.Second)
+		for _, d := range []*domain.CustomDomain{second, first} {
+			if err := repo.Save(ctx, d); err != nil {
+				t.Fatalf("Save: %v", err)
+			}
+		}
+
+		byTunnel, err := repo.ListByTunnel(ctx, other.ID)
+		if err != nil {
+			t.Fatalf("ListByTunnel: %v", err)
+		}
+		if len(byTunnel) != 2 || byTunnel[0].Hostname != first.Hostname || byTunnel[1].Hostname != second.Hostname {
+			t.Fatalf("ListByTunnel() = %v, want both domains oldest first", byTunnel)
+		}
+		assertDomainEqual(t, byTunnel[0], first)
+
+		all, err := repo.List(ctx)
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		found := 0
+		for _, d := range all {
+			if d.TunnelID == other.ID {
+				found++
+			}
+		}
+		if found != 2 {
+			t.Errorf("List() contains %d domains of the tunnel, want 2", found)
+		}
+	})
+
+	t.Run("UpdateVerificationAndCertificate", func(t *testing.T) {
+		d := newTestDomain(t, tunnel.ID)
+		if err := repo.Save(ctx, d); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+
+		d.MarkVerified(time.Now().Truncate(time.Microsecond))
+		if err := repo.UpdateVerification(ctx, d); err != nil {
+			t.Fatalf("UpdateVerification: %v", err)
+		}
+		d.CertificateIssued(time.Now().Add(90 * 24 * time.Hour).Truncate(time.Second))
+		if err := repo.UpdateCertificate(ctx, d); err != nil {
+			t.Fatalf("UpdateCertificate: %v", err)
+		}
+		found, _ := repo.FindVerified(ctx, d.Hostname)
+		assertDomainEqual(t, found, d)
+
+		// ошибка продления сохраняет дату истечения прежнего сертификата
+		d.CertificateFailed(errors.New("rate limited"))
+		if err := repo.UpdateCertificate(ctx, d); err != nil {
+			t.Fatalf("UpdateCertificate: %v", err)
+		}
+		found, _ = repo.FindVerified(ctx, d.Hostname)
+		assertDomainEqual(t, found, d)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		d := newTestDomain(t, tunnel.ID)
+		if err := repo.Save(ctx, d); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+		if err := repo.Delete(ctx, d.Hostname, tunnel.ID); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		if found, err := repo.FindClaim(ctx, d.Hostname, tunnel.ID); err != nil || found != nil {
+			t.Errorf("FindClaim after Delete = %v, %v; want nil", found, err)
+		}
+	})
+
+	t.Run("DeleteUnverified", func(t *testing.T) {
+		cutoff := time.Now().Add(-domain.UnverifiedDomainTTL)
+		expired := newTestDomain(t, tunnel.ID)
+		expired.CreatedAt = cutoff.Add(-time.Hour).Truncate(time.Microsecond)
+		fresh := newTestDomain(t, tunnel.ID)
+		verified := newTestDomain(t, tunnel.ID)
+		verified.CreatedAt = expired.CreatedAt
+		for _, d := range []*domain.CustomDomain{expired, fresh, verified} {
+			if err := repo.Save(ctx, d); err != nil {
+				t.Fatalf("Save: %v", err)
+			}
+		}
+		verified.MarkVerified(time.Now().Truncate(time.Microsecond))
+		if err := repo.UpdateVerification(ctx, verified); err != nil {
+			t.Fatalf("UpdateVerification: %v", err)
+		}
+
+		n, err := repo.DeleteUnverified(ctx, cutoff)
+		if err != nil || n < 1 {
+			t.Fatalf("DeleteUnverified() = %d, %v; want the expired claim deleted", n, err)
+		}
+		for _, tt := range []struct {
+			d    *domain.CustomDomain
+			kept bool
+		}{{expired, false}, {fresh, true}, {verified, true}} {
+			found, err := repo.FindClaim(ctx, tt.d.Hostname, tunnel.ID)
+			if err != nil || (found != nil) != tt.kept {
+				t.Errorf("claim %s after DeleteUnverified = %v, %v; want kept %v", tt.d.Hostname, found, err, tt.kept)
+			}
+		}
+	})
+}
+
+func newTestDomainNamed(t *testing.T, hostname string, tunnelID domain.TunnelID) *domain.CustomDomain {
+	t.Helper()
+	d, err := domain.NewCustomDomain(hostname, tunnelID, "other-token")
+	if err != nil {
+		t.Fatalf("NewCustomDomain: %v", err)
+	}
+	return d
+}
+
+func testACMECache(t *testing.T, cache autocert.Cache) {
+	ctx := context.Background()
+	key := "k-" + uuid.New().String()
+
+	if _, err := cache.Get(ctx, key); !errors.Is(err, autocert.ErrCacheMiss) {
+		t.Fatalf("Get(missing) = %v, want ErrCacheMiss", err)
+	}
+	for _, data := range [][]byte{[]byte("first"), []byte("second")} {
+		if err := cache.Put(ctx, key, data); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+		got, err := cache.Get(ctx, key)
+		if err != nil || string(got) != string(data) {
+			t.Fatalf("Get() = %q, %v; want %q", got, err, data)
+		}
+	}
+	if err := cache.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := cache.Get(ctx, key); !errors.Is(err, autocert.ErrCacheMiss) {
+		t.Errorf("Get after Delete = %v, want ErrCacheMiss", err)
+	}
+	if err := cache.Delete(ctx, key); err != nil {
+		t.Errorf("Delete(missing) = %v, want nil", err)
+	}
+}
@@ -0,0 +1,57 @@
+package persistence
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// SQLiteScheme — схема DSN SQLite: sqlite:///var/lib/ghost-tunnel/ghost.db
+// для абсолютного пути или sqlite://ghost.db для пути от рабочего каталога.
+const SQLiteScheme = "sqlite://"
+
+// OpenSQLite открывает файл базы SQLite в режиме WAL. Читатели не блокируют
+// писателя, а конкурирующие записи ждут друг друга до busy_timeout.
+func OpenSQLite(dsn string) (*sql.DB, error) {
+	path, query, _ := strings.Cut(strings.TrimPrefix(dsn, SQLiteScheme), "?")
+	if path == "" {
+		return nil, fmt.Errorf("invalid sqlite dsn %q: empty path", dsn)
+	}
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sqlite dsn %q: %w", dsn, err)
+	}
+	params.Add("_pragma", "journal_mode(WAL)")
+	params.Add("_pragma", "busy_timeout(5000)")
+	params.Add("_pragma", "foreign_keys(1)")
+	params.Set("_time_format", "sqlite")
+
+	db, err := sql.Open("sqlite", "file:"+path+"?"+params.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite database: %w", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open sqlite database: %w", err)
+	}
+	return db, nil
+}
+
+// isSQLiteUniqueViolation сообщает, нарушено ли ограничение уникальности, и
+// возвращает текст ошибки вида "UNIQUE constraint failed: tunnels.public_port".
+func isSQLiteUniqueViolation(err error) (string, bool) {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return "", false
+	}
+	switch sqliteErr.Code() {
+	case sqlite3.SQLITE_CONSTRAINT_UNIQUE, sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY:
+		return sqliteErr.Error(), true
+	}
+	return "", false
+}
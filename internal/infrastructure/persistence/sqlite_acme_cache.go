@@ -0,0 +1,49 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// SQLiteACMECache хранит ключ ACME-аккаунта и выпущенные сертификаты в SQLite.
+type SQLiteACMECache struct {
+	db *sql.DB
+}
+
+func NewSQLiteACMECache(db *sql.DB) autocert.Cache {
+	return &SQLiteACMECache{db: db}
+}
+
+func (c *SQLiteACMECache) Get(ctx context.Context, key string) ([]byte, error) {
+	var data []byte
+	err := c.db.QueryRowContext(ctx, `SELECT data FROM acme_cache WHERE key = ?`, key).Scan(&data)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, autocert.ErrCacheMiss
+		}
+		return nil, fmt.Errorf("could not read acme cache: %w", err)
+	}
+	return data, nil
+}
+
+func (c *SQLiteACMECache) Put(ctx context.Context, key string, data []byte) error {
+	query := `
+		INSERT INTO acme_cache (key, data, updated_at) VALUES (?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT (key) DO UPDATE SET data = excluded.data, updated_at = CURRENT_TIMESTAMP
+	`
+	if _, err := c.db.ExecContext(ctx, query, key, data); err != nil {
+		return fmt.Errorf("could not write acme cache: %w", err)
+	}
+	return nil
+}
+
+func (c *SQLiteACMECache) Delete(ctx context.Context, key string) error {
+	if _, err := c.db.ExecContext(ctx, `DELETE FROM acme_cache WHERE key = ?`, key); err != nil {
+		return fmt.Errorf("could not delete from acme cache: %w", err)
+	}
+	return nil
+}
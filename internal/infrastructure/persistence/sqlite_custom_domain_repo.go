@@ -0,0 +1,192 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/waste3d/ghost-tunnel/internal/domain"
+)
+
+type SQLiteCustomDomainRepository struct {
+	db *sql.DB
+}
+
+func NewSQLiteCustomDomainRepository(db *sql.DB) domain.CustomDomainRepository {
+	return &SQLiteCustomDomainRepository{db: db}
+}
+
+func (r *SQLiteCustomDomainRepository) Save(ctx context.Context, d *domain.CustomDomain) error {
+	// подтверждённое имя уже никто не получит: его заявку вытесняет только
+	// подтверждение другого туннеля, см. UpdateVerification
+	verified, err := r.FindVerified(ctx, d.Hostname)
+	if err != nil {
+		return err
+	}
+	if verified != nil {
+		return domain.ErrDomainTaken
+	}
+
+	query := `
+		INSERT INTO domains (` + customDomainColumns + `)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err = r.db.ExecContext(ctx, query,
+		d.Hostname,
+		string(d.TunnelID),
+		d.VerificationToken,
+		d.VerifiedAt,
+		string(d.CertStatus),
+		d.CertError,
+		d.CertExpiresAt,
+		d.CreatedAt,
+	)
+
+	if err != nil {
+		if _, ok := isSQLiteUniqueViolation(err); ok {
+			return domain.ErrDomainTaken
+		}
+		return fmt.Errorf("could not save domain: %w", err)
+	}
+	return nil
+}
+
+func (r *SQLiteCustomDomainRepository) FindVerified(ctx context.Context, hostname string) (*domain.CustomDomain, error) {
+	query := `SELECT ` + customDomainColumns + ` FROM domains WHERE hostname = ? AND verified_at IS NOT NULL`
+	return r.find(ctx, query, hostname)
+}
+
+func (r *SQLiteCustomDomainRepository) FindClaim(ctx context.Context, hostname string, tunnelID domain.TunnelID) (*domain.CustomDomain, error) {
+	query := `SELECT ` + customDomainColumns + ` FROM domains WHERE hostname = ? AND tunnel_id = ?`
+	return r.find(ctx, query, hostname, string(tunnelID))
+}
+
+func (r *SQLiteCustomDomainRepository) find(ctx context.Context, query string, args ...any) (*domain.CustomDomain, error) {
+	d, err := r.scanDomain(r.db.QueryRowContext(ctx, query, args...))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not find domain: %w", err)
+	}
+	return d, nil
+}
+
+func (r *SQLiteCustomDomainRepository) ListByTunnel(ctx context.Context, tunnelID domain.TunnelID) ([]*domain.CustomDomain, error) {
+	query := `SELECT ` + customDomainColumns + ` FROM domains WHERE tunnel_id = ? ORDER BY created_at`
+	return r.list(ctx, query, string(tunnelID))
+}
+
+func (r *SQLiteCustomDomainRepository) List(ctx context.Context) ([]*domain.CustomDomain, error) {
+	query := `SELECT ` + customDomainColumns + ` FROM domains ORDER BY created_at`
+	return r.list(ctx, query)
+}
+
+func (r *SQLiteCustomDomainRepository) UpdateVerification(ctx context.Context, d *domain.CustomDomain) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not update domain verification: %w", err)
+	}
+	defer tx.Rollback()
+
+	// второе подтверждение того же имени нарушит idx_domains_verified_hostname
+	query := `UPDATE domains SET verified_at = ? WHERE hostname = ? AND tunnel_id = ?`
+	res, err := tx.ExecContext(ctx, query, d.VerifiedAt, d.Hostname, string(d.TunnelID))
+	if err != nil {
+		if _, ok := isSQLiteUniqueViolation(err); ok {
+			return domain.ErrDomainTaken
+		}
+		return fmt.Errorf("could not update domain verification: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not update domain verification: %w", err)
+	}
+	if n == 0 {
+		// заявку вытеснил другой туннель или её удалили
+		return domain.ErrDomainNotFound
+	}
+	if d.Verified() {
+		query := `DELETE FROM domains WHERE hostname = ? AND tunnel_id <> ?`
+		if _, err := tx.ExecContext(ctx, query, d.Hostname, string(d.TunnelID)); err != nil {
+			return fmt.Errorf("could not update domain verification: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not update domain verification: %w", err)
+	}
+	return nil
+}
+
+func (r *SQLiteCustomDomainRepository) UpdateCertificate(ctx context.Context, d *domain.CustomDomain) error {
+	query := `UPDATE domains SET cert_status = ?, cert_error = ?, cert_expires_at = ? WHERE hostname = ? AND tunnel_id = ?`
+	_, err := r.db.ExecContext(ctx, query, string(d.CertStatus), d.CertError, d.CertExpiresAt, d.Hostname, string(d.TunnelID))
+	if err != nil {
+		return fmt.Errorf("could not update domain certificate: %w", err)
+	}
+	return nil
+}
+
+func (r *SQLiteCustomDomainRepository) Delete(ctx context.Context, hostname string, tunnelID domain.TunnelID) error {
+	query := `DELETE FROM domains WHERE hostname = ? AND tunnel_id = ?`
+	_, err := r.db.ExecContext(ctx, query, hostname, string(tunnelID))
+	if err != nil {
+		return fmt.Errorf("could not delete domain: %w", err)
+	}
+	return nil
+}
+
+func (r *SQLiteCustomDomainRepository) DeleteUnverified(ctx context.Context, createdBefore time.Time) (int, error) {
+	// время сравнивается через julianday, как в SQLiteTunnelRepository.ListAbandoned
+	query := `DELETE FROM domains WHERE verified_at IS NULL AND julianday(created_at) < julianday(?)`
+	res, err := r.db.ExecContext(ctx, query, createdBefore.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("could not delete unverified domains: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("could not delete unverified domains: %w", err)
+	}
+	return int(n), nil
+}
+
+func (r *SQLiteCustomDomainRepository) list(ctx context.Context, query string, args ...any) ([]*domain.CustomDomain, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not list domains: %w", err)
+	}
+	defer rows.Close()
+
+	var domains []*domain.CustomDomain
+	for rows.Next() {
+		d, err := r.scanDomain(rows)
+		if err != nil {
+			return nil, fmt.Errorf("could not scan domain: %w", err)
+		}
+		domains = append(domains, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not list domains: %w", err)
+	}
+	return domains, nil
+}
+
+func (r *SQLiteCustomDomainRepository) scanDomain(row interface{ Scan(...any) error }) (*domain.CustomDomain, error) {
+	var d domain.CustomDomain
+	err := row.Scan(
+		&d.Hostname,
+		&d.TunnelID,
+		&d.VerificationToken,
+		&d.VerifiedAt,
+		&d.CertStatus,
+		&d.CertError,
+		&d.CertExpiresAt,
+		&d.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
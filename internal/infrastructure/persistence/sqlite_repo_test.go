@@ -0,0 +1,63 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+// testSQLiteDB создаёт свежую базу во временном каталоге и накатывает миграции.
+func testSQLiteDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := SQLiteScheme + filepath.Join(t.TempDir(), "ghost.db")
+
+	migrator, err := NewSQLiteMigrator(dsn)
+	if err != nil {
+		t.Fatalf("NewSQLiteMigrator: %v", err)
+	}
+	defer migrator.Close()
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	db, err := OpenSQLite(dsn)
+	if err != nil {
+		t.Fatalf("OpenSQLite: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestSQLiteTunnelRepository(t *testing.T) {
+	db := testSQLiteDB(t)
+	testTunnelRepository(t, NewSQLiteTunnelRepository(db), NewSQLiteUserRepository(db))
+}
+
+func TestSQLiteTunnelStatsRepository(t *testing.T) {
+	db := testSQLiteDB(t)
+	testTunnelStatsRepository(t, NewSQLiteTunnelStatsRepository(db), NewSQLiteTunnelRepository(db))
+}
+
+func TestSQLiteUserRepository(t *testing.T) {
+	testUserRepository(t, NewSQLiteUserRepository(testSQLiteDB(t)))
+}
+
+func TestSQLiteCustomDomainRepository(t *testing.T) {
+	db := testSQLiteDB(t)
+	testCustomDomainRepository(t, NewSQLiteCustomDomainRepository(db), NewSQLiteTunnelRepository(db))
+}
+
+func TestSQLiteACMECache(t *testing.T) {
+	testACMECache(t, NewSQLiteACMECache(testSQLiteDB(t)))
+}
+
+func TestSQLiteUsesWAL(t *testing.T) {
+	var mode string
+	if err := testSQLiteDB(t).QueryRow(`PRAGMA journal_mode`).Scan(&mode); err != nil {
+		t.Fatalf("journal_mode: %v", err)
+	}
+	if mode != "wal" {
+		t.Errorf("journal_mode = %q, want wal", mode)
+	}
+}
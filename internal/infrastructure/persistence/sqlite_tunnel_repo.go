@@ -0,0 +1,299 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/waste3d/ghost-tunnel/internal/domain"
+)
+
+const sqliteTunnelColumns = `id, user_id, protocol, subdomain, domain, public_port, local_host, local_port, https_redirect, reserved, status, created_at, last_connected_at, last_disconnected_at, agent_version, agent_addr`
+
+type SQLiteTunnelRepository struct {
+	db *sql.DB
+}
+
+func NewSQLiteTunnelRepository(db *sql.DB) domain.TunnelRepository {
+	return &SQLiteTunnelRepository{db: db}
+}
+
+func (r *SQLiteTunnelRepository) Save(ctx context.Context, tunnel *domain.Tunnel) error {
+	query := `
+		INSERT INTO tunnels (` + sqliteTunnelColumns + `)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	var userID any
+	if tunnel.UserID != "" {
+		userID = string(tunnel.UserID)
+	}
+
+	// Выделенный порт есть только у TCP-туннелей, у HTTP колонка пустая
+	var publicPort any
+	if tunnel.Protocol == domain.ProtocolTCP {
+		publicPort = tunnel.Endpoints.Port
+	}
+
+	_, err := r.db.ExecContext(ctx, query,
+		string(tunnel.ID),
+		userID,
+		string(tunnel.Protocol),
+		tunnel.Endpoints.Subdomain,
+		tunnel.Endpoints.Domain,
+		publicPort,
+		tunnel.LocalTarget.Host,
+		tunnel.LocalTarget.Port,
+		tunnel.HTTPSRedirect,
+		tunnel.Reserved,
+		string(tunnel.Status),
+		tunnel.CreatedAt,
+		tunnel.LastConnectedAt,
+		tunnel.LastDisconnectedAt,
+		tunnel.Agent.Version,
+		tunnel.Agent.RemoteAddr,
+	)
+
+	if err != nil {
+		if msg, ok := isSQLiteUniqueViolation(err); ok {
+			if strings.Contains(msg, "tunnels.public_port") {
+				return domain.ErrPortTaken
+			}
+			return domain.ErrSubdomainTaken
+		}
+		return fmt.Errorf("could not save tunnel: %w", err)
+	}
+	return nil
+}
+
+func (r *SQLiteTunnelRepository) FindBySubdomain(ctx context.Context, subdomain string) (*domain.Tunnel, error) {
+	query := `SELECT ` + sqliteTunnelColumns + ` FROM tunnels WHERE subdomain = ?`
+	row := r.db.QueryRowContext(ctx, query, subdomain)
+
+	tunnel, err := r.scanTunnel(row)
+	if err != nil {
+		return nil, fmt.Errorf("could not find tunnel by subdomain: %w", err)
+	}
+	return tunnel, nil
+}
+
+func (r *SQLiteTunnelRepository) FindByID(ctx context.Context, id domain.TunnelID) (*domain.Tunnel, error) {
+	query := `SELECT ` + sqliteTunnelColumns + ` FROM tunnels WHERE id = ?`
+	row := r.db.QueryRowContext(ctx, query, string(id))
+
+	tunnel, err := r.scanTunnel(row)
+	if err != nil {
+		return nil, fmt.Errorf("could not find tunnel by id: %w", err)
+	}
+	return tunnel, nil
+}
+
+func (r *SQLiteTunnelRepository) scanTunnel(row interface{ Scan(...any) error }) (*domain.Tunnel, error) {
+	var t domain.Tunnel
+	var userID sql.NullString
+	var publicPort sql.NullInt32
+
+	err := row.Scan(
+		&t.ID,
+		&userID,
+		&t.Protocol,
+		&t.Endpoints.Subdomain,
+		&t.Endpoints.Domain,
+		&publicPort,
+		&t.LocalTarget.Host,
+		&t.LocalTarget.Port,
+		&t.HTTPSRedirect,
+		&t.Reserved,
+		&t.Status,
+		&t.CreatedAt,
+		&t.LastConnectedAt,
+		&t.LastDisconnectedAt,
+		&t.Agent.Version,
+		&t.Agent.RemoteAddr,
+	)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if userID.Valid {
+		t.UserID = domain.UserID(userID.String)
+	}
+	t.Endpoints.Port = 80
+	if publicPort.Valid {
+		t.Endpoints.Port = int(publicPort.Int32)
+	}
+	return &t, nil
+}
+
+func (r *SQLiteTunnelRepository) ListByUser(ctx context.Context, userID domain.UserID, filter domain.TunnelFilter) ([]*domain.Tunnel, int, error) {
+	where := ` FROM tunnels WHERE user_id = ?1 AND (?2 = '' OR status = ?2)`
+
+	var total int
+	err := r.db.QueryRowContext(ctx, `SELECT count(*)`+where, string(userID), string(filter.Status)).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not count tunnels: %w", err)
+	}
+
+	// отрицательный LIMIT в SQLite означает «без ограничения»
+	limit := -1
+	if filter.Limit > 0 {
+		limit = filter.Limit
+	}
+	query := `SELECT ` + sqliteTunnelColumns + where + ` ORDER BY created_at DESC, id LIMIT ?3 OFFSET ?4`
+	rows, err := r.db.QueryContext(ctx, query, string(userID), string(filter.Status), limit, filter.Offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not list tunnels: %w", err)
+	}
+	defer rows.Close()
+
+	var tunnels []*domain.Tunnel
+	for rows.Next() {
+		tunnel, err := r.scanTunnel(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("could not scan tunnel: %w", err)
+		}
+		tunnels = append(tunnels, tunnel)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("could not list tunnels: %w", err)
+	}
+	return tunnels, total, nil
+}
+
+func (r *SQLiteTunnelRepository) ListPublicPorts(ctx context.Context) ([]int, error) {
+	query := `SELECT public_port FROM tunnels WHERE public_port IS NOT NULL`
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("could not list public ports: %w", err)
+	}
+	defer rows.Close()
+
+	var ports []int
+	for rows.Next() {
+		var port int
+		if err := rows.Scan(&port); err != nil {
+			return nil, fmt.Errorf("could not list public ports: %w", err)
+		}
+		ports = append(ports, port)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not list public ports: %w", err)
+	}
+	return ports, nil
+}
+
+func (r *SQLiteTunnelRepository) Update(ctx context.Context, tunnel *domain.Tunnel) error {
+	query := `
+		UPDATE tunnels SET subdomain = ?, local_host = ?, local_port = ?, https_redirect = ?, reserved = ?
+		WHERE id = ?
+	`
+	res, err := r.db.ExecContext(ctx, query,
+		tunnel.Endpoints.Subdomain,
+		tunnel.LocalTarget.Host,
+		tunnel.LocalTarget.Port,
+		tunnel.HTTPSRedirect,
+		tunnel.Reserved,
+		string(tunnel.ID),
+	)
+	if err != nil {
+		if _, ok := isSQLiteUniqueViolation(err); ok {
+			return domain.ErrSubdomainTaken
+		}
+		return fmt.Errorf("could not update tunnel: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return domain.ErrTunnelNotFound
+	}
+	return nil
+}
+
+func (r *SQLiteTunnelRepository) UpdateStatus(ctx context.Context, tunnel *domain.Tunnel) error {
+	query := `
+		UPDATE tunnels SET status = ?, last_connected_at = ?, last_disconnected_at = ?, agent_version = ?, agent_addr = ?
+		WHERE id = ?
+	`
+	res, err := r.db.ExecContext(ctx, query,
+		string(tunnel.Status),
+		tunnel.LastConnectedAt,
+		tunnel.LastDisconnectedAt,
+		tunnel.Agent.Version,
+		tunnel.Agent.RemoteAddr,
+		string(tunnel.ID),
+	)
+	if err != nil {
+		return fmt.Errorf("could not update tunnel status: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return domain.ErrTunnelNotFound
+	}
+	return nil
+}
+
+func (r *SQLiteTunnelRepository) ResetStatuses(ctx context.Context, at time.Time) (int, error) {
+	query := `UPDATE tunnels SET status = ?, last_disconnected_at = ? WHERE status = ?`
+	res, err := r.db.ExecContext(ctx, query, string(domain.StatusInactive), at, string(domain.StatusActive))
+	if err != nil {
+		return 0, fmt.Errorf("could not reset tunnel statuses: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("could not reset tunnel statuses: %w", err)
+	}
+	return int(n), nil
+}
+
+func (r *SQLiteTunnelRepository) ListAbandoned(ctx context.Context, offlineBefore time.Time) ([]*domain.Tunnel, error) {
+	// Время хранится текстом и может быть записано с разными смещениями,
+	// поэтому сравнивается через julianday, а не как строки
+	query := `SELECT ` + sqliteTunnelColumns + ` FROM tunnels
+		WHERE NOT reserved AND status = ? AND julianday(COALESCE(last_disconnected_at, last_connected_at, created_at)) < julianday(?)
+		ORDER BY julianday(COALESCE(last_disconnected_at, last_connected_at, created_at)), id`
+	rows, err := r.db.QueryContext(ctx, query, string(domain.StatusInactive), offlineBefore.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("could not list abandoned tunnels: %w", err)
+	}
+	defer rows.Close()
+
+	var tunnels []*domain.Tunnel
+	for rows.Next() {
+		tunnel, err := r.scanTunnel(rows)
+		if err != nil {
+			return nil, fmt.Errorf("could not scan tunnel: %w", err)
+		}
+		tunnels = append(tunnels, tunnel)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not list abandoned tunnels: %w", err)
+	}
+	return tunnels, nil
+}
+
+func (r *SQLiteTunnelRepository) DeleteAbandoned(ctx context.Context, id domain.TunnelID, offlineBefore time.Time) (bool, error) {
+	query := `DELETE FROM tunnels
+		WHERE id = ? AND NOT reserved AND status = ? AND julianday(COALESCE(last_disconnected_at, last_connected_at, created_at)) < julianday(?)`
+	res, err := r.db.ExecContext(ctx, query, string(id), string(domain.StatusInactive), offlineBefore.UTC())
+	if err != nil {
+		return false, fmt.Errorf("could not delete abandoned tunnel: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("could not delete abandoned tunnel: %w", err)
+	}
+	return n > 0, nil
+}
+
+func (r *SQLiteTunnelRepository) Delete(ctx context.Context, subdomain string) error {
+	query := `DELETE FROM tunnels WHERE subdomain = ?`
+	_, err := r.db.ExecContext(ctx, query, subdomain)
+	if err != nil {
+		return fmt.Errorf("could not delete tunnel: %w", err)
+	}
+	return nil
+}
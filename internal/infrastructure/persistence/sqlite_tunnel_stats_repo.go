@@ -0,0 +1,122 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/waste3d/ghost-tunnel/internal/domain"
+)
+
+type SQLiteTunnelStatsRepository struct {
+	db *sql.DB
+}
+
+func NewSQLiteTunnelStatsRepository(db *sql.DB) domain.TunnelStatsRepository {
+	return &SQLiteTunnelStatsRepository{db: db}
+}
+
+func (r *SQLiteTunnelStatsRepository) Add(ctx context.Context, stats []*domain.TunnelStats) error {
+	// Время хранится текстом, поэтому корзины всегда пишутся в UTC: иначе
+	// сравнение строк в Range не совпадёт со сравнением моментов времени.
+	// WHERE EXISTS пропускает туннели, удалённые после подсчёта.
+	query := `
+		INSERT INTO tunnel_stats (` + tunnelStatsColumns + `)
+		SELECT ?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9, ?10, ?11, ?12, ?13
+		WHERE EXISTS (SELECT 1 FROM tunnels WHERE id = ?1)
+		ON CONFLICT (tunnel_id, bucket_start) DO UPDATE SET
+			requests = requests + excluded.requests,
+			connections = connections + excluded.connections,
+			bytes_in = bytes_in + excluded.bytes_in,
+			bytes_out = bytes_out + excluded.bytes_out,
+			status_1xx = status_1xx + excluded.status_1xx,
+			status_2xx = status_2xx + excluded.status_2xx,
+			status_3xx = status_3xx + excluded.status_3xx,
+			status_4xx = status_4xx + excluded.status_4xx,
+			status_5xx = status_5xx + excluded.status_5xx,
+			latency_total_us = latency_total_us + excluded.latency_total_us,
+			latency_max_us = MAX(latency_max_us, excluded.latency_max_us)
+	`
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not save tunnel stats: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("could not save tunnel stats: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, s := range stats {
+		_, err := stmt.ExecContext(ctx,
+			string(s.TunnelID),
+			s.Start.UTC(),
+			s.Requests,
+			s.Connections,
+			s.BytesIn,
+			s.BytesOut,
+			s.Status1xx,
+			s.Status2xx,
+			s.Status3xx,
+			s.Status4xx,
+			s.Status5xx,
+			s.LatencyTotal.Microseconds(),
+			s.LatencyMax.Microseconds(),
+		)
+		if err != nil {
+			return fmt.Errorf("could not save tunnel stats: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not save tunnel stats: %w", err)
+	}
+	return nil
+}
+
+func (r *SQLiteTunnelStatsRepository) Range(ctx context.Context, tunnelID domain.TunnelID, from, to time.Time) ([]*domain.TunnelStats, error) {
+	query := `
+		SELECT ` + tunnelStatsColumns + ` FROM tunnel_stats
+		WHERE tunnel_id = ? AND bucket_start >= ? AND bucket_start < ?
+		ORDER BY bucket_start
+	`
+	rows, err := r.db.QueryContext(ctx, query, string(tunnelID), from.UTC(), to.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("could not list tunnel stats: %w", err)
+	}
+	defer rows.Close()
+
+	var stats []*domain.TunnelStats
+	for rows.Next() {
+		var s domain.TunnelStats
+		var latencyTotal, latencyMax int64
+		err := rows.Scan(
+			&s.TunnelID,
+			&s.Start,
+			&s.Requests,
+			&s.Connections,
+			&s.BytesIn,
+			&s.BytesOut,
+			&s.Status1xx,
+			&s.Status2xx,
+			&s.Status3xx,
+			&s.Status4xx,
+			&s.Status5xx,
+			&latencyTotal,
+			&latencyMax,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("could not scan tunnel stats: %w", err)
+		}
+		s.Start = s.Start.UTC()
+		s.LatencyTotal = time.Duration(latencyTotal) * time.Microsecond
+		s.LatencyMax = time.Duration(latencyMax) * time.Microsecond
+		stats = append(stats, &s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not list tunnel stats: %w", err)
+	}
+	return stats, nil
+}
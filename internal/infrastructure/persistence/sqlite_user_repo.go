@@ -0,0 +1,76 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/waste3d/ghost-tunnel/internal/domain"
+)
+
+type SQLiteUserRepository struct {
+	db *sql.DB
+}
+
+func NewSQLiteUserRepository(db *sql.DB) domain.UserRepository {
+	return &SQLiteUserRepository{db: db}
+}
+
+func (r *SQLiteUserRepository) Save(ctx context.Context, user *domain.User) error {
+	query := `
+		INSERT INTO users (id, email, password_hash, api_key, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`
+	_, err := r.db.ExecContext(ctx, query,
+		string(user.ID),
+		user.Email,
+		user.PasswordHash,
+		string(user.APIKey),
+		user.CreatedAt,
+	)
+
+	if err != nil {
+		if msg, ok := isSQLiteUniqueViolation(err); ok {
+			return fmt.Errorf("%w: %s", domain.ErrUserAlreadyExists, msg)
+		}
+		return fmt.Errorf("could not save user: %w", err)
+	}
+	return nil
+}
+
+func (r *SQLiteUserRepository) FindByEmail(ctx context.Context, email string) (*domain.User, error) {
+	query := `select id, email, password_hash, api_key, created_at from users where email = ?`
+	row := r.db.QueryRowContext(ctx, query, email)
+
+	return r.scanUser(row)
+}
+
+func (r *SQLiteUserRepository) FindByAPIKey(ctx context.Context, apiKey string) (*domain.User, error) {
+	query := `select id, email, password_hash, api_key, created_at from users where api_key = ?`
+	row := r.db.QueryRowContext(ctx, query, apiKey)
+
+	return r.scanUser(row)
+}
+
+func (r *SQLiteUserRepository) scanUser(row *sql.Row) (*domain.User, error) {
+	var user domain.User
+
+	err := row.Scan(
+		&user.ID,
+		&user.Email,
+		&user.PasswordHash,
+		&user.APIKey,
+		&user.CreatedAt,
+	)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("could not scan user: %w", err)
+	}
+
+	return &user, nil
+}
@@ -0,0 +1,34 @@
+package cli
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// backoff — экспоненциальная задержка между попытками переподключения
+// со случайным разбросом, чтобы агенты не ломились на сервер одновременно
+// после его перезапуска.
+type backoff struct {
+	min     time.Duration
+	max     time.Duration
+	attempt int
+}
+
+func newBackoff(min, max time.Duration) *backoff {
+	return &backoff{min: min, max: max}
+}
+
+func (b *backoff) next() time.Duration {
+	delay := b.min << b.attempt
+	if delay > b.max || delay <= 0 {
+		delay = b.max
+	} else {
+		b.attempt++
+	}
+	// половина задержки фиксирована, вторая половина случайна
+	return delay/2 + rand.N(delay/2+1)
+}
+
+func (b *backoff) reset() {
+	b.attempt = 0
+}
@@ -2,170 +2,492 @@ package cli
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net"
 	"sync"
+	"time"
 
 	"github.com/waste3d/ghost-tunnel/api"
+	"github.com/waste3d/ghost-tunnel/internal/infrastructure/logging"
+	"github.com/waste3d/ghost-tunnel/internal/infrastructure/mux"
+	"github.com/waste3d/ghost-tunnel/internal/interfaces/cli/inspector"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 )
 
+type clientConn struct {
+	conn     *mux.Conn
+	tunnelID string
+}
+
 type connectionManager struct {
-	connections map[string]chan []byte
+	connections map[string]clientConn
 	mu          sync.RWMutex
 }
 
 func newConnectionManager() *connectionManager {
 	return &connectionManager{
-		connections: make(map[string]chan []byte),
+		connections: make(map[string]clientConn),
+	}
+}
+
+func (cm *connectionManager) add(tunnelID string, conn *mux.Conn) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.connections[conn.ID()] = clientConn{conn: conn, tunnelID: tunnelID}
+}
+
+func (cm *connectionManager) get(connID string) (*mux.Conn, bool) {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	entry, ok := cm.connections[connID]
+	return entry.conn, ok
+}
+
+func (cm *connectionManager) remove(connID string) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	delete(cm.connections, connID)
+}
+
+// tunnelConns возвращает соединения туннеля.
+func (cm *connectionManager) tunnelConns(tunnelID string) []*mux.Conn {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	var conns []*mux.Conn
+	for _, entry := range cm.connections {
+		if entry.tunnelID == tunnelID {
+			conns = append(conns, entry.conn)
+		}
+	}
+	return conns
+}
+
+// resume продолжает соединения туннеля, которые сервер сохранил за время обрыва.
+func (cm *connectionManager) resume(tunnelID string, peer []*api.ConnectionState) int {
+	conns := cm.tunnelConns(tunnelID)
+	lost := mux.Reconcile(conns, peer)
+	for _, conn := range lost {
+		conn.DeliverReset(api.CloseReason_CLOSE_REASON_SHUTDOWN, "connection lost while reconnecting")
+		cm.remove(conn.ID())
+	}
+	return len(conns) - len(lost)
+}
+
+// resetTunnel обрывает соединения туннеля, когда продолжить их уже нельзя.
+func (cm *connectionManager) resetTunnel(tunnelID string) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	for connID, entry := range cm.connections {
+		if entry.tunnelID == tunnelID {
+			entry.conn.DeliverReset(api.CloseReason_CLOSE_REASON_SHUTDOWN, "connection to server lost")
+			delete(cm.connections, connID)
+		}
+	}
+}
+
+// resetAll обрывает все соединения, когда продолжить их уже нельзя.
+func (cm *connectionManager) resetAll() {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	for connID, entry := range cm.connections {
+		entry.conn.DeliverReset(api.CloseReason_CLOSE_REASON_SHUTDOWN, "connection to server lost")
+		delete(cm.connections, connID)
 	}
 }
 
+type tunnelState string
+
+const (
+	stateConnecting   tunnelState = "connecting"
+	stateOnline       tunnelState = "online"
+	stateReconnecting tunnelState = "reconnecting"
+	stateClosed       tunnelState = "closed"
+)
+
+// Route — туннель, который обслуживает клиент, и локальный сервис, куда
+// ведут его соединения.
+type Route struct {
+	TunnelID  string
+	LocalAddr string
+	// Inspector разбирает HTTP-трафик для локального веб-интерфейса; nil — не разбирать
+	Inspector *inspector.Inspector
+}
+
+type route struct {
+	Route
+	logger      *slog.Logger
+	resumeToken string
+}
+
+// Client держит туннели открытыми через одну gRPC-сессию с сервером.
 type Client struct {
-	grpcConn  *grpc.ClientConn
-	stream    api.TunnelService_EstablishTunnelClient
-	tunnelID  string
-	localAddr string
-	connMgr   *connectionManager
+	apiKey  string
+	routes  []*route
+	connMgr *connectionManager
+	logger  *slog.Logger
+
+	// stream равен nil, пока нет связи с сервером; отправленные в это время
+	// данные остаются в буферах соединений и досылаются после переподключения
+	sendMu sync.Mutex
+	stream api.TunnelService_EstablishTunnelClient
+
+	state tunnelState
 }
 
-func NewClient(tunnelID, localAddr string) *Client {
-	return &Client{
-		tunnelID:  tunnelID,
-		localAddr: localAddr,
-		connMgr:   newConnectionManager(),
+func NewClient(apiKey string, routes ...Route) *Client {
+	c := &Client{
+		apiKey:  apiKey,
+		connMgr: newConnectionManager(),
+		logger:  slog.Default(),
 	}
+	for _, r := range routes {
+		c.routes = append(c.routes, &route{Route: r, logger: slog.Default().With(logging.KeyTunnelID, r.TunnelID)})
+	}
+	if len(c.routes) == 1 {
+		c.logger = c.routes[0].logger
+	}
+	return c
+}
+
+// route находит туннель соединения; старый сервер не присылает tunnel_id,
+// но и обслуживает только один туннель.
+func (c *Client) route(tunnelID string) (*route, bool) {
+	if tunnelID == "" && len(c.routes) == 1 {
+		return c.routes[0], true
+	}
+	for _, r := range c.routes {
+		if r.TunnelID == tunnelID {
+			return r, true
+		}
+	}
+	return nil, false
 }
 
+// Run держит туннель открытым до отмены ctx, переподключаясь к серверу
+// с экспоненциальной задержкой. Ошибки авторизации не повторяются, как и
+// перехват туннеля другим агентом: иначе два агента отбирали бы его друг у друга.
 func (c *Client) Run(ctx context.Context, serverAddr string) error {
-	log.Printf("Connecting to server at %s...", serverAddr)
+	c.logger.Info("Connecting to server", "server", serverAddr)
 	conn, err := grpc.Dial(serverAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
 	if err != nil {
 		return fmt.Errorf("failed to connect to server: %v", err)
 	}
 	defer conn.Close()
-	c.grpcConn = conn
 
-	log.Println("Connection established.")
-	grpcClient := api.NewTunnelServiceClient(c.grpcConn)
-	stream, err := grpcClient.EstablishTunnel(ctx)
+	grpcClient := api.NewTunnelServiceClient(conn)
+	retry := newBackoff(500*time.Millisecond, 30*time.Second)
+	var offlineSince time.Time
+
+	for {
+		c.setState(stateConnecting)
+		wasOnline, err := c.runSession(ctx, grpcClient, retry)
+		if ctx.Err() != nil {
+			c.connMgr.resetAll()
+			c.setState(stateClosed)
+			return nil
+		}
+		if isPermanent(err) {
+			c.connMgr.resetAll()
+			return describeStreamError(err)
+		}
+
+		if wasOnline || offlineSince.IsZero() {
+			offlineSince = time.Now()
+		}
+		if time.Since(offlineSince) > mux.ResumeTimeout {
+			// сервер уже забыл сессию, держать соединения дальше бессмысленно
+			c.connMgr.resetAll()
+			for _, r := range c.routes {
+				r.resumeToken = ""
+			}
+		}
+
+		delay := retry.next()
+		c.setState(stateReconnecting, "delay", delay.Round(100*time.Millisecond), logging.Err(describeStreamError(err)))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			c.connMgr.resetAll()
+			c.setState(stateClosed)
+			return nil
+		}
+	}
+}
+
+// runSession проходит регистрацию на одном gRPC-стриме и обслуживает его до обрыва.
+func (c *Client) runSession(ctx context.Context, grpcClient api.TunnelServiceClient, retry *backoff) (bool, error) {
+	// Стрим отменяем сами: при выходе сначала штатно закрываем его, чтобы
+	// сервер сразу освободил туннель, а не ждал переподключения
+	streamCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := grpcClient.EstablishTunnel(streamCtx)
 	if err != nil {
-		return fmt.Errorf("failed to establish tunnel: %v", err)
+		return false, err
 	}
-	c.stream = stream
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.closeSend(stream)
+			select {
+			case <-time.After(2 * time.Second):
+				cancel()
+			case <-streamCtx.Done():
+			}
+		case <-streamCtx.Done():
+		}
+	}()
 
-	log.Printf("Registering tunnel ID: %s", c.tunnelID)
-	err = c.stream.Send(&api.ClientToServer{
-		Message: &api.ClientToServer_Register{
-			Register: &api.Register{
-				TunnelId: c.tunnelID,
-			},
-		},
+	err = stream.Send(&api.ClientToServer{
+		Message: &api.ClientToServer_Register{Register: c.register()},
 	})
 	if err != nil {
-		return fmt.Errorf("failed to send register message: %v", err)
+		return false, err
+	}
+
+	msg, err := stream.Recv()
+	if err != nil {
+		return false, err
+	}
+	registered := msg.GetRegistered()
+	if registered == nil {
+		return false, errors.New("server did not confirm tunnel registration")
+	}
+	replies := registered.GetTunnels()
+	if len(c.routes) == 1 {
+		replies = []*api.TunnelRegistered{{
+			TunnelId:    c.routes[0].TunnelID,
+			ResumeToken: registered.GetResumeToken(),
+			Resumed:     registered.GetResumed(),
+			Connections: registered.GetConnections(),
+		}}
 	}
-	go c.listenServer()
-	<-c.stream.Context().Done()
-	return c.stream.Context().Err()
+	if len(replies) != len(c.routes) {
+		return false, errors.New("server does not support several tunnels per connection")
+	}
+
+	c.attach(stream)
+	defer c.attach(nil)
+
+	resumed, resumedConns := false, 0
+	for i, reply := range replies {
+		r := c.routes[i]
+		r.resumeToken = reply.GetResumeToken()
+		if reply.GetResumed() {
+			resumed = true
+			resumedConns += c.connMgr.resume(r.TunnelID, reply.GetConnections())
+		} else {
+			c.connMgr.resetTunnel(r.TunnelID)
+		}
+	}
+	if resumed {
+		c.setState(stateOnline, "resumed_connections", resumedConns)
+	} else {
+		c.setState(stateOnline)
+	}
+	retry.reset()
+
+	return true, c.listenServer(stream)
+}
+
+// register собирает Register для всех туннелей клиента. Один туннель
+// описывается полями самого Register, чтобы его понимали и старые серверы.
+func (c *Client) register() *api.Register {
+	reg := &api.Register{ApiKey: c.apiKey, AgentVersion: Version}
+	if len(c.routes) == 1 {
+		r := c.routes[0]
+		reg.TunnelId = r.TunnelID
+		reg.ResumeToken = r.resumeToken
+		reg.Connections = mux.States(c.connMgr.tunnelConns(r.TunnelID))
+		return reg
+	}
+	for _, r := range c.routes {
+		reg.Tunnels = append(reg.Tunnels, &api.TunnelRegister{
+			TunnelId:    r.TunnelID,
+			ResumeToken: r.resumeToken,
+			Connections: mux.States(c.connMgr.tunnelConns(r.TunnelID)),
+		})
+	}
+	return reg
+}
+
+// setState пишет в лог смену состояния туннеля; args — подробности перехода.
+func (c *Client) setState(state tunnelState, args ...any) {
+	if c.state == state && len(args) == 0 {
+		return
+	}
+	c.state = state
+	c.logger.Info("Tunnel "+string(state), args...)
 }
 
-func (c *Client) listenServer() {
+func (c *Client) attach(stream api.TunnelService_EstablishTunnelClient) {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	c.stream = stream
+}
+
+func (c *Client) closeSend(stream api.TunnelService_EstablishTunnelClient) {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	stream.CloseSend()
+}
+
+func (c *Client) listenServer(stream api.TunnelService_EstablishTunnelClient) error {
 	for {
-		msg, err := c.stream.Recv()
+		msg, err := stream.Recv()
 		if err != nil {
-			if err != io.EOF {
-				log.Printf("Error receiving from server: %v", err)
-			}
-			c.connMgr.mu.Lock()
-			for _, ch := range c.connMgr.connections {
-				close(ch)
+			if err == io.EOF {
+				return errStreamClosed
 			}
-			c.connMgr.connections = make(map[string]chan []byte)
-			c.connMgr.mu.Unlock()
-			return
+			return err
 		}
-		if newConn := msg.GetNewConnection(); newConn != nil {
-			connID := newConn.GetConnectionId()
-			log.Printf("Received request for new connection: %s", connID)
-			dataChan := make(chan []byte, 100)
-			c.connMgr.mu.Lock()
-			c.connMgr.connections[connID] = dataChan
-			c.connMgr.mu.Unlock()
-			go c.handleConnection(connID, dataChan)
-		}
-		if data := msg.GetData(); data != nil {
-			c.connMgr.mu.RLock()
-			ch, ok := c.connMgr.connections[data.GetConnectionId()]
-			c.connMgr.mu.RUnlock()
-			if ok {
-				ch <- data.GetChunk()
+
+		switch m := msg.GetMessage().(type) {
+		case *api.ServerToClient_NewConnection:
+			connID := m.NewConnection.GetConnectionId()
+			r, ok := c.route(m.NewConnection.GetTunnelId())
+			if !ok {
+				c.logger.Warn("Received connection for unknown tunnel", logging.KeyConnID, connID, logging.KeyTunnelID, m.NewConnection.GetTunnelId())
+				c.SendReset(connID, api.CloseReason_CLOSE_REASON_DIAL_FAILED, "agent does not serve this tunnel")
+				continue
+			}
+			r.logger.Debug("Received request for new connection", logging.KeyConnID, connID)
+			conn := mux.NewConn(connID, c)
+			c.connMgr.add(r.TunnelID, conn)
+			go c.handleConnection(r, conn)
+		case *api.ServerToClient_Data:
+			if conn, ok := c.connMgr.get(m.Data.GetConnectionId()); ok {
+				conn.Deliver(m.Data.GetOffset(), m.Data.GetChunk())
+			}
+		case *api.ServerToClient_HalfClose:
+			if conn, ok := c.connMgr.get(m.HalfClose.GetConnectionId()); ok {
+				conn.DeliverHalfClose(m.HalfClose.GetFinalOffset())
+			}
+		case *api.ServerToClient_Close:
+			if conn, ok := c.connMgr.get(m.Close.GetConnectionId()); ok {
+				conn.DeliverClose(m.Close.GetFinalOffset())
+			}
+		case *api.ServerToClient_ResetConnection:
+			if conn, ok := c.connMgr.get(m.ResetConnection.GetConnectionId()); ok {
+				conn.DeliverReset(m.ResetConnection.GetReason(), m.ResetConnection.GetMessage())
+			}
+		case *api.ServerToClient_WindowUpdate:
+			if conn, ok := c.connMgr.get(m.WindowUpdate.GetConnectionId()); ok {
+				conn.DeliverWindowUpdate(m.WindowUpdate.GetIncrement())
 			}
 		}
 	}
 }
 
-func (c *Client) handleConnection(connectionID string, dataChan chan []byte) {
+func (c *Client) handleConnection(r *route, conn *mux.Conn) {
+	logger := r.logger.With(logging.KeyConnID, conn.ID())
 	defer func() {
-		c.connMgr.mu.Lock()
-		delete(c.connMgr.connections, connectionID)
-		c.connMgr.mu.Unlock()
-		log.Printf("Connection %s: cleaned up.", connectionID)
+		c.connMgr.remove(conn.ID())
+		logger.Info("Connection closed")
 	}()
 
-	localConn, err := net.Dial("tcp", c.localAddr)
+	localConn, err := net.Dial("tcp", r.LocalAddr)
 	if err != nil {
-		log.Printf("Failed to connect to local service at %s: %v", c.localAddr, err)
-		_ = c.stream.Send(&api.ClientToServer{
-			Message: &api.ClientToServer_Data{
-				Data: &api.Data{ConnectionId: connectionID, Chunk: nil},
-			},
-		})
+		logger.Warn("Failed to connect to local service", "local", r.LocalAddr, logging.Err(err))
+		conn.Reset(api.CloseReason_CLOSE_REASON_DIAL_FAILED, err.Error())
 		return
 	}
-	defer localConn.Close()
-	log.Printf("Connection %s: established to local service %s", connectionID, c.localAddr)
+	logger.Info("Connection established to local service", "local", r.LocalAddr)
 
-	grpcWriter := &StreamWriter{stream: c.stream, connID: connectionID}
+	if r.Inspector != nil {
+		localConn = r.Inspector.Wrap(conn.ID(), localConn)
+	}
+	mux.Join(localConn, conn)
+}
 
-	go func() {
-		io.Copy(grpcWriter, localConn)
-		_ = c.stream.Send(&api.ClientToServer{
-			Message: &api.ClientToServer_Data{
-				Data: &api.Data{ConnectionId: connectionID, Chunk: nil},
-			},
-		})
-	}()
+// isPermanent сообщает, что повторная попытка подключения не поможет.
+func isPermanent(err error) bool {
+	switch status.Code(err) {
+	case codes.Unauthenticated, codes.PermissionDenied, codes.NotFound, codes.InvalidArgument, codes.Aborted:
+		return true
+	default:
+		return false
+	}
+}
 
-	for data := range dataChan {
-		if data == nil {
-			return
-		}
-		if _, err := localConn.Write(data); err != nil {
-			return
-		}
+// describeStreamError превращает gRPC-статус сервера в понятное пользователю сообщение.
+func describeStreamError(err error) error {
+	st, ok := status.FromError(err)
+	if !ok {
+		return fmt.Errorf("connection to server lost: %w", err)
+	}
+
+	switch st.Code() {
+	case codes.Unauthenticated:
+		return fmt.Errorf("authentication failed: %s. Please run 'ghost-tunnel login' again", st.Message())
+	case codes.PermissionDenied:
+		return fmt.Errorf("access denied: %s", st.Message())
+	case codes.NotFound:
+		return fmt.Errorf("server rejected tunnel: %s", st.Message())
+	case codes.Aborted:
+		return errors.New("tunnel taken over by another agent. Stop the other agent or pick another subdomain")
+	default:
+		return fmt.Errorf("connection to server lost: %s", st.Message())
 	}
 }
 
-type StreamWriter struct {
-	stream api.TunnelService_EstablishTunnelClient
-	connID string
+var (
+	errNotConnected = errors.New("not connected to server")
+	errStreamClosed = errors.New("server closed the tunnel stream")
+)
+
+// gRPC-стрим не допускает конкурентных Send, поэтому все отправки идут через мьютекс.
+func (c *Client) send(msg *api.ClientToServer) error {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	if c.stream == nil {
+		return errNotConnected
+	}
+	return c.stream.Send(msg)
 }
 
-func (w *StreamWriter) Write(p []byte) (n int, err error) {
-	err = w.stream.Send(&api.ClientToServer{
+func (c *Client) SendData(connID string, offset uint64, chunk []byte) error {
+	return c.send(&api.ClientToServer{
 		Message: &api.ClientToServer_Data{
-			Data: &api.Data{
-				ConnectionId: w.connID,
-				Chunk:        p,
-			},
+			Data: &api.Data{ConnectionId: connID, Chunk: chunk, Offset: offset},
+		},
+	})
+}
+
+func (c *Client) SendHalfClose(connID string, finalOffset uint64) error {
+	return c.send(&api.ClientToServer{
+		Message: &api.ClientToServer_HalfClose{
+			HalfClose: &api.HalfClose{ConnectionId: connID, FinalOffset: finalOffset},
+		},
+	})
+}
+
+func (c *Client) SendClose(connID string, reason api.CloseReason, finalOffset uint64) error {
+	return c.send(&api.ClientToServer{
+		Message: &api.ClientToServer_Close{
+			Close: &api.Close{ConnectionId: connID, Reason: reason, FinalOffset: finalOffset},
+		},
+	})
+}
+
+func (c *Client) SendReset(connID string, reason api.CloseReason, message string) error {
+	return c.send(&api.ClientToServer{
+		Message: &api.ClientToServer_ResetConnection{
+			ResetConnection: &api.Reset{ConnectionId: connID, Reason: reason, Message: message},
+		},
+	})
+}
+
+func (c *Client) SendWindowUpdate(connID string, increment uint32) error {
+	return c.send(&api.ClientToServer{
+		Message: &api.ClientToServer_WindowUpdate{
+			WindowUpdate: &api.WindowUpdate{ConnectionId: connID, Increment: increment},
 		},
 	})
-	if err != nil {
-		return 0, err
-	}
-	return len(p), nil
 }
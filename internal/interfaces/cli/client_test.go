@@ -0,0 +1,81 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/waste3d/ghost-tunnel/api"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestIsPermanent(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{status.Error(codes.Unauthenticated, "invalid token"), true},
+		{status.Error(codes.PermissionDenied, "denied"), true},
+		{status.Error(codes.NotFound, "tunnel not found"), true},
+		{status.Error(codes.InvalidArgument, "bad register"), true},
+		{status.Error(codes.Aborted, "taken over"), true},
+		{status.Error(codes.Unavailable, "server restarting"), false},
+		{status.Error(codes.Internal, "stream broken"), false},
+		{status.Error(codes.DeadlineExceeded, "timeout"), false},
+		{errors.New("connection reset by peer"), false},
+	}
+	for _, tt := range tests {
+		if got := isPermanent(tt.err); got != tt.want {
+			t.Errorf("isPermanent(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+// takeoverServer регистрирует агента и сразу отдаёт туннель другому,
+// как TunnelServer при подключении нового агента.
+type takeoverServer struct {
+	api.UnimplementedTunnelServiceServer
+	registers atomic.Int32
+}
+
+func (s *takeoverServer) EstablishTunnel(stream api.TunnelService_EstablishTunnelServer) error {
+	if _, err := stream.Recv(); err != nil {
+		return err
+	}
+	s.registers.Add(1)
+	if err := stream.Send(&api.ServerToClient{Message: &api.ServerToClient_Registered{Registered: &api.Registered{ResumeToken: "token"}}}); err != nil {
+		return err
+	}
+	return status.Error(codes.Aborted, "tunnel session was taken over by a newer connection")
+}
+
+func TestClientStopsOnTakeover(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	server := &takeoverServer{}
+	grpcServer := grpc.NewServer()
+	api.RegisterTunnelServiceServer(grpcServer, server)
+	go grpcServer.Serve(lis)
+	t.Cleanup(grpcServer.Stop)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = NewClient("key", Route{TunnelID: "tunnel", LocalAddr: "127.0.0.1:1"}).Run(ctx, lis.Addr().String())
+	if ctx.Err() != nil {
+		t.Fatal("Run kept reconnecting after the tunnel was taken over")
+	}
+	if err == nil || !strings.Contains(err.Error(), "taken over by another agent") {
+		t.Errorf("Run() = %v, want takeover error", err)
+	}
+	if n := server.registers.Load(); n != 1 {
+		t.Errorf("agent registered %d times, want 1", n)
+	}
+}
@@ -1,24 +1,31 @@
 package cli
 
 import (
-	"context"
-	"log"
-
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/waste3d/ghost-tunnel/internal/infrastructure/logging"
 )
 
 func newConnectCmd() *cobra.Command {
 	var serverAddr string
 	var tunnelID string
 	var localAddr string
+	var apiKey string
 
 	cmd := &cobra.Command{
 		Use:   "connect",
 		Short: "Connect to the server and start a tunnel",
 		Run: func(cmd *cobra.Command, args []string) {
-			client := NewClient(tunnelID, localAddr)
-			if err := client.Run(context.Background(), serverAddr); err != nil {
-				log.Fatalf("Client error: %v", err)
+			if apiKey == "" {
+				apiKey = viper.GetString("api_key")
+			}
+			if apiKey == "" {
+				logging.Fatal("Not logged in. Please run 'ghost-tunnel login' first or pass --api-key")
+			}
+
+			client := NewClient(apiKey, Route{TunnelID: tunnelID, LocalAddr: localAddr})
+			if err := client.Run(cmd.Context(), serverAddr); err != nil {
+				logging.Fatal("Client error", logging.Err(err))
 			}
 		},
 	}
@@ -27,6 +34,7 @@ func newConnectCmd() *cobra.Command {
 	cmd.Flags().StringVarP(&serverAddr, "server", "s", "localhost:50051", "Server address")
 	cmd.Flags().StringVarP(&tunnelID, "tunnel-id", "t", "", "Tunnel ID to connect to")
 	cmd.Flags().StringVarP(&localAddr, "local", "l", "localhost:8080", "Local address to forward traffic to (host:port)")
+	cmd.Flags().StringVar(&apiKey, "api-key", "", "API key to authenticate with (defaults to the one saved by 'login')")
 
 	_ = cmd.MarkFlagRequired("tunnel-id")
 
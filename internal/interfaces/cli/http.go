@@ -2,19 +2,26 @@ package cli
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"github.com/waste3d/ghost-tunnel/internal/infrastructure/logging"
+	"github.com/waste3d/ghost-tunnel/internal/interfaces/cli/inspector"
 )
 
 func newHttpCmd() *cobra.Command {
-	var serverAPI, serverGRPC, subdomain string
+	var serverAPI, serverGRPC, subdomain, inspectAddr string
+	var httpsRedirect bool
 
 	cmd := &cobra.Command{
 		Use:   "http [port]",
@@ -32,48 +39,115 @@ func newHttpCmd() *cobra.Command {
 				return fmt.Errorf("not logged in. Please run 'ghost-tunnel login' first")
 			}
 
-			// 2. Делаем запрос на создание туннеля
-			reqBody, _ := json.Marshal(map[string]interface{}{
-				"subdomain": subdomain, // Будет пустым, если не указан флаг
-				"localport": localPort,
+			// 2. Создаём туннель или переиспользуем закреплённый поддомен
+			result, created, err := requestTunnel(serverAPI, apiKey, map[string]interface{}{
+				"protocol":      "http",
+				"subdomain":     subdomain, // Будет пустым, если не указан флаг
+				"localport":     localPort,
+				"httpsredirect": httpsRedirect,
 			})
-
-			client := &http.Client{}
-			req, _ := http.NewRequest("POST", fmt.Sprintf("%s/tunnels", serverAPI), bytes.NewBuffer(reqBody))
-			req.Header.Set("Content-Type", "application/json")
-			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", apiKey))
-
-			resp, err := client.Do(req)
 			if err != nil {
-				return fmt.Errorf("could not create tunnel: %w", err)
-			}
-			defer resp.Body.Close()
-
-			if resp.StatusCode != http.StatusCreated {
-				bodyBytes, _ := io.ReadAll(resp.Body)
-				return fmt.Errorf("failed to create tunnel (status %d): %s", resp.StatusCode, string(bodyBytes))
+				return err
 			}
 
-			var result map[string]interface{}
-			json.NewDecoder(resp.Body).Decode(&result)
-
 			tunnelID := result["ID"].(string)
 			endpoint := result["Endpoints"].(map[string]interface{})
 			publicSubdomain := endpoint["Subdomain"].(string)
 			publicDomain := endpoint["Domain"].(string)
 
 			// 3. Выводим красивый URL
-			log.Printf("Tunnel created successfully!")
-			log.Printf("Forwarding http://%s.%s -> http://localhost:%d", publicSubdomain, publicDomain, localPort)
+			local := fmt.Sprintf("http://localhost:%d", localPort)
+			logTunnel(result, created, logging.KeySubdomain, publicSubdomain)
+			slog.Info("Forwarding", "public", fmt.Sprintf("https://%s.%s", publicSubdomain, publicDomain), "local", local)
+			if !httpsRedirect {
+				slog.Info("Forwarding", "public", fmt.Sprintf("http://%s.%s", publicSubdomain, publicDomain), "local", local)
+			}
 
 			// 4. Запускаем gRPC-клиент с полученным ID
-			tunnelClient := NewClient(tunnelID, fmt.Sprintf("localhost:%d", localPort))
+			localAddr := fmt.Sprintf("localhost:%d", localPort)
+			insp := startInspector(cmd.Context(), inspectAddr, localAddr)
+			tunnelClient := NewClient(apiKey, Route{TunnelID: tunnelID, LocalAddr: localAddr, Inspector: insp})
 			return tunnelClient.Run(cmd.Context(), serverGRPC)
 		},
 	}
 
 	cmd.Flags().StringVar(&serverAPI, "api-server", "https://api.gtunnel.ru", "The address of the API server")
 	cmd.Flags().StringVar(&serverGRPC, "grpc-server", "83.166.247.105:50051", "The address of the gRPC server")
-	cmd.Flags().StringVar(&subdomain, "subdomain", "", "Request a specific subdomain (if available)")
+	cmd.Flags().StringVar(&subdomain, "subdomain", "", "Request a specific subdomain; it stays reserved for you and is reused on later runs")
+	cmd.Flags().BoolVar(&httpsRedirect, "https-redirect", false, "Redirect plain HTTP requests to HTTPS")
+	cmd.Flags().StringVar(&inspectAddr, "inspect", "127.0.0.1:4040", "Address of the local request inspector web UI (empty to disable)")
 	return cmd
 }
+
+// startInspector поднимает веб-интерфейс инспектора до отмены ctx; повторы
+// запросов уходят на localAddr. Если адрес занят, туннель работает без инспектора.
+func startInspector(ctx context.Context, addr, localAddr string) *inspector.Inspector {
+	if addr == "" {
+		return nil
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		slog.Warn("Request inspector is disabled", "addr", addr, logging.Err(err))
+		return nil
+	}
+
+	insp := inspector.New(localAddr, inspector.DefaultHistory)
+	// потоки событий завершаются вместе с ctx, иначе Shutdown ждал бы их
+	server := &http.Server{Handler: insp.Handler(), BaseContext: func(net.Listener) context.Context { return ctx }}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Warn("Request inspector stopped", logging.Err(err))
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	// инспектор отвечает только на Host localhost, см. inspector.Handler
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	slog.Info("Request inspector", "url", "http://localhost:"+port)
+	return insp
+}
+
+// requestTunnel создаёт туннель через API сервера и возвращает его описание.
+// Если поддомен уже закреплён за пользователем, сервер отдаёт существующий
+// туннель, тогда created ложен.
+func requestTunnel(serverAPI, apiKey string, params map[string]interface{}) (result map[string]interface{}, created bool, err error) {
+	reqBody, _ := json.Marshal(params)
+
+	client := &http.Client{}
+	req, _ := http.NewRequest("POST", fmt.Sprintf("%s/tunnels", serverAPI), bytes.NewBuffer(reqBody))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", apiKey))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, false, fmt.Errorf("could not create tunnel: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, false, fmt.Errorf("failed to create tunnel (status %d): %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	json.NewDecoder(resp.Body).Decode(&result)
+	return result, resp.StatusCode == http.StatusCreated, nil
+}
+
+// logTunnel сообщает, создан туннель или переиспользован закреплённый. Агента,
+// который уже подключён к закреплённому туннелю, сервер отключит.
+func logTunnel(result map[string]interface{}, created bool, attrs ...any) {
+	attrs = append([]any{logging.KeyTunnelID, result["ID"].(string)}, attrs...)
+	if created {
+		slog.Info("Tunnel created successfully!", attrs...)
+		return
+	}
+	slog.Info("Reattached to reserved tunnel", attrs...)
+	if online, _ := result["Online"].(bool); online {
+		slog.Warn("Another agent is serving this tunnel and will be disconnected", attrs...)
+	}
+}
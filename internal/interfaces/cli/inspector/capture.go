@@ -0,0 +1,251 @@
+package inspector
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// pendingRequests — сколько запросов соединения может ждать ответа
+	// (HTTP pipelining); больше на практике не встречается
+	pendingRequests = 64
+	// teeBuffer — сколько прочитанных кусков может ждать разбора
+	teeBuffer = 64
+)
+
+var (
+	errUpgraded        = errors.New("connection upgraded, capture stopped")
+	errCaptureOverflow = errors.New("capture fell behind the connection, capture stopped")
+)
+
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// Wrap оборачивает соединение с локальным сервисом: записанное в него
+// разбирается как HTTP-запросы, прочитанное — как ответы. Разбор идёт
+// параллельно с передачей данных; если поток не похож на HTTP или
+// соединение переключено на другой протокол, разбор прекращается, а
+// данные продолжают идти как есть.
+func (i *Inspector) Wrap(connID string, local net.Conn) net.Conn {
+	reqR, reqW := io.Pipe()
+	respR, respW := io.Pipe()
+	pending := make(chan *Exchange, pendingRequests)
+
+	go i.readRequests(connID, reqR, pending)
+	go i.readResponses(respR, pending)
+
+	return &tapConn{Conn: local, requests: newTee(reqW), responses: newTee(respW)}
+}
+
+// readRequests разбирает запросы посетителей. Запрос попадает в историю
+// сразу после заголовков, чтобы разбор ответа не ждал тела запроса.
+func (i *Inspector) readRequests(connID string, r *io.PipeReader, pending chan<- *Exchange) {
+	defer close(pending)
+	br := bufio.NewReader(r)
+	for {
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			r.CloseWithError(err)
+			return
+		}
+		ex := i.add(connID, Message{
+			Method: req.Method,
+			URL:    req.RequestURI,
+			Proto:  req.Proto,
+			Header: withHost(req.Header, req.Host),
+		})
+		ex.method = req.Method
+		pending <- ex
+
+		body, size, err := readBody(req.Body)
+		i.update(ex, func(ex *Exchange) {
+			ex.Request.Body = body
+			ex.Request.BodySize = size
+		})
+		if err != nil {
+			r.CloseWithError(err)
+			return
+		}
+	}
+}
+
+// readResponses разбирает ответы локального сервиса в порядке запросов.
+func (i *Inspector) readResponses(r *io.PipeReader, pending <-chan *Exchange) {
+	// после сбоя разбора запросы всё равно забираем, иначе встанет разбор запросов
+	defer func() {
+		for ex := range pending {
+			i.update(ex, func(ex *Exchange) { ex.Error = "response was not captured" })
+		}
+	}()
+
+	br := bufio.NewReader(r)
+	for ex := range pending {
+		resp, err := readFinalResponse(br, ex.method)
+		if err != nil {
+			i.update(ex, func(ex *Exchange) { ex.Error = "failed to parse response: " + err.Error() })
+			r.CloseWithError(err)
+			return
+		}
+		msg := &Message{Status: resp.StatusCode, Proto: resp.Proto, Header: resp.Header}
+		if resp.StatusCode == http.StatusSwitchingProtocols {
+			i.finish(ex, msg)
+			r.CloseWithError(errUpgraded)
+			return
+		}
+
+		msg.Body, msg.BodySize, err = readBody(resp.Body)
+		i.finish(ex, msg)
+		if err != nil {
+			r.CloseWithError(err)
+			return
+		}
+	}
+	r.CloseWithError(io.ErrUnexpectedEOF)
+}
+
+// readFinalResponse пропускает промежуточные ответы вроде 100 Continue.
+func readFinalResponse(br *bufio.Reader, method string) (*http.Response, error) {
+	for {
+		resp, err := http.ReadResponse(br, &http.Request{Method: method})
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode >= 200 || resp.StatusCode == http.StatusSwitchingProtocols {
+			return resp, nil
+		}
+	}
+}
+
+func (i *Inspector) finish(ex *Exchange, resp *Message) {
+	i.update(ex, func(ex *Exchange) {
+		ex.Response = resp
+		ex.Duration = time.Since(ex.StartedAt)
+	})
+}
+
+// readBody сохраняет начало тела и дочитывает остальное, чтобы дойти до
+// следующего сообщения в потоке.
+func readBody(body io.ReadCloser) ([]byte, int64, error) {
+	defer body.Close()
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, io.LimitReader(body, maxBodySize))
+	if err != nil {
+		return buf.Bytes(), n, err
+	}
+	rest, err := io.Copy(io.Discard, body)
+	return buf.Bytes(), n + rest, err
+}
+
+// withHost возвращает заголовки вместе с Host, который net/http выносит в отдельное поле.
+func withHost(header http.Header, host string) http.Header {
+	header = header.Clone()
+	if host != "" {
+		header.Set("Host", host)
+	}
+	return header
+}
+
+// tapConn копирует данные соединения в разборщики.
+type tapConn struct {
+	net.Conn
+	requests  *tee
+	responses *tee
+}
+
+// Write копирует запрос до отправки, чтобы ответ сервиса не опередил его в разборе.
+func (c *tapConn) Write(p []byte) (int, error) {
+	c.requests.write(p)
+	return c.Conn.Write(p)
+}
+
+func (c *tapConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.responses.write(p[:n])
+	if err != nil {
+		c.responses.close()
+	}
+	return n, err
+}
+
+func (c *tapConn) CloseWrite() error {
+	c.requests.close()
+	if cw, ok := c.Conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return c.Conn.Close()
+}
+
+func (c *tapConn) Close() error {
+	c.requests.close()
+	c.responses.close()
+	return c.Conn.Close()
+}
+
+// tee передаёт копию данных в разборщик через очередь, чтобы медленный или
+// остановившийся разбор не задерживал само соединение. Если очередь
+// переполнена, разбор этого направления прекращается.
+type tee struct {
+	chunks  chan []byte
+	stopped atomic.Bool
+
+	mu     sync.Mutex
+	closed bool
+	err    error
+}
+
+func newTee(w *io.PipeWriter) *tee {
+	t := &tee{chunks: make(chan []byte, teeBuffer)}
+	go t.pump(w)
+	return t
+}
+
+func (t *tee) pump(w *io.PipeWriter) {
+	for chunk := range t.chunks {
+		if _, err := w.Write(chunk); err != nil {
+			// разборщик остановился, остальное ему не нужно
+			t.stopped.Store(true)
+			for range t.chunks {
+			}
+			return
+		}
+	}
+	w.CloseWithError(t.err)
+}
+
+func (t *tee) write(p []byte) {
+	if len(p) == 0 || t.stopped.Load() {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return
+	}
+	select {
+	case t.chunks <- bytes.Clone(p):
+	default:
+		t.closeLocked(errCaptureOverflow)
+	}
+}
+
+func (t *tee) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.closed {
+		t.closeLocked(nil)
+	}
+}
+
+func (t *tee) closeLocked(err error) {
+	t.closed = true
+	t.err = err
+	close(t.chunks)
+}
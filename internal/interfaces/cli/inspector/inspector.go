@@ -0,0 +1,137 @@
+// Package inspector показывает HTTP-запросы, прошедшие через туннель, в
+// локальном веб-интерфейсе клиента.
+package inspector
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultHistory — сколько последних запросов хранится по умолчанию
+	DefaultHistory = 100
+	// maxBodySize — сколько байт тела запроса и ответа сохраняется, остальное
+	// только считается
+	maxBodySize = 256 << 10
+	// subscriberBuffer — очередь обновлений одного подписчика; медленный
+	// подписчик пропускает обновления, а не тормозит туннель
+	subscriberBuffer = 64
+)
+
+// Message — запрос или ответ. Для запроса заполнены Method и URL, для ответа — Status.
+type Message struct {
+	Method string
+	URL    string
+	Status int
+	Proto  string
+	Header http.Header
+	Body   []byte
+	// BodySize — полный размер тела; больше len(Body), если тело обрезано
+	BodySize int64
+}
+
+// Exchange — запрос и ответ на него. Response равен nil, пока ответ не получен.
+type Exchange struct {
+	ID        int64
+	ConnID    string
+	StartedAt time.Time
+	Duration  time.Duration
+	Request   Message
+	Response  *Message
+	// Error — почему ответ не удалось разобрать или повтор не удался
+	Error string
+	// ReplayOf — ID запроса, повтором которого является этот; 0 для запросов посетителей
+	ReplayOf int64
+
+	// method нужен разбору ответа: ответ на HEAD приходит без тела
+	method string
+}
+
+// Inspector хранит ограниченную историю запросов и рассылает её изменения подписчикам.
+type Inspector struct {
+	// target — адрес локального сервиса, куда отправляются повторы
+	target string
+	limit  int
+
+	mu          sync.Mutex
+	nextID      int64
+	history     []*Exchange
+	subscribers map[chan Exchange]struct{}
+}
+
+func New(target string, limit int) *Inspector {
+	if limit <= 0 {
+		limit = DefaultHistory
+	}
+	return &Inspector{target: target, limit: limit, subscribers: make(map[chan Exchange]struct{})}
+}
+
+// List возвращает копии запросов в истории, от новых к старым.
+func (i *Inspector) List() []Exchange {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	list := make([]Exchange, 0, len(i.history))
+	for n := len(i.history) - 1; n >= 0; n-- {
+		list = append(list, *i.history[n])
+	}
+	return list
+}
+
+// Get возвращает копию запроса по ID, если он ещё в истории.
+func (i *Inspector) Get(id int64) (Exchange, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for _, ex := range i.history {
+		if ex.ID == id {
+			return *ex, true
+		}
+	}
+	return Exchange{}, false
+}
+
+// Subscribe возвращает канал обновлений: новый запрос или полученный ответ.
+// Вызовите cancel, когда обновления больше не нужны.
+func (i *Inspector) Subscribe() (updates <-chan Exchange, cancel func()) {
+	ch := make(chan Exchange, subscriberBuffer)
+	i.mu.Lock()
+	i.subscribers[ch] = struct{}{}
+	i.mu.Unlock()
+	return ch, func() {
+		i.mu.Lock()
+		delete(i.subscribers, ch)
+		i.mu.Unlock()
+	}
+}
+
+// add заводит запрос, у которого пока разобраны только заголовки.
+func (i *Inspector) add(connID string, req Message) *Exchange {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.nextID++
+	ex := &Exchange{ID: i.nextID, ConnID: connID, StartedAt: time.Now(), Request: req}
+	if len(i.history) == i.limit {
+		i.history[0] = nil
+		i.history = i.history[1:]
+	}
+	i.history = append(i.history, ex)
+	i.publish(ex)
+	return ex
+}
+
+// update меняет запрос под мьютексом и рассылает его новую версию.
+func (i *Inspector) update(ex *Exchange, apply func(*Exchange)) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	apply(ex)
+	i.publish(ex)
+}
+
+func (i *Inspector) publish(ex *Exchange) {
+	for ch := range i.subscribers {
+		select {
+		case ch <- *ex:
+		default:
+		}
+	}
+}
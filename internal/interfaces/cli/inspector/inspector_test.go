@@ -0,0 +1,342 @@
+package inspector
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// serveLocal изображает локальный сервис: на каждый запрос отвечает
+// respond(req) и закрывает соединение после последнего.
+func serveLocal(t *testing.T, conn net.Conn, requests int, respond func(*http.Request) string) {
+	t.Helper()
+	go func() {
+		defer conn.Close()
+		br := bufio.NewReader(conn)
+		for range requests {
+			req, err := http.ReadRequest(br)
+			if err != nil {
+				return
+			}
+			io.Copy(io.Discard, req.Body)
+			if _, err := io.WriteString(conn, respond(req)); err != nil {
+				return
+			}
+		}
+	}()
+}
+
+// roundTrip отправляет сырые запросы через обёрнутое соединение и читает всё, что ответит сервис.
+func roundTrip(t *testing.T, insp *Inspector, requests int, raw string, respond func(*http.Request) string) {
+	t.Helper()
+	tunnelSide, localSide := net.Pipe()
+	serveLocal(t, localSide, requests, respond)
+
+	conn := insp.Wrap("conn-1", tunnelSide)
+	go io.WriteString(conn, raw)
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+// waitComplete ждёт, пока у n запросов в истории появятся ответы.
+func waitComplete(t *testing.T, insp *Inspector, n int) []Exchange {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		list := insp.List()
+		done := 0
+		for _, ex := range list {
+			if ex.Response != nil || ex.Error != "" {
+				done++
+			}
+		}
+		if len(list) == n && done == n {
+			return list
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("history has %d exchanges, %d complete; want %d", len(list), done, n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWrapCapturesExchange(t *testing.T) {
+	insp := New("", 10)
+	raw := "POST /api/items?x=1 HTTP/1.1\r\nHost: app.example.test\r\nContent-Type: application/json\r\nContent-Length: 9\r\n\r\n{\"a\": 1}\n"
+	roundTrip(t, insp, 1, raw, func(*http.Request) string {
+		return "HTTP/1.1 201 Created\r\nContent-Type: text/plain\r\nContent-Length: 2\r\n\r\nok"
+	})
+
+	ex := waitComplete(t, insp, 1)[0]
+	if ex.ConnID != "conn-1" || ex.Request.Method != "POST" || ex.Request.URL != "/api/items?x=1" {
+		t.Errorf("unexpected request %+v", ex.Request)
+	}
+	if got := ex.Request.Header.Get("Host"); got != "app.example.test" {
+		t.Errorf("Host header = %q", got)
+	}
+	if string(ex.Request.Body) != "{\"a\": 1}\n" || ex.Request.BodySize != 9 {
+		t.Errorf("request body = %q (%d bytes)", ex.Request.Body, ex.Request.BodySize)
+	}
+	if ex.Response.Status != http.StatusCreated || string(ex.Response.Body) != "ok" {
+		t.Errorf("unexpected response %+v", ex.Response)
+	}
+}
+
+func TestWrapCapturesPipelinedRequests(t *testing.T) {
+	insp := New("", 10)
+	raw := "GET /first HTTP/1.1\r\nHost: a\r\n\r\n" +
+		"HEAD /second HTTP/1.1\r\nHost: a\r\n\r\n" +
+		"GET /third HTTP/1.1\r\nHost: a\r\nConnection: close\r\n\r\n"
+	roundTrip(t, insp, 3, raw, func(req *http.Request) string {
+		if req.Method == http.MethodHead {
+			// ответ на HEAD без тела, несмотря на Content-Length
+			return "HTTP/1.1 200 OK\r\nContent-Length: 100\r\n\r\n"
+		}
+		return "HTTP/1.1 404 Not Found\r\nContent-Length: 1\r\n\r\n" + req.URL.Path[1:2]
+	})
+
+	list := waitComplete(t, insp, 3)
+	want := []struct {
+		url    string
+		status int
+		body   string
+	}{{"/third", 404, "t"}, {"/second", 200, ""}, {"/first", 404, "f"}}
+	for n, w := range want {
+		ex := list[n]
+		if ex.Request.URL != w.url || ex.Response == nil || ex.Response.Status != w.status || string(ex.Response.Body) != w.body {
+			t.Errorf("exchange %d: got %s %+v, want %s %d %q", n, ex.Request.URL, ex.Response, w.url, w.status, w.body)
+		}
+	}
+}
+
+func TestWrapTruncatesLargeBodies(t *testing.T) {
+	insp := New("", 10)
+	size := maxBodySize + 1000
+	roundTrip(t, insp, 1, "GET / HTTP/1.1\r\nHost: a\r\n\r\n", func(*http.Request) string {
+		return "HTTP/1.1 200 OK\r\nContent-Length: " + strconv.Itoa(size) + "\r\n\r\n" + strings.Repeat("x", size)
+	})
+
+	ex := waitComplete(t, insp, 1)[0]
+	if len(ex.Response.Body) != maxBodySize || ex.Response.BodySize != int64(size) {
+		t.Errorf("kept %d of %d bytes, want %d of %d", len(ex.Response.Body), ex.Response.BodySize, maxBodySize, size)
+	}
+}
+
+func TestWrapPassesThroughNonHTTP(t *testing.T) {
+	insp := New("", 10)
+	tunnelSide, localSide := net.Pipe()
+	go func() {
+		defer localSide.Close()
+		buf := make([]byte, 5)
+		io.ReadFull(localSide, buf)
+		localSide.Write([]byte("pong"))
+	}()
+
+	conn := insp.Wrap("conn-1", tunnelSide)
+	go conn.Write([]byte("\x00\x01\x02\x03\x04"))
+	got, err := io.ReadAll(conn)
+	if err != nil || string(got) != "pong" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+	conn.Close()
+	if list := insp.List(); len(list) != 0 {
+		t.Errorf("captured %d exchanges from non-HTTP traffic", len(list))
+	}
+}
+
+func TestHistoryIsBounded(t *testing.T) {
+	insp := New("", 2)
+	updates, cancel := insp.Subscribe()
+	defer cancel()
+
+	for _, url := range []string{"/1", "/2", "/3"} {
+		insp.add("c", Message{Method: "GET", URL: url})
+	}
+	list := insp.List()
+	if len(list) != 2 || list[0].Request.URL != "/3" || list[1].Request.URL != "/2" {
+		t.Errorf("unexpected history %+v", list)
+	}
+	if _, ok := insp.Get(1); ok {
+		t.Error("oldest exchange should have been dropped")
+	}
+	if len(updates) != 3 {
+		t.Errorf("subscriber got %d updates, want 3", len(updates))
+	}
+}
+
+func TestReplay(t *testing.T) {
+	var got *http.Request
+	var gotBody string
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		got, gotBody = r, string(data)
+		w.WriteHeader(http.StatusAccepted)
+		io.WriteString(w, "replayed")
+	}))
+	defer target.Close()
+
+	insp := New(strings.TrimPrefix(target.URL, "http://"), 10)
+	orig := insp.add("c", Message{
+		Method: "POST",
+		URL:    "/hooks/stripe?v=1",
+		Header: http.Header{"Host": {"app.example.test"}, "Stripe-Signature": {"old"}, "X-Debug": {"1"}, "Content-Length": {"7"}},
+		Body:   []byte(`{"a":1}`), BodySize: 7,
+	})
+
+	body := []byte(`{"a":2}`)
+	ex, err := insp.Replay(context.Background(), orig.ID, ReplayEdit{
+		Header:       http.Header{"stripe-signature": {"new"}},
+		RemoveHeader: []string{"X-Debug"},
+		Body:         &body,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ex.ReplayOf != orig.ID || ex.Response == nil || ex.Response.Status != http.StatusAccepted || string(ex.Response.Body) != "replayed" {
+		t.Errorf("unexpected replay %+v", ex)
+	}
+	if got.Method != "POST" || got.RequestURI != "/hooks/stripe?v=1" || got.Host != "app.example.test" || gotBody != `{"a":2}` {
+		t.Errorf("target got %s %s host=%s body=%q", got.Method, got.RequestURI, got.Host, gotBody)
+	}
+	if got.Header.Get("Stripe-Signature") != "new" || got.Header.Get("X-Debug") != "" || got.Header.Get("User-Agent") != "" {
+		t.Errorf("target got headers %v", got.Header)
+	}
+
+	if _, err := insp.Replay(context.Background(), 42, ReplayEdit{}); !errors.Is(err, ErrRequestNotFound) {
+		t.Errorf("Replay(unknown) = %v, want ErrRequestNotFound", err)
+	}
+	truncated := insp.add("c", Message{Method: "POST", URL: "/", Body: []byte("part"), BodySize: 100})
+	if _, err := insp.Replay(context.Background(), truncated.ID, ReplayEdit{}); !errors.Is(err, ErrBodyTruncated) {
+		t.Errorf("Replay(truncated) = %v, want ErrBodyTruncated", err)
+	}
+}
+
+func TestRenderBody(t *testing.T) {
+	tests := []struct {
+		contentType string
+		data        string
+		want        body
+	}{
+		{"application/json; charset=utf-8", `{"a":[1,2]}`, body{Text: `{"a":[1,2]}`, Pretty: "{\n  \"a\": [\n    1,\n    2\n  ]\n}"}},
+		{"application/problem+json", `{"b":true}`, body{Text: `{"b":true}`, Pretty: "{\n  \"b\": true\n}"}},
+		{"application/json", `{broken`, body{Text: `{broken`}},
+		{"application/x-www-form-urlencoded", "name=ghost&tag=b&tag=a", body{Text: "name=ghost&tag=b&tag=a", Pretty: "name: ghost\ntag: b\ntag: a\n"}},
+		{"text/plain", "hello", body{Text: "hello"}},
+		{"image/png", "\x89PNG\xff", body{Binary: true}},
+		{"", "", body{}},
+	}
+	for _, tt := range tests {
+		if got := renderBody(tt.contentType, []byte(tt.data)); got != tt.want {
+			t.Errorf("renderBody(%q, %q) = %+v, want %+v", tt.contentType, tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestHandlerRejectsForeignHost(t *testing.T) {
+	insp := New("", 10)
+	insp.add("c", Message{Method: "GET", URL: "/", Header: http.Header{"Cookie": {"session=secret"}}})
+	server := httptest.NewServer(insp.Handler())
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+
+	tests := []struct {
+		host string
+		want int
+	}{
+		{"127.0.0.1:" + port, http.StatusOK},
+		{"localhost:" + port, http.StatusOK},
+		{"LOCALHOST:" + port, http.StatusOK},
+		{"[::1]:" + port, http.StatusOK},
+		{"rebind.attacker.example:" + port, http.StatusForbidden},
+		{"127.0.0.1", http.StatusForbidden},
+		{"127.0.0.1:1", http.StatusForbidden},
+		{"localhost.attacker.example:" + port, http.StatusForbidden},
+	}
+	for _, path := range []string{"/", "/api/requests", "/api/requests/1"} {
+		for _, tt := range tests {
+			req, _ := http.NewRequest(http.MethodGet, server.URL+path, nil)
+			req.Host = tt.host
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("GET %s with Host %s = %d, want %d", path, tt.host, resp.StatusCode, tt.want)
+			}
+		}
+	}
+
+	// поток событий тоже закрыт для чужого Host
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/api/events", nil)
+	req.Host = "rebind.attacker.example:" + port
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("GET /api/events with a foreign Host = %d, want 403", resp.StatusCode)
+	}
+}
+
+func TestHandlerReplayRejectsCrossOrigin(t *testing.T) {
+	var replays atomic.Int32
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		replays.Add(1)
+	}))
+	defer target.Close()
+
+	insp := New(strings.TrimPrefix(target.URL, "http://"), 10)
+	orig := insp.add("c", Message{Method: "POST", URL: "/", Header: http.Header{"Host": {"app.example.test"}}})
+	server := httptest.NewServer(insp.Handler())
+	defer server.Close()
+
+	tests := []struct {
+		name, contentType, origin string
+		want                      int
+	}{
+		{"replay command", "application/json", "", http.StatusOK},
+		{"inspector page", "application/json; charset=utf-8", server.URL, http.StatusOK},
+		{"simple cross-origin post", "text/plain", "https://attacker.example", http.StatusForbidden},
+		{"text/plain without origin", "text/plain", "", http.StatusUnsupportedMediaType},
+		{"form post", "application/x-www-form-urlencoded", "", http.StatusUnsupportedMediaType},
+		{"no content type", "", "", http.StatusUnsupportedMediaType},
+		{"json from another origin", "application/json", "http://attacker.example", http.StatusForbidden},
+		{"json from null origin", "application/json", "null", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := replays.Load()
+			req, _ := http.NewRequest(http.MethodPost, server.URL+"/api/requests/"+strconv.FormatInt(orig.ID, 10)+"/replay", strings.NewReader("{}"))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+			if replayed := replays.Load() != before; replayed != (tt.want == http.StatusOK) {
+				t.Errorf("request replayed = %v, want %v", replayed, tt.want == http.StatusOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package inspector
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+var (
+	ErrRequestNotFound = errors.New("request is no longer in history")
+	ErrBodyTruncated   = errors.New("request body was truncated when captured, provide a body to replay it")
+	ErrNoTarget        = errors.New("inspector has no local service to replay to")
+)
+
+// replayTimeout ограничивает ожидание ответа локального сервиса
+const replayTimeout = 30 * time.Second
+
+// ReplayEdit — правки запроса перед повтором. Header заменяет значения
+// перечисленных заголовков, RemoveHeader удаляет заголовки, Body, если не
+// nil, заменяет тело целиком.
+type ReplayEdit struct {
+	Header       http.Header
+	RemoveHeader []string
+	Body         *[]byte
+}
+
+// replayClient ходит только к локальному сервису: без прокси, сжатия,
+// редиректов и переиспользования соединений, чтобы повтор был похож на
+// исходный запрос.
+var replayClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:              nil,
+		DisableCompression: true,
+		DisableKeepAlives:  true,
+	},
+	CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+}
+
+// Replay повторно отправляет сохранённый запрос локальному сервису.
+// Повтор попадает в историю отдельной записью с ReplayOf, равным id
+// исходной; её копия возвращается и тогда, когда сервис не ответил.
+func (i *Inspector) Replay(ctx context.Context, id int64, edit ReplayEdit) (Exchange, error) {
+	if i.target == "" {
+		return Exchange{}, ErrNoTarget
+	}
+	orig, ok := i.Get(id)
+	if !ok {
+		return Exchange{}, ErrRequestNotFound
+	}
+
+	msg := Message{
+		Method: orig.Request.Method,
+		URL:    orig.Request.URL,
+		Proto:  "HTTP/1.1",
+		Header: orig.Request.Header.Clone(),
+		Body:   orig.Request.Body,
+	}
+	if edit.Body != nil {
+		msg.Body = *edit.Body
+	} else if int64(len(msg.Body)) < orig.Request.BodySize {
+		return Exchange{}, ErrBodyTruncated
+	}
+	msg.BodySize = int64(len(msg.Body))
+	if msg.Header == nil {
+		msg.Header = make(http.Header)
+	}
+	for _, key := range edit.RemoveHeader {
+		msg.Header.Del(key)
+	}
+	for key, values := range edit.Header {
+		msg.Header[http.CanonicalHeaderKey(key)] = values
+	}
+	// длину тела и способ передачи выставляет клиент по новому телу
+	msg.Header.Del("Content-Length")
+	msg.Header.Del("Transfer-Encoding")
+
+	req, err := i.replayRequest(ctx, &msg)
+	if err != nil {
+		return Exchange{}, err
+	}
+
+	ex := i.add("", msg)
+	i.update(ex, func(ex *Exchange) { ex.ReplayOf = id })
+
+	ctx, cancel := context.WithTimeout(req.Context(), replayTimeout)
+	defer cancel()
+	resp, err := replayClient.Do(req.WithContext(ctx))
+	if err != nil {
+		i.update(ex, func(ex *Exchange) { ex.Error = "replay failed: " + err.Error() })
+		return i.snapshot(ex), nil
+	}
+	body, size, err := readBody(resp.Body)
+	i.finish(ex, &Message{Status: resp.StatusCode, Proto: resp.Proto, Header: resp.Header, Body: body, BodySize: size})
+	if err != nil {
+		i.update(ex, func(ex *Exchange) { ex.Error = "failed to read response: " + err.Error() })
+	}
+	return i.snapshot(ex), nil
+}
+
+// replayRequest собирает запрос к локальному сервису так, как его прислал посетитель.
+func (i *Inspector) replayRequest(ctx context.Context, msg *Message) (*http.Request, error) {
+	target, err := url.ParseRequestURI(msg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid request URL %q: %w", msg.URL, err)
+	}
+	target.Scheme = "http"
+	target.Host = i.target
+
+	req, err := http.NewRequestWithContext(ctx, msg.Method, target.String(), bytes.NewReader(msg.Body))
+	if err != nil {
+		return nil, fmt.Errorf("invalid request: %w", err)
+	}
+	req.Header = msg.Header.Clone()
+	req.Host = req.Header.Get("Host")
+	req.Header.Del("Host")
+	if _, ok := req.Header["User-Agent"]; !ok {
+		// иначе net/http подставит свой User-Agent
+		req.Header["User-Agent"] = nil
+	}
+	return req, nil
+}
+
+func (i *Inspector) snapshot(ex *Exchange) Exchange {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return *ex
+}
@@ -0,0 +1,321 @@
+package inspector
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+//go:embed ui/index.html
+var ui embed.FS
+
+// Handler отдаёт веб-интерфейс инспектора и его API:
+//
+//	GET /                   — страница со списком запросов
+//	GET /api/requests       — история, от новых к старым
+//	GET /api/requests/{id}  — запрос и ответ целиком
+//	POST /api/requests/{id}/replay — повтор запроса, см. ReplayRequest и checkSameOrigin
+//	GET /api/events         — обновления истории (Server-Sent Events)
+//
+// История содержит заголовки и тела целиком, вместе с cookie и токенами,
+// поэтому все маршруты отвечают только на запросы к localhost, см. localOnly.
+func (i *Inspector) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFileFS(w, r, ui, "ui/index.html")
+	})
+	mux.HandleFunc("GET /api/requests", i.listRequests)
+	mux.HandleFunc("GET /api/requests/{id}", i.getRequest)
+	mux.HandleFunc("POST /api/requests/{id}/replay", i.replay)
+	mux.HandleFunc("GET /api/events", i.events)
+	return localOnly(mux)
+}
+
+// localOnly отклоняет запросы, в Host которых не 127.0.0.1, localhost или
+// [::1] с портом самого инспектора. Так чужая страница не прочитает историю
+// через DNS rebinding: браузер пришлёт в Host имя её домена.
+func localOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isLocalHost(r) {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "the inspector only accepts requests to localhost"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isLocalHost(r *http.Request) bool {
+	addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	if !ok {
+		return false
+	}
+	_, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return false
+	}
+	host, hostPort, err := net.SplitHostPort(r.Host)
+	if err != nil || hostPort != port {
+		return false
+	}
+	switch strings.ToLower(host) {
+	case "127.0.0.1", "localhost", "::1":
+		return true
+	default:
+		return false
+	}
+}
+
+// exchangeSummary — строка списка запросов.
+type exchangeSummary struct {
+	ID         int64     `json:"id"`
+	ConnID     string    `json:"conn_id"`
+	StartedAt  time.Time `json:"started_at"`
+	DurationMs float64   `json:"duration_ms"`
+	Method     string    `json:"method"`
+	URL        string    `json:"url"`
+	Status     int       `json:"status,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	ReplayOf   int64     `json:"replay_of,omitempty"`
+}
+
+type exchangeDetail struct {
+	exchangeSummary
+	Request  messageDetail  `json:"request"`
+	Response *messageDetail `json:"response,omitempty"`
+}
+
+type messageDetail struct {
+	Proto     string      `json:"proto"`
+	Header    http.Header `json:"header"`
+	Body      body        `json:"body"`
+	Truncated bool        `json:"truncated,omitempty"`
+	BodySize  int64       `json:"body_size"`
+}
+
+// body — тело в виде для показа: Pretty заполнен для JSON и форм, Binary
+// означает, что тело не текст и показывается только его размер.
+type body struct {
+	Text   string `json:"text,omitempty"`
+	Pretty string `json:"pretty,omitempty"`
+	Binary bool   `json:"binary,omitempty"`
+}
+
+func newSummary(ex *Exchange) exchangeSummary {
+	s := exchangeSummary{
+		ID:         ex.ID,
+		ConnID:     ex.ConnID,
+		StartedAt:  ex.StartedAt,
+		DurationMs: float64(ex.Duration.Microseconds()) / 1000,
+		Method:     ex.Request.Method,
+		URL:        ex.Request.URL,
+		Error:      ex.Error,
+		ReplayOf:   ex.ReplayOf,
+	}
+	if ex.Response != nil {
+		s.Status = ex.Response.Status
+	}
+	return s
+}
+
+func newMessageDetail(m *Message) messageDetail {
+	return messageDetail{
+		Proto:     m.Proto,
+		Header:    m.Header,
+		Body:      renderBody(m.Header.Get("Content-Type"), m.Body),
+		Truncated: int64(len(m.Body)) < m.BodySize,
+		BodySize:  m.BodySize,
+	}
+}
+
+// renderBody готовит тело к показу: JSON выводится с отступами, форма —
+// построчно «ключ: значение».
+func renderBody(contentType string, data []byte) body {
+	if len(data) == 0 {
+		return body{}
+	}
+	if !utf8.Valid(data) {
+		return body{Binary: true}
+	}
+	b := body{Text: string(data)}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		var buf bytes.Buffer
+		if json.Indent(&buf, data, "", "  ") == nil {
+			b.Pretty = buf.String()
+		}
+	case mediaType == "application/x-www-form-urlencoded":
+		if values, err := url.ParseQuery(string(data)); err == nil {
+			b.Pretty = formatForm(values)
+		}
+	}
+	return b
+}
+
+func formatForm(values url.Values) string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	for _, key := range keys {
+		for _, value := range values[key] {
+			fmt.Fprintf(&sb, "%s: %s\n", key, value)
+		}
+	}
+	return sb.String()
+}
+
+func (i *Inspector) listRequests(w http.ResponseWriter, r *http.Request) {
+	list := i.List()
+	resp := make([]exchangeSummary, 0, len(list))
+	for n := range list {
+		resp = append(resp, newSummary(&list[n]))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (i *Inspector) getRequest(w http.ResponseWriter, r *http.Request) {
+	id, ok := requestID(w, r)
+	if !ok {
+		return
+	}
+	ex, ok := i.Get(id)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": ErrRequestNotFound.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, newExchangeDetail(&ex))
+}
+
+// ReplayRequest — тело POST /api/requests/{id}/replay; все поля необязательны.
+type ReplayRequest struct {
+	Header       http.Header `json:"header,omitempty"`
+	RemoveHeader []string    `json:"remove_header,omitempty"`
+	Body         *string     `json:"body,omitempty"`
+}
+
+// replay повторяет запрос и отвечает записью повтора. Ответ 200 означает,
+// что повтор выполнен; дошёл ли он до сервиса, видно по полю error.
+func (i *Inspector) replay(w http.ResponseWriter, r *http.Request) {
+	if err := checkSameOrigin(r); err != nil {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": err.Error()})
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		writeJSON(w, http.StatusUnsupportedMediaType, map[string]string{"error": "replay request must have Content-Type: application/json"})
+		return
+	}
+	id, ok := requestID(w, r)
+	if !ok {
+		return
+	}
+	var req ReplayRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid replay request: " + err.Error()})
+			return
+		}
+	}
+	edit := ReplayEdit{Header: req.Header, RemoveHeader: req.RemoveHeader}
+	if req.Body != nil {
+		body := []byte(*req.Body)
+		edit.Body = &body
+	}
+
+	ex, err := i.Replay(r.Context(), id, edit)
+	switch {
+	case errors.Is(err, ErrRequestNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+	case errors.Is(err, ErrBodyTruncated):
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+	case err != nil:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+	default:
+		writeJSON(w, http.StatusOK, newExchangeDetail(&ex))
+	}
+}
+
+// checkSameOrigin не даёт чужой странице повторять запросы через браузер
+// разработчика. Origin присылают браузеры, у команды replay его нет; вместе
+// с обязательным application/json это закрывает простые кросс-доменные POST.
+func checkSameOrigin(r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	if u, err := url.Parse(origin); err != nil || u.Scheme != "http" || !strings.EqualFold(u.Host, r.Host) {
+		return fmt.Errorf("cross-origin request from %s is not allowed", origin)
+	}
+	return nil
+}
+
+func newExchangeDetail(ex *Exchange) exchangeDetail {
+	resp := exchangeDetail{exchangeSummary: newSummary(ex), Request: newMessageDetail(&ex.Request)}
+	if ex.Response != nil {
+		detail := newMessageDetail(ex.Response)
+		resp.Response = &detail
+	}
+	return resp
+}
+
+func requestID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request id"})
+		return 0, false
+	}
+	return id, true
+}
+
+// events шлёт краткое описание каждого нового или изменившегося запроса.
+func (i *Inspector) events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	updates, cancel := i.Subscribe()
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ex := <-updates:
+			data, err := json.Marshal(newSummary(&ex))
+			if err != nil {
+				return
+			}
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -5,7 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"syscall"
@@ -83,7 +83,7 @@ func newLoginCmd() *cobra.Command {
 				return fmt.Errorf("could not save config file: %w", err)
 			}
 
-			log.Println("Login successful! API key saved.")
+			slog.Info("Login successful! API key saved.")
 			return nil
 		},
 	}
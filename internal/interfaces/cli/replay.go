@@ -0,0 +1,119 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/waste3d/ghost-tunnel/internal/interfaces/cli/inspector"
+)
+
+func newReplayCmd() *cobra.Command {
+	var inspectAddr, body, bodyFile string
+	var headers, removeHeaders []string
+
+	cmd := &cobra.Command{
+		Use:   "replay <request-id>",
+		Short: "Re-send a request captured by the inspector of a running http tunnel",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid request id: %s", args[0])
+			}
+
+			req := inspector.ReplayRequest{RemoveHeader: removeHeaders}
+			if len(headers) > 0 {
+				req.Header = make(http.Header)
+				for _, header := range headers {
+					name, value, ok := strings.Cut(header, ":")
+					if !ok {
+						return fmt.Errorf("invalid header %q, expected 'Name: value'", header)
+					}
+					req.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+				}
+			}
+			switch {
+			case cmd.Flags().Changed("body") && bodyFile != "":
+				return fmt.Errorf("--body and --body-file cannot be used together")
+			case cmd.Flags().Changed("body"):
+				req.Body = &body
+			case bodyFile != "":
+				data, err := readBodyFile(bodyFile)
+				if err != nil {
+					return err
+				}
+				s := string(data)
+				req.Body = &s
+			}
+
+			result, err := replayRequest(inspectAddr, id, req)
+			if err != nil {
+				return err
+			}
+			if result.Error != "" {
+				return fmt.Errorf("request %d was replayed as %d but failed: %s", id, result.ID, result.Error)
+			}
+			slog.Info("Request replayed", "request_id", id, "replay_id", result.ID, "status", result.Status, "duration_ms", result.DurationMs)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&inspectAddr, "inspect", "127.0.0.1:4040", "Address of the inspector of the running http tunnel")
+	cmd.Flags().StringArrayVarP(&headers, "header", "H", nil, "Set a header before replaying, as 'Name: value' (repeatable)")
+	cmd.Flags().StringArrayVar(&removeHeaders, "remove-header", nil, "Remove a header before replaying (repeatable)")
+	cmd.Flags().StringVar(&body, "body", "", "Replace the request body")
+	cmd.Flags().StringVar(&bodyFile, "body-file", "", "Replace the request body with the contents of a file ('-' for stdin)")
+	return cmd
+}
+
+func readBodyFile(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read body file: %w", err)
+	}
+	return data, nil
+}
+
+// replayResult — поля ответа инспектора, которые нужны команде.
+type replayResult struct {
+	ID         int64   `json:"id"`
+	Status     int     `json:"status"`
+	DurationMs float64 `json:"duration_ms"`
+	Error      string  `json:"error"`
+}
+
+// replayRequest просит инспектор запущенного туннеля повторить запрос.
+func replayRequest(inspectAddr string, id int64, params inspector.ReplayRequest) (*replayResult, error) {
+	reqBody, _ := json.Marshal(params)
+
+	resp, err := http.Post(fmt.Sprintf("http://%s/api/requests/%d/replay", inspectAddr, id), "application/json", bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("could not reach the inspector at %s, is 'ghost-tunnel http' running? %w", inspectAddr, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		return nil, fmt.Errorf("failed to replay request %d (status %d): %s", id, resp.StatusCode, apiErr.Error)
+	}
+
+	var result replayResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("invalid response from the inspector: %w", err)
+	}
+	return &result, nil
+}
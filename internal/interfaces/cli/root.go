@@ -1,21 +1,39 @@
 package cli
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"github.com/waste3d/ghost-tunnel/internal/infrastructure/logging"
 )
 
+// Version — версия клиента, задаётся при сборке:
+// go build -ldflags "-X github.com/waste3d/ghost-tunnel/internal/interfaces/cli.Version=1.2.3"
+var Version = "dev"
+
 var rootCmd = &cobra.Command{
-	Use:   "ghost-tunnel",
-	Short: "Ghost Tunnel CLI client",
-	Long:  `A client to establish a secure tunnel to the Ghost Tunnel server.`,
+	Use:     "ghost-tunnel",
+	Short:   "Ghost Tunnel CLI client",
+	Long:    `A client to establish a secure tunnel to the Ghost Tunnel server.`,
+	Version: Version,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return logging.Setup(logLevel, logFormat)
+	},
 }
 
+var logLevel, logFormat string
+
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
+	// По Ctrl+C туннель закрывается штатно, а не обрывается
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
@@ -25,9 +43,15 @@ func init() {
 
 	cobra.OnInitialize(initConfig)
 
+	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "info", "Log level: debug, info, warn or error")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", logging.FormatText, "Log output format: text or json")
+
 	rootCmd.AddCommand(newConnectCmd())
 	rootCmd.AddCommand(newLoginCmd())
 	rootCmd.AddCommand(newHttpCmd())
+	rootCmd.AddCommand(newTcpCmd())
+	rootCmd.AddCommand(newReplayCmd())
+	rootCmd.AddCommand(newStartCmd())
 
 	newConnectCmd().Hidden = true
 }
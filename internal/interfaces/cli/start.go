@@ -0,0 +1,168 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// TunnelConfig — туннель из раздела tunnels конфига клиента
+// (~/.config/ghost-tunnel/config.yaml), см. client.example.yaml.
+type TunnelConfig struct {
+	// Type — http или tcp
+	Type string `mapstructure:"type"`
+	// Local — порт или host:port локального сервиса
+	Local         string `mapstructure:"local"`
+	Subdomain     string `mapstructure:"subdomain"`
+	HTTPSRedirect bool   `mapstructure:"https_redirect"`
+}
+
+// localAddr возвращает адрес локального сервиса и его порт.
+func (t TunnelConfig) localAddr() (string, int, error) {
+	addr := t.Local
+	if _, err := strconv.Atoi(addr); err == nil {
+		addr = net.JoinHostPort("localhost", addr)
+	}
+	_, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, fmt.Errorf("local must be a port or host:port, got %q", t.Local)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return "", 0, fmt.Errorf("invalid local port in %q", t.Local)
+	}
+	return addr, port, nil
+}
+
+func (t TunnelConfig) validate() error {
+	switch t.Type {
+	case "http":
+	case "tcp":
+		if t.Subdomain != "" || t.HTTPSRedirect {
+			return errors.New("subdomain and https_redirect apply only to http tunnels")
+		}
+	default:
+		return fmt.Errorf("type must be http or tcp, got %q", t.Type)
+	}
+	_, _, err := t.localAddr()
+	return err
+}
+
+// loadTunnelConfigs читает раздел tunnels и выбирает из него туннели по
+// именам; при all — все, в алфавитном порядке.
+func loadTunnelConfigs(names []string, all bool) ([]string, map[string]TunnelConfig, error) {
+	var tunnels map[string]TunnelConfig
+	if err := viper.UnmarshalKey("tunnels", &tunnels); err != nil {
+		return nil, nil, fmt.Errorf("invalid tunnels section in config: %w", err)
+	}
+	if len(tunnels) == 0 {
+		return nil, nil, fmt.Errorf("no tunnels defined in %s", configFileHint())
+	}
+	available := slices.Sorted(maps.Keys(tunnels))
+
+	switch {
+	case all && len(names) > 0:
+		return nil, nil, errors.New("pass tunnel names or --all, not both")
+	case all:
+		names = available
+	case len(names) == 0:
+		return nil, nil, fmt.Errorf("pass tunnel names or --all; defined tunnels: %s", strings.Join(available, ", "))
+	}
+
+	selected := make(map[string]TunnelConfig, len(names))
+	unique := make([]string, 0, len(names))
+	for _, name := range names {
+		if _, ok := selected[name]; ok {
+			continue
+		}
+		t, ok := tunnels[name]
+		if !ok {
+			return nil, nil, fmt.Errorf("tunnel %q is not defined; defined tunnels: %s", name, strings.Join(available, ", "))
+		}
+		if err := t.validate(); err != nil {
+			return nil, nil, fmt.Errorf("tunnel %q: %w", name, err)
+		}
+		selected[name] = t
+		unique = append(unique, name)
+	}
+	return unique, selected, nil
+}
+
+func configFileHint() string {
+	if file := viper.ConfigFileUsed(); file != "" {
+		return file
+	}
+	return "~/.config/ghost-tunnel/config.yaml"
+}
+
+func newStartCmd() *cobra.Command {
+	var serverAPI, serverGRPC string
+	var all bool
+
+	cmd := &cobra.Command{
+		Use:   "start [names...]",
+		Short: "Start tunnels defined in the config file",
+		Long: `Start tunnels defined in the tunnels section of ~/.config/ghost-tunnel/config.yaml.
+All of them are served over a single connection to the server.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			apiKey := viper.GetString("api_key")
+			if apiKey == "" {
+				return fmt.Errorf("not logged in. Please run 'ghost-tunnel login' first")
+			}
+
+			names, tunnels, err := loadTunnelConfigs(args, all)
+			if err != nil {
+				return err
+			}
+
+			routes := make([]Route, 0, len(names))
+			for _, name := range names {
+				t := tunnels[name]
+				localAddr, localPort, _ := t.localAddr()
+				params := map[string]interface{}{
+					"protocol":  t.Type,
+					"localport": localPort,
+				}
+				if t.Type == "http" {
+					params["subdomain"] = t.Subdomain
+					params["httpsredirect"] = t.HTTPSRedirect
+				}
+				result, created, err := requestTunnel(serverAPI, apiKey, params)
+				if err != nil {
+					return fmt.Errorf("tunnel %q: %w", name, err)
+				}
+
+				tunnelID := result["ID"].(string)
+				endpoint := result["Endpoints"].(map[string]interface{})
+				publicDomain := endpoint["Domain"].(string)
+				var public, local string
+				if t.Type == "http" {
+					public = fmt.Sprintf("https://%s.%s", endpoint["Subdomain"].(string), publicDomain)
+					local = "http://" + localAddr
+				} else {
+					public = fmt.Sprintf("tcp://%s:%d", publicDomain, int(endpoint["Port"].(float64)))
+					local = "tcp://" + localAddr
+				}
+				logTunnel(result, created, "name", name)
+				slog.Info("Forwarding", "name", name, "public", public, "local", local)
+
+				routes = append(routes, Route{TunnelID: tunnelID, LocalAddr: localAddr})
+			}
+
+			return NewClient(apiKey, routes...).Run(cmd.Context(), serverGRPC)
+		},
+	}
+
+	cmd.Flags().StringVar(&serverAPI, "api-server", "https://api.gtunnel.ru", "The address of the API server")
+	cmd.Flags().StringVar(&serverGRPC, "grpc-server", "83.166.247.105:50051", "The address of the gRPC server")
+	cmd.Flags().BoolVar(&all, "all", false, "Start all tunnels defined in the config file")
+	return cmd
+}
@@ -0,0 +1,113 @@
+package cli
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+const testTunnelsConfig = `
+tunnels:
+  web:
+    type: http
+    local: "3000"
+    subdomain: myapp
+    https_redirect: true
+  api:
+    type: http
+    local: 127.0.0.1:8080
+  ssh:
+    type: tcp
+    local: "22"
+  bad-type:
+    type: udp
+    local: "53"
+  bad-local:
+    type: http
+    local: localhost
+  tcp-subdomain:
+    type: tcp
+    local: "5432"
+    subdomain: db
+`
+
+// useClientConfig подменяет конфиг клиента в viper на время теста.
+func useClientConfig(t *testing.T, config string) {
+	t.Helper()
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.SetConfigType("yaml")
+	if err := viper.ReadConfig(strings.NewReader(config)); err != nil {
+		t.Fatalf("ReadConfig: %v", err)
+	}
+}
+
+func TestLoadTunnelConfigs(t *testing.T) {
+	web := TunnelConfig{Type: "http", Local: "3000", Subdomain: "myapp", HTTPSRedirect: true}
+	api := TunnelConfig{Type: "http", Local: "127.0.0.1:8080"}
+	ssh := TunnelConfig{Type: "tcp", Local: "22"}
+
+	tests := []struct {
+		name    string
+		config  string
+		names   []string
+		all     bool
+		want    []string
+		wantCfg map[string]TunnelConfig
+		wantErr string
+	}{
+		{
+			name:    "by name",
+			config:  testTunnelsConfig,
+			names:   []string{"web", "ssh"},
+			want:    []string{"web", "ssh"},
+			wantCfg: map[string]TunnelConfig{"web": web, "ssh": ssh},
+		},
+		{
+			name:    "duplicate names start once",
+			config:  testTunnelsConfig,
+			names:   []string{"api", "web", "api"},
+			want:    []string{"api", "web"},
+			wantCfg: map[string]TunnelConfig{"api": api, "web": web},
+		},
+		{
+			name:    "all in alphabetical order",
+			config:  "tunnels:\n  web: {type: http, local: \"3000\", subdomain: myapp, https_redirect: true}\n  api: {type: http, local: \"127.0.0.1:8080\"}\n  ssh: {type: tcp, local: \"22\"}\n",
+			all:     true,
+			want:    []string{"api", "ssh", "web"},
+			wantCfg: map[string]TunnelConfig{"api": api, "ssh": ssh, "web": web},
+		},
+		{name: "unknown type", config: testTunnelsConfig, names: []string{"bad-type"}, wantErr: `tunnel "bad-type": type must be http or tcp`},
+		{name: "local without port", config: testTunnelsConfig, names: []string{"bad-local"}, wantErr: `tunnel "bad-local": local must be a port or host:port`},
+		{name: "subdomain on tcp", config: testTunnelsConfig, names: []string{"tcp-subdomain"}, wantErr: "subdomain and https_redirect apply only to http tunnels"},
+		{name: "all with an invalid entry", config: testTunnelsConfig, all: true, wantErr: `tunnel "bad-local"`},
+		{name: "undefined name", config: testTunnelsConfig, names: []string{"db"}, wantErr: `tunnel "db" is not defined`},
+		{name: "names and all", config: testTunnelsConfig, names: []string{"web"}, all: true, wantErr: "not both"},
+		{name: "no names", config: testTunnelsConfig, wantErr: "defined tunnels: api, bad-local, bad-type, ssh, tcp-subdomain, web"},
+		{name: "no tunnels section", config: "api_key: secret\n", names: []string{"web"}, wantErr: "no tunnels defined"},
+		{name: "malformed section", config: "tunnels:\n  web: [1, 2]\n", names: []string{"web"}, wantErr: "invalid tunnels section"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useClientConfig(t, tt.config)
+			names, configs, err := loadTunnelConfigs(tt.names, tt.all)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("loadTunnelConfigs() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadTunnelConfigs() error = %v", err)
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("names = %v, want %v", names, tt.want)
+			}
+			if !reflect.DeepEqual(configs, tt.wantCfg) {
+				t.Errorf("configs = %+v, want %+v", configs, tt.wantCfg)
+			}
+		})
+	}
+}
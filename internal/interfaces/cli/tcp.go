@@ -0,0 +1,57 @@
+package cli
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func newTcpCmd() *cobra.Command {
+	var serverAPI, serverGRPC string
+
+	cmd := &cobra.Command{
+		Use:   "tcp [port]",
+		Short: "Create a new raw TCP tunnel on a dedicated public port",
+		Long: `Create a new raw TCP tunnel. The server allocates a dedicated public port
+and forwards bytes to the local port as is, so any TCP protocol works:
+Postgres, SSH, gRPC and so on.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			localPort, err := strconv.Atoi(args[0])
+			if err != nil {
+				return fmt.Errorf("invalid port number: %s", args[0])
+			}
+
+			apiKey := viper.GetString("api_key")
+			if apiKey == "" {
+				return fmt.Errorf("not logged in. Please run 'ghost-tunnel login' first")
+			}
+
+			result, created, err := requestTunnel(serverAPI, apiKey, map[string]interface{}{
+				"protocol":  "tcp",
+				"localport": localPort,
+			})
+			if err != nil {
+				return err
+			}
+
+			tunnelID := result["ID"].(string)
+			endpoint := result["Endpoints"].(map[string]interface{})
+			publicDomain := endpoint["Domain"].(string)
+			publicPort := int(endpoint["Port"].(float64))
+
+			logTunnel(result, created)
+			slog.Info("Forwarding", "public", fmt.Sprintf("tcp://%s:%d", publicDomain, publicPort), "local", fmt.Sprintf("localhost:%d", localPort))
+
+			tunnelClient := NewClient(apiKey, Route{TunnelID: tunnelID, LocalAddr: fmt.Sprintf("localhost:%d", localPort)})
+			return tunnelClient.Run(cmd.Context(), serverGRPC)
+		},
+	}
+
+	cmd.Flags().StringVar(&serverAPI, "api-server", "https://api.gtunnel.ru", "The address of the API server")
+	cmd.Flags().StringVar(&serverGRPC, "grpc-server", "83.166.247.105:50051", "The address of the gRPC server")
+	return cmd
+}
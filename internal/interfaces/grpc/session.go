@@ -0,0 +1,288 @@
+package tunnelgrpc
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/waste3d/ghost-tunnel/api"
+	"github.com/waste3d/ghost-tunnel/internal/domain"
+	"github.com/waste3d/ghost-tunnel/internal/infrastructure/metrics"
+)
+
+var errSessionDetached = errors.New("tunnel agent is not connected")
+
+// agentStream — gRPC-стрим агента. Через один стрим могут работать сессии
+// нескольких туннелей, поэтому Send сериализуется здесь: gRPC-стрим не
+// допускает конкурентной отправки.
+type agentStream struct {
+	api.TunnelService_EstablishTunnelServer
+	mu sync.Mutex
+}
+
+func newAgentStream(stream api.TunnelService_EstablishTunnelServer) *agentStream {
+	return &agentStream{TunnelService_EstablishTunnelServer: stream}
+}
+
+func (s *agentStream) Send(msg *api.ServerToClient) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.TunnelService_EstablishTunnelServer.Send(msg)
+}
+
+// register вызывает attach, который привязывает сессии к стриму, и первым
+// сообщением отправляет ответ на Register: до этого сессии не могут ничего
+// отправить в стрим.
+func (s *agentStream) register(attach func(), reply *api.Registered) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attach()
+	return s.TunnelService_EstablishTunnelServer.Send(&api.ServerToClient{
+		Message: &api.ServerToClient_Registered{Registered: reply},
+	})
+}
+
+// attachment — конкретный gRPC-стрим, через который сейчас работает сессия.
+// superseded закрывается, когда стрим заменили более новым.
+type attachment struct {
+	stream     *agentStream
+	superseded chan struct{}
+}
+
+// Session — подключённый агент туннеля. Сессия переживает обрыв стрима:
+// агент может переподключиться с resume-токеном, и открытые соединения
+// продолжат работу через новый стрим.
+type Session struct {
+	tunnelID string
+	token    string
+	traffic  metrics.Traffic
+
+	// resumes защищён мьютексом SessionManager
+	resumes uint64
+
+	// recvMu держится на время доставки кадра, чтобы кадры старого стрима
+	// не доставлялись после переключения на новый; sendMu держится на время
+	// отправки, чтобы кадры не ушли в уже заменённый стрим.
+	// current меняется только под обоими мьютексами.
+	recvMu  sync.Mutex
+	sendMu  sync.Mutex
+	current *attachment
+}
+
+func newSession(tunnelID string, traffic metrics.Traffic) *Session {
+	return &Session{tunnelID: tunnelID, token: uuid.New().String(), traffic: traffic}
+}
+
+// attach переключает сессию на новый стрим; вызывается из agentStream.register.
+func (s *Session) attach(stream *agentStream) *attachment {
+	s.recvMu.Lock()
+	defer s.recvMu.Unlock()
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+
+	if s.current != nil {
+		close(s.current.superseded)
+	}
+	s.current = &attachment{stream: stream, superseded: make(chan struct{})}
+	return s.current
+}
+
+// detach отвязывает стрим от сессии, если он всё ещё текущий.
+func (s *Session) detach(att *attachment) bool {
+	s.recvMu.Lock()
+	defer s.recvMu.Unlock()
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+
+	if s.current != att {
+		return false
+	}
+	s.current = nil
+	return true
+}
+
+// terminate отключает текущий стрим навсегда, например когда агент зарегистрировался заново без токена.
+func (s *Session) terminate() {
+	s.recvMu.Lock()
+	defer s.recvMu.Unlock()
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+
+	if s.current != nil {
+		close(s.current.superseded)
+		s.current = nil
+	}
+}
+
+// dispatchFrom доставляет кадр, только если он пришёл из текущего стрима.
+func (s *Session) dispatchFrom(att *attachment, deliver func()) bool {
+	s.recvMu.Lock()
+	defer s.recvMu.Unlock()
+	if s.current != att {
+		return false
+	}
+	deliver()
+	return true
+}
+
+func (s *Session) TunnelID() string {
+	return s.tunnelID
+}
+
+func (s *Session) attached() bool {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	return s.current != nil
+}
+
+func (s *Session) send(msg *api.ServerToClient) error {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	if s.current == nil {
+		return errSessionDetached
+	}
+	return s.current.stream.Send(msg)
+}
+
+func (s *Session) SendData(connID string, offset uint64, chunk []byte) error {
+	err := s.send(&api.ServerToClient{
+		Message: &api.ServerToClient_Data{
+			Data: &api.Data{ConnectionId: connID, Chunk: chunk, Offset: offset},
+		},
+	})
+	if err == nil {
+		s.traffic.Inbound.Add(float64(len(chunk)))
+	}
+	return err
+}
+
+func (s *Session) SendHalfClose(connID string, finalOffset uint64) error {
+	return s.send(&api.ServerToClient{
+		Message: &api.ServerToClient_HalfClose{
+			HalfClose: &api.HalfClose{ConnectionId: connID, FinalOffset: finalOffset},
+		},
+	})
+}
+
+func (s *Session) SendClose(connID string, reason api.CloseReason, finalOffset uint64) error {
+	return s.send(&api.ServerToClient{
+		Message: &api.ServerToClient_Close{
+			Close: &api.Close{ConnectionId: connID, Reason: reason, FinalOffset: finalOffset},
+		},
+	})
+}
+
+func (s *Session) SendReset(connID string, reason api.CloseReason, message string) error {
+	return s.send(&api.ServerToClient{
+		Message: &api.ServerToClient_ResetConnection{
+			ResetConnection: &api.Reset{ConnectionId: connID, Reason: reason, Message: message},
+		},
+	})
+}
+
+func (s *Session) SendWindowUpdate(connID string, increment uint32) error {
+	return s.send(&api.ServerToClient{
+		Message: &api.ServerToClient_WindowUpdate{
+			WindowUpdate: &api.WindowUpdate{ConnectionId: connID, Increment: increment},
+		},
+	})
+}
+
+type SessionManager struct {
+	mu       sync.RWMutex
+	sessions map[string]*Session
+	metrics  *metrics.Metrics
+}
+
+func NewSessionManager(m *metrics.Metrics) *SessionManager {
+	return &SessionManager{
+		sessions: make(map[string]*Session),
+		metrics:  m,
+	}
+}
+
+// Register находит сессию для продолжения по resume-токену или атомарно
+// заменяет прежнюю сессию туннеля новой. Заменённая сессия возвращается,
+// чтобы вызывающий оборвал её соединения.
+func (sm *SessionManager) Register(tunnelID, resumeToken string) (session *Session, resumed bool, replaced *Session) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	existing := sm.sessions[tunnelID]
+	if existing != nil && resumeToken != "" && existing.token == resumeToken {
+		existing.resumes++
+		return existing, true, nil
+	}
+
+	session = newSession(tunnelID, sm.metrics.TunnelTraffic(tunnelID))
+	sm.sessions[tunnelID] = session
+	return session, false, existing
+}
+
+// Get возвращает сессию туннеля, если агент сейчас на связи.
+func (sm *SessionManager) Get(tunnelID string) (*Session, bool) {
+	sm.mu.RLock()
+	session, ok := sm.sessions[tunnelID]
+	sm.mu.RUnlock()
+	if !ok || !session.attached() {
+		return nil, false
+	}
+	return session, true
+}
+
+// SessionCounts возвращает число сессий и сколько из них с подключённым агентом.
+func (sm *SessionManager) SessionCounts() (total, attached int) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	for _, session := range sm.sessions {
+		if session.attached() {
+			attached++
+		}
+	}
+	return len(sm.sessions), attached
+}
+
+// AgentOnline сообщает, подключён ли агент туннеля прямо сейчас.
+func (sm *SessionManager) AgentOnline(id domain.TunnelID) bool {
+	_, ok := sm.Get(string(id))
+	return ok
+}
+
+// registered сообщает, есть ли у туннеля сессия, в том числе ждущая переподключения агента.
+func (sm *SessionManager) registered(tunnelID string) bool {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	_, ok := sm.sessions[tunnelID]
+	return ok
+}
+
+// Remove удаляет сессию, только если она всё ещё зарегистрирована для туннеля.
+func (sm *SessionManager) Remove(session *Session) bool {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if sm.sessions[session.tunnelID] != session {
+		return false
+	}
+	delete(sm.sessions, session.tunnelID)
+	sm.metrics.ForgetTunnel(session.tunnelID)
+	return true
+}
+
+// resumeCount возвращает, сколько раз сессию пытались продолжить.
+func (sm *SessionManager) resumeCount(session *Session) uint64 {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return session.resumes
+}
+
+// expire удаляет сессию, если за время ожидания агент так и не переподключился.
+func (sm *SessionManager) expire(session *Session, resumes uint64) bool {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if sm.sessions[session.tunnelID] != session || session.resumes != resumes || session.attached() {
+		return false
+	}
+	delete(sm.sessions, session.tunnelID)
+	sm.metrics.ForgetTunnel(session.tunnelID)
+	return true
+}
@@ -0,0 +1,131 @@
+package tunnelgrpc
+
+import (
+	"testing"
+
+	"github.com/waste3d/ghost-tunnel/api"
+	"github.com/waste3d/ghost-tunnel/internal/infrastructure/metrics"
+)
+
+// fakeStream — стрим агента, который просто запоминает отправленные сообщения.
+type fakeStream struct {
+	api.TunnelService_EstablishTunnelServer
+	sent []*api.ServerToClient
+}
+
+func (s *fakeStream) Send(msg *api.ServerToClient) error {
+	s.sent = append(s.sent, msg)
+	return nil
+}
+
+func attachStream(t *testing.T, session *Session) (*attachment, *fakeStream) {
+	t.Helper()
+	stream := &fakeStream{}
+	agent := newAgentStream(stream)
+	var att *attachment
+	err := agent.register(func() {
+		att = session.attach(agent)
+	}, &api.Registered{})
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	return att, stream
+}
+
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestSessionManagerRegister(t *testing.T) {
+	sm := NewSessionManager(metrics.New())
+
+	first, resumed, replaced := sm.Register("tunnel", "")
+	if resumed || replaced != nil {
+		t.Fatalf("first Register = resumed %v, replaced %v; want a new session", resumed, replaced)
+	}
+
+	same, resumed, replaced := sm.Register("tunnel", first.token)
+	if same != first || !resumed || replaced != nil {
+		t.Errorf("Register with the resume token did not resume the session")
+	}
+
+	current := first
+	for _, token := range []string{"", "stale-token"} {
+		next, resumed, replaced := sm.Register("tunnel", token)
+		if next == current || resumed || replaced != current {
+			t.Errorf("Register with token %q did not replace the session", token)
+		}
+		current = next
+	}
+}
+
+func TestSessionSupersede(t *testing.T) {
+	sm := NewSessionManager(metrics.New())
+	session, _, _ := sm.Register("tunnel", "")
+
+	old, _ := attachStream(t, session)
+	current, stream := attachStream(t, session)
+	if !isClosed(old.superseded) {
+		t.Error("previous attachment was not superseded")
+	}
+	if isClosed(current.superseded) {
+		t.Error("current attachment is superseded")
+	}
+
+	// кадры старого стрима больше не доставляются, отправка идёт в новый
+	if session.dispatchFrom(old, func() {}) {
+		t.Error("frame from the superseded stream was delivered")
+	}
+	if !session.dispatchFrom(current, func() {}) {
+		t.Error("frame from the current stream was dropped")
+	}
+	if session.detach(old) {
+		t.Error("detach of the superseded stream detached the session")
+	}
+	before := len(stream.sent)
+	if err := session.SendWindowUpdate("conn", 1); err != nil {
+		t.Fatalf("SendWindowUpdate: %v", err)
+	}
+	if len(stream.sent) != before+1 {
+		t.Error("frame was not sent to the current stream")
+	}
+}
+
+func TestSessionManagerExpire(t *testing.T) {
+	sm := NewSessionManager(metrics.New())
+	session, _, _ := sm.Register("tunnel", "")
+	att, _ := attachStream(t, session)
+
+	if sm.expire(session, sm.resumeCount(session)) {
+		t.Fatal("expire removed a session with an attached agent")
+	}
+
+	// агент отключился и переподключился до истечения ResumeTimeout
+	if !session.detach(att) {
+		t.Fatal("detach of the current stream failed")
+	}
+	if sm.AgentOnline("tunnel") {
+		t.Error("AgentOnline after detach = true")
+	}
+	resumes := sm.resumeCount(session)
+	sm.Register("tunnel", session.token)
+	if sm.expire(session, resumes) {
+		t.Fatal("expire removed a session that was resumed")
+	}
+
+	// агент так и не вернулся
+	if !sm.expire(session, sm.resumeCount(session)) {
+		t.Fatal("expire kept a detached session")
+	}
+	if sm.registered("tunnel") {
+		t.Error("expired session is still registered")
+	}
+	if err := session.SendWindowUpdate("conn", 1); err != errSessionDetached {
+		t.Errorf("send on an expired session = %v, want errSessionDetached", err)
+	}
+}
@@ -1,84 +1,171 @@
 package tunnelgrpc
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"sync"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/waste3d/ghost-tunnel/api"
+	"github.com/waste3d/ghost-tunnel/internal/application"
+	"github.com/waste3d/ghost-tunnel/internal/domain"
+	"github.com/waste3d/ghost-tunnel/internal/infrastructure/logging"
+	"github.com/waste3d/ghost-tunnel/internal/infrastructure/mux"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 )
 
-type SessionManager struct {
-	mu       sync.RWMutex
-	sessions map[string]api.TunnelService_EstablishTunnelServer
+type connEntry struct {
+	conn    *mux.Conn
+	session *Session
 }
 
-func NewSessionManager() *SessionManager {
-	return &SessionManager{
-		sessions: make(map[string]api.TunnelService_EstablishTunnelServer),
+type ConnectionManager struct {
+	conns map[string]connEntry
+	mu    sync.RWMutex
+}
+
+func NewConnectionManager() *ConnectionManager {
+	return &ConnectionManager{
+		conns: make(map[string]connEntry),
 	}
 }
 
-func (sm *SessionManager) Add(tunnelID string, stream api.TunnelService_EstablishTunnelServer) {
-	sm.mu.Lock()
-	defer sm.mu.Unlock()
-	sm.sessions[tunnelID] = stream
+// Open регистрирует новое соединение и сообщает о нём агенту.
+func (cm *ConnectionManager) Open(session *Session) (*mux.Conn, error) {
+	connID := uuid.New().String()
+	conn := mux.NewConn(connID, session)
+
+	cm.mu.Lock()
+	cm.conns[connID] = connEntry{conn: conn, session: session}
+	cm.mu.Unlock()
+
+	err := session.send(&api.ServerToClient{
+		Message: &api.ServerToClient_NewConnection{
+			NewConnection: &api.NewConnection{ConnectionId: connID, TunnelId: session.tunnelID},
+		},
+	})
+	if err != nil {
+		cm.Remove(connID)
+		return nil, err
+	}
+	return conn, nil
 }
 
-func (sm *SessionManager) Get(tunnelID string) (api.TunnelService_EstablishTunnelServer, bool) {
-	sm.mu.RLock()
-	defer sm.mu.RUnlock()
-	stream, ok := sm.sessions[tunnelID]
-	return stream, ok
+// lookup ищет соединение и сессию, которой оно принадлежит.
+func (cm *ConnectionManager) lookup(connID string) (*mux.Conn, *Session, bool) {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	entry, ok := cm.conns[connID]
+	return entry.conn, entry.session, ok
 }
 
-func (sm *SessionManager) Remove(tunnelID string) {
-	sm.mu.Lock()
-	defer sm.mu.Unlock()
-	delete(sm.sessions, tunnelID)
+func (cm *ConnectionManager) Remove(connID string) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	delete(cm.conns, connID)
 }
 
-type ConnectionManager struct {
-	channels map[string]chan []byte
-	mu       sync.RWMutex
+// ConnectionsByTunnel возвращает число открытых соединений каждого туннеля.
+func (cm *ConnectionManager) ConnectionsByTunnel() map[string]int {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	counts := make(map[string]int)
+	for _, entry := range cm.conns {
+		counts[entry.session.tunnelID]++
+	}
+	return counts
 }
 
-func NewConnectionManager() *ConnectionManager {
-	return &ConnectionManager{
-		channels: make(map[string]chan []byte),
+func (cm *ConnectionManager) sessionConns(session *Session) []*mux.Conn {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	var conns []*mux.Conn
+	for _, entry := range cm.conns {
+		if entry.session == session {
+			conns = append(conns, entry.conn)
+		}
 	}
+	return conns
 }
 
-func (cm *ConnectionManager) Add(connID string, ch chan []byte) {
-	cm.mu.Lock()
-	defer cm.mu.Unlock()
-	cm.channels[connID] = ch
+// Resume продолжает соединения сессии после переподключения агента.
+func (cm *ConnectionManager) Resume(session *Session, peer []*api.ConnectionState) int {
+	conns := cm.sessionConns(session)
+	lost := mux.Reconcile(conns, peer)
+	for _, conn := range lost {
+		conn.DeliverReset(api.CloseReason_CLOSE_REASON_SHUTDOWN, "connection lost while tunnel agent was reconnecting")
+		cm.Remove(conn.ID())
+	}
+	return len(conns) - len(lost)
 }
 
-// *** ИСПРАВЛЕННАЯ ФУНКЦИЯ ***
-func (cm *ConnectionManager) Remove(connID string) {
+// ResetSession обрывает все соединения отключившегося агента.
+func (cm *ConnectionManager) ResetSession(session *Session) {
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
-	delete(cm.channels, connID)
+	for connID, entry := range cm.conns {
+		if entry.session == session {
+			entry.conn.DeliverReset(api.CloseReason_CLOSE_REASON_SHUTDOWN, "tunnel agent disconnected")
+			delete(cm.conns, connID)
+		}
+	}
 }
 
+// TunnelObserver узнаёт о появлении и окончательном уходе сессий туннелей,
+// например чтобы открыть выделенный публичный порт TCP-туннеля.
+type TunnelObserver interface {
+	TunnelOnline(tunnel *domain.Tunnel, session *Session)
+	TunnelOffline(session *Session)
+}
+
+// statusTimeout ограничивает запись статуса туннеля в базу, чтобы медленная
+// база не задерживала подключение и отключение агентов.
+const statusTimeout = 5 * time.Second
+
 type TunnelServer struct {
 	api.UnimplementedTunnelServiceServer
-	sm      *SessionManager
-	connMgr *ConnectionManager
+	sm            *SessionManager
+	connMgr       *ConnectionManager
+	tunnelService *application.TunnelService
+	observer      TunnelObserver
+	// statusMu упорядочивает записи статуса, чтобы «offline» ушедшей сессии
+	// не затёр «online» сессии, зарегистрированной сразу после неё
+	statusMu sync.Mutex
 }
 
-func NewTunnelServer(sessionManager *SessionManager, connMgr *ConnectionManager) *TunnelServer {
+func NewTunnelServer(sessionManager *SessionManager, connMgr *ConnectionManager, tunnelService *application.TunnelService, observer TunnelObserver) *TunnelServer {
 	return &TunnelServer{
-		sm:      sessionManager,
-		connMgr: connMgr,
+		sm:            sessionManager,
+		connMgr:       connMgr,
+		tunnelService: tunnelService,
+		observer:      observer,
 	}
 }
 
+// maxTunnelsPerAgent ограничивает число туннелей в одном Register
+const maxTunnelsPerAgent = 32
+
+// tunnelAgent — туннель, который агент обслуживает через текущий стрим.
+type tunnelAgent struct {
+	tunnel  *domain.Tunnel
+	session *Session
+	resumed bool
+	att     *attachment
+	logger  *slog.Logger
+}
+
 func (s *TunnelServer) EstablishTunnel(stream api.TunnelService_EstablishTunnelServer) error {
-	log.Println("Client connected")
+	logger := slog.Default()
+	if p, ok := peer.FromContext(stream.Context()); ok {
+		logger = logger.With(logging.KeyRemoteAddr, p.Addr.String())
+	}
+	logger.Info("Client connected")
 	msg, err := stream.Recv()
 	if err != nil {
 		return err
@@ -87,25 +174,274 @@ func (s *TunnelServer) EstablishTunnel(stream api.TunnelService_EstablishTunnelS
 	if reg == nil {
 		return status.Errorf(codes.InvalidArgument, "first message must be a Register message")
 	}
-	tunnelID := reg.GetTunnelId()
-	log.Printf("Client registered for tunnel ID: %s", tunnelID)
-	s.sm.Add(tunnelID, stream)
-	defer s.sm.Remove(tunnelID)
-	for {
-		msg, err := stream.Recv()
+	requests, err := tunnelRequests(reg)
+	if err != nil {
+		logger.Warn("Client rejected", logging.Err(err))
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	// все туннели проверяются до регистрации, чтобы не занять часть из них
+	agents := make([]*tunnelAgent, len(requests))
+	for i, req := range requests {
+		tunnelLogger := logger.With(logging.KeyTunnelID, req.GetTunnelId())
+		tunnel, err := s.tunnelService.AuthorizeAgent(stream.Context(), domain.TunnelID(req.GetTunnelId()), reg.GetApiKey())
 		if err != nil {
-			if err == io.EOF || status.Code(err) == codes.Canceled {
-				return nil
+			tunnelLogger.Warn("Client rejected", logging.Err(err))
+			if len(requests) > 1 {
+				err = fmt.Errorf("tunnel %s: %w", req.GetTunnelId(), err)
 			}
-			return err
-		}
-		if data := msg.GetData(); data != nil {
-			s.connMgr.mu.RLock()
-			ch, ok := s.connMgr.channels[data.GetConnectionId()]
-			s.connMgr.mu.RUnlock()
-			if ok {
-				ch <- data.GetChunk()
+			return authError(err)
+		}
+		agents[i] = &tunnelAgent{
+			tunnel: tunnel,
+			logger: tunnelLogger.With(logging.KeySubdomain, tunnel.Endpoints.Subdomain, logging.KeyUserID, tunnel.UserID),
+		}
+	}
+
+	reply := &api.Registered{}
+	for i, agent := range agents {
+		session, resumed, replaced := s.sm.Register(string(agent.tunnel.ID), requests[i].GetResumeToken())
+		if replaced != nil {
+			agent.logger.Info("Previous agent session replaced")
+			replaced.terminate()
+			s.connMgr.ResetSession(replaced)
+		}
+		agent.session, agent.resumed = session, resumed
+
+		state := &api.TunnelRegistered{TunnelId: string(agent.tunnel.ID), ResumeToken: session.token, Resumed: resumed}
+		if resumed {
+			state.Connections = mux.States(s.connMgr.sessionConns(session))
+		}
+		reply.Tunnels = append(reply.Tunnels, state)
+	}
+	if len(reg.GetTunnels()) == 0 {
+		// агент с одним туннелем ждёт ответ в полях самого Registered
+		state := reply.Tunnels[0]
+		reply = &api.Registered{ResumeToken: state.ResumeToken, Resumed: state.Resumed, Connections: state.Connections}
+	}
+
+	link := newAgentStream(stream)
+	err = link.register(func() {
+		for _, agent := range agents {
+			agent.att = agent.session.attach(link)
+		}
+	}, reply)
+	if err != nil {
+		for _, agent := range agents {
+			s.release(agent)
+		}
+		return err
+	}
+
+	for i, agent := range agents {
+		if agent.resumed {
+			n := s.connMgr.Resume(agent.session, requests[i].GetConnections())
+			agent.logger.Info("Client resumed tunnel", "connections", n, "agent_version", reg.GetAgentVersion())
+		} else {
+			agent.logger.Info("Client registered for tunnel", "agent_version", reg.GetAgentVersion())
+		}
+		s.observer.TunnelOnline(agent.tunnel, agent.session)
+		s.markOnline(stream.Context(), agent.tunnel, reg, agent.logger)
+	}
+
+	return s.serve(link, agents, logger)
+}
+
+// tunnelRequests возвращает туннели из Register: список tunnels или, у
+// агента с одним туннелем, поля самого Register.
+func tunnelRequests(reg *api.Register) ([]*api.TunnelRegister, error) {
+	if len(reg.GetTunnels()) == 0 {
+		return []*api.TunnelRegister{{
+			TunnelId:    reg.GetTunnelId(),
+			ResumeToken: reg.GetResumeToken(),
+			Connections: reg.GetConnections(),
+		}}, nil
+	}
+	if len(reg.GetTunnels()) > maxTunnelsPerAgent {
+		return nil, fmt.Errorf("at most %d tunnels per agent, got %d", maxTunnelsPerAgent, len(reg.GetTunnels()))
+	}
+	seen := make(map[string]bool, len(reg.GetTunnels()))
+	for _, req := range reg.GetTunnels() {
+		if seen[req.GetTunnelId()] {
+			return nil, fmt.Errorf("tunnel %s is registered twice", req.GetTunnelId())
+		}
+		seen[req.GetTunnelId()] = true
+	}
+	return reg.GetTunnels(), nil
+}
+
+// serve читает кадры агента, пока стрим не оборвётся или один из его
+// туннелей не заберёт новое подключение.
+func (s *TunnelServer) serve(link *agentStream, agents []*tunnelAgent, logger *slog.Logger) error {
+	done := make(chan struct{})
+	defer close(done)
+	superseded := make(chan struct{})
+	var once sync.Once
+	atts := make(map[*Session]*attachment, len(agents))
+	for _, agent := range agents {
+		atts[agent.session] = agent.att
+		go func(att *attachment) {
+			select {
+			case <-att.superseded:
+				once.Do(func() { close(superseded) })
+			case <-done:
 			}
+		}(agent.att)
+	}
+
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			msg, err := link.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			if !s.dispatch(atts, msg) {
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-superseded:
+		// остальные туннели стрима ждут переподключения агента как при обрыве
+		for _, agent := range agents {
+			s.release(agent)
+		}
+		return status.Error(codes.Aborted, "tunnel session was taken over by a newer connection")
+	case err := <-recvErr:
+		if err == io.EOF {
+			// агент штатно закрыл туннель, ждать его обратно незачем
+			for _, agent := range agents {
+				if agent.session.detach(agent.att) && s.sm.Remove(agent.session) {
+					s.connMgr.ResetSession(agent.session)
+					s.observer.TunnelOffline(agent.session)
+					s.markOffline(agent.session, agent.logger)
+				}
+				agent.logger.Info("Client closed tunnel")
+			}
+			return nil
+		}
+		for _, agent := range agents {
+			s.release(agent)
+		}
+		if status.Code(err) == codes.Canceled {
+			return nil
+		}
+		return err
+	}
+}
+
+// release отвязывает оборвавшийся стрим и даёт агенту mux.ResumeTimeout на
+// переподключение, после чего сессия и её соединения удаляются.
+func (s *TunnelServer) release(agent *tunnelAgent) {
+	session, logger := agent.session, agent.logger
+	if !session.detach(agent.att) {
+		return
+	}
+	logger.Info("Agent disconnected, waiting for it to resume", "timeout", mux.ResumeTimeout)
+
+	resumes := s.sm.resumeCount(session)
+	time.AfterFunc(mux.ResumeTimeout, func() {
+		if s.sm.expire(session, resumes) {
+			logger.Info("Agent did not resume, closing its connections")
+			s.connMgr.ResetSession(session)
+			s.observer.TunnelOffline(session)
+			s.markOffline(session, logger)
+		}
+	})
+}
+
+// markOnline сохраняет, что агент подключился: статус, время, версию и адрес агента.
+// Ошибка записи не обрывает туннель, он работает и без актуального статуса.
+func (s *TunnelServer) markOnline(ctx context.Context, tunnel *domain.Tunnel, reg *api.Register, logger *slog.Logger) {
+	agent := domain.AgentInfo{Version: reg.GetAgentVersion()}
+	if p, ok := peer.FromContext(ctx); ok {
+		agent.RemoteAddr = p.Addr.String()
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), statusTimeout)
+	defer cancel()
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	if err := s.tunnelService.AgentConnected(ctx, tunnel, agent); err != nil {
+		logger.Error("Failed to mark tunnel online", logging.Err(err))
+	}
+}
+
+// markOffline сохраняет, что сессия туннеля закрыта окончательно.
+func (s *TunnelServer) markOffline(session *Session, logger *slog.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), statusTimeout)
+	defer cancel()
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	// агент уже успел зарегистрироваться заново, туннель снова на связи
+	if s.sm.registered(session.tunnelID) {
+		return
+	}
+	if err := s.tunnelService.AgentDisconnected(ctx, domain.TunnelID(session.tunnelID)); err != nil {
+		logger.Error("Failed to mark tunnel offline", logging.Err(err))
+	}
+}
+
+// dispatch доставляет кадр от агента в соответствующее соединение. Кадры
+// соединений чужих сессий отбрасываются; false означает, что сессия
+// соединения уже переключилась на другой стрим.
+func (s *TunnelServer) dispatch(atts map[*Session]*attachment, msg *api.ClientToServer) bool {
+	conn, session, ok := s.connMgr.lookup(frameConnID(msg))
+	if !ok {
+		return true
+	}
+	att, ok := atts[session]
+	if !ok {
+		return true
+	}
+	return session.dispatchFrom(att, func() {
+		switch m := msg.GetMessage().(type) {
+		case *api.ClientToServer_Data:
+			session.traffic.Outbound.Add(float64(len(m.Data.GetChunk())))
+			conn.Deliver(m.Data.GetOffset(), m.Data.GetChunk())
+		case *api.ClientToServer_HalfClose:
+			conn.DeliverHalfClose(m.HalfClose.GetFinalOffset())
+		case *api.ClientToServer_Close:
+			conn.DeliverClose(m.Close.GetFinalOffset())
+		case *api.ClientToServer_ResetConnection:
+			conn.DeliverReset(m.ResetConnection.GetReason(), m.ResetConnection.GetMessage())
+		case *api.ClientToServer_WindowUpdate:
+			conn.DeliverWindowUpdate(m.WindowUpdate.GetIncrement())
 		}
+	})
+}
+
+// frameConnID возвращает соединение, к которому относится кадр.
+func frameConnID(msg *api.ClientToServer) string {
+	switch m := msg.GetMessage().(type) {
+	case *api.ClientToServer_Data:
+		return m.Data.GetConnectionId()
+	case *api.ClientToServer_HalfClose:
+		return m.HalfClose.GetConnectionId()
+	case *api.ClientToServer_Close:
+		return m.Close.GetConnectionId()
+	case *api.ClientToServer_ResetConnection:
+		return m.ResetConnection.GetConnectionId()
+	case *api.ClientToServer_WindowUpdate:
+		return m.WindowUpdate.GetConnectionId()
+	default:
+		return ""
+	}
+}
+
+// authError переводит ошибки авторизации агента в gRPC-статусы,
+// которые CLI может показать пользователю. Чужой туннель неотличим от
+// несуществующего, иначе по ответам можно было бы перебирать чужие ID.
+func authError(err error) error {
+	switch {
+	case errors.Is(err, domain.ErrInvalidAPIKey):
+		return status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, domain.ErrForbidden), errors.Is(err, domain.ErrTunnelNotFound):
+		return status.Error(codes.NotFound, domain.ErrTunnelNotFound.Error())
+	default:
+		return status.Error(codes.Internal, "failed to authorize tunnel")
 	}
 }
@@ -0,0 +1,39 @@
+package tunnelgrpc
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/waste3d/ghost-tunnel/internal/domain"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAuthError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		code codes.Code
+		msg  string
+	}{
+		{"invalid key", domain.ErrInvalidAPIKey, codes.Unauthenticated, domain.ErrInvalidAPIKey.Error()},
+		{"missing tunnel", domain.ErrTunnelNotFound, codes.NotFound, "tunnel not found"},
+		{"wrapped missing tunnel", fmt.Errorf("tunnel 42: %w", domain.ErrTunnelNotFound), codes.NotFound, "tunnel not found"},
+		// чужой туннель отвечает так же, как несуществующий
+		{"forbidden", domain.ErrForbidden, codes.NotFound, "tunnel not found"},
+		{"not owned", domain.ErrTunnelNotOwned, codes.NotFound, "tunnel not found"},
+		{"internal", errors.New("connection refused"), codes.Internal, "failed to authorize tunnel"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st, ok := status.FromError(authError(tt.err))
+			if !ok {
+				t.Fatalf("authError(%v) is not a gRPC status", tt.err)
+			}
+			if st.Code() != tt.code || st.Message() != tt.msg {
+				t.Errorf("authError(%v) = %s %q, want %s %q", tt.err, st.Code(), st.Message(), tt.code, tt.msg)
+			}
+		})
+	}
+}
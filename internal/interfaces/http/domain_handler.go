@@ -0,0 +1,151 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/waste3d/ghost-tunnel/internal/application"
+	"github.com/waste3d/ghost-tunnel/internal/domain"
+	"github.com/waste3d/ghost-tunnel/internal/interfaces/http/middlewares"
+)
+
+type DomainHandler struct {
+	domainService *application.DomainService
+	userRepo      domain.UserRepository
+}
+
+func NewDomainHandler(domainService *application.DomainService, userRepo domain.UserRepository) *DomainHandler {
+	return &DomainHandler{domainService: domainService, userRepo: userRepo}
+}
+
+func (h *DomainHandler) RegisterRoutes(router *gin.Engine) {
+	private := router.Group("/tunnels/:tunnel/domains")
+	private.Use(middlewares.AuthMiddleware(h.userRepo))
+
+	{
+		private.POST("", h.AddDomain)
+		private.GET("", h.ListDomains)
+		private.POST("/:hostname/verify", h.VerifyDomain)
+		private.DELETE("/:hostname", h.RemoveDomain)
+	}
+}
+
+// domainResponse — домен вместе со статусом подтверждения и сертификата.
+// Для подтверждения нужна TXT-запись с токеном или CNAME на адрес туннеля.
+type domainResponse struct {
+	Hostname        string                   `json:"hostname"`
+	Verified        bool                     `json:"verified"`
+	VerificationTXT verificationRecord       `json:"verification_txt"`
+	CertStatus      domain.CertificateStatus `json:"cert_status"`
+	CertError       string                   `json:"cert_error,omitempty"`
+	CertExpiresAt   *time.Time               `json:"cert_expires_at"`
+}
+
+type verificationRecord struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+func newDomainResponse(d *domain.CustomDomain) domainResponse {
+	return domainResponse{
+		Hostname:        d.Hostname,
+		Verified:        d.Verified(),
+		VerificationTXT: verificationRecord{Name: d.VerificationRecord(), Value: d.VerificationToken},
+		CertStatus:      d.CertStatus,
+		CertError:       d.CertError,
+		CertExpiresAt:   d.CertExpiresAt,
+	}
+}
+
+func (h *DomainHandler) AddDomain(c *gin.Context) {
+	var req application.AddDomainRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, exists := middlewares.GetUserFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	d, err := h.domainService.AddDomain(c.Request.Context(), user.ID, c.Param("tunnel"), req)
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, newDomainResponse(d))
+}
+
+func (h *DomainHandler) ListDomains(c *gin.Context) {
+	user, exists := middlewares.GetUserFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	domains, err := h.domainService.ListDomains(c.Request.Context(), user.ID, c.Param("tunnel"))
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+
+	resp := make([]domainResponse, 0, len(domains))
+	for _, d := range domains {
+		resp = append(resp, newDomainResponse(d))
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func (h *DomainHandler) VerifyDomain(c *gin.Context) {
+	user, exists := middlewares.GetUserFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	d, err := h.domainService.VerifyDomain(c.Request.Context(), user.ID, c.Param("tunnel"), c.Param("hostname"))
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, newDomainResponse(d))
+}
+
+func (h *DomainHandler) RemoveDomain(c *gin.Context) {
+	user, exists := middlewares.GetUserFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	err := h.domainService.RemoveDomain(c.Request.Context(), user.ID, c.Param("tunnel"), c.Param("hostname"))
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Domain removed successfully"})
+}
+
+func (h *DomainHandler) error(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, domain.ErrInvalidHostname):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, domain.ErrTunnelNotFound), errors.Is(err, domain.ErrDomainNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, domain.ErrForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, domain.ErrDomainNotVerified):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	case errors.Is(err, domain.ErrDomainTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
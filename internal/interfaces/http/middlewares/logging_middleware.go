@@ -0,0 +1,45 @@
+package middlewares
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/waste3d/ghost-tunnel/internal/infrastructure/logging"
+)
+
+// RequestLogger пишет строку лога на каждый запрос к API и возвращает его ID
+// в заголовке X-Request-Id. Логгер с ID запроса кладётся в контекст запроса.
+func RequestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		requestID := logging.RequestID(c.GetHeader(logging.HeaderRequestID))
+		c.Header(logging.HeaderRequestID, requestID)
+
+		logger := slog.Default().With(logging.KeyRequestID, requestID, logging.KeyRemoteAddr, c.ClientIP())
+		c.Request = c.Request.WithContext(logging.WithContext(c.Request.Context(), logger))
+
+		c.Next()
+
+		status := c.Writer.Status()
+		args := []any{
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", status,
+			"duration", time.Since(start),
+		}
+		// пользователь известен только после AuthMiddleware
+		if user, ok := GetUserFromContext(c); ok {
+			args = append(args, logging.KeyUserID, user.ID)
+		}
+		if len(c.Errors) > 0 {
+			args = append(args, logging.KeyError, c.Errors.String())
+		}
+
+		level := slog.LevelInfo
+		if status >= 500 {
+			level = slog.LevelError
+		}
+		logger.Log(c.Request.Context(), level, "API request", args...)
+	}
+}
@@ -0,0 +1,151 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/waste3d/ghost-tunnel/internal/application"
+	"github.com/waste3d/ghost-tunnel/internal/domain"
+	"github.com/waste3d/ghost-tunnel/internal/interfaces/http/middlewares"
+)
+
+type StatsHandler struct {
+	statsService *application.StatsService
+	userRepo     domain.UserRepository
+}
+
+func NewStatsHandler(statsService *application.StatsService, userRepo domain.UserRepository) *StatsHandler {
+	return &StatsHandler{statsService: statsService, userRepo: userRepo}
+}
+
+func (h *StatsHandler) RegisterRoutes(router *gin.Engine) {
+	private := router.Group("/tunnels/:tunnel/stats")
+	private.Use(middlewares.AuthMiddleware(h.userRepo))
+
+	{
+		private.GET("", h.GetTunnelStats)
+	}
+}
+
+// statsResponse — статистика туннеля; задержка — время до заголовков ответа
+// локального сервиса в миллисекундах.
+type statsResponse struct {
+	TunnelID domain.TunnelID `json:"tunnel_id"`
+	From     time.Time       `json:"from"`
+	To       time.Time       `json:"to"`
+	Interval string          `json:"interval"`
+	Buckets  []statsBucket   `json:"buckets"`
+	Total    statsBucket     `json:"total"`
+}
+
+type statsBucket struct {
+	Start        time.Time        `json:"start"`
+	Requests     int64            `json:"requests"`
+	Connections  int64            `json:"connections"`
+	BytesIn      int64            `json:"bytes_in"`
+	BytesOut     int64            `json:"bytes_out"`
+	Status       map[string]int64 `json:"status"`
+	LatencyAvgMs float64          `json:"latency_avg_ms"`
+	LatencyMaxMs float64          `json:"latency_max_ms"`
+}
+
+func newStatsBucket(s *domain.TunnelStats) statsBucket {
+	b := statsBucket{
+		Start:       s.Start,
+		Requests:    s.Requests,
+		Connections: s.Connections,
+		BytesIn:     s.BytesIn,
+		BytesOut:    s.BytesOut,
+		Status: map[string]int64{
+			"1xx": s.Status1xx,
+			"2xx": s.Status2xx,
+			"3xx": s.Status3xx,
+			"4xx": s.Status4xx,
+			"5xx": s.Status5xx,
+		},
+		LatencyMaxMs: milliseconds(s.LatencyMax),
+	}
+	if s.Requests > 0 {
+		b.LatencyAvgMs = milliseconds(s.LatencyTotal / time.Duration(s.Requests))
+	}
+	return b
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// GetTunnelStats отдаёт историю трафика туннеля:
+// GET /tunnels/:tunnel/stats?from=2026-10-17T00:00:00Z&to=2026-10-18T00:00:00Z&interval=1h
+// from и to — RFC 3339, interval — длительность вида 5m или 1h.
+func (h *StatsHandler) GetTunnelStats(c *gin.Context) {
+	user, exists := middlewares.GetUserFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req application.TunnelStatsRequest
+	var err error
+	if req.From, err = queryTime(c, "from"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.To, err = queryTime(c, "to"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if value := c.Query("interval"); value != "" {
+		if req.Interval, err = time.ParseDuration(value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "interval must be a duration such as 5m or 1h"})
+			return
+		}
+	}
+
+	report, err := h.statsService.GetTunnelStats(c.Request.Context(), user.ID, c.Param("tunnel"), req)
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+
+	resp := statsResponse{
+		TunnelID: report.TunnelID,
+		From:     report.From,
+		To:       report.To,
+		Interval: report.Interval.String(),
+		Buckets:  make([]statsBucket, 0, len(report.Buckets)),
+		Total:    newStatsBucket(&report.Total),
+	}
+	for _, bucket := range report.Buckets {
+		resp.Buckets = append(resp.Buckets, newStatsBucket(bucket))
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func (h *StatsHandler) error(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, domain.ErrInvalidStatsRange):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, domain.ErrTunnelNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, domain.ErrForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// queryTime читает необязательный параметр запроса в формате RFC 3339.
+func queryTime(c *gin.Context, name string) (time.Time, error) {
+	value := c.Query(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, errors.New(name + " must be an RFC 3339 timestamp")
+	}
+	return t, nil
+}
@@ -1,13 +1,23 @@
 package http
 
 import (
+	"errors"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/waste3d/ghost-tunnel/internal/application"
+	"github.com/waste3d/ghost-tunnel/internal/domain"
 	"github.com/waste3d/ghost-tunnel/internal/interfaces/http/middlewares"
 )
 
+// tunnelResponse — туннель вместе с признаком Online: подключён ли к нему
+// агент прямо сейчас. Поля туннеля отдаются на верхнем уровне, как и раньше.
+type tunnelResponse struct {
+	*domain.Tunnel
+	Online bool
+}
+
 type TunnelHandler struct {
 	tunnelService *application.TunnelService
 }
@@ -22,14 +32,20 @@ func (h *TunnelHandler) RegisterRoutes(router *gin.Engine) {
 	private.Use(middlewares.AuthMiddleware(h.tunnelService.GetUserRepository()))
 
 	{
-		// Регистрируем приватные маршруты в этой группе
+		// Регистрируем приватные маршруты в этой группе.
+		// :tunnel — ID туннеля или его поддомен
 		private.POST("/tunnels", h.CreateTunnel)
-		private.DELETE("/tunnels/:subdomain", h.DeleteTunnel)
+		private.GET("/tunnels", h.ListTunnels)
+		private.GET("/tunnels/:tunnel", h.GetTunnel)
+		private.PATCH("/tunnels/:tunnel", h.UpdateTunnel)
+		private.DELETE("/tunnels/:tunnel", h.DeleteTunnel)
 	}
 
 	router.GET("/healthz", h.HealthCheck)
 }
 
+// CreateTunnel создаёт туннель или, если поддомен уже закреплён за
+// пользователем, переиспользует существующий: 201 — создан, 200 — найден.
 func (h *TunnelHandler) CreateTunnel(c *gin.Context) {
 	var req application.CreateTunnelRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -45,31 +61,148 @@ func (h *TunnelHandler) CreateTunnel(c *gin.Context) {
 
 	req.UserID = user.ID
 
-	tunnel, err := h.tunnelService.CreateTunnel(c.Request.Context(), req)
+	tunnel, created, err := h.tunnelService.OpenTunnel(c.Request.Context(), req)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		h.error(c, err)
 		return
 	}
 
-	c.JSON(http.StatusCreated, tunnel)
+	if !created {
+		c.JSON(http.StatusOK, h.response(tunnel))
+		return
+	}
+	c.JSON(http.StatusCreated, h.response(tunnel))
+}
+
+// ListTunnels отдаёт туннели пользователя постранично:
+// GET /tunnels?status=active&limit=50&offset=0
+func (h *TunnelHandler) ListTunnels(c *gin.Context) {
+	user, exists := middlewares.GetUserFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	req := application.ListTunnelsRequest{Status: domain.TunnelStatus(c.Query("status"))}
+	var err error
+	if req.Limit, err = queryInt(c, "limit"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Offset, err = queryInt(c, "offset"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	page, err := h.tunnelService.ListTunnels(c.Request.Context(), user.ID, req)
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+
+	tunnels := make([]tunnelResponse, 0, len(page.Tunnels))
+	for _, tunnel := range page.Tunnels {
+		tunnels = append(tunnels, h.response(tunnel))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"tunnels": tunnels,
+		"total":   page.Total,
+		"limit":   page.Limit,
+		"offset":  page.Offset,
+	})
+}
+
+func (h *TunnelHandler) GetTunnel(c *gin.Context) {
+	user, exists := middlewares.GetUserFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	tunnel, err := h.tunnelService.GetTunnel(c.Request.Context(), user.ID, c.Param("tunnel"))
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, h.response(tunnel))
+}
+
+func (h *TunnelHandler) UpdateTunnel(c *gin.Context) {
+	var req application.UpdateTunnelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, exists := middlewares.GetUserFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	tunnel, err := h.tunnelService.UpdateTunnel(c.Request.Context(), user.ID, c.Param("tunnel"), req)
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, h.response(tunnel))
 }
 
 func (h *TunnelHandler) DeleteTunnel(c *gin.Context) {
-	subdomain := c.Param("subdomain")
-	if subdomain == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "subdomain is required"})
+	user, exists := middlewares.GetUserFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 		return
 	}
 
-	err := h.tunnelService.DeleteTunnel(c.Request.Context(), subdomain)
+	err := h.tunnelService.DeleteTunnel(c.Request.Context(), user.ID, c.Param("tunnel"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		h.error(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Tunnel deleted successfully"})
 }
 
+func (h *TunnelHandler) response(tunnel *domain.Tunnel) tunnelResponse {
+	return tunnelResponse{Tunnel: tunnel, Online: h.tunnelService.Online(tunnel.ID)}
+}
+
 func (h *TunnelHandler) HealthCheck(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "OK"})
 }
+
+func (h *TunnelHandler) error(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, domain.ErrUnsupportedProtocol),
+		errors.Is(err, domain.ErrInvalidSubdomain),
+		errors.Is(err, domain.ErrInvalidLocalTarget),
+		errors.Is(err, domain.ErrInvalidTunnelStatus):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, domain.ErrTunnelNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, domain.ErrForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, domain.ErrSubdomainTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, domain.ErrNoFreePorts):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// queryInt читает необязательный числовой параметр запроса; 0, если его нет.
+func queryInt(c *gin.Context, name string) (int, error) {
+	value := c.Query(name)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, errors.New(name + " must be a non-negative integer")
+	}
+	return n, nil
+}
@@ -0,0 +1,84 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/waste3d/ghost-tunnel/internal/application"
+	"github.com/waste3d/ghost-tunnel/internal/domain"
+	"github.com/waste3d/ghost-tunnel/internal/infrastructure/persistence"
+)
+
+func TestTunnelHandlerForbidsOtherUsers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+
+	tunnels := persistence.NewMemoryTunnelRepository()
+	users := persistence.NewMemoryUserRepository()
+	service := application.NewTunnelService(tunnels, users, nil, "example.test", domain.PortRange{Min: 20000, Max: 20010})
+
+	newUser := func(email string) *domain.User {
+		user, err := domain.NewUser(email, "secret")
+		if err != nil {
+			t.Fatalf("NewUser: %v", err)
+		}
+		if err := users.Save(ctx, user); err != nil {
+			t.Fatalf("Save user: %v", err)
+		}
+		return user
+	}
+	alice := newUser("alice@example.test")
+	bob := newUser("bob@example.test")
+
+	tunnel, err := service.CreateTunnel(ctx, application.CreateTunnelRequest{UserID: alice.ID, Subdomain: "alice-app", LocalPort: 3000})
+	if err != nil {
+		t.Fatalf("CreateTunnel: %v", err)
+	}
+
+	router := gin.New()
+	NewTunnelHandler(service).RegisterRoutes(router)
+	do := func(user *domain.User, method, path, body string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+string(user.APIKey))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	cases := []struct {
+		method, path, body string
+	}{
+		{http.MethodGet, "/tunnels/" + string(tunnel.ID), ""},
+		{http.MethodPatch, "/tunnels/" + string(tunnel.ID), `{"subdomain":"stolen"}`},
+		{http.MethodDelete, "/tunnels/alice-app", ""},
+		{http.MethodDelete, "/tunnels/" + string(tunnel.ID), ""},
+	}
+	for _, tc := range cases {
+		if code := do(bob, tc.method, tc.path, tc.body); code != http.StatusForbidden {
+			t.Errorf("%s %s by other user = %d, want %d", tc.method, tc.path, code, http.StatusForbidden)
+		}
+	}
+
+	if code := do(bob, http.MethodGet, "/tunnels/missing", ""); code != http.StatusNotFound {
+		t.Errorf("GET missing tunnel = %d, want %d", code, http.StatusNotFound)
+	}
+	// закреплённый поддомен переиспользуется владельцем и занят для остальных
+	if code := do(alice, http.MethodPost, "/tunnels", `{"subdomain":"alice-app","localport":8080}`); code != http.StatusOK {
+		t.Errorf("POST reserved subdomain by owner = %d, want %d", code, http.StatusOK)
+	}
+	if code := do(bob, http.MethodPost, "/tunnels", `{"subdomain":"alice-app","localport":8080}`); code != http.StatusConflict {
+		t.Errorf("POST reserved subdomain by other user = %d, want %d", code, http.StatusConflict)
+	}
+	if code := do(bob, http.MethodPost, "/tunnels", `{"subdomain":"bob-app","localport":8080}`); code != http.StatusCreated {
+		t.Errorf("POST new subdomain = %d, want %d", code, http.StatusCreated)
+	}
+
+	if code := do(alice, http.MethodDelete, "/tunnels/alice-app", ""); code != http.StatusOK {
+		t.Errorf("DELETE by owner = %d, want %d", code, http.StatusOK)
+	}
+}